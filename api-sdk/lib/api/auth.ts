@@ -9,9 +9,17 @@ import {
   GroupLoginResponse,
   LoginRequest,
   LoginResponse,
+  UpdateUserRequest,
+  UpdateUserResponse,
 } from "./dto/auth";
 import { ApiClient, ApiError } from "./client";
-import { UserSelf } from "./dto/user";
+import {
+  UpdateMessageKeyRequest,
+  UserExport,
+  UserSelf,
+} from "./dto/user";
+import { GroupAPIError, GroupAPIErrorCode } from "./group";
+import { GroupAPIStatusCode } from "./dto/group";
 
 export enum AuthAPIErrorCode {
   BAD_GROUP_ID = "BAD_GROUP_ID",
@@ -23,6 +31,8 @@ export enum AuthAPIErrorCode {
   AUTH_ERROR = "AUTH_ERROR",
   FORBIDDEN = "FORBIDDEN",
 
+  ALREADY_USED = "ALREADY_USED",
+
   UNKNOWN_ERROR = "UNKNOWN_ERROR",
 }
 
@@ -224,6 +234,179 @@ export class AuthAPI {
     }
   }
 
+  /**
+   * Get a copy of everything the server stores about the user.
+   * @throws {AuthAPIError} AUTH_ERROR, UNKNOWN_ERROR
+   */
+  async exportUser(): Promise<UserExport> {
+    try {
+      return await this.client.get<UserExport>(`${AuthAPI.basePath}/me/export`);
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+      }
+      throw new AuthAPIError(
+        AuthAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to export user"
+      );
+    }
+  }
+
+  /**
+   * Update the username or the email of the user, empty fields are left unchanged.
+   * Changing the email requires the current email and password, which are
+   * proven with SRP. The new email is kept pending, as `pending_email`, until
+   * it is confirmed from the link mailed to it, and only then becomes the new
+   * login identity.
+   * @throws {AuthAPIError} AUTH_ERROR, BAD_EMAIL, BAD_PASSWORD, ALREADY_USED, UNKNOWN_ERROR
+   * @throws {GroupAPIError} GROUP_ARCHIVED
+   */
+  async updateUser(
+    update: { username?: string; email?: string },
+    credentials?: { email: string; password: string }
+  ): Promise<UserSelf> {
+    const request: UpdateUserRequest = { ...update };
+
+    if (credentials) {
+      let challenge: GetLoginChallengeResponse;
+      try {
+        challenge = await this.client.post<null, GetLoginChallengeResponse>(
+          `${AuthAPI.basePath}/me/challenge`,
+          null
+        );
+      } catch (error) {
+        if (error instanceof ApiError) {
+          if (error.status === 401)
+            throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        }
+        throw new AuthAPIError(
+          AuthAPIErrorCode.UNKNOWN_ERROR,
+          error,
+          "Failed to get login challenge"
+        );
+      }
+
+      const solve = await this.srp.solveChallenge(
+        challenge.user_challenge.server_pub_key,
+        credentials.email,
+        credentials.password,
+        challenge.user_challenge.salt
+      );
+      request.session_id = challenge.session_id;
+      request.user_auth = {
+        client_pub_key: solve.clientPublicEphemeral,
+        client_auth: solve.clientSession.proof,
+      };
+    }
+
+    try {
+      const { server_auth, ...user } = await this.client.put<
+        UpdateUserRequest,
+        UpdateUserResponse
+      >(`${AuthAPI.basePath}/me`, request);
+      return user;
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 400)
+          throw new AuthAPIError(AuthAPIErrorCode.BAD_EMAIL, error);
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.BAD_PASSWORD, error);
+        if (error.status === 409)
+          throw new AuthAPIError(
+            AuthAPIErrorCode.ALREADY_USED,
+            error,
+            "Username or email already used in the group"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new AuthAPIError(
+        AuthAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to update user"
+      );
+    }
+  }
+
+  /**
+   * Give a key pair of the mailbox to a user who joined before it existed.
+   * @throws {AuthAPIError} AUTH_ERROR, ALREADY_USED (The user already has one), UNKNOWN_ERROR
+   * @throws {GroupAPIError} GROUP_ARCHIVED
+   */
+  async updateMessageKey(request: UpdateMessageKeyRequest): Promise<UserSelf> {
+    try {
+      return await this.client.put<UpdateMessageKeyRequest, UserSelf>(
+        `${AuthAPI.basePath}/me/message-key`,
+        request
+      );
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 409)
+          throw new AuthAPIError(
+            AuthAPIErrorCode.ALREADY_USED,
+            error,
+            "Message key already set"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new AuthAPIError(
+        AuthAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to update message key"
+      );
+    }
+  }
+
+  /**
+   * Erase the account of the user.
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN, UNKNOWN_ERROR
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS
+   */
+  async deleteUser(): Promise<void> {
+    try {
+      await this.client.delete(`${AuthAPI.basePath}/me`);
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === 409)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_DONE,
+            error,
+            "Draw already done"
+          );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+      }
+      throw new AuthAPIError(
+        AuthAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to delete user"
+      );
+    }
+  }
+
   /**
    * Check if the group token is valid.
    */
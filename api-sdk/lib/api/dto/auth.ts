@@ -50,6 +50,19 @@ export interface LoginResponse {
   token: string;
 }
 
+// Profile update, empty fields are left unchanged
+export interface UpdateUserRequest {
+  username?: string;
+  email?: string;
+  /** Proof of the password, required to change the email */
+  session_id?: string;
+  user_auth?: SrpAuth;
+}
+
+export interface UpdateUserResponse extends UserSelf {
+  server_auth?: string;
+}
+
 // Authentication responses
 export interface AuthResponse {
   claims: {
@@ -3,11 +3,28 @@ import { CreateUserRequest, User } from "./user";
 export enum GroupAPIStatusCode {
   NOT_ENOUGH_USERS = 460,
   DRAW_SESSION_NOT_FOUND = 461,
+  DRAW_SESSION_OUTDATED = 462,
+  DRAW_IN_PROGRESS = 463,
+  GROUP_ARCHIVED = 464,
+  OVER_BUDGET = 465,
 }
 
+export type GroupState = "open" | "drawing" | "drawn" | "archived";
+
+/** Members give outside their team, members without a team to anyone */
+export type DrawRule = "" | "outside_team";
+
 export interface CreateGroupRequest {
   name: string;
   secret_verifier: string;
+  exchange_date?: string;
+  /**
+   * Title, url and notes of wish items encrypted under the group secret, can't be
+   * changed later. The price, currency and priority stay readable by the server.
+   */
+  encrypted_wishes?: boolean;
+  /** The admin is part of the draw unless false */
+  admin_participates?: boolean;
   admin: CreateUserRequest;
 }
 
@@ -18,15 +35,39 @@ export interface JoinGroupRequest {
 
 export interface InitDrawResponse {
   public_keys_secret: string[];
+  gifts_per_person: number;
+  /** Opaque tag of the team of each key for the rule of the group, empty for no team.
+   * Omitted without a rule. */
+  team_tags?: string[];
 }
 
 export interface FinishDrawRequest {
+  /** Key of the giver of each member of the draw session, once per gift */
   public_keys: string[];
+  /** Team tag of the giver of each key, required with a rule */
+  team_proofs?: string[];
 }
 
 export interface GroupModel {
   id: string;
   name: string;
+  exchange_date: string | null;
+  /** Maximum price of a gift in minor units of the currency */
+  budget: number | null;
+  currency: string;
+  /**
+   * The title, url and notes of wish items are encrypted under the group secret,
+   * the server can't read them. Their price, currency and priority stay in
+   * plaintext for the budget check.
+   */
+  encrypted_wishes: boolean;
+  /** Each participant gives this many gifts, to as many different members */
+  gifts_per_person: number;
+  /** Who members may give to according to their teams, anyone when empty */
+  draw_rule: DrawRule;
+  state: GroupState;
+  draw_round: number;
+  /** Every draw result of the round, each encrypted to the key of its giver */
   results?: string[];
   users: User[];
   created_at: string;
@@ -37,3 +78,27 @@ export interface GroupInfo {
   id: string;
   name: string;
 }
+
+export interface UpdateGroupSettingsRequest {
+  exchange_date: string | null;
+  budget?: number | null;
+  /** Required with a budget */
+  currency?: string;
+  /** Left unchanged when omitted, only before the draw */
+  gifts_per_person?: number;
+  /** Left unchanged when omitted, only before the draw */
+  draw_rule?: DrawRule;
+}
+
+export interface TransferAdminRequest {
+  user_id: string;
+}
+
+export interface SetParticipationRequest {
+  participates: boolean;
+}
+
+export interface SetTeamRequest {
+  /** Empty for no team */
+  team: string;
+}
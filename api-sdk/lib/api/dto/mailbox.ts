@@ -0,0 +1,84 @@
+/** Set by the santa up to shipped, then by the recipient */
+export type GiftStatus = "bought" | "shipped" | "received" | "thanked";
+
+export interface MailboxMessage {
+  id: string;
+  created_at: string;
+  from_santa: boolean;
+  /** JWE encrypted to both ends of the thread */
+  payload: string;
+  /** Decrypted payload, filled by the SDK */
+  text?: string;
+}
+
+export interface MailboxThread {
+  id: string;
+  created_at: string;
+  /** Time of the latest message */
+  updated_at: string;
+  /** Draw round the thread belongs to */
+  round: number;
+  recipient_id: string;
+  /** Draw result whose thread token started the thread, null for threads started before thread tokens */
+  result_id: string | null;
+  /** Public JWK the recipient encrypts their replies to */
+  reply_key: string;
+  /** Reply private key and sender token, encrypted by the santa to their own key */
+  sender_key: string;
+  /** Key of the recipient's address, encrypted by the santa to the recipient's message key */
+  address_key: string;
+  /** Shipping address encrypted by the recipient under the address key, only this santa can read it */
+  address: string;
+  /** Progress of the santa's gift, empty until set */
+  gift_status: GiftStatus | "";
+  gift_status_at: string | null;
+  messages: MailboxMessage[];
+}
+
+export interface StartThreadRequest {
+  recipient_id: string;
+  reply_key: string;
+  sender_key: string;
+  sender_token: string;
+  /** Token of your draw result for the recipient, binds the thread to it */
+  thread_token: string;
+  /** First message, omitted to open the thread only to track the gift */
+  payload?: string;
+  /** Key of the recipient's address, encrypted to their message key */
+  address_key?: string;
+}
+
+export interface PostMessageRequest {
+  /** Only sent by the santa */
+  sender_token?: string;
+  payload: string;
+}
+
+export interface SetGiftStatusRequest {
+  /** Only sent by the santa, for bought and shipped */
+  sender_token?: string;
+  status: GiftStatus;
+}
+
+export interface SetAddressKeyRequest {
+  sender_token: string;
+  /** Token of your draw result the thread is bound to */
+  thread_token: string;
+  address_key: string;
+}
+
+export interface SetAddressRequest {
+  /** Compact JWE under the address key of the thread, empty to remove it */
+  address: string;
+}
+
+/** Gifts of the latest draw, each recipient counted once per gift at their most advanced statuses */
+export interface GiftSummary {
+  round: number;
+  gifts: number;
+  not_bought: number;
+  bought: number;
+  shipped: number;
+  received: number;
+  thanked: number;
+}
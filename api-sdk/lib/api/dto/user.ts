@@ -1,17 +1,73 @@
+import { GroupState } from "./group";
+import { MailboxThread } from "./mailbox";
+
 export interface CreateUserRequest {
   username: string;
   email: string;
   password_verifier: string;
   public_key_secret: string;
   private_key_encrypted: string;
+  message_key_secret: string;
+  message_key_encrypted: string;
+}
+
+export interface WishItem {
+  id: string;
+  title: string;
+  url: string;
+  /**
+   * In minor units of the currency, e.g. cents. Like the currency and the
+   * priority, never encrypted: the server checks it against the budget.
+   */
+  price: number | null;
+  /** ISO 4217 code, empty without a price */
+  currency: string;
+  /** From 0 to 3, the most wanted */
+  priority: number;
+  notes: string;
+  order: number;
+  /**
+   * Title, url and notes encrypted under the group secret, in groups with
+   * encrypted wishes. Decrypted by the SDK, which fills them back.
+   */
+  payload: string;
+  /** Being bought by a member, always false on the user's own items */
+  claimed: boolean;
+  claimed_by_me: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface WishItemRequest {
+  /**
+   * Encrypted into the payload by the SDK in groups with encrypted wishes, with
+   * the url and notes. The price, currency and priority are sent in plaintext.
+   */
+  title: string;
+  url?: string;
+  price?: number | null;
+  /** Defaults to the currency of the group budget */
+  currency?: string;
+  priority?: number;
+  notes?: string;
+  /** Added last, or left unchanged, when omitted */
+  order?: number;
+  /** Set by the SDK in groups with encrypted wishes */
+  payload?: string;
 }
 
-export interface UpdateWishesRequest {
-  wishes: string;
+/** Fields of a wish item encrypted into its payload */
+export interface WishItemSecret {
+  title: string;
+  url: string;
+  notes: string;
 }
 
-export interface UpdateWishesResponse {
-  wishes: string;
+export interface UpdateMessageKeyRequest {
+  /** Public key of the mailbox encrypted with the group secret */
+  message_key_secret: string;
+  /** Private key of the mailbox encrypted with the password */
+  message_key_encrypted: string;
 }
 
 export interface User {
@@ -19,7 +75,14 @@ export interface User {
   username: string;
   email: string;
   is_admin: boolean;
-  wishes: string;
+  /** Left out of the draw when false, like an organiser */
+  participates: boolean;
+  /** Set by the admin, constrains the draw with the rule of the group */
+  team: string;
+  /** Public key of the mailbox encrypted with the group secret, to write to the user.
+   * Empty until the user logs in once, for members who joined before it existed. */
+  message_key_secret: string;
+  wishes: WishItem[];
   created_at: string;
 }
 
@@ -27,11 +90,54 @@ export interface UserSelf {
   id: string;
   username: string;
   email: string;
+  /** New email waiting to be confirmed from the link mailed to it */
+  pending_email?: string;
   group_id: string;
   is_admin: boolean;
+  participates: boolean;
+  team: string;
   public_key_secret: string;
   private_key_encrypted: string;
-  wishes: string;
+  /** Key pair of the mailbox, empty for members who joined before it existed */
+  message_key_secret: string;
+  message_key_encrypted: string;
+  wishes: WishItem[];
   created_at: string;
   updated_at: string;
 }
+
+export interface UserExport {
+  exported_at: string;
+  profile: UserSelf;
+  group: {
+    id: string;
+    name: string;
+    state: GroupState;
+    draw_round: number;
+    exchange_date: string | null;
+    joined_at: string;
+    is_admin: boolean;
+  };
+  notification_preferences: {
+    delivery: "immediate" | "digest";
+    events: Record<string, boolean>;
+  };
+  notifications: {
+    id: string;
+    created_at: string;
+    event: string;
+    subject: string;
+    data: Record<string, unknown> | null;
+    delivery: string;
+    sent_at: string | null;
+  }[];
+  claims: {
+    wish_item_id: string;
+    title: string;
+    /** Encrypted title, in groups with encrypted wishes */
+    payload: string;
+    claimed_at: string;
+  }[];
+  /** Threads addressed to the user, still encrypted */
+  mailbox: MailboxThread[];
+}
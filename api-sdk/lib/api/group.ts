@@ -8,15 +8,29 @@ import {
   GroupModel,
   InitDrawResponse,
   JoinGroupRequest,
+  SetParticipationRequest,
+  SetTeamRequest,
+  TransferAdminRequest,
+  UpdateGroupSettingsRequest,
 } from "./dto/group";
-import { UpdateWishesRequest, UpdateWishesResponse, User } from "./dto/user";
+import { User, WishItem, WishItemRequest } from "./dto/user";
 
 export enum GroupAPIErrorCode {
   GROUP_AUTH_ERROR = "GROUP_AUTH_ERROR",
 
   NOT_ENOUGH_USERS = "NOT_ENOUGH_USERS",
   DRAW_NOT_INITIED = "DRAW_NOT_INITIED",
+  DRAW_OUTDATED = "DRAW_OUTDATED",
   DRAW_DONE = "DRAW_DONE",
+  DRAW_IN_PROGRESS = "DRAW_IN_PROGRESS",
+  GROUP_ARCHIVED = "GROUP_ARCHIVED",
+
+  USER_NOT_FOUND = "USER_NOT_FOUND",
+  WISH_ITEM_NOT_FOUND = "WISH_ITEM_NOT_FOUND",
+  INVALID_WISH_ITEM = "INVALID_WISH_ITEM",
+  OVER_BUDGET = "OVER_BUDGET",
+  OWN_WISH_ITEM = "OWN_WISH_ITEM",
+  WISH_ITEM_CLAIMED = "WISH_ITEM_CLAIMED",
 
   UNKNOWN_ERROR = "UNKNOWN_ERROR",
 }
@@ -52,19 +66,27 @@ export class GroupAPI {
       passwordVerifier: string;
       privateKeyEncrypted: string;
       publicKeySecret: string;
-    }
+      messageKeySecret: string;
+      messageKeyEncrypted: string;
+    },
+    encryptedWishes = false,
+    adminParticipates = true
   ): Promise<GroupModel> {
     const group = await this.client.post<CreateGroupRequest, GroupModel>(
       `${GroupAPI.basePath}`,
       {
         name,
         secret_verifier: encodedKeys.secretVerifier,
+        encrypted_wishes: encryptedWishes,
+        admin_participates: adminParticipates,
         admin: {
           email: admin.email,
           username: admin.username,
           password_verifier: encodedKeys.passwordVerifier,
           public_key_secret: encodedKeys.publicKeySecret,
           private_key_encrypted: encodedKeys.privateKeyEncrypted,
+          message_key_secret: encodedKeys.messageKeySecret,
+          message_key_encrypted: encodedKeys.messageKeyEncrypted,
         },
       }
     );
@@ -78,6 +100,8 @@ export class GroupAPI {
       passwordVerifier: string;
       privateKeyEncrypted: string;
       publicKeySecret: string;
+      messageKeySecret: string;
+      messageKeyEncrypted: string;
     }
   ): Promise<User> {
     const groupToken = this.authContext.getGroupToken();
@@ -89,19 +113,31 @@ export class GroupAPI {
       );
     }
 
-    return await this.client.post<JoinGroupRequest, User>(
-      `${GroupAPI.basePath}/join`,
-      {
-        group_token: groupToken,
-        user: {
-          username: user.username,
-          email: user.email,
-          password_verifier: encodedKeys.passwordVerifier,
-          public_key_secret: encodedKeys.publicKeySecret,
-          private_key_encrypted: encodedKeys.privateKeyEncrypted,
-        },
-      }
-    );
+    try {
+      return await this.client.post<JoinGroupRequest, User>(
+        `${GroupAPI.basePath}/join`,
+        {
+          group_token: groupToken,
+          user: {
+            username: user.username,
+            email: user.email,
+            password_verifier: encodedKeys.passwordVerifier,
+            public_key_secret: encodedKeys.publicKeySecret,
+            private_key_encrypted: encodedKeys.privateKeyEncrypted,
+            message_key_secret: encodedKeys.messageKeySecret,
+            message_key_encrypted: encodedKeys.messageKeyEncrypted,
+          },
+        }
+      );
+    } catch (error) {
+      if (error instanceof ApiError && error.status === 409)
+        throw new AuthAPIError(
+          AuthAPIErrorCode.ALREADY_USED,
+          error,
+          "Username or email already used in the group"
+        );
+      throw error;
+    }
   }
 
   async getGroupInfo(groupID: string): Promise<GroupInfo | null> {
@@ -121,9 +157,12 @@ export class GroupAPI {
     }
   }
 
+  /**
+   * Every result of the draw comes along, the caller keeps those its key decrypts
+   */
   async getGroup(): Promise<GroupModel> {
     try {
-      return await this.client.get<GroupModel>(`${GroupAPI.basePath}`);
+      return await this.client.get<GroupModel>(GroupAPI.basePath);
     } catch (error) {
       if (error instanceof ApiError) {
         // 400 should not occur
@@ -138,37 +177,205 @@ export class GroupAPI {
     }
   }
 
-  async updateWishes(wishes: string): Promise<string> {
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   */
+  async getWishItems(): Promise<WishItem[]> {
     try {
-      const { wishes: newWishes } = await this.client.put<
-        UpdateWishesRequest,
-        UpdateWishesResponse
-      >(`${GroupAPI.basePath}/wishes`, { wishes });
-      return newWishes;
+      return await this.client.get<WishItem[]>(`${GroupAPI.basePath}/wishes`);
     } catch (error) {
       if (error instanceof ApiError) {
-        // 400 and 404 should not occur
         if (error.status === 401)
           throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
       }
       throw new GroupAPIError(
         GroupAPIErrorCode.UNKNOWN_ERROR,
         error,
-        "Failed to update wishes"
+        "Failed to get wishes"
       );
     }
   }
 
   /**
    *
-   * @throws {GroupAPIError} NOT_ENOUGH_USERS, DRAW_NOT_INITIED
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} INVALID_WISH_ITEM, OVER_BUDGET, GROUP_ARCHIVED
    */
-  async initDraw(): Promise<string[]> {
+  async createWishItem(item: WishItemRequest): Promise<WishItem> {
     try {
-      const { public_keys_secret } = await this.client.get<InitDrawResponse>(
+      return await this.client.post<WishItemRequest, WishItem>(
+        `${GroupAPI.basePath}/wishes`,
+        item
+      );
+    } catch (error) {
+      this.throwWishItemError(error, "Failed to add wish");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, INVALID_WISH_ITEM, OVER_BUDGET, GROUP_ARCHIVED
+   */
+  async updateWishItem(
+    itemID: string,
+    item: WishItemRequest
+  ): Promise<WishItem> {
+    try {
+      return await this.client.put<WishItemRequest, WishItem>(
+        `${GroupAPI.basePath}/wishes/${itemID}`,
+        item
+      );
+    } catch (error) {
+      this.throwWishItemError(error, "Failed to update wish");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, GROUP_ARCHIVED
+   */
+  async deleteWishItem(itemID: string): Promise<void> {
+    try {
+      await this.client.delete(`${GroupAPI.basePath}/wishes/${itemID}`);
+    } catch (error) {
+      this.throwWishItemError(error, "Failed to delete wish");
+    }
+  }
+
+  /**
+   * Mark a wish of another member as being bought, the owner doesn't see it.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, OWN_WISH_ITEM, WISH_ITEM_CLAIMED, GROUP_ARCHIVED
+   */
+  async claimWishItem(itemID: string): Promise<WishItem> {
+    try {
+      return await this.client.post<null, WishItem>(
+        `${GroupAPI.basePath}/wishes/${itemID}/claim`,
+        null
+      );
+    } catch (error) {
+      this.throwWishItemError(error, "Failed to claim wish");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, WISH_ITEM_CLAIMED (not by the user), GROUP_ARCHIVED
+   */
+  async unclaimWishItem(itemID: string): Promise<WishItem> {
+    try {
+      return await this.client.delete<WishItem>(
+        `${GroupAPI.basePath}/wishes/${itemID}/claim`
+      );
+    } catch (error) {
+      this.throwWishItemError(error, "Failed to unclaim wish");
+    }
+  }
+
+  private throwWishItemError(error: unknown, message: string): never {
+    if (error instanceof ApiError) {
+      if (error.status === 401)
+        throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+      if (error.status === 403)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.OWN_WISH_ITEM,
+          error,
+          "You can't claim your own wish"
+        );
+      if (error.status === 409)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.WISH_ITEM_CLAIMED,
+          error,
+          "Wish claimed by another member"
+        );
+      if (error.status === 400)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.INVALID_WISH_ITEM,
+          error,
+          "Invalid wish"
+        );
+      if (error.status === 404)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.WISH_ITEM_NOT_FOUND,
+          error,
+          "Wish not found"
+        );
+      if (error.status === GroupAPIStatusCode.OVER_BUDGET)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.OVER_BUDGET,
+          error,
+          "Price exceeds the group budget"
+        );
+      if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+        throw new GroupAPIError(
+          GroupAPIErrorCode.GROUP_ARCHIVED,
+          error,
+          "Group archived"
+        );
+    }
+    throw new GroupAPIError(GroupAPIErrorCode.UNKNOWN_ERROR, error, message);
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async updateSettings(
+    settings: UpdateGroupSettingsRequest
+  ): Promise<GroupModel> {
+    try {
+      return await this.client.put<UpdateGroupSettingsRequest, GroupModel>(
+        `${GroupAPI.basePath}/settings`,
+        settings
+      );
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === 409)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_DONE,
+            error,
+            "Draw already done"
+          );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to update settings"
+      );
+    }
+  }
+
+  /**
+   *
+   * @throws {GroupAPIError} NOT_ENOUGH_USERS, GROUP_ARCHIVED
+   */
+  async initDraw(): Promise<InitDrawResponse> {
+    try {
+      return await this.client.get<InitDrawResponse>(
         `${GroupAPI.basePath}/draw`
       );
-      return public_keys_secret;
     } catch (error) {
       if (error instanceof ApiError) {
         if (error.status === GroupAPIStatusCode.NOT_ENOUGH_USERS)
@@ -183,6 +390,12 @@ export class GroupAPI {
             error,
             "Draw already done"
           );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
         if (error.status === 401)
           throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
         if (error.status === 403)
@@ -198,14 +411,18 @@ export class GroupAPI {
 
   /**
    *
-   * @throws {GroupAPIError} DRAW_NOT_INITIED, DRAW_DONE
+   * @throws {GroupAPIError} DRAW_NOT_INITIED, DRAW_OUTDATED, DRAW_DONE, GROUP_ARCHIVED
    */
-  async finishDraw(publicKeys: JsonWebKey[]): Promise<void> {
+  async finishDraw(
+    publicKeys: JsonWebKey[],
+    teamProofs?: string[]
+  ): Promise<void> {
     try {
       await this.client.post<FinishDrawRequest, null>(
         `${GroupAPI.basePath}/draw`,
         {
           public_keys: publicKeys.map((key) => JSON.stringify(key)),
+          team_proofs: teamProofs,
         }
       );
     } catch (error) {
@@ -216,12 +433,24 @@ export class GroupAPI {
             error,
             "Draw not initied or too old. init draw again"
           );
+        if (error.status === GroupAPIStatusCode.DRAW_SESSION_OUTDATED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_OUTDATED,
+            error,
+            "Group members changed since draw init. init draw again"
+          );
         if (error.status === 409)
           throw new GroupAPIError(
             GroupAPIErrorCode.DRAW_DONE,
             error,
             "Draw already done"
           );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
         if (error.status === 401)
           throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
         if (error.status === 403)
@@ -238,7 +467,71 @@ export class GroupAPI {
   /**
    *
    * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
-   * @throws {GroupAPIError} DRAW_DONE
+   * @throws {GroupAPIError} DRAW_NOT_INITIED, DRAW_DONE
+   */
+  async cancelDraw(): Promise<void> {
+    try {
+      await this.client.delete(`${GroupAPI.basePath}/draw`);
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === GroupAPIStatusCode.DRAW_SESSION_NOT_FOUND)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_NOT_INITIED,
+            error,
+            "No draw in progress"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to cancel draw"
+      );
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async archiveGroup(): Promise<void> {
+    try {
+      await this.client.post<null, null>(`${GroupAPI.basePath}/archive`, null);
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to archive group"
+      );
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
    */
   async deleteUser(userID: string): Promise<void> {
     try {
@@ -255,6 +548,18 @@ export class GroupAPI {
             error,
             "Draw already done"
           );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
       }
       throw new GroupAPIError(
         GroupAPIErrorCode.UNKNOWN_ERROR,
@@ -267,7 +572,7 @@ export class GroupAPI {
   /**
    *
    * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
-   * @throws {GroupAPIError} DRAW_DONE
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
    * */
   async leaveGroup(): Promise<void> {
     try {
@@ -284,6 +589,18 @@ export class GroupAPI {
             error,
             "Draw already done"
           );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
       }
       throw new GroupAPIError(
         GroupAPIErrorCode.UNKNOWN_ERROR,
@@ -292,4 +609,138 @@ export class GroupAPI {
       );
     }
   }
+
+  /**
+   * Put a member in or out of the draw.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND, DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async setParticipation(userID: string, participates: boolean): Promise<User> {
+    try {
+      return await this.client.put<SetParticipationRequest, User>(
+        `${GroupAPI.basePath}/user/${userID}/participation`,
+        { participates }
+      );
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === 404)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.USER_NOT_FOUND,
+            error,
+            "User not found in group"
+          );
+        if (error.status === 409)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_DONE,
+            error,
+            "Draw already done"
+          );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to set participation"
+      );
+    }
+  }
+
+  /**
+   * Put a member in a team, or in none with an empty team.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND, DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async setTeam(userID: string, team: string): Promise<User> {
+    try {
+      return await this.client.put<SetTeamRequest, User>(
+        `${GroupAPI.basePath}/user/${userID}/team`,
+        { team }
+      );
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === 404)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.USER_NOT_FOUND,
+            error,
+            "User not found in group"
+          );
+        if (error.status === 409)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_DONE,
+            error,
+            "Draw already done"
+          );
+        if (error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.DRAW_IN_PROGRESS,
+            error,
+            "Draw in progress"
+          );
+        if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.GROUP_ARCHIVED,
+            error,
+            "Group archived"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to set team"
+      );
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND
+   */
+  async transferAdmin(userID: string): Promise<User> {
+    try {
+      return await this.client.put<TransferAdminRequest, User>(
+        `${GroupAPI.basePath}/admin`,
+        { user_id: userID }
+      );
+    } catch (error) {
+      if (error instanceof ApiError) {
+        if (error.status === 401)
+          throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+        if (error.status === 403)
+          throw new AuthAPIError(AuthAPIErrorCode.FORBIDDEN, error);
+        if (error.status === 404)
+          throw new GroupAPIError(
+            GroupAPIErrorCode.USER_NOT_FOUND,
+            error,
+            "User not found in group"
+          );
+      }
+      throw new GroupAPIError(
+        GroupAPIErrorCode.UNKNOWN_ERROR,
+        error,
+        "Failed to transfer admin"
+      );
+    }
+  }
 }
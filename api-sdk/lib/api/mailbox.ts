@@ -0,0 +1,280 @@
+import { AuthAPIError, AuthAPIErrorCode } from "./auth";
+import { ApiClient, ApiError } from "./client";
+import { GroupAPIStatusCode } from "./dto/group";
+import {
+  GiftSummary,
+  MailboxMessage,
+  MailboxThread,
+  PostMessageRequest,
+  SetAddressKeyRequest,
+  SetAddressRequest,
+  SetGiftStatusRequest,
+  StartThreadRequest,
+} from "./dto/mailbox";
+
+export enum MailboxAPIErrorCode {
+  THREAD_NOT_FOUND = "THREAD_NOT_FOUND",
+  USER_NOT_FOUND = "USER_NOT_FOUND",
+  INVALID_MESSAGE = "INVALID_MESSAGE",
+  FORBIDDEN = "FORBIDDEN",
+  INVALID_THREAD_TOKEN = "INVALID_THREAD_TOKEN",
+  UNBOUND_THREAD = "UNBOUND_THREAD",
+  THREAD_CLOSED = "THREAD_CLOSED",
+  THREAD_EXISTS = "THREAD_EXISTS",
+  GIFT_STATUS_BACKWARD = "GIFT_STATUS_BACKWARD",
+  ADDRESS_KEY_SET = "ADDRESS_KEY_SET",
+  NO_ADDRESS_KEY = "NO_ADDRESS_KEY",
+  DRAW_NOT_DONE = "DRAW_NOT_DONE",
+  GROUP_ARCHIVED = "GROUP_ARCHIVED",
+
+  UNKNOWN_ERROR = "UNKNOWN_ERROR",
+}
+
+export class MailboxAPIError extends Error {
+  constructor(
+    public code: MailboxAPIErrorCode,
+    error: unknown = null,
+    message: string = "Unknown error"
+  ) {
+    super(
+      error instanceof Error
+        ? `${message} : [${error.name}] ${error.message}`
+        : message
+    );
+    this.name = "MailboxAPIError";
+  }
+}
+
+export class MailboxAPI {
+  private static basePath = "/mailbox";
+
+  constructor(private client: ApiClient) {}
+
+  /**
+   * Get the threads addressed to a member of the latest draw, the user's own by default.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} USER_NOT_FOUND
+   */
+  async getThreads(recipientID?: string): Promise<MailboxThread[]> {
+    const query = recipientID
+      ? `?recipient_id=${encodeURIComponent(recipientID)}`
+      : "";
+    try {
+      return await this.client.get<MailboxThread[]>(
+        `${MailboxAPI.basePath}/threads${query}`
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to get threads");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} USER_NOT_FOUND, INVALID_MESSAGE, INVALID_THREAD_TOKEN, THREAD_EXISTS, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async startThread(request: StartThreadRequest): Promise<MailboxThread> {
+    try {
+      return await this.client.post<StartThreadRequest, MailboxThread>(
+        `${MailboxAPI.basePath}/threads`,
+        request
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to start thread");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, INVALID_MESSAGE, FORBIDDEN, THREAD_CLOSED, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async postMessage(
+    threadID: string,
+    request: PostMessageRequest
+  ): Promise<MailboxMessage> {
+    try {
+      return await this.client.post<PostMessageRequest, MailboxMessage>(
+        `${MailboxAPI.basePath}/threads/${threadID}/messages`,
+        request
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to post message");
+    }
+  }
+
+  /**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, INVALID_MESSAGE, FORBIDDEN, UNBOUND_THREAD, THREAD_CLOSED, GIFT_STATUS_BACKWARD, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async setGiftStatus(
+    threadID: string,
+    request: SetGiftStatusRequest
+  ): Promise<MailboxThread> {
+    try {
+      return await this.client.put<SetGiftStatusRequest, MailboxThread>(
+        `${MailboxAPI.basePath}/threads/${threadID}/status`,
+        request
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to set gift status");
+    }
+  }
+
+  /**
+   * Give the recipient of a thread the key of their address, once.
+   *
+   * **Only the santa of the thread can give it**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, INVALID_MESSAGE, FORBIDDEN, THREAD_CLOSED, ADDRESS_KEY_SET, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async setAddressKey(
+    threadID: string,
+    request: SetAddressKeyRequest
+  ): Promise<MailboxThread> {
+    try {
+      return await this.client.put<SetAddressKeyRequest, MailboxThread>(
+        `${MailboxAPI.basePath}/threads/${threadID}/address-key`,
+        request
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to set address key");
+    }
+  }
+
+  /**
+   * Set the shipping address of the recipient of a thread, encrypted under its address key.
+   * Empty to remove it.
+   *
+   * **Only the recipient of the thread can set it**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, INVALID_MESSAGE, FORBIDDEN, THREAD_CLOSED, NO_ADDRESS_KEY, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async setAddress(
+    threadID: string,
+    request: SetAddressRequest
+  ): Promise<MailboxThread> {
+    try {
+      return await this.client.put<SetAddressRequest, MailboxThread>(
+        `${MailboxAPI.basePath}/threads/${threadID}/address`,
+        request
+      );
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to set address");
+    }
+  }
+
+  /**
+   * Count the gifts of the latest draw by progress.
+   *
+   * **Only the admin can get the summary**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} FORBIDDEN
+   */
+  async getGiftSummary(): Promise<GiftSummary> {
+    try {
+      return await this.client.get<GiftSummary>(`${MailboxAPI.basePath}/gifts`);
+    } catch (error) {
+      this.throwMailboxError(error, "Failed to get gift summary");
+    }
+  }
+
+  private throwMailboxError(error: unknown, message: string): never {
+    if (error instanceof ApiError) {
+      if (error.status === 401)
+        throw new AuthAPIError(AuthAPIErrorCode.AUTH_ERROR, error);
+      if (error.status === 400)
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.INVALID_MESSAGE,
+          error,
+          "Invalid message"
+        );
+      if (error.status === 403 && error.message === "Invalid thread token")
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.INVALID_THREAD_TOKEN,
+          error,
+          "The thread token matches none of your results"
+        );
+      if (
+        error.status === 403 &&
+        error.message === "Thread bound to no draw result"
+      )
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.UNBOUND_THREAD,
+          error,
+          "The thread was started before thread tokens and tracks nothing"
+        );
+      if (error.status === 403)
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.FORBIDDEN,
+          error,
+          "Only the recipient and their santa can write in a thread"
+        );
+      if (error.status === 404)
+        throw new MailboxAPIError(
+          error.message === "Thread not found"
+            ? MailboxAPIErrorCode.THREAD_NOT_FOUND
+            : MailboxAPIErrorCode.USER_NOT_FOUND,
+          error,
+          "Not found"
+        );
+      if (
+        error.status === 409 &&
+        error.message === "Gift status can only move forward"
+      )
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.GIFT_STATUS_BACKWARD,
+          error,
+          "Gift status can only move forward"
+        );
+      if (error.status === 409 && error.message === "Thread already started")
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.THREAD_EXISTS,
+          error,
+          "A thread was already started for this result"
+        );
+      if (error.status === 409 && error.message === "Address key already set")
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.ADDRESS_KEY_SET,
+          error,
+          "Address key already set"
+        );
+      if (
+        error.status === 409 &&
+        error.message === "No address key in the thread"
+      )
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.NO_ADDRESS_KEY,
+          error,
+          "The santa has not given the address key yet"
+        );
+      if (error.status === 409)
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.THREAD_CLOSED,
+          error,
+          "Thread belongs to a previous draw"
+        );
+      if (
+        error.status === GroupAPIStatusCode.DRAW_SESSION_NOT_FOUND ||
+        error.status === GroupAPIStatusCode.DRAW_IN_PROGRESS
+      )
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.DRAW_NOT_DONE,
+          error,
+          "The draw is not done"
+        );
+      if (error.status === GroupAPIStatusCode.GROUP_ARCHIVED)
+        throw new MailboxAPIError(
+          MailboxAPIErrorCode.GROUP_ARCHIVED,
+          error,
+          "Group archived"
+        );
+    }
+    throw new MailboxAPIError(MailboxAPIErrorCode.UNKNOWN_ERROR, error, message);
+  }
+}
@@ -89,4 +89,99 @@ export class AES {
       );
     }
   }
+
+  /**
+   * Generate a random key encrypting data, to be wrapped with a secret key.
+   */
+  async generateDataKey(): Promise<CryptoKey> {
+    return await crypto.subtle.generateKey(
+      {
+        name: "AES-GCM",
+        length: 128,
+      },
+      true,
+      ["encrypt", "decrypt"]
+    );
+  }
+
+  async wrapDataKey(
+    key: CryptoKey,
+    wrapingKey: CryptoKey,
+    iv: ArrayBuffer
+  ): Promise<ArrayBuffer> {
+    try {
+      return await crypto.subtle.wrapKey("raw", key, wrapingKey, {
+        name: "AES-GCM",
+        iv,
+      });
+    } catch (error) {
+      throw new CryptoError(
+        CryptoErrorCode.WRAP_FAILED,
+        error,
+        "Failed to wrap data key"
+      );
+    }
+  }
+
+  async unwrapDataKey(
+    wrapedKey: ArrayBuffer,
+    wrapingKey: CryptoKey,
+    iv: ArrayBuffer
+  ): Promise<CryptoKey> {
+    try {
+      return await crypto.subtle.unwrapKey(
+        "raw",
+        wrapedKey,
+        wrapingKey,
+        {
+          name: "AES-GCM",
+          iv,
+        },
+        {
+          name: "AES-GCM",
+          length: 128,
+        },
+        false,
+        ["decrypt"]
+      );
+    } catch (error) {
+      throw new CryptoError(
+        CryptoErrorCode.UNWRAP_FAILED,
+        error,
+        "Failed to unwrap data key"
+      );
+    }
+  }
+
+  async encrypt(
+    data: BufferSource,
+    key: CryptoKey,
+    iv: ArrayBuffer
+  ): Promise<ArrayBuffer> {
+    try {
+      return await crypto.subtle.encrypt({ name: "AES-GCM", iv }, key, data);
+    } catch (error) {
+      throw new CryptoError(
+        CryptoErrorCode.ENCRYPTION_FAILED,
+        error,
+        "Failed to encrypt data"
+      );
+    }
+  }
+
+  async decrypt(
+    data: ArrayBuffer,
+    key: CryptoKey,
+    iv: ArrayBuffer
+  ): Promise<ArrayBuffer> {
+    try {
+      return await crypto.subtle.decrypt({ name: "AES-GCM", iv }, key, data);
+    } catch (error) {
+      throw new CryptoError(
+        CryptoErrorCode.DECRYPTION_FAILED,
+        error,
+        "Failed to decrypt data"
+      );
+    }
+  }
 }
@@ -1,8 +1,31 @@
-import { compactDecrypt } from "jose";
+import {
+  CompactEncrypt,
+  compactDecrypt,
+  GeneralEncrypt,
+  generalDecrypt,
+} from "jose";
 import { AES } from "./crypto/aes";
 import { RSA } from "./crypto/rsa";
 import { SRP } from "./crypto/srp";
 import { CryptoUtils } from "./crypto/utils";
+import { WishItemSecret } from "./api/dto/user";
+
+/** Keys of a mailbox thread, only known by the santa who started it */
+export interface ThreadKeys {
+  /** Private key the recipient's replies are encrypted to */
+  replyKey: CryptoKey;
+  /** Proves to the server that a message comes from the santa */
+  senderToken: string;
+  /** Key the recipient encrypts their address under, derived from the sender token */
+  addressKey: Uint8Array;
+}
+
+/** Result of the draw for one gift, only readable by its giver */
+export interface DrawResult {
+  userID: string;
+  /** Proves to the server that the giver may start the thread with the recipient, undefined before thread tokens */
+  threadToken?: string;
+}
 
 export enum CryptoContextErrorCode {
   OVERWRITE = "OVERWRITE",
@@ -11,6 +34,7 @@ export enum CryptoContextErrorCode {
   NOT_FOUND = "NOT_FOUND",
 
   INVALID_PUBLIC_KEY = "INVALID_PUBLIC_KEY",
+  INVALID_PAYLOAD = "INVALID_PAYLOAD",
 
   UNKNOWN_ERROR = "UNKNOWN_ERROR",
 }
@@ -27,8 +51,11 @@ export class CryptoContext {
 
   private secretKey: CryptoKey | null = null;
   private privateKey: CryptoKey | null = null;
+  /** Private key of the mailbox, apart from the key of the draw */
+  private messageKey: CryptoKey | null = null;
 
   private textDecoder = new TextDecoder();
+  private textEncoder = new TextEncoder();
 
   constructor(
     private cryptoUtils: CryptoUtils,
@@ -52,6 +79,9 @@ export class CryptoContext {
   hasPrivateKey() {
     return this.privateKey !== null;
   }
+  hasMessageKey() {
+    return this.messageKey !== null;
+  }
 
   async importPrivateKey(
     passwordKey: CryptoKey,
@@ -70,18 +100,45 @@ export class CryptoContext {
 
     return this;
   }
+
+  async importMessageKey(
+    passwordKey: CryptoKey,
+    wrappedMessageKeyBase64: string
+  ) {
+    const { wrappedKey, iv } = this.cryptoUtils.Base64ToWrapped(
+      wrappedMessageKeyBase64
+    );
+
+    this.messageKey = await this.rsa.unwrapKey(
+      wrappedKey,
+      passwordKey,
+      iv,
+      true
+    );
+
+    return this;
+  }
   isComplete() {
-    return this.secretKey !== null && this.privateKey !== null;
+    return (
+      this.secretKey !== null &&
+      this.privateKey !== null &&
+      this.messageKey !== null
+    );
   }
 
   async saveToLocalStorage() {
-    if (this.secretKey === null || this.privateKey === null) {
+    if (
+      this.secretKey === null ||
+      this.privateKey === null ||
+      this.messageKey === null
+    ) {
       throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
     }
 
-    const [secretKey, privateKey] = await Promise.all([
+    const [secretKey, privateKey, messageKey] = await Promise.all([
       this.aes.exportKey(this.secretKey),
       this.rsa.exportKey(this.privateKey),
+      this.rsa.exportKey(this.messageKey),
     ]);
 
     localStorage.setItem(
@@ -89,6 +146,7 @@ export class CryptoContext {
       JSON.stringify({
         secretKey,
         privateKey,
+        messageKey,
       })
     );
   }
@@ -107,14 +165,15 @@ export class CryptoContext {
       throw new CryptoContextError(CryptoContextErrorCode.NOT_FOUND);
     }
 
-    const { secretKey, privateKey } = JSON.parse(data);
+    const { secretKey, privateKey, messageKey } = JSON.parse(data);
 
-    if (!secretKey || !privateKey) {
+    if (!secretKey || !privateKey || !messageKey) {
       throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
     }
 
     this.secretKey = await this.aes.importKey(secretKey);
     this.privateKey = await this.rsa.importKey(privateKey, true);
+    this.messageKey = await this.rsa.importKey(messageKey, true);
 
     return this;
   }
@@ -122,6 +181,7 @@ export class CryptoContext {
   clear() {
     this.secretKey = null;
     this.privateKey = null;
+    this.messageKey = null;
     localStorage.removeItem(CryptoContext.LocalStorageKey);
   }
 
@@ -153,10 +213,11 @@ export class CryptoContext {
   }
 
   /**
-   * Generate the srp password verifier and a new key pair for the user.
+   * Generate the srp password verifier and new key pairs for the user,
+   * one for the draw and one for the mailbox.
    *
-   * - Encrypt the private key with the password key.
-   * - Encrypt the public key with the secret key.
+   * - Encrypt the private keys with the password key.
+   * - Encrypt the public keys with the secret key.
    *
    * @throws {CryptoContextError} OVERWRITE, MISSING_SECRET_KEY
    */
@@ -164,6 +225,8 @@ export class CryptoContext {
     passwordVerifierEncoded: string;
     publicKeySecretEncoded: string;
     privateKeyEncryptedEncoded: string;
+    messageKeySecretEncoded: string;
+    messageKeyEncryptedEncoded: string;
   }> {
     if (!this.secretKey) {
       throw new CryptoContextError(CryptoContextErrorCode.MISSING_SECRET_KEY);
@@ -208,6 +271,53 @@ export class CryptoContext {
         privateKey,
         ivPrivateKey
       ),
+      ...(await this.createMessageKeys(passwordKey)),
+    };
+  }
+
+  /**
+   * Generate a new key pair for the mailbox of the user, members who joined
+   * before it existed get one at login.
+   *
+   * - Encrypt the private key with the password key.
+   * - Encrypt the public key with the secret key.
+   *
+   * @throws {CryptoContextError} MISSING_SECRET_KEY
+   */
+  async createMessageKeys(passwordKey: CryptoKey): Promise<{
+    messageKeySecretEncoded: string;
+    messageKeyEncryptedEncoded: string;
+  }> {
+    if (!this.secretKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.MISSING_SECRET_KEY);
+    }
+
+    const keyPair = await this.rsa.generateKeyPair();
+    this.messageKey = keyPair.privateKey;
+
+    const ivPrivateKey = await this.aes.generateIV();
+    const privateKey = await this.rsa.wrapKey(
+      keyPair.privateKey,
+      passwordKey,
+      ivPrivateKey
+    );
+
+    const ivPublicKey = await this.aes.generateIV();
+    const publicKey = await this.rsa.wrapKey(
+      keyPair.publicKey,
+      this.secretKey,
+      ivPublicKey
+    );
+
+    return {
+      messageKeySecretEncoded: this.cryptoUtils.wrappedToBase64(
+        publicKey,
+        ivPublicKey
+      ),
+      messageKeyEncryptedEncoded: this.cryptoUtils.wrappedToBase64(
+        privateKey,
+        ivPrivateKey
+      ),
     };
   }
 
@@ -239,19 +349,298 @@ export class CryptoContext {
 
   /**
    * Decrypt the result using the private key.
+   *
+   * Results hold the recipient's ID and the token starting the thread with them as JSON,
+   * results drawn before thread tokens only the ID.
    * @throws {CryptoContextError} INCOMPLETE
-   * @returns {string | null} The decrypted result or null if the decryption failed
+   * @returns {DrawResult | null} The decrypted result or null if the decryption failed
    */
-  async decryptResult(result: string): Promise<string | null> {
+  async decryptResult(result: string): Promise<DrawResult | null> {
     if (!this.privateKey) {
       throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
     }
 
+    let plaintext: string;
     try {
-      const { plaintext } = await compactDecrypt(result, this.privateKey);
+      plaintext = this.textDecoder.decode(
+        (await compactDecrypt(result, this.privateKey)).plaintext
+      );
+    } catch (error) {
+      return null;
+    }
+
+    try {
+      const { user_id, thread_token } = JSON.parse(plaintext);
+      if (typeof user_id === "string") {
+        return { userID: user_id, threadToken: thread_token };
+      }
+    } catch (error) {
+      // A user ID alone
+    }
+    return { userID: plaintext };
+  }
+
+  /**
+   * Encrypt the fields of a wish item with the secret key, so only the group can read them.
+   *
+   * A new key encrypts the fields and is wrapped with the secret key,
+   * the payload is `ivKey.wrappedKey.ivData.data` in base64.
+   * @throws {CryptoContextError} MISSING_SECRET_KEY
+   */
+  async encryptWish(wish: WishItemSecret): Promise<string> {
+    if (!this.secretKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.MISSING_SECRET_KEY);
+    }
+
+    const dataKey = await this.aes.generateDataKey();
+
+    const ivKey = await this.aes.generateIV();
+    const wrappedKey = await this.aes.wrapDataKey(
+      dataKey,
+      this.secretKey,
+      ivKey
+    );
+
+    const ivData = await this.aes.generateIV();
+    const data = await this.aes.encrypt(
+      this.textEncoder.encode(JSON.stringify(wish)),
+      dataKey,
+      ivData
+    );
+
+    return (
+      this.cryptoUtils.wrappedToBase64(wrappedKey, ivKey) +
+      "." +
+      this.cryptoUtils.wrappedToBase64(data, ivData)
+    );
+  }
+
+  /**
+   * Decrypt the fields of a wish item encrypted with encryptWish.
+   * @throws {CryptoContextError} MISSING_SECRET_KEY, INVALID_PAYLOAD
+   * @throws {CryptoError} UNWRAP_FAILED (The payload was probably not encrypted with this secret key)
+   */
+  async decryptWish(payload: string): Promise<WishItemSecret> {
+    if (!this.secretKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.MISSING_SECRET_KEY);
+    }
+
+    const parts = payload.split(".");
+    if (parts.length !== 4) {
+      throw new CryptoContextError(CryptoContextErrorCode.INVALID_PAYLOAD);
+    }
+    const key = this.cryptoUtils.Base64ToWrapped(parts[0] + "." + parts[1]);
+    const data = this.cryptoUtils.Base64ToWrapped(parts[2] + "." + parts[3]);
+
+    const dataKey = await this.aes.unwrapDataKey(
+      key.wrappedKey,
+      this.secretKey,
+      key.iv
+    );
+    const plaintext = await this.aes.decrypt(data.wrappedKey, dataKey, data.iv);
+
+    try {
+      const { title, url, notes } = JSON.parse(
+        this.textDecoder.decode(plaintext)
+      );
+      return { title: title ?? "", url: url ?? "", notes: notes ?? "" };
+    } catch (error) {
+      throw new CryptoContextError(CryptoContextErrorCode.INVALID_PAYLOAD);
+    }
+  }
+
+  /**
+   * Get the user's public key of the mailbox, from its private key.
+   * @throws {CryptoContextError} INCOMPLETE
+   */
+  async getMessagePublicKey(): Promise<JsonWebKey> {
+    if (!this.messageKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
+    }
+
+    const { kty, n, e } = await this.rsa.exportKey(this.messageKey);
+    return { kty, n, e, alg: "RSA-OAEP-256", ext: true, key_ops: ["encrypt"] };
+  }
+
+  /**
+   * Generate the keys of a new mailbox thread.
+   *
+   * The reply key pair is new, its private key and the sender token are encrypted
+   * to the user's own public key of the mailbox so only they can open the thread again.
+   * @throws {CryptoContextError} INCOMPLETE
+   */
+  async createThreadKeys(): Promise<{
+    keys: ThreadKeys;
+    replyKeyEncoded: string;
+    senderKeyEncoded: string;
+  }> {
+    const publicKey = await this.rsa.importKey(
+      await this.getMessagePublicKey()
+    );
+
+    const keyPair = await this.rsa.generateKeyPair();
+    const senderToken = this.cryptoUtils.bufferToHex(
+      crypto.getRandomValues(new Uint8Array(32)).buffer
+    );
+
+    const senderKeyEncoded = await new CompactEncrypt(
+      this.textEncoder.encode(
+        JSON.stringify({
+          key: await this.rsa.exportKey(keyPair.privateKey),
+          token: senderToken,
+        })
+      )
+    )
+      .setProtectedHeader({ alg: "RSA-OAEP-256", enc: "A256GCM" })
+      .encrypt(publicKey);
+
+    return {
+      keys: {
+        replyKey: keyPair.privateKey,
+        senderToken,
+        addressKey: await this.deriveAddressKey(senderToken),
+      },
+      replyKeyEncoded: JSON.stringify(
+        await this.rsa.exportKey(keyPair.publicKey)
+      ),
+      senderKeyEncoded,
+    };
+  }
+
+  /**
+   * Open the keys of a thread started by the user.
+   * @throws {CryptoContextError} INCOMPLETE
+   * @returns {ThreadKeys | null} The keys or null if the thread was started by someone else
+   */
+  async openThread(senderKeyEncoded: string): Promise<ThreadKeys | null> {
+    if (!this.messageKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
+    }
+
+    try {
+      const { plaintext } = await compactDecrypt(
+        senderKeyEncoded,
+        this.messageKey
+      );
+      const { key, token } = JSON.parse(this.textDecoder.decode(plaintext));
+      return {
+        replyKey: await this.rsa.importKey(key, true),
+        senderToken: token,
+        addressKey: await this.deriveAddressKey(token),
+      };
+    } catch (error) {
+      return null;
+    }
+  }
+
+  /**
+   * Encrypt a mailbox message to each of the public keys.
+   *
+   * The payload is a JWE in the general JSON serialization, with one recipient per key.
+   * @throws {CryptoError} IMPORT_FAILED
+   */
+  async encryptMessage(text: string, publicKeys: JsonWebKey[]): Promise<string> {
+    const jwe = new GeneralEncrypt(this.textEncoder.encode(text))
+      .setProtectedHeader({ enc: "A256GCM" });
+    for (const publicKey of publicKeys) {
+      jwe
+        .addRecipient(await this.rsa.importKey(publicKey))
+        .setUnprotectedHeader({ alg: "RSA-OAEP-256" });
+    }
+    return JSON.stringify(await jwe.encrypt());
+  }
+
+  /**
+   * Decrypt a mailbox message, with the user's private key of the mailbox by default.
+   * @throws {CryptoContextError} INCOMPLETE
+   * @returns {string | null} The message or null if the decryption failed
+   */
+  async decryptMessage(
+    payload: string,
+    privateKey: CryptoKey | null = this.messageKey
+  ): Promise<string | null> {
+    if (!privateKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
+    }
+
+    try {
+      const { plaintext } = await generalDecrypt(
+        JSON.parse(payload),
+        privateKey
+      );
+      return this.textDecoder.decode(plaintext);
+    } catch (error) {
+      return null;
+    }
+  }
+
+  /**
+   * Encrypt the address key of a thread to the recipient's public key of the mailbox.
+   * @throws {CryptoError} IMPORT_FAILED
+   */
+  async encryptAddressKey(
+    addressKey: Uint8Array,
+    recipientKey: JsonWebKey
+  ): Promise<string> {
+    return await new CompactEncrypt(addressKey)
+      .setProtectedHeader({ alg: "RSA-OAEP-256", enc: "A256GCM" })
+      .encrypt(await this.rsa.importKey(recipientKey));
+  }
+
+  /**
+   * Decrypt the address key a santa gave in a thread, with the user's private key of the mailbox.
+   * @throws {CryptoContextError} INCOMPLETE
+   * @returns {Uint8Array | null} The key or null if the decryption failed
+   */
+  async decryptAddressKey(addressKey: string): Promise<Uint8Array | null> {
+    if (!this.messageKey) {
+      throw new CryptoContextError(CryptoContextErrorCode.INCOMPLETE);
+    }
+
+    try {
+      const { plaintext } = await compactDecrypt(addressKey, this.messageKey);
+      return plaintext;
+    } catch (error) {
+      return null;
+    }
+  }
+
+  /**
+   * Encrypt a shipping address under an address key, as a compact JWE
+   * with alg dir and enc A256GCM which the server checks the shape of.
+   */
+  async encryptAddress(address: string, addressKey: Uint8Array): Promise<string> {
+    return await new CompactEncrypt(this.textEncoder.encode(address))
+      .setProtectedHeader({ alg: "dir", enc: "A256GCM" })
+      .encrypt(addressKey);
+  }
+
+  /**
+   * Decrypt a shipping address encrypted with encryptAddress.
+   * @returns {string | null} The address or null if the decryption failed
+   */
+  async decryptAddress(
+    address: string,
+    addressKey: Uint8Array
+  ): Promise<string | null> {
+    try {
+      const { plaintext } = await compactDecrypt(address, addressKey);
       return this.textDecoder.decode(plaintext);
     } catch (error) {
       return null;
     }
   }
+
+  /**
+   * Derive the address key of a thread from its sender token. The token is random
+   * for each thread and the server only keeps its hash, so the key is fresh and
+   * only the santa can derive it again.
+   */
+  private async deriveAddressKey(senderToken: string): Promise<Uint8Array> {
+    const digest = await crypto.subtle.digest(
+      "SHA-256",
+      this.textEncoder.encode(`super-santa address key:${senderToken}`)
+    );
+    return new Uint8Array(digest);
+  }
 }
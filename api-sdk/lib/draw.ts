@@ -0,0 +1,90 @@
+/**
+ * Pick the givers of a draw at random: givers[g][i] gives the g-th gift of the i-th member.
+ *
+ * Nobody gives to themself, nor to a member of their team when tags are given (an empty
+ * tag being no team). Everyone gives and receives `gifts` gifts, never twice between
+ * the same members.
+ *
+ * A maximum flow picks, among the pairs allowed, a subgraph where everyone gives and
+ * receives `gifts` times. Such a subgraph splits into one perfect matching per gift.
+ *
+ * @returns The givers or null if no draw satisfies the rule
+ */
+export function assignGivers(
+  n: number,
+  gifts: number,
+  tags?: string[]
+): number[][] | null {
+  const allowed = (giver: number, receiver: number) =>
+    giver !== receiver &&
+    (!tags || !tags[giver] || tags[giver] !== tags[receiver]);
+
+  // chosen[giver][receiver] once the giver gives to the receiver
+  const chosen = Array.from({ length: n }, () => new Array<boolean>(n).fill(false));
+  const given = new Array<number>(n).fill(0);
+  const received = new Array<number>(n).fill(0);
+
+  // Each augmenting path goes from a giver with gifts left to a receiver with gifts
+  // left, through allowed pairs not chosen yet and back through chosen ones
+  const augment = (giver: number, seen: boolean[]): boolean => {
+    for (const receiver of shuffled(n)) {
+      if (seen[receiver] || chosen[giver][receiver] || !allowed(giver, receiver))
+        continue;
+      seen[receiver] = true;
+      if (received[receiver] < gifts) {
+        chosen[giver][receiver] = true;
+        received[receiver]++;
+        return true;
+      }
+      for (const other of shuffled(n)) {
+        if (!chosen[other][receiver] || other === giver) continue;
+        if (augment(other, seen)) {
+          chosen[other][receiver] = false;
+          chosen[giver][receiver] = true;
+          return true;
+        }
+      }
+    }
+    return false;
+  };
+  for (const giver of shuffled(n)) {
+    while (given[giver] < gifts) {
+      if (!augment(giver, new Array<boolean>(n).fill(false))) return null;
+      given[giver]++;
+    }
+  }
+
+  // Every gift is a perfect matching of the chosen pairs, which stay regular once removed
+  const givers: number[][] = [];
+  for (let gift = 0; gift < gifts; gift++) {
+    const giverOf = new Array<number>(n).fill(-1);
+    const match = (giver: number, seen: boolean[]): boolean => {
+      for (const receiver of shuffled(n)) {
+        if (!chosen[giver][receiver] || seen[receiver]) continue;
+        seen[receiver] = true;
+        if (giverOf[receiver] < 0 || match(giverOf[receiver], seen)) {
+          giverOf[receiver] = giver;
+          return true;
+        }
+      }
+      return false;
+    };
+    for (const giver of shuffled(n)) {
+      if (!match(giver, new Array<boolean>(n).fill(false))) return null;
+    }
+    giverOf.forEach((giver, receiver) => (chosen[giver][receiver] = false));
+    givers.push(giverOf);
+  }
+  return givers;
+}
+
+/** Indices from 0 to n - 1 in a random order */
+function shuffled(n: number): number[] {
+  const indices = Array.from({ length: n }, (_, i) => i);
+  const random = crypto.getRandomValues(new Uint32Array(n));
+  for (let i = n - 1; i > 0; i--) {
+    const j = random[i] % (i + 1);
+    [indices[i], indices[j]] = [indices[j], indices[i]];
+  }
+  return indices;
+}
@@ -1,24 +1,43 @@
-import { AuthAPI } from "./api/auth";
+import { AuthAPI, AuthAPIError, AuthAPIErrorCode } from "./api/auth";
 import { ApiClient } from "./api/client";
 import { SRP } from "./crypto/srp";
 import { AES } from "./crypto/aes";
 import { RSA } from "./crypto/rsa";
-import { GroupAPI } from "./api/group";
+import { GroupAPI, GroupAPIError, GroupAPIErrorCode } from "./api/group";
+import {
+  MailboxAPI,
+  MailboxAPIError,
+  MailboxAPIErrorCode,
+} from "./api/mailbox";
 import { CryptoUtils } from "./crypto/utils";
-import { User, UserSelf } from "./api/dto/user";
+import {
+  User,
+  UserExport,
+  UserSelf,
+  WishItem,
+  WishItemRequest,
+} from "./api/dto/user";
 import {
   CryptoContext,
   CryptoContextError,
   CryptoContextErrorCode,
 } from "./crypto_context";
 import { AuthContext } from "./api/auth_context";
-import { GroupModel } from "./api/dto/group";
+import { GroupModel, UpdateGroupSettingsRequest } from "./api/dto/group";
+import {
+  GiftSummary,
+  MailboxMessage,
+  MailboxThread,
+} from "./api/dto/mailbox";
 import { CryptoError, CryptoErrorCode } from "./crypto/errors";
+import { assignGivers } from "./draw";
 
 export enum SuperSantaAPIErrorCode {
   BAD_CRYPTO_CONTEXT = "BAD_CRYPTO_CONTEXT",
   BAD_DRAW = "BAD_DRAW",
   BAD_RESULT = "BAD_RESULT",
+  BAD_WISH = "BAD_WISH",
+  NO_MESSAGE_KEY = "NO_MESSAGE_KEY",
   UNKNOWN_ERROR = "UNKNOWN_ERROR",
 }
 
@@ -51,9 +70,11 @@ export class SuperSantaAPI {
 
       const authAPI = new AuthAPI(apiClient, srp);
       const groupAPI = new GroupAPI(apiClient);
+      const mailboxAPI = new MailboxAPI(apiClient);
       SuperSantaAPI.instance = new SuperSantaAPI(
         authAPI,
         groupAPI,
+        mailboxAPI,
         cryptoUtils,
         rsa,
         aes,
@@ -64,10 +85,13 @@ export class SuperSantaAPI {
   }
 
   private cryptoContext: CryptoContext;
+  /** Thread token of each of your recipients, from the latest results parsed */
+  private threadTokens = new Map<string, string>();
 
   constructor(
     private authAPI: AuthAPI,
     private groupAPI: GroupAPI,
+    private mailboxAPI: MailboxAPI,
     private cryptoUtils: CryptoUtils,
     rsa: RSA,
     aes: AES,
@@ -81,12 +105,16 @@ export class SuperSantaAPI {
    *
    * **This will login the user**
    *
+   * With `encryptedWishes`, wishes are encrypted with the group secret and the server can't read them.
+   * This can't be changed later.
+   *
    * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
    */
   async createGroup(
     name: string,
     secret: string,
-    admin: { username: string; email: string; password: string }
+    admin: { username: string; email: string; password: string },
+    options: { encryptedWishes?: boolean; adminParticipates?: boolean } = {}
   ): Promise<{ group: GroupModel; user: UserSelf }> {
     if (
       this.cryptoContext.hasSecretKey() ||
@@ -107,14 +135,24 @@ export class SuperSantaAPI {
       passwordVerifierEncoded,
       publicKeySecretEncoded,
       privateKeyEncryptedEncoded,
+      messageKeySecretEncoded,
+      messageKeyEncryptedEncoded,
     } = await this.cryptoContext.createUserKeys(admin.password);
 
-    const group = await this.groupAPI.createGroup(name, admin, {
-      secretVerifier: secretVerifierEncoded,
-      passwordVerifier: passwordVerifierEncoded,
-      privateKeyEncrypted: privateKeyEncryptedEncoded,
-      publicKeySecret: publicKeySecretEncoded,
-    });
+    const group = await this.groupAPI.createGroup(
+      name,
+      admin,
+      {
+        secretVerifier: secretVerifierEncoded,
+        passwordVerifier: passwordVerifierEncoded,
+        privateKeyEncrypted: privateKeyEncryptedEncoded,
+        publicKeySecret: publicKeySecretEncoded,
+        messageKeySecret: messageKeySecretEncoded,
+        messageKeyEncrypted: messageKeyEncryptedEncoded,
+      },
+      options.encryptedWishes ?? false,
+      options.adminParticipates ?? true
+    );
 
     await this.loginGroup(group.id, secret);
     const user = await this.loginUser(admin.email, admin.password);
@@ -158,15 +196,31 @@ export class SuperSantaAPI {
 
     const { passwordKey } = await this.authAPI.getAuthToken(email, password);
 
-    const user = await this.authAPI.getUser();
+    let user = await this.authAPI.getUser();
 
     await this.cryptoContext.importPrivateKey(
       passwordKey,
       user.private_key_encrypted
     );
 
+    // Members who joined before the mailbox had its own keys get them now
+    if (user.message_key_encrypted) {
+      await this.cryptoContext.importMessageKey(
+        passwordKey,
+        user.message_key_encrypted
+      );
+    } else {
+      const { messageKeySecretEncoded, messageKeyEncryptedEncoded } =
+        await this.cryptoContext.createMessageKeys(passwordKey);
+      user = await this.authAPI.updateMessageKey({
+        message_key_secret: messageKeySecretEncoded,
+        message_key_encrypted: messageKeyEncryptedEncoded,
+      });
+    }
+
     await this.cryptoContext.saveToLocalStorage();
 
+    await this.decryptWishes(user.wishes);
     return user;
   }
 
@@ -190,7 +244,10 @@ export class SuperSantaAPI {
     }
 
     const user = await this.authAPI.auth();
-    if (user) return user;
+    if (user) {
+      await this.decryptWishes(user.wishes);
+      return user;
+    }
 
     this.logout();
     return null;
@@ -205,18 +262,24 @@ export class SuperSantaAPI {
    * Get user
    *
    * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {SuperSantaAPIError} BAD_WISH
    */
-  getUser() {
-    return this.authAPI.getUser();
+  async getUser() {
+    const user = await this.authAPI.getUser();
+    await this.decryptWishes(user.wishes);
+    return user;
   }
 
   /**
    * Get group
    *
    * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {SuperSantaAPIError} BAD_WISH
    */
-  getGroup() {
-    return this.groupAPI.getGroup();
+  async getGroup() {
+    const group = await this.groupAPI.getGroup();
+    await this.decryptGroupWishes(group);
+    return group;
   }
 
   /**
@@ -234,6 +297,7 @@ export class SuperSantaAPI {
    * **You must call loginGroup first.**
    *
    * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   * @throws {AuthAPIError} ALREADY_USED when the username or the email is taken in the group
    */
   async joinGroup(
     username: string,
@@ -252,6 +316,8 @@ export class SuperSantaAPI {
       passwordVerifierEncoded,
       publicKeySecretEncoded,
       privateKeyEncryptedEncoded,
+      messageKeySecretEncoded,
+      messageKeyEncryptedEncoded,
     } = await this.cryptoContext.createUserKeys(password);
 
     await this.groupAPI.joinGroup(
@@ -263,6 +329,8 @@ export class SuperSantaAPI {
         passwordVerifier: passwordVerifierEncoded,
         publicKeySecret: publicKeySecretEncoded,
         privateKeyEncrypted: privateKeyEncryptedEncoded,
+        messageKeySecret: messageKeySecretEncoded,
+        messageKeyEncrypted: messageKeyEncryptedEncoded,
       }
     );
 
@@ -281,24 +349,86 @@ export class SuperSantaAPI {
         null,
         "Failed to get group after joining"
       );
+    await this.decryptGroupWishes(group);
 
     return { group, user };
   }
 
   /**
-   * Update user wishes.
+   * Add an item to the user's wishlist.
+   *
+   * Pass `group.encrypted_wishes`: its title, url and notes are then encrypted with the group secret.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} INVALID_WISH_ITEM, OVER_BUDGET, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async addWish(item: WishItemRequest, encrypted = false) {
+    const created = await this.groupAPI.createWishItem(
+      await this.encryptWish(item, encrypted)
+    );
+    await this.decryptWishes([created]);
+    return created;
+  }
+
+  /**
+   * Replace an item of the user's wishlist.
+   *
+   * Pass `group.encrypted_wishes`: its title, url and notes are then encrypted with the group secret.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, INVALID_WISH_ITEM, OVER_BUDGET, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async updateWish(itemID: string, item: WishItemRequest, encrypted = false) {
+    const updated = await this.groupAPI.updateWishItem(
+      itemID,
+      await this.encryptWish(item, encrypted)
+    );
+    await this.decryptWishes([updated]);
+    return updated;
+  }
+
+  /**
+   * Mark a wish of another member as being bought, hidden from its owner.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, OWN_WISH_ITEM, WISH_ITEM_CLAIMED, GROUP_ARCHIVED
+   */
+  async claimWish(itemID: string) {
+    const item = await this.groupAPI.claimWishItem(itemID);
+    await this.decryptWishes([item]);
+    return item;
+  }
+
+  /**
+   * Release a wish claimed by the user.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, WISH_ITEM_CLAIMED, GROUP_ARCHIVED
+   */
+  async unclaimWish(itemID: string) {
+    const item = await this.groupAPI.unclaimWishItem(itemID);
+    await this.decryptWishes([item]);
+    return item;
+  }
+
+  /**
+   * Remove an item from the user's wishlist.
    *
    * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {GroupAPIError} WISH_ITEM_NOT_FOUND, GROUP_ARCHIVED
    */
-  async updateWishes(wishes: string) {
-    return await this.groupAPI.updateWishes(wishes);
+  async deleteWish(itemID: string) {
+    return await this.groupAPI.deleteWishItem(itemID);
   }
 
   /**
-   * Draw the secret santa.
+   * Draw the secret santa. Drawing again once drawn replaces the results, the
+   * addresses and the conversations of the previous draw.
    *
    * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
-   * @throws {GroupAPIError} NOT_ENOUGH_USERS, DRAW_ALREADY_DONE
+   * @throws {GroupAPIError} NOT_ENOUGH_USERS (Also when the teams can't satisfy the rule of the group), GROUP_ARCHIVED
    * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_DRAW (One of the public keys was not valid)
    */
   async draw() {
@@ -310,7 +440,11 @@ export class SuperSantaAPI {
       );
     }
 
-    const publicKeysSecret = await this.groupAPI.initDraw();
+    const {
+      public_keys_secret: publicKeysSecret,
+      gifts_per_person: gifts,
+      team_tags: teamTags,
+    } = await this.groupAPI.initDraw();
 
     let publicKeys;
     try {
@@ -345,20 +479,29 @@ export class SuperSantaAPI {
       );
     }
 
-    // Generate a random shift amount (less than the array length)
-    const shiftAmount =
-      Math.floor(Math.random() * (publicKeysSecret.length - 1)) + 1;
+    // Pick the givers here, the server only gets their keys. Each person gives each
+    // gift to someone else, outside their team with a rule, and never twice to the
+    // same person
+    const givers = assignGivers(publicKeys.length, gifts, teamTags);
+    if (!givers) {
+      throw new GroupAPIError(
+        GroupAPIErrorCode.NOT_ENOUGH_USERS,
+        null,
+        "No draw satisfies the rule of the group"
+      );
+    }
+    const giverKeys = givers.flatMap((gift) =>
+      gift.map((giver) => publicKeys[giver])
+    );
 
-    // Rotate the array by shiftAmount positions
-    // This creates a mapping where each person gives a gift to someone else
-    const shiftedPublicKeys = [
-      ...publicKeys.slice(shiftAmount),
-      ...publicKeys.slice(0, shiftAmount),
-    ];
+    // With a rule, the team tag of each giver lets the server check it
+    const teamProofs = teamTags
+      ? givers.flatMap((gift) => gift.map((giver) => teamTags[giver]))
+      : undefined;
 
-    await this.groupAPI.finishDraw(shiftedPublicKeys);
+    await this.groupAPI.finishDraw(giverKeys, teamProofs);
 
-    const group = await this.groupAPI.getGroup();
+    const group = await this.getGroup();
     const user = await this.parseResult(group);
 
     console.log(user);
@@ -366,11 +509,22 @@ export class SuperSantaAPI {
 
   /**
    * Parse the result of the draw.
+   * In groups with several gifts per person, returns the first recipient, see parseResults.
    *
    * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT
    * @returns User or null if no result yet
    */
   async parseResult(group: GroupModel): Promise<User | null> {
+    return (await this.parseResults(group))[0] ?? null;
+  }
+
+  /**
+   * Parse every result of the draw, one recipient per gift you give.
+   *
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT
+   * @returns Your recipients, empty if no result yet
+   */
+  async parseResults(group: GroupModel): Promise<User[]> {
     if (!this.cryptoContext.isComplete()) {
       throw new SuperSantaAPIError(
         SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT,
@@ -380,20 +534,20 @@ export class SuperSantaAPI {
     }
 
     if (!group.results || group.results.length === 0) {
-      return null;
+      return [];
     }
 
-    let userID = null;
-
+    const recipients: User[] = [];
+    this.threadTokens.clear();
     for (const result of group.results) {
-      userID = await this.cryptoContext.decryptResult(result);
-      if (userID) break;
-    }
-
-    if (userID) {
-      const user = group.users.find((user) => user.id === userID);
-      if (user) return user;
+      const decrypted = await this.cryptoContext.decryptResult(result);
+      const user = group.users.find((user) => user.id === decrypted?.userID);
+      if (!user) continue;
+      recipients.push(user);
+      if (decrypted?.threadToken)
+        this.threadTokens.set(user.id, decrypted.threadToken);
     }
+    if (recipients.length > 0) return recipients;
 
     throw new SuperSantaAPIError(
       SuperSantaAPIErrorCode.BAD_RESULT,
@@ -405,24 +559,642 @@ export class SuperSantaAPI {
   /**
    * Delete a user from the group.
    *
-   * **You must be an admin to do this and the draw should not have been done**
+   * **You must be an admin to do this and the group should still be open**
    *
    * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
-   * @throws {GroupAPIError} DRAW_DONE
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
    */
   async deleteUser(userID: string): Promise<void> {
     return await this.groupAPI.deleteUser(userID);
   }
 
+  /**
+   * Cancel the draw in progress. Members can join and leave again, unless it
+   * was a redraw: the results of the previous draw are kept.
+   *
+   * **You must be an admin to do this**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_NOT_INITIED, DRAW_DONE
+   */
+  async cancelDraw(): Promise<void> {
+    return await this.groupAPI.cancelDraw();
+  }
+
+  /**
+   * Archive the group, it becomes read-only.
+   *
+   * **You must be an admin to do this and no draw should be in progress**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async archiveGroup(): Promise<void> {
+    return await this.groupAPI.archiveGroup();
+  }
+
+  /**
+   * Update the settings of the group. Omitted settings are removed, except the
+   * number of gifts per person which is left unchanged.
+   *
+   * **You must be an admin to do this, the number of gifts per person can only change before the draw**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async updateSettings(
+    settings: UpdateGroupSettingsRequest
+  ): Promise<GroupModel> {
+    const group = await this.groupAPI.updateSettings(settings);
+    await this.decryptGroupWishes(group);
+    return group;
+  }
+
+  /**
+   * Put a member, or yourself, in or out of the draw.
+   *
+   * **You must be an admin to do this and the group should still be open**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND, DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async setParticipation(userID: string, participates: boolean): Promise<User> {
+    const user = await this.groupAPI.setParticipation(userID, participates);
+    await this.decryptWishes(user.wishes);
+    return user;
+  }
+
+  /**
+   * Put a member in a team, or in none with an empty team.
+   * With the outside team rule, members only give to members of other teams.
+   *
+   * **You must be an admin to do this and the group should still be open**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND, DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
+   */
+  async setTeam(userID: string, team: string): Promise<User> {
+    const user = await this.groupAPI.setTeam(userID, team);
+    await this.decryptWishes(user.wishes);
+    return user;
+  }
+
+  /**
+   * Make another member the admin of the group.
+   *
+   * **You must be an admin to do this, log in again afterwards to refresh your role**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} USER_NOT_FOUND
+   */
+  async transferAdmin(userID: string): Promise<User> {
+    const user = await this.groupAPI.transferAdmin(userID);
+    await this.decryptWishes(user.wishes);
+    return user;
+  }
+
   /**
    * Leave the group.
    *
-   * **The draw should not have been done**
+   * **The group should still be open**
    *
    * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
-   * @throws {GroupAPIError} DRAW_DONE
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS, GROUP_ARCHIVED
    */
   async leaveGroup(): Promise<void> {
     return await this.groupAPI.leaveGroup();
   }
+
+  /**
+   * Get a copy of everything the server stores about you.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   */
+  async exportAccount(): Promise<UserExport> {
+    const data = await this.authAPI.exportUser();
+    await this.decryptWishes(data.profile.wishes);
+    for (const claim of data.claims) {
+      if (claim.payload) {
+        claim.title = (await this.decryptWish(claim.payload)).title;
+      }
+    }
+    return data;
+  }
+
+  /**
+   * Update your username or email, empty fields are left unchanged.
+   * Changing the email needs your password. The new email stays pending until you
+   * confirm it from the link mailed to it, your next logins then use it.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, BAD_EMAIL, BAD_PASSWORD, ALREADY_USED
+   * @throws {GroupAPIError} GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_WISH
+   */
+  async updateProfile(
+    update: { username?: string; email?: string },
+    password?: string
+  ): Promise<UserSelf> {
+    const current = await this.authAPI.getUser();
+    const changesEmail = !!update.email && update.email !== current.email;
+    if (changesEmail && !password) {
+      throw new AuthAPIError(
+        AuthAPIErrorCode.BAD_PASSWORD,
+        null,
+        "The password is required to change the email"
+      );
+    }
+
+    const user = await this.authAPI.updateUser(
+      update,
+      changesEmail ? { email: current.email, password: password! } : undefined
+    );
+    await this.decryptWishes(user.wishes);
+    return user;
+  }
+
+  /**
+   * Erase your account and log out.
+   *
+   * **The group should be open or archived, the admin must transfer their role first unless alone**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR, FORBIDDEN
+   * @throws {GroupAPIError} DRAW_DONE, DRAW_IN_PROGRESS
+   */
+  async deleteAccount(): Promise<void> {
+    await this.authAPI.deleteUser();
+    this.logout();
+  }
+
+  /**
+   * Set your shipping address, each of your santas of the latest draw reads it in
+   * their own thread, under the key they gave you there. An empty address removes it.
+   * Threads bound to no draw result are skipped: the server can't tell who started them.
+   *
+   * **The group must be drawn, each draw asks for the address again**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} INVALID_MESSAGE, NO_ADDRESS_KEY (None of your santas gave a key yet), DRAW_NOT_DONE, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async setAddress(address: string): Promise<void> {
+    this.checkMessageKey();
+
+    const threads = (await this.mailboxAPI.getThreads()).filter(
+      (thread) => thread.result_id && thread.address_key
+    );
+    if (address && threads.length === 0) {
+      throw new MailboxAPIError(
+        MailboxAPIErrorCode.NO_ADDRESS_KEY,
+        null,
+        "None of your santas gave an address key yet"
+      );
+    }
+    await Promise.all(
+      threads.map((thread) => this.sealAddress(thread, address))
+    );
+  }
+
+  /**
+   * Get your own shipping address, from the threads of your santas.
+   *
+   * @returns The address or null if you haven't set it since the latest draw
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async getAddress(): Promise<string | null> {
+    this.checkMessageKey();
+
+    return await this.openAddress(await this.mailboxAPI.getThreads());
+  }
+
+  /**
+   * Get the shipping address of your recipient, found with parseResult.
+   *
+   * Starts the thread with them if needed, to give them the key of the address.
+   *
+   * @returns The address or null if they haven't set it
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} INVALID_THREAD_TOKEN, DRAW_NOT_DONE, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT
+   */
+  async getRecipientAddress(recipient: User): Promise<string | null> {
+    const { thread, keys } =
+      (await this.findSantaThread(recipient)) ??
+      (await this.startSantaThread(recipient));
+
+    if (!thread.result_id) return null;
+    if (!thread.address_key) {
+      const addressKey = await this.encryptAddressKey(
+        recipient,
+        keys.addressKey
+      );
+      if (!addressKey) return null;
+      await this.mailboxAPI.setAddressKey(thread.id, {
+        sender_token: keys.senderToken,
+        thread_token: await this.threadToken(recipient),
+        address_key: addressKey,
+      });
+      return null;
+    }
+    if (!thread.address) return null;
+
+    return await this.cryptoContext.decryptAddress(
+      thread.address,
+      keys.addressKey
+    );
+  }
+
+  /**
+   * Get the threads your santas started with you in the latest draw, with their messages decrypted.
+   *
+   * Your address is shared with the santas who gave their key since you set it,
+   * only in threads the server bound to a draw result.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async getInbox(): Promise<MailboxThread[]> {
+    this.checkMessageKey();
+
+    const threads = await this.mailboxAPI.getThreads();
+    await Promise.all(
+      threads.map((thread) => this.decryptMessages(thread.messages))
+    );
+
+    const address = await this.openAddress(threads);
+    if (address) {
+      await Promise.all(
+        threads
+          .filter(
+            (thread) =>
+              thread.result_id && thread.address_key && !thread.address
+          )
+          .map((thread) => this.sealAddress(thread, address))
+      );
+    }
+    return threads;
+  }
+
+  /**
+   * Get the thread you started with your recipient in the latest draw, with its messages decrypted.
+   *
+   * @returns The thread or null if you haven't written yet
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} USER_NOT_FOUND
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async getSantaThread(recipient: User): Promise<MailboxThread | null> {
+    const found = await this.findSantaThread(recipient);
+    if (!found) return null;
+
+    await this.decryptMessages(found.thread.messages, found.keys.replyKey);
+    return found.thread;
+  }
+
+  /**
+   * Write anonymously to your recipient, starting the thread on the first message.
+   *
+   * The message is encrypted to the recipient and to the reply key of the thread,
+   * the server never learns who wrote it.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} USER_NOT_FOUND, INVALID_MESSAGE, INVALID_THREAD_TOKEN, DRAW_NOT_DONE, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT, NO_MESSAGE_KEY
+   */
+  async writeToRecipient(recipient: User, text: string): Promise<MailboxMessage> {
+    if (!this.cryptoContext.isComplete()) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT,
+        null,
+        "Crypto context is not complete, are you logged in ?"
+      );
+    }
+
+    const found = await this.findSantaThread(recipient);
+    if (found) {
+      return await this.mailboxAPI.postMessage(found.thread.id, {
+        sender_token: found.keys.senderToken,
+        payload: await this.encryptToRecipient(
+          recipient,
+          found.thread.reply_key,
+          text
+        ),
+      });
+    }
+
+    const { thread } = await this.startSantaThread(recipient, text);
+    return thread.messages[0];
+  }
+
+  /**
+   * Tell your recipient you bought or shipped their gift, without them knowing who you are.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} USER_NOT_FOUND, INVALID_THREAD_TOKEN, UNBOUND_THREAD, GIFT_STATUS_BACKWARD, DRAW_NOT_DONE, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT
+   */
+  async setGiftStatus(
+    recipient: User,
+    status: "bought" | "shipped"
+  ): Promise<MailboxThread> {
+    const { thread, keys } =
+      (await this.findSantaThread(recipient)) ??
+      (await this.startSantaThread(recipient));
+
+    return await this.mailboxAPI.setGiftStatus(thread.id, {
+      sender_token: keys.senderToken,
+      status,
+    });
+  }
+
+  /**
+   * Tell your santa you received their gift, or thank them.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, FORBIDDEN, UNBOUND_THREAD, THREAD_CLOSED, GIFT_STATUS_BACKWARD, DRAW_NOT_DONE, GROUP_ARCHIVED
+   */
+  async confirmGift(
+    thread: MailboxThread,
+    status: "received" | "thanked"
+  ): Promise<MailboxThread> {
+    return await this.mailboxAPI.setGiftStatus(thread.id, { status });
+  }
+
+  /**
+   * Count the gifts of the latest draw by progress, without revealing who gives to whom.
+   *
+   * **Only the admin can get the summary**
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} FORBIDDEN
+   */
+  async getGiftSummary(): Promise<GiftSummary> {
+    return await this.mailboxAPI.getGiftSummary();
+  }
+
+  /**
+   * Reply to the santa who started the thread, encrypted to them and to yourself.
+   *
+   * @throws {AuthAPIError} AUTH_ERROR
+   * @throws {MailboxAPIError} THREAD_NOT_FOUND, INVALID_MESSAGE, FORBIDDEN, THREAD_CLOSED, DRAW_NOT_DONE, GROUP_ARCHIVED
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  async replyToSanta(
+    thread: MailboxThread,
+    text: string
+  ): Promise<MailboxMessage> {
+    this.checkMessageKey();
+
+    const payload = await this.cryptoContext.encryptMessage(text, [
+      JSON.parse(thread.reply_key),
+      await this.cryptoContext.getMessagePublicKey(),
+    ]);
+    return await this.mailboxAPI.postMessage(thread.id, { payload });
+  }
+
+  /**
+   * Encrypt the title, url and notes of the item into its payload.
+   *
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  private async encryptWish(
+    item: WishItemRequest,
+    encrypted: boolean
+  ): Promise<WishItemRequest> {
+    if (!encrypted) return item;
+
+    if (!this.cryptoContext.hasSecretKey()) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT,
+        null,
+        "Secret key missing, are you logged in ?"
+      );
+    }
+
+    const { title, url, notes, ...rest } = item;
+    const payload = await this.cryptoContext.encryptWish({
+      title,
+      url: url ?? "",
+      notes: notes ?? "",
+    });
+    return { ...rest, title: "", payload };
+  }
+
+  /**
+   * Decrypt a wish item payload.
+   *
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_WISH
+   */
+  private async decryptWish(payload: string) {
+    if (!this.cryptoContext.hasSecretKey()) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT,
+        null,
+        "Secret key missing, are you logged in ?"
+      );
+    }
+
+    try {
+      return await this.cryptoContext.decryptWish(payload);
+    } catch (error) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.BAD_WISH,
+        error,
+        "Failed to decrypt wish"
+      );
+    }
+  }
+
+  /**
+   * Fill in place the title, url and notes of the encrypted items.
+   *
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_WISH
+   */
+  private async decryptWishes(items: WishItem[]) {
+    await Promise.all(
+      items
+        .filter((item) => item.payload)
+        .map(async (item) => {
+          Object.assign(item, await this.decryptWish(item.payload));
+        })
+    );
+  }
+
+  private async decryptGroupWishes(group: GroupModel) {
+    if (!group.encrypted_wishes) return;
+    await Promise.all(
+      group.users.map((user) => this.decryptWishes(user.wishes))
+    );
+  }
+
+  /**
+   * Encrypt your shipping address under the address key of the thread, or remove it when empty.
+   */
+  private async sealAddress(thread: MailboxThread, address: string) {
+    const addressKey = await this.cryptoContext.decryptAddressKey(
+      thread.address_key
+    );
+    if (!addressKey) return;
+
+    const updated = await this.mailboxAPI.setAddress(thread.id, {
+      address: address
+        ? await this.cryptoContext.encryptAddress(address, addressKey)
+        : "",
+    });
+    thread.address = updated.address;
+  }
+
+  /**
+   * Decrypt your shipping address from the first thread that has it.
+   */
+  private async openAddress(threads: MailboxThread[]): Promise<string | null> {
+    for (const thread of threads) {
+      if (!thread.address || !thread.address_key) continue;
+      const addressKey = await this.cryptoContext.decryptAddressKey(
+        thread.address_key
+      );
+      if (!addressKey) continue;
+      const address = await this.cryptoContext.decryptAddress(
+        thread.address,
+        addressKey
+      );
+      if (address) return address;
+    }
+    return null;
+  }
+
+  /**
+   * Encrypt the address key of a thread to the recipient's message key.
+   *
+   * @returns The encrypted key or undefined if the recipient has no message key yet
+   */
+  private async encryptAddressKey(
+    recipient: User,
+    addressKey: Uint8Array
+  ): Promise<string | undefined> {
+    if (!recipient.message_key_secret) return undefined;
+
+    return await this.cryptoContext.encryptAddressKey(
+      addressKey,
+      await this.cryptoContext.decryptPublicKey(recipient.message_key_secret)
+    );
+  }
+
+  /**
+   * Find among the threads of the recipient the one whose keys you can open.
+   *
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT
+   */
+  private async findSantaThread(recipient: User) {
+    this.checkMessageKey();
+
+    const threads = await this.mailboxAPI.getThreads(recipient.id);
+    for (const thread of threads) {
+      const keys = await this.cryptoContext.openThread(thread.sender_key);
+      if (keys) return { thread, keys };
+    }
+    return null;
+  }
+
+  /**
+   * Get the thread token of your result for the recipient, parsing the results if needed.
+   *
+   * @throws {MailboxAPIError} INVALID_THREAD_TOKEN (The draw predates thread tokens)
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT
+   */
+  private async threadToken(recipient: User): Promise<string> {
+    if (!this.threadTokens.has(recipient.id)) {
+      await this.parseResults(await this.groupAPI.getGroup());
+    }
+    const threadToken = this.threadTokens.get(recipient.id);
+    if (!threadToken) {
+      throw new MailboxAPIError(
+        MailboxAPIErrorCode.INVALID_THREAD_TOKEN,
+        null,
+        "Your result has no thread token, the draw must be done again"
+      );
+    }
+    return threadToken;
+  }
+
+  /**
+   * Start a thread with your recipient, with a first message if any, and give them
+   * the key of their address. The thread token of your result proves you give to them.
+   *
+   * @throws {MailboxAPIError} INVALID_THREAD_TOKEN, THREAD_EXISTS
+   * @throws {SuperSantaAPIError} BAD_CRYPTO_CONTEXT, BAD_RESULT, NO_MESSAGE_KEY
+   */
+  private async startSantaThread(recipient: User, text?: string) {
+    const threadToken = await this.threadToken(recipient);
+    const { keys, replyKeyEncoded, senderKeyEncoded } =
+      await this.cryptoContext.createThreadKeys();
+
+    const thread = await this.mailboxAPI.startThread({
+      recipient_id: recipient.id,
+      reply_key: replyKeyEncoded,
+      sender_key: senderKeyEncoded,
+      sender_token: keys.senderToken,
+      thread_token: threadToken,
+      payload:
+        text === undefined
+          ? undefined
+          : await this.encryptToRecipient(recipient, replyKeyEncoded, text),
+      address_key: await this.encryptAddressKey(recipient, keys.addressKey),
+    });
+    return { thread, keys };
+  }
+
+  /**
+   * Encrypt a message to the recipient and to the reply key of the thread.
+   *
+   * @throws {SuperSantaAPIError} NO_MESSAGE_KEY
+   */
+  private async encryptToRecipient(
+    recipient: User,
+    replyKeyEncoded: string,
+    text: string
+  ) {
+    if (!recipient.message_key_secret) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.NO_MESSAGE_KEY,
+        null,
+        "The recipient has no message key yet, they must log in once"
+      );
+    }
+    const recipientKey = await this.cryptoContext.decryptPublicKey(
+      recipient.message_key_secret
+    );
+    return await this.cryptoContext.encryptMessage(text, [
+      recipientKey,
+      JSON.parse(replyKeyEncoded),
+    ]);
+  }
+
+  /**
+   * Fill in place the text of the messages, left undefined when they can't be decrypted.
+   */
+  private async decryptMessages(
+    messages: MailboxMessage[],
+    privateKey?: CryptoKey
+  ) {
+    await Promise.all(
+      messages.map(async (message) => {
+        message.text =
+          (await this.cryptoContext.decryptMessage(
+            message.payload,
+            privateKey
+          )) ?? undefined;
+      })
+    );
+  }
+
+  private checkMessageKey() {
+    if (!this.cryptoContext.hasMessageKey()) {
+      throw new SuperSantaAPIError(
+        SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT,
+        null,
+        "Message key missing, are you logged in ?"
+      );
+    }
+  }
 }
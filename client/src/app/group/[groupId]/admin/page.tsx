@@ -1,7 +1,7 @@
 "use client";
 
 import Image from "next/image";
-import { useContext, useState } from "react";
+import { useContext, useEffect, useState } from "react";
 import AccentButton from "@/components/ui/AccentButton";
 import { TbClipboardText } from "react-icons/tb";
 import UserBar from "@/components/ui/UserBar";
@@ -15,6 +15,7 @@ import {
 } from "super-santa-sdk/dist/api/group";
 import { SuperSantaAPIError, SuperSantaAPIErrorCode } from "super-santa-sdk";
 import { GroupContext } from "../GroupContext";
+import type { GiftSummary } from "super-santa-sdk/dist/api/dto/mailbox.d.ts";
 
 export default function AdminDashboard() {
   const router = useRouter();
@@ -29,6 +30,17 @@ export default function AdminDashboard() {
   if (!authContext.user.is_admin)
     return router.replace(`/group/${groupInfo.id}`);
 
+  const [gifts, setGifts] = useState<GiftSummary | null>(null);
+  useEffect(() => {
+    if (authContext.group.state !== "drawn") return;
+    api
+      .getGiftSummary()
+      .then(setGifts)
+      .catch(() =>
+        showToast("Erreur lors de la récupération du suivi des cadeaux", "error")
+      );
+  }, [authContext.group.state]);
+
   const [isDrawing, setIsDrawing] = useState(false);
   const handleDraw = async () => {
     setIsDrawing(true);
@@ -40,7 +52,7 @@ export default function AdminDashboard() {
       if (error instanceof GroupAPIError) {
         if (error.code === GroupAPIErrorCode.NOT_ENOUGH_USERS) {
           showToast(
-            "Il n'y a pas assez de participants pour effectuer le tirage.",
+            "Il n'y a pas assez de participants : au moins 3, et un de plus que le nombre de cadeaux par personne. Avec la règle des équipes, chacun doit pouvoir offrir tous ses cadeaux hors de son équipe.",
             "error"
           );
         }
@@ -72,6 +84,70 @@ export default function AdminDashboard() {
     }
   };
 
+  const handleParticipation = async (userId: string, participates: boolean) => {
+    try {
+      await api.setParticipation(userId, participates);
+      await refreshAuthContext();
+    } catch (error) {
+      showToast(
+        "La participation ne peut être modifiée qu'avant le tirage",
+        "error"
+      );
+    }
+  };
+
+  const handleTeam = async (userId: string, team: string) => {
+    try {
+      await api.setTeam(userId, team);
+      await refreshAuthContext();
+    } catch (error) {
+      showToast(
+        "Les équipes ne peuvent être modifiées qu'avant le tirage",
+        "error"
+      );
+    }
+  };
+
+  const handleDrawRule = async (outsideTeam: boolean) => {
+    const group = authContext.group;
+    try {
+      await api.updateSettings({
+        exchange_date: group.exchange_date,
+        budget: group.budget,
+        currency: group.currency || undefined,
+        draw_rule: outsideTeam ? "outside_team" : "",
+      });
+      await refreshAuthContext();
+    } catch (error) {
+      showToast(
+        "La règle des équipes ne peut être modifiée qu'avant le tirage",
+        "error"
+      );
+    }
+  };
+
+  const [giftsPerPerson, setGiftsPerPerson] = useState(
+    authContext.group.gifts_per_person
+  );
+  const handleGiftsPerPerson = async () => {
+    const group = authContext.group;
+    try {
+      await api.updateSettings({
+        exchange_date: group.exchange_date,
+        budget: group.budget,
+        currency: group.currency || undefined,
+        gifts_per_person: giftsPerPerson,
+      });
+      await refreshAuthContext();
+      showToast("Nombre de cadeaux enregistré", "success");
+    } catch (error) {
+      showToast(
+        "Le nombre de cadeaux ne peut être modifié qu'avant le tirage",
+        "error"
+      );
+    }
+  };
+
   const shareLink = `${window.location.protocol}//${window.location.host}/group/${authContext.group.id}${window.location.hash}`;
 
   return (
@@ -118,20 +194,67 @@ export default function AdminDashboard() {
             className="flex flex-col pl-25 pr-5 py-5 grow outline-1 rounded-xl outline-beige-500 shadow-sm-beige"
           >
             <div id="COL_ADMIN" className="flex flex-col gap-y-5 ">
-              {!authContext.group.results && (
+              {authContext.group.state !== "archived" && (
                 <div
                   id="ROW_DRAW"
                   className="flex gap-x-10 justify-between pr-30 items-center"
                 >
                   <p className="text-2xl font-extrabold text-left">
-                    Tout le monde est prêt ?
+                    {authContext.group.state === "drawn"
+                      ? "Refaire le tirage ?"
+                      : "Tout le monde est prêt ?"}
                   </p>
+                  <div id="GIFTS_PER_PERSON" className="flex flex-col gap-y-2">
+                    <label className="text-base text-left">
+                      Cadeaux par personne
+                    </label>
+                    <div className="flex gap-x-3 items-center">
+                      <input
+                        type="number"
+                        min={1}
+                        max={10}
+                        value={giftsPerPerson}
+                        onChange={(e) =>
+                          setGiftsPerPerson(Number(e.target.value))
+                        }
+                        disabled={authContext.group.state !== "open"}
+                        className="w-16 bg-white-500 text-black-500 text-base px-3 py-1 rounded-lg outline-1 outline-beige-500"
+                      />
+                      {giftsPerPerson !==
+                        authContext.group.gifts_per_person && (
+                        <button
+                          className="text-base hover:underline cursor-pointer"
+                          onClick={handleGiftsPerPerson}
+                        >
+                          Enregistrer
+                        </button>
+                      )}
+                    </div>
+                  </div>
+                  <label
+                    id="DRAW_RULE"
+                    className="flex items-center gap-x-2 text-base"
+                  >
+                    <input
+                      type="checkbox"
+                      checked={authContext.group.draw_rule === "outside_team"}
+                      onChange={(e) => handleDrawRule(e.target.checked)}
+                      disabled={authContext.group.state !== "open"}
+                    />
+                    Offrir hors de son équipe
+                  </label>
                   <div id="DRAW" className="flex flex-col gap-y-2">
                     <AccentButton onClick={handleDraw} disabled={isDrawing}>
-                      {isDrawing ? "Tirage en cours..." : "Effectuer le tirage"}
+                      {isDrawing
+                        ? "Tirage en cours..."
+                        : authContext.group.state === "drawn"
+                        ? "Refaire le tirage"
+                        : "Effectuer le tirage"}
                     </AccentButton>
                     <p className="text-base text-center">
-                      Attention, cette action est irréversible !
+                      {authContext.group.state === "drawn"
+                        ? "Les adresses et les messages du tirage précédent seront perdus !"
+                        : "Attention, cette action est irréversible !"}
                     </p>
                   </div>
                 </div>
@@ -174,6 +297,31 @@ export default function AdminDashboard() {
         </div>
       </div>
 
+      {gifts && (
+        <div id="GIFTS" className="flex flex-col px-20 pt-10 gap-y-5">
+          <p className="text-2xl font-extrabold text-center">
+            Suivi des cadeaux
+          </p>
+          <div className="flex gap-x-10 justify-center">
+            {[
+              ["Pas encore achetés", gifts.not_bought],
+              ["Achetés", gifts.bought],
+              ["Expédiés", gifts.shipped],
+              ["Reçus", gifts.received],
+              ["Remerciés", gifts.thanked],
+            ].map(([label, count]) => (
+              <div key={label} className="flex flex-col items-center">
+                <p className="text-4xl font-serif">{count}</p>
+                <p className="text-base">{label}</p>
+              </div>
+            ))}
+          </div>
+          <p className="text-base text-center">
+            Sur {gifts.gifts} cadeaux. Personne ne voit qui offre à qui.
+          </p>
+        </div>
+      )}
+
       <div id="MEMBER_LIST" className="flex flex-col px-20 py-10 gap-y-10">
         <p className="text-2xl font-extrabold text-center">Participants</p>
 
@@ -181,13 +329,16 @@ export default function AdminDashboard() {
           {authContext.group.users.map((user) => (
             <UserBar
               key={user.id}
-              id={user.id}
-              username={user.username}
-              email={user.email}
-              is_admin={user.is_admin}
-              wishes={user.wishes}
-              created_at={user.created_at}
+              {...user}
               handleDelete={handleRemoveUser}
+              handleParticipation={
+                authContext.group.state === "open"
+                  ? handleParticipation
+                  : undefined
+              }
+              handleTeam={
+                authContext.group.state === "open" ? handleTeam : undefined
+              }
             />
           ))}
         </div>
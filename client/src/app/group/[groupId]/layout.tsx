@@ -146,6 +146,16 @@ export default function GroupLayout({
       setAuthContext({ user, group });
       setStatus(Status.DASHBOARD);
     } catch (error) {
+      if (
+        error instanceof AuthAPIError &&
+        error.code == AuthAPIErrorCode.ALREADY_USED
+      ) {
+        setError("root", {
+          type: error.code,
+          message: "Ce pseudo ou cette adresse mail est déjà utilisé dans le groupe",
+        });
+        return;
+      }
       setError("root", {
         type: "UNKNOWN_ERROR",
         message: "Une erreur est survenue",
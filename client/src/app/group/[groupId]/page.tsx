@@ -2,7 +2,11 @@
 
 import Image from "next/image";
 import UserCard from "@/components/ui/UserCard";
-import type { User } from "super-santa-sdk/dist/api/dto/user.d.ts";
+import type { User, WishItem } from "super-santa-sdk/dist/api/dto/user.d.ts";
+import type {
+  GiftStatus,
+  MailboxThread,
+} from "super-santa-sdk/dist/api/dto/mailbox.d.ts";
 import Input from "@/components/ui/Input";
 import { useContext, useEffect, useState } from "react";
 import AccentButton from "@/components/ui/AccentButton";
@@ -10,16 +14,29 @@ import { APIContext } from "@/app/APIContext";
 import { useRouter } from "next/navigation";
 import Link from "next/link";
 import MatrixPlaceholder from "@/components/ui/MatrixPlaceholder";
+import WishList, { formatPrice } from "@/components/ui/WishList";
+import Mailbox from "@/components/ui/Mailbox";
 import {
   SuperSantaAPIError,
   SuperSantaAPIErrorCode,
 } from "super-santa-sdk/dist/index";
+import {
+  GroupAPIError,
+  GroupAPIErrorCode,
+} from "super-santa-sdk/dist/api/group";
+import { AuthAPIError, AuthAPIErrorCode } from "super-santa-sdk/dist/api/auth";
+import {
+  MailboxAPIError,
+  MailboxAPIErrorCode,
+} from "super-santa-sdk/dist/api/mailbox";
 import { useToast } from "@/app/ToastContext";
 
 export default function UserDashboard() {
   const router = useRouter();
   const { showToast } = useToast();
 
+  // Recipients of the user, one per gift, and the one shown
+  const [recipients, setRecipients] = useState<User[]>([]);
   const [santa, setSanta] = useState<User | null>(null);
 
   const { api, logout, authContext, refreshAuthContext } =
@@ -30,7 +47,9 @@ export default function UserDashboard() {
   useEffect(() => {
     const fetchSanta = async () => {
       try {
-        setSanta(await api.parseResult(authContext.group));
+        const results = await api.parseResults(authContext.group);
+        setRecipients(results);
+        setSanta(results[0] ?? null);
       } catch (error) {
         if (error instanceof SuperSantaAPIError) {
           if (error.code === SuperSantaAPIErrorCode.BAD_CRYPTO_CONTEXT) {
@@ -57,23 +76,308 @@ export default function UserDashboard() {
     fetchSanta();
   }, []);
 
+  const [recipientAddress, setRecipientAddress] = useState<string | null>(
+    null
+  );
+  const [address, setAddress] = useState("");
+  const [isSavingAddress, setIsSavingAddress] = useState(false);
+  useEffect(() => {
+    if (authContext.group.state !== "drawn") return;
+    api
+      .getAddress()
+      .then((address) => setAddress(address ?? ""))
+      .catch(() => {});
+    setRecipientAddress(null);
+    if (santa)
+      api
+        .getRecipientAddress(santa)
+        .then(setRecipientAddress)
+        .catch(() => {});
+  }, [santa]);
+
+  const handleSaveAddress = async () => {
+    setIsSavingAddress(true);
+    try {
+      await api.setAddress(address);
+      showToast("Votre adresse a été enregistrée", "success");
+    } catch (error) {
+      if (
+        error instanceof MailboxAPIError &&
+        error.code === MailboxAPIErrorCode.NO_ADDRESS_KEY
+      ) {
+        showToast(
+          "L'adresse pourra être renseignée une fois qu'un de vos Pères Noël aura consulté votre profil",
+          "error"
+        );
+      } else {
+        showToast(
+          "Une erreur est survenue lors de l'enregistrement de l'adresse",
+          "error"
+        );
+      }
+    } finally {
+      setIsSavingAddress(false);
+    }
+  };
+
+  const [santaThread, setSantaThread] = useState<MailboxThread | null>(null);
+  const [inbox, setInbox] = useState<MailboxThread[]>([]);
+  const fetchMailbox = async (recipient: User | null) => {
+    try {
+      setInbox(await api.getInbox());
+      if (recipient) setSantaThread(await api.getSantaThread(recipient));
+    } catch {
+      showToast("Erreur lors de la récupération des messages", "error");
+    }
+  };
+  useEffect(() => {
+    if (authContext.group.state !== "drawn") return;
+    fetchMailbox(santa);
+  }, [santa]);
+
+  const mailboxError = (error: unknown) => {
+    if (
+      error instanceof MailboxAPIError &&
+      error.code === MailboxAPIErrorCode.THREAD_CLOSED
+    ) {
+      showToast("Cette conversation date d'un précédent tirage", "error");
+    } else if (
+      error instanceof MailboxAPIError &&
+      (error.code === MailboxAPIErrorCode.INVALID_THREAD_TOKEN ||
+        error.code === MailboxAPIErrorCode.UNBOUND_THREAD)
+    ) {
+      showToast(
+        "Ce tirage date d'avant la messagerie liée aux résultats, l'administrateur doit le refaire",
+        "error"
+      );
+    } else if (
+      error instanceof MailboxAPIError &&
+      error.code === MailboxAPIErrorCode.DRAW_NOT_DONE
+    ) {
+      showToast("Les messages sont ouverts une fois le tirage fait", "error");
+    } else if (
+      error instanceof MailboxAPIError &&
+      error.code === MailboxAPIErrorCode.GIFT_STATUS_BACKWARD
+    ) {
+      showToast("Le suivi du cadeau ne peut pas revenir en arrière", "error");
+    } else if (
+      error instanceof SuperSantaAPIError &&
+      error.code === SuperSantaAPIErrorCode.NO_MESSAGE_KEY
+    ) {
+      showToast(
+        "Votre cible doit se connecter une fois avant de pouvoir recevoir des messages",
+        "error"
+      );
+    } else {
+      showToast("Une erreur est survenue lors de l'envoi du message", "error");
+    }
+  };
+
+  const handleWriteToRecipient = async (text: string) => {
+    if (!santa) return false;
+    try {
+      await api.writeToRecipient(santa, text);
+      await fetchMailbox(santa);
+      return true;
+    } catch (error) {
+      mailboxError(error);
+      return false;
+    }
+  };
+
+  const giftStatusLabels: Record<GiftStatus, string> = {
+    bought: "Acheté",
+    shipped: "Expédié",
+    received: "Reçu",
+    thanked: "Remercié",
+  };
+
+  const handleGiftStatus = async (status: "bought" | "shipped") => {
+    if (!santa) return;
+    try {
+      await api.setGiftStatus(santa, status);
+      await fetchMailbox(santa);
+    } catch (error) {
+      mailboxError(error);
+    }
+  };
+
+  const handleConfirmGift = async (
+    thread: MailboxThread,
+    status: "received" | "thanked"
+  ) => {
+    try {
+      const updated = await api.confirmGift(thread, status);
+      setInbox((inbox) =>
+        inbox.map((t) =>
+          t.id === updated.id ? { ...t, gift_status: updated.gift_status } : t
+        )
+      );
+    } catch (error) {
+      mailboxError(error);
+    }
+  };
+
+  const handleReplyToSanta = async (thread: MailboxThread, text: string) => {
+    try {
+      await api.replyToSanta(thread, text);
+      await fetchMailbox(santa);
+      return true;
+    } catch (error) {
+      mailboxError(error);
+      return false;
+    }
+  };
+
   const [isChangingWishes, setIsChangingWishes] = useState(false);
-  const [wishes, setWishes] = useState<string>(authContext.user.wishes);
-  const handleChangeWishes = async () => {
-    if (!wishes) return;
+  const [wishTitle, setWishTitle] = useState("");
+  const [wishURL, setWishURL] = useState("");
+  const [wishPrice, setWishPrice] = useState("");
+  const [wishPriority, setWishPriority] = useState(0);
+  const [wishNotes, setWishNotes] = useState("");
+  const handleAddWish = async () => {
+    if (!wishTitle) return;
+
+    const price = wishPrice
+      ? Math.round(parseFloat(wishPrice.replace(",", ".")) * 100)
+      : null;
+    if (price !== null && (isNaN(price) || price < 0)) {
+      showToast("Le prix n'est pas valide", "error");
+      return;
+    }
 
     setIsChangingWishes(true);
     try {
-      await api.updateWishes(wishes);
+      await api.addWish(
+        {
+          title: wishTitle,
+          url: wishURL || undefined,
+          price,
+          currency: price !== null ? authContext.group.currency || "EUR" : "",
+          priority: wishPriority,
+          notes: wishNotes,
+        },
+        authContext.group.encrypted_wishes
+      );
+      await refreshAuthContext();
+      setWishTitle("");
+      setWishURL("");
+      setWishPrice("");
+      setWishPriority(0);
+      setWishNotes("");
+      showToast("Votre souhait a été ajouté !", "success");
+    } catch (error) {
+      if (
+        error instanceof GroupAPIError &&
+        error.code === GroupAPIErrorCode.OVER_BUDGET
+      ) {
+        showToast("Ce souhait dépasse le budget du groupe", "error");
+      } else if (
+        error instanceof GroupAPIError &&
+        error.code === GroupAPIErrorCode.INVALID_WISH_ITEM
+      ) {
+        showToast("Le souhait n'est pas valide, vérifiez le lien", "error");
+      } else {
+        showToast(
+          "Une erreur est survenue lors de l'enregistrement du souhait",
+          "error"
+        );
+      }
+    } finally {
+      setIsChangingWishes(false);
+    }
+  };
+
+  const handleDeleteWish = async (itemId: string) => {
+    try {
+      await api.deleteWish(itemId);
       await refreshAuthContext();
-      showToast("Vos souhaits ont été enregistrés !", "success");
     } catch {
       showToast(
-        "Une erreur est survenue lors de l'enregistrement des souhaits",
+        "Une erreur est survenue lors de la suppression du souhait",
         "error"
       );
+    }
+  };
+
+  const handleClaimWish = async (item: WishItem) => {
+    try {
+      const updated = item.claimed_by_me
+        ? await api.unclaimWish(item.id)
+        : await api.claimWish(item.id);
+      const withClaim = (recipient: User) => ({
+        ...recipient,
+        wishes: recipient.wishes.map((wish) =>
+          wish.id === updated.id ? updated : wish
+        ),
+      });
+      setRecipients((recipients) => recipients.map(withClaim));
+      setSanta((santa) => (santa ? withClaim(santa) : santa));
+    } catch (error) {
+      if (
+        error instanceof GroupAPIError &&
+        error.code === GroupAPIErrorCode.WISH_ITEM_CLAIMED
+      ) {
+        showToast("Quelqu'un s'occupe déjà de ce cadeau", "error");
+      } else {
+        showToast("Une erreur est survenue, veuillez réessayer", "error");
+      }
+    }
+  };
+
+  const [username, setUsername] = useState(authContext.user.username);
+  const [email, setEmail] = useState(authContext.user.email);
+  const [profilePassword, setProfilePassword] = useState("");
+  const [isSavingProfile, setIsSavingProfile] = useState(false);
+  const emailChanged = email !== authContext.user.email;
+  const handleSaveProfile = async () => {
+    setIsSavingProfile(true);
+    try {
+      await api.updateProfile(
+        {
+          username:
+            username !== authContext.user.username ? username : undefined,
+          email: emailChanged ? email : undefined,
+        },
+        emailChanged ? profilePassword : undefined
+      );
+      setProfilePassword("");
+      await refreshAuthContext();
+      if (emailChanged) {
+        // The new email is only used once confirmed from the mail sent to it
+        setEmail(authContext.user.email);
+        showToast(
+          "Un mail de confirmation a été envoyé à votre nouvelle adresse",
+          "success"
+        );
+      } else {
+        showToast("Votre profil a été mis à jour", "success");
+      }
+    } catch (error) {
+      if (error instanceof AuthAPIError) {
+        if (error.code === AuthAPIErrorCode.ALREADY_USED) {
+          showToast(
+            "Ce pseudo ou cette adresse mail est déjà utilisé dans le groupe",
+            "error"
+          );
+        } else if (error.code === AuthAPIErrorCode.BAD_PASSWORD) {
+          showToast("Mot de passe incorrect", "error");
+        } else if (error.code === AuthAPIErrorCode.BAD_EMAIL) {
+          showToast("Adresse mail invalide", "error");
+        } else {
+          showToast(
+            "Une erreur est survenue lors de la mise à jour du profil",
+            "error"
+          );
+        }
+      } else {
+        showToast(
+          "Une erreur est survenue lors de la mise à jour du profil",
+          "error"
+        );
+      }
     } finally {
-      setIsChangingWishes(false);
+      setIsSavingProfile(false);
     }
   };
 
@@ -91,6 +395,46 @@ export default function UserDashboard() {
     }
   };
 
+  const handleExport = async () => {
+    try {
+      const data = await api.exportAccount();
+      const url = URL.createObjectURL(
+        new Blob([JSON.stringify(data, null, 2)], { type: "application/json" })
+      );
+      const link = document.createElement("a");
+      link.href = url;
+      link.download = "super-santa-export.json";
+      link.click();
+      URL.revokeObjectURL(url);
+    } catch {
+      showToast("Une erreur est survenue lors de l'export des données", "error");
+    }
+  };
+
+  const [isDeleting, setIsDeleting] = useState(false);
+  const handleDeleteAccount = async () => {
+    if (
+      !window.confirm(
+        "Supprimer définitivement votre compte ? Cette action est irréversible."
+      )
+    )
+      return;
+
+    setIsDeleting(true);
+    try {
+      await api.deleteAccount();
+      showToast("Votre compte a été supprimé", "success");
+      logout();
+      router.push("/");
+    } catch {
+      setIsDeleting(false);
+      showToast(
+        "Impossible de supprimer le compte : le tirage est en cours ou terminé, ou vous êtes administrateur",
+        "error"
+      );
+    }
+  };
+
   return (
     <div>
       <div id="HEADER" className="flex px-10 py-5 gap-x-10 justify-end">
@@ -139,7 +483,9 @@ export default function UserDashboard() {
             >
               <div id="PHRASE" className="flex pl-15">
                 <p className="text-2xl text-left font-extrabold">
-                  Tu es le père Noël de :
+                  {authContext.user.participates
+                    ? "Tu es le père Noël de :"
+                    : "Tu organises ce tirage sans y participer"}
                 </p>
               </div>
               <div
@@ -150,16 +496,70 @@ export default function UserDashboard() {
                   <p className="text-4xl text-left font-serif">
                     {santa.username}
                   </p>
-                ) : (
+                ) : authContext.user.participates ? (
                   <MatrixPlaceholder className="w-full" />
+                ) : (
+                  <p className="text-xl text-left">
+                    Tu ne recevras ni n’offriras de cadeau.
+                  </p>
                 )}
               </div>
+              {recipients.length > 1 && (
+                <div id="RECIPIENTS" className="flex pl-15 gap-x-5">
+                  <p className="text-base text-left">Tes cibles :</p>
+                  {recipients.map((recipient) => (
+                    <button
+                      key={recipient.id}
+                      className={`text-base cursor-pointer hover:underline ${
+                        recipient.id === santa?.id ? "font-extrabold" : ""
+                      }`}
+                      onClick={() => setSanta(recipient)}
+                    >
+                      {recipient.username}
+                    </button>
+                  ))}
+                </div>
+              )}
             </div>
 
             {santa ? (
               <div id="LIST" className="flex flex-col p-5 gap-y-3">
+                {recipientAddress && (
+                  <p className="text-xl text-left whitespace-pre-line">
+                    Son adresse : {recipientAddress}
+                  </p>
+                )}
                 <p className="text-xl text-left">Sa liste au Père Noël :</p>
-                <p className="text-xl text-left">{santa.wishes}</p>
+                <WishList items={santa.wishes} handleClaim={handleClaimWish} />
+                <div className="flex gap-x-5 items-center">
+                  <p className="text-xl text-left grow">
+                    Mon cadeau :{" "}
+                    {santaThread?.gift_status
+                      ? giftStatusLabels[santaThread.gift_status]
+                      : "Pas encore acheté"}
+                  </p>
+                  <button
+                    className="text-base hover:underline cursor-pointer"
+                    onClick={() => handleGiftStatus("bought")}
+                  >
+                    Je l’ai acheté
+                  </button>
+                  <button
+                    className="text-base hover:underline cursor-pointer"
+                    onClick={() => handleGiftStatus("shipped")}
+                  >
+                    Je l’ai expédié
+                  </button>
+                </div>
+                <p className="text-xl text-left">
+                  Écrire à ma cible anonymement :
+                </p>
+                <Mailbox
+                  messages={santaThread?.messages ?? []}
+                  mine={(message) => message.from_santa}
+                  handleSend={handleWriteToRecipient}
+                  placeholder="Une question sur sa liste ?"
+                />
               </div>
             ) : (
               <div id="LIST" className="flex flex-col p-5 gap-y-3">
@@ -173,6 +573,51 @@ export default function UserDashboard() {
         </div>
       </div>
 
+      {inbox.length > 0 && (
+        <div id="INBOX" className="flex flex-col gap-y-5 px-40 pt-10">
+          <p className="text-2xl text-center font-extrabold">
+            Messages de mon Père Noël secret
+          </p>
+          {inbox.map((thread) => (
+            <div
+              key={thread.id}
+              className="flex flex-col p-5 rounded-xl outline-1 outline-beige-500 shadow-sm-beige"
+            >
+              <div className="flex gap-x-5 items-center pb-3">
+                <p className="text-xl text-left grow">
+                  Mon cadeau :{" "}
+                  {thread.gift_status
+                    ? giftStatusLabels[thread.gift_status]
+                    : "Pas encore acheté"}
+                </p>
+                {thread.result_id && (
+                  <>
+                    <button
+                      className="text-base hover:underline cursor-pointer"
+                      onClick={() => handleConfirmGift(thread, "received")}
+                    >
+                      Je l’ai reçu
+                    </button>
+                    <button
+                      className="text-base hover:underline cursor-pointer"
+                      onClick={() => handleConfirmGift(thread, "thanked")}
+                    >
+                      Merci !
+                    </button>
+                  </>
+                )}
+              </div>
+              <Mailbox
+                messages={thread.messages}
+                mine={(message) => !message.from_santa}
+                handleSend={(text) => handleReplyToSanta(thread, text)}
+                placeholder="Répondre"
+              />
+            </div>
+          ))}
+        </div>
+      )}
+
       <div id="MEMBERS" className="flex flex-col gap-y-10 px-20 py-10">
         <p className="text-2xl text-center font-extrabold">Participants</p>
         <div
@@ -180,15 +625,7 @@ export default function UserDashboard() {
           className="flex flex-row gap-10 flex-wrap justify-center"
         >
           {authContext.group.users.map((user) => (
-            <UserCard
-              key={user.id}
-              id={user.id}
-              username={user.username}
-              email={user.email}
-              is_admin={user.is_admin}
-              wishes={user.wishes}
-              created_at={user.created_at}
-            />
+            <UserCard key={user.id} {...user} />
           ))}
         </div>
       </div>
@@ -209,21 +646,69 @@ export default function UserDashboard() {
                 <Input
                   type="email"
                   placeholder="Adresse mail"
-                  value={authContext.user.email}
-                  onChange={() => {}}
-                  disabled={true}
-                  className="text-white-700"
+                  value={email}
+                  onChange={(e) => setEmail(e.target.value)}
+                  disabled={isSavingProfile}
                 />
+                {authContext.user.pending_email && (
+                  <p className="col-start-2 text-base text-left">
+                    {authContext.user.pending_email} en attente de confirmation
+                  </p>
+                )}
                 <p className="text-xl text-left">Pseudo</p>
                 <Input
                   type="text"
                   placeholder="Pseudo"
-                  value={authContext.user.username}
-                  onChange={() => {}}
-                  disabled={true}
-                  className="text-white-700"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  disabled={isSavingProfile}
                 />
+                {emailChanged && (
+                  <>
+                    <p className="text-xl text-left">Mot de passe</p>
+                    <Input
+                      type="password"
+                      placeholder="Mot de passe actuel"
+                      value={profilePassword}
+                      onChange={(e) => setProfilePassword(e.target.value)}
+                      disabled={isSavingProfile}
+                    />
+                  </>
+                )}
               </div>
+              <button
+                className="text-base text-center hover:underline cursor-pointer"
+                onClick={handleSaveProfile}
+                disabled={
+                  isSavingProfile ||
+                  !username ||
+                  !email ||
+                  (emailChanged && !profilePassword)
+                }
+              >
+                Enregistrer mon profil
+              </button>
+              {authContext.group.state === "drawn" && (
+                <div className="flex flex-col gap-y-3">
+                  <p className="text-xl text-left">
+                    Adresse de livraison (lisible par mon Père Noël seulement)
+                  </p>
+                  <textarea
+                    placeholder="Adresse"
+                    value={address}
+                    onChange={(e) => setAddress(e.target.value)}
+                    disabled={isSavingAddress}
+                    className="h-25 bg-white-500 text-black-500 text-left text-base px-3 py-1 rounded-lg outline-1 outline-beige-500"
+                  />
+                  <button
+                    className="text-base text-center hover:underline cursor-pointer"
+                    onClick={handleSaveAddress}
+                    disabled={isSavingAddress}
+                  >
+                    Enregistrer mon adresse
+                  </button>
+                </div>
+              )}
               {!authContext.user.is_admin && (
                 <button
                   className="text-base text-red-500 text-center hover:underline cursor-pointer"
@@ -233,14 +718,75 @@ export default function UserDashboard() {
                   Me retirer du tirage
                 </button>
               )}
+              <button
+                className="text-base text-center hover:underline cursor-pointer"
+                onClick={handleExport}
+              >
+                Télécharger mes données
+              </button>
+              <button
+                className="text-base text-red-500 text-center hover:underline cursor-pointer"
+                onClick={handleDeleteAccount}
+                disabled={isDeleting}
+              >
+                Supprimer mon compte
+              </button>
             </div>
 
             <div id="RIGHT" className="flex flex-col grow gap-y-3">
               <p className="text-xl text-left">Ma liste au Père Noël</p>
+              {authContext.group.budget !== null && (
+                <p className="text-base text-left">
+                  Budget :{" "}
+                  {formatPrice(
+                    authContext.group.budget,
+                    authContext.group.currency
+                  )}
+                </p>
+              )}
+              <WishList
+                items={authContext.user.wishes}
+                handleDelete={handleDeleteWish}
+              />
+              <Input
+                type="text"
+                placeholder="Souhait"
+                value={wishTitle}
+                onChange={(e) => setWishTitle(e.target.value)}
+                disabled={isChangingWishes}
+              />
+              <Input
+                type="url"
+                placeholder="Lien (facultatif)"
+                value={wishURL}
+                onChange={(e) => setWishURL(e.target.value)}
+                disabled={isChangingWishes}
+              />
+              <div className="flex gap-x-3">
+                <Input
+                  type="text"
+                  placeholder="Prix (facultatif)"
+                  value={wishPrice}
+                  onChange={(e) => setWishPrice(e.target.value)}
+                  disabled={isChangingWishes}
+                  className="grow"
+                />
+                <select
+                  value={wishPriority}
+                  onChange={(e) => setWishPriority(Number(e.target.value))}
+                  disabled={isChangingWishes}
+                  className="bg-white-500 text-black-500 text-base px-3 py-1 rounded-lg outline-1 outline-beige-500"
+                >
+                  <option value={0}>Priorité</option>
+                  <option value={1}>★</option>
+                  <option value={2}>★★</option>
+                  <option value={3}>★★★</option>
+                </select>
+              </div>
               <textarea
-                placeholder="Mes souhaits"
-                value={wishes}
-                onChange={(e) => setWishes(e.target.value)}
+                placeholder="Notes (taille, couleur…)"
+                value={wishNotes}
+                onChange={(e) => setWishNotes(e.target.value)}
                 disabled={isChangingWishes}
                 className="h-25 bg-white-500 text-black-500 text-left text-base px-3 py-1 rounded-lg outline-1 outline-beige-500 "
               />
@@ -249,10 +795,10 @@ export default function UserDashboard() {
 
           <div className="w-1/3">
             <AccentButton
-              onClick={handleChangeWishes}
-              disabled={isChangingWishes}
+              onClick={handleAddWish}
+              disabled={isChangingWishes || !wishTitle}
             >
-              Enregistrer
+              Ajouter
             </AccentButton>
           </div>
         </div>
@@ -48,7 +48,11 @@ export default function NewGroup() {
         const { group, user } = await api.createGroup(
           PreCreateGroupData.groupName,
           PreCreateGroupData.password,
-          { email: data.email, username: data.pseudo, password: data.password }
+          { email: data.email, username: data.pseudo, password: data.password },
+          {
+            encryptedWishes: PreCreateGroupData.encryptedWishes,
+            adminParticipates: PreCreateGroupData.adminParticipates,
+          }
         );
         setAuthContext({ user, group });
         showToast(`Groupe "${group.name}" créé avec succès !`, "success");
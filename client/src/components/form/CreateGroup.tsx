@@ -7,6 +7,8 @@ export type PreCreateGroupProps = {
   groupName: string;
   password: string;
   passwordConfirm: string;
+  encryptedWishes: boolean;
+  adminParticipates: boolean;
 };
 
 export interface CreateGroupComponentProps {
@@ -111,6 +113,27 @@ const CreateGroup: React.FC<CreateGroupComponentProps> = ({
           groupe. Notez le bien, vous ne pourrez pas le modifier.
         </p>
 
+        <label className="flex items-start gap-x-3 text-xl text-left col-span-2">
+          <input
+            {...register("encryptedWishes")}
+            type="checkbox"
+            className="mt-2"
+          />
+          Chiffrer les souhaits avec le mot de passe du groupe : même
+          l’hébergeur du site ne pourra pas les lire. Ce choix est définitif.
+        </label>
+
+        <label className="flex items-start gap-x-3 text-xl text-left col-span-2">
+          <input
+            {...register("adminParticipates")}
+            type="checkbox"
+            defaultChecked={true}
+            className="mt-2"
+          />
+          Je participe au tirage. Décochez pour organiser sans recevoir ni
+          offrir de cadeau.
+        </label>
+
         <div className="col-span-2">
           <PrimaryButton type="submit">Suivant</PrimaryButton>
         </div>
@@ -0,0 +1,64 @@
+import React from "react";
+import type { MailboxMessage } from "super-santa-sdk/dist/api/dto/mailbox.d.ts";
+import Input from "@/components/ui/Input";
+
+// Conversation of a mailbox thread, mine is true for the messages of the viewer
+const Mailbox: React.FC<{
+  messages: MailboxMessage[];
+  mine: (message: MailboxMessage) => boolean;
+  handleSend?: (text: string) => Promise<boolean>;
+  placeholder?: string;
+}> = ({ messages, mine, handleSend, placeholder }) => {
+  const [text, setText] = React.useState("");
+  const [sending, setSending] = React.useState(false);
+
+  return (
+    <div className="flex flex-col gap-y-3">
+      {messages.length === 0 && (
+        <p className="text-base text-left">Aucun message pour l’instant.</p>
+      )}
+      {messages.map((message) => (
+        <p
+          key={message.id}
+          className={`text-base whitespace-pre-line px-3 py-1 rounded-lg max-w-2/3 ${
+            mine(message)
+              ? "self-end text-right bg-beige-500"
+              : "self-start text-left outline-1 outline-beige-500"
+          }`}
+        >
+          {message.text ?? "Message illisible"}
+        </p>
+      ))}
+      {handleSend && (
+        <form
+          className="flex gap-x-3"
+          onSubmit={async (e) => {
+            e.preventDefault();
+            if (!text) return;
+            setSending(true);
+            if (await handleSend(text)) setText("");
+            setSending(false);
+          }}
+        >
+          <Input
+            type="text"
+            placeholder={placeholder ?? "Message"}
+            value={text}
+            onChange={(e) => setText(e.target.value)}
+            disabled={sending}
+            className="grow"
+          />
+          <button
+            type="submit"
+            className="text-base hover:underline cursor-pointer disabled:opacity-10 disabled:cursor-not-allowed"
+            disabled={sending || !text}
+          >
+            Envoyer
+          </button>
+        </form>
+      )}
+    </div>
+  );
+};
+
+export default Mailbox;
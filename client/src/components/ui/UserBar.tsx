@@ -4,9 +4,29 @@ import { TbTrash } from "react-icons/tb";
 import Avatar from "boring-avatars";
 
 const UserCard: React.FC<
-  User & { handleDelete: (userId: string) => Promise<void> }
-> = ({ id, username, email, is_admin, wishes, created_at, handleDelete }) => {
+  User & {
+    handleDelete: (userId: string) => Promise<void>;
+    handleParticipation?: (
+      userId: string,
+      participates: boolean
+    ) => Promise<void>;
+    handleTeam?: (userId: string, team: string) => Promise<void>;
+  }
+> = ({
+  id,
+  username,
+  email,
+  is_admin,
+  participates,
+  team,
+  created_at,
+  handleDelete,
+  handleParticipation,
+  handleTeam,
+}) => {
   const [deleting, setDeleting] = React.useState(false);
+  const [teamInput, setTeamInput] = React.useState(team);
+  React.useEffect(() => setTeamInput(team), [team]);
 
   return (
     <div className="flex items-center px-5 py-3 gap-x-10 outline-1 outline-beige-500 rounded-xl">
@@ -26,6 +46,29 @@ const UserCard: React.FC<
         <p className="text-xl font-bold">depuis le :</p>
         <p className="text-xl">{new Date(created_at).toLocaleDateString()}</p>
       </div>
+      <label className="flex items-center gap-x-2 text-base whitespace-nowrap">
+        <input
+          type="checkbox"
+          checked={participates}
+          onChange={(e) => handleParticipation?.(id, e.target.checked)}
+          disabled={!handleParticipation}
+        />
+        Participe
+      </label>
+      <input
+        className="w-32 text-base px-3 py-1 rounded-lg outline-1 outline-beige-500 disabled:opacity-50"
+        placeholder="Équipe"
+        maxLength={64}
+        value={teamInput}
+        onChange={(e) => setTeamInput(e.target.value)}
+        onBlur={() => {
+          if (teamInput.trim() !== team) handleTeam?.(id, teamInput.trim());
+        }}
+        onKeyDown={(e) => {
+          if (e.key === "Enter") e.currentTarget.blur();
+        }}
+        disabled={!handleTeam}
+      />
       <button
         className="rounded-full text-red-500 outline-1 p-2 outline-red-500 cursor-pointer shadow-sm-red hover:bg-red-500 hover:text-white transition-all duration-300 ease-in-out disabled:opacity-10 disabled:cursor-not-allowed"
         onClick={async () => {
@@ -0,0 +1,89 @@
+import React from "react";
+import type { WishItem } from "super-santa-sdk/dist/api/dto/user.d.ts";
+import { TbTrash } from "react-icons/tb";
+
+export const formatPrice = (price: number, currency: string) =>
+  new Intl.NumberFormat("fr-FR", { style: "currency", currency }).format(
+    price / 100
+  );
+
+const WishList: React.FC<{
+  items: WishItem[];
+  handleDelete?: (itemId: string) => Promise<void>;
+  handleClaim?: (item: WishItem) => Promise<void>;
+}> = ({ items, handleDelete, handleClaim }) => {
+  const [deleting, setDeleting] = React.useState<string | null>(null);
+  const [claiming, setClaiming] = React.useState<string | null>(null);
+
+  if (items.length === 0)
+    return <p className="text-xl text-left">Aucun souhait pour l’instant.</p>;
+
+  return (
+    <ul className="flex flex-col gap-y-3">
+      {items.map((item) => (
+        <li key={item.id} className="flex items-start gap-x-3">
+          <div className="flex flex-col grow">
+            <p className="text-xl text-left">
+              {"★".repeat(item.priority)}
+              {item.priority > 0 && " "}
+              {item.url ? (
+                <a
+                  href={item.url}
+                  target="_blank"
+                  rel="noopener noreferrer"
+                  className="underline"
+                >
+                  {item.title}
+                </a>
+              ) : (
+                item.title
+              )}
+              {item.price !== null && (
+                <span className="text-base">
+                  {" "}
+                  — {formatPrice(item.price, item.currency)}
+                </span>
+              )}
+            </p>
+            {item.notes && (
+              <p className="text-base text-left whitespace-pre-line">
+                {item.notes}
+              </p>
+            )}
+          </div>
+          {handleClaim &&
+            (item.claimed && !item.claimed_by_me ? (
+              <p className="text-base text-white-700">Déjà pris</p>
+            ) : (
+              <button
+                className="text-base hover:underline cursor-pointer disabled:opacity-10 disabled:cursor-not-allowed"
+                onClick={async () => {
+                  setClaiming(item.id);
+                  await handleClaim(item);
+                  setClaiming(null);
+                }}
+                disabled={claiming === item.id}
+              >
+                {item.claimed_by_me ? "Je ne l’achète plus" : "Je l’achète"}
+              </button>
+            ))}
+          {handleDelete && (
+            <button
+              className="rounded-full text-red-500 p-1 cursor-pointer hover:bg-red-500 hover:text-white transition-all duration-300 ease-in-out disabled:opacity-10 disabled:cursor-not-allowed"
+              onClick={async () => {
+                setDeleting(item.id);
+                await handleDelete(item.id);
+                setDeleting(null);
+              }}
+              disabled={deleting === item.id}
+            >
+              <TbTrash size={20} />
+            </button>
+          )}
+        </li>
+      ))}
+    </ul>
+  );
+};
+
+export default WishList;
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/utils"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const usage = `Usage: server [command]
+
+Without a command the HTTP server is started.
+
+Commands:
+  migrate up [version]     Apply pending migrations, up to version if given
+  migrate down [version]   Revert migrations down to version, one step if not given
+  migrate status           List migrations and their state
+  export <file>            Write every table to an archive
+  import <file>            Restore an archive into an empty database
+  encryption rotate        Re-encrypt the user columns and notifications with the primary key
+`
+
+var errUsage = errors.New("invalid usage")
+
+// runCommand runs a CLI command with only the config, the logger and the database available
+func runCommand(args []string) int {
+	var commandErr error
+	app := fx.New(
+		fx.Provide(
+			utils.InitZap,
+			utils.InitConfig,
+			database.NewDB,
+		),
+		fx.NopLogger,
+		fx.Invoke(func(db *database.DB, log *zap.Logger) {
+			switch args[0] {
+			case "migrate":
+				commandErr = migrateCommand(db, log, args[1:])
+			case "export":
+				commandErr = exportCommand(db, log, args[1:])
+			case "import":
+				commandErr = importCommand(db, log, args[1:])
+			case "encryption":
+				commandErr = encryptionCommand(db, log, args[1:])
+			default:
+				commandErr = errUsage
+			}
+		}),
+	)
+	if err := app.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := app.Start(ctx); err == nil {
+		app.Stop(ctx)
+	}
+
+	if errors.Is(commandErr, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if commandErr != nil {
+		fmt.Fprintln(os.Stderr, commandErr)
+		return 1
+	}
+	return 0
+}
+
+func migrateCommand(db *database.DB, log *zap.Logger, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errUsage
+	}
+
+	version := -1
+	if len(args) == 2 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return errUsage
+		}
+		version = v
+	}
+
+	switch args[0] {
+	case "up":
+		if version == -1 {
+			version = 0 // Latest
+		}
+		applied, err := db.MigrateUp(version)
+		for _, m := range applied {
+			log.Info("Migration applied", zap.Int("version", m.Version), zap.String("name", m.Name))
+		}
+		return err
+	case "down":
+		if version == -1 {
+			current, err := db.SchemaVersion()
+			if err != nil {
+				return err
+			}
+			version = max(current-1, 0)
+		}
+		reverted, err := db.MigrateDown(version)
+		for _, m := range reverted {
+			log.Info("Migration reverted", zap.Int("version", m.Version), zap.String("name", m.Name))
+		}
+		return err
+	case "status":
+		status, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range status {
+			appliedAt := "pending"
+			if m.AppliedAt != nil {
+				appliedAt = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errUsage
+	}
+}
+
+func exportCommand(db *database.DB, log *zap.Logger, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	// Archives hold verifiers and encrypted keys, keep them private
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	data, err := db.Export(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return err
+	}
+
+	logArchive(log, "Archive exported", args[0], data)
+	return nil
+}
+
+func importCommand(db *database.DB, log *zap.Logger, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := db.Import(file)
+	if err != nil {
+		return err
+	}
+
+	logArchive(log, "Archive imported", args[0], data)
+	return nil
+}
+
+func logArchive(log *zap.Logger, msg string, path string, data *database.ArchiveData) {
+	log.Info(msg,
+		zap.String("file", path),
+		zap.Int("groups", len(data.Groups)),
+		zap.Int("users", len(data.Users)),
+		zap.Int("wishItems", len(data.WishItems)),
+		zap.Int("drawResults", len(data.DrawResults)),
+		zap.Int("mailboxThreads", len(data.MailboxThreads)),
+		zap.Int("mailboxMessages", len(data.MailboxMessages)),
+		zap.Int("notificationPreferences", len(data.NotificationPreferences)),
+		zap.Int("notifications", len(data.Notifications)))
+}
+
+func encryptionCommand(db *database.DB, log *zap.Logger, args []string) error {
+	if len(args) != 1 || args[0] != "rotate" {
+		return errUsage
+	}
+
+	users, wishItems, notifications, err := db.RotateEncryption()
+	if err != nil {
+		return err
+	}
+	log.Info("User columns re-encrypted", zap.Int("users", users), zap.Int("wishItems", wishItems), zap.Int("notifications", notifications))
+	return nil
+}
@@ -1,31 +1,39 @@
 package controllers
 
 import (
+	"encoding/json"
 	"errors"
+	"html/template"
 	"onxzy/super-santa-server/controllers/dto"
+	"onxzy/super-santa-server/database/models"
 	"onxzy/super-santa-server/middlewares"
 	"onxzy/super-santa-server/services"
 	"onxzy/super-santa-server/services/authService"
 	"onxzy/super-santa-server/services/groupService"
 	"onxzy/super-santa-server/services/userService"
 	"onxzy/super-santa-server/utils"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthController struct {
-	config       *utils.Config
-	groupService *services.GroupService
-	authService  *services.AuthService
-	userService  *services.UserService
+	config              *utils.Config
+	groupService        *services.GroupService
+	authService         *services.AuthService
+	userService         *services.UserService
+	notificationService *services.NotificationService
+	mailboxService      *services.MailboxService
 }
 
-func NewAuthController(confg *utils.Config, groupService *services.GroupService, authService *services.AuthService, userService *services.UserService) *AuthController {
+func NewAuthController(confg *utils.Config, groupService *services.GroupService, authService *services.AuthService, userService *services.UserService, notificationService *services.NotificationService, mailboxService *services.MailboxService) *AuthController {
 	return &AuthController{
-		config:       confg,
-		groupService: groupService,
-		authService:  authService,
-		userService:  userService,
+		config:              confg,
+		groupService:        groupService,
+		authService:         authService,
+		userService:         userService,
+		notificationService: notificationService,
+		mailboxService:      mailboxService,
 	}
 }
 
@@ -37,6 +45,14 @@ func (ac *AuthController) RegisterRoutes(router *gin.RouterGroup, authMiddleware
 	router.POST("/login/challenge", ac.GetLoginChallenge)
 	router.POST("/login", ac.PostUserLogin)
 	router.GET("/login", authMiddleware.Auth, ac.GetUser)
+
+	router.POST("/me/challenge", authMiddleware.Auth, ac.GetUserChallenge)
+	router.PUT("/me", authMiddleware.Auth, ac.UpdateUser)
+	router.GET("/email/confirm", ac.GetConfirmEmail)
+	router.POST("/email/confirm", ac.PostConfirmEmail)
+	router.GET("/me/export", authMiddleware.Auth, ac.ExportUser)
+	router.PUT("/me/message-key", authMiddleware.Auth, ac.UpdateMessageKey)
+	router.DELETE("/me", authMiddleware.Auth, ac.DeleteUser)
 }
 
 // GetUser
@@ -52,24 +68,354 @@ func (ac *AuthController) GetUser(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(200, &dto.GetUserResponse{
+	c.JSON(200, userResponse(u))
+}
+
+func userResponse(u *models.User) *dto.GetUserResponse {
+	return &dto.GetUserResponse{
 		ID:        u.ID,
 		CreatedAt: u.CreatedAt,
 		UpdatedAt: u.UpdatedAt,
 
-		Username: u.Username,
-		Email:    u.Email,
+		Username:     u.Username,
+		Email:        u.Email,
+		PendingEmail: u.PendingEmail,
 
-		GroupID: u.GroupID,
-		IsAdmin: u.IsAdmin,
+		GroupID:      u.GroupID,
+		IsAdmin:      u.IsAdmin,
+		Participates: u.Participates,
+		Team:         u.Team,
 
 		PublicKeySecret:     u.PublicKeySecret,
 		PrivateKeyEncrypted: u.PrivateKeyEncrypted,
 
-		Wishes: u.Wishes,
+		MessageKeySecret:    u.MessageKeySecret,
+		MessageKeyEncrypted: u.MessageKeyEncrypted,
+
+		Wishes: u.WishItems,
+	}
+}
+
+// GetUserChallenge starts a login session for the authenticated user, to prove
+// their password again before sensitive changes
+func (ac *AuthController) GetUserChallenge(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	u, err := ac.userService.GetUser(claims.Subject)
+	if err != nil {
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	sessionID, challenge, err := ac.authService.InitiateUserLogin(u.GroupID, u.Email)
+	if err != nil {
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, dto.GetLoginChallengeResponse{
+		SessionID: sessionID,
+		Challenge: *challenge,
+	})
+}
+
+// UpdateUser changes the username or the email of the user. Changing the email
+// requires a proof of the password, since it becomes the SRP identity once
+// confirmed from the link mailed to it.
+func (ac *AuthController) UpdateUser(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := ac.userService.GetUser(claims.Subject)
+	if err != nil {
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var serverAuth string
+	if req.Email != "" && req.Email != u.Email {
+		if req.SessionID == "" || req.UserAuth == nil {
+			c.JSON(400, gin.H{"error": "session_id and user_auth are required to change the email"})
+			return
+		}
+
+		userID, session, err := ac.authService.CompleteLogin(authService.LoginSessionTypeUser, req.SessionID, &authService.SrpAuth{
+			ClientPubKey: req.UserAuth.ClientPubKey,
+			ClientAuth:   req.UserAuth.ClientAuth,
+		})
+		if err != nil {
+			var invalidSession *authService.InvalidSessionError
+			if errors.As(err, &invalidSession) {
+				c.JSON(401, gin.H{"error": "Unauthorized", "details": invalidSession.Error()})
+				return
+			}
+			if errors.Is(err, authService.ErrSrpAuthenticator) {
+				c.JSON(403, gin.H{"error": "Forbidden"})
+				return
+			}
+
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if userID != claims.Subject {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		serverAuth = session.ServerAuth
+	}
+
+	u, err = ac.userService.UpdateProfile(claims.GroupID, claims.Subject, req.Username, req.Email)
+	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserAlreadyExists) {
+			c.JSON(409, gin.H{"error": "Username already taken"})
+			return
+		}
+		if errors.Is(err, userService.ErrEmailAlreadyUsed) {
+			c.JSON(409, gin.H{"error": "Email already used"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, dto.UpdateUserResponse{
+		GetUserResponse: *userResponse(u),
+		ServerAuth:      serverAuth,
+	})
+}
+
+var confirmEmailPage = template.Must(template.New("confirm_email").Parse(`<!DOCTYPE html>
+<html>
+  <head><meta charset="UTF-8" /><title>Confirm your email</title></head>
+  <body style="font-family: Arial, sans-serif; text-align: center">
+    <h1>Confirm your new Secret Santa email</h1>
+    <form method="POST" action="?token={{.}}">
+      <button type="submit">Confirm</button>
+    </form>
+  </body>
+</html>`))
+
+// GetConfirmEmail only renders a confirmation page so link scanners can't confirm emails
+func (ac *AuthController) GetConfirmEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=UTF-8")
+	c.Status(200)
+	if err := confirmEmailPage.Execute(c.Writer, token); err != nil {
+		c.Error(err)
+	}
+}
+
+// PostConfirmEmail replaces the email of the user by the pending one the token was sent to
+func (ac *AuthController) PostConfirmEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	if _, err := ac.userService.ConfirmEmail(token); err != nil {
+		if errors.Is(err, userService.ErrInvalidEmailToken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, userService.ErrEmailAlreadyUsed) {
+			c.JSON(409, gin.H{"error": "Email already used"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Email confirmed"})
+}
+
+// UpdateMessageKey stores the key pair of the mailbox of a user who joined before it existed
+func (ac *AuthController) UpdateMessageKey(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.UpdateMessageKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	u, err := ac.userService.SetMessageKey(claims.GroupID, claims.Subject, req.MessageKeySecret, req.MessageKeyEncrypted)
+	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrMessageKeySet) {
+			c.JSON(409, gin.H{"error": "Message key already set"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, userResponse(u))
+}
+
+// ExportUser returns a copy of everything stored about the user
+func (ac *AuthController) ExportUser(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	u, err := ac.userService.GetUser(claims.Subject)
+	if err != nil {
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := ac.groupService.GetGroup(u.GroupID)
+	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := ac.notificationService.GetPreferences(u.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	history, err := ac.notificationService.GetHistory(u.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	notifications := make([]dto.ExportUserNotification, 0, len(history))
+	for _, n := range history {
+		var data json.RawMessage
+		if json.Valid([]byte(n.Data)) {
+			data = json.RawMessage(n.Data)
+		}
+		notifications = append(notifications, dto.ExportUserNotification{
+			ID:        n.ID,
+			CreatedAt: n.CreatedAt,
+			Event:     n.Event,
+			Subject:   n.Subject,
+			Data:      data,
+			Delivery:  n.Delivery,
+			SentAt:    n.SentAt,
+		})
+	}
+
+	claimed := make([]dto.ExportUserClaim, 0)
+	for _, member := range group.Users {
+		for _, item := range member.WishItems {
+			if item.ClaimedByID != nil && *item.ClaimedByID == u.ID && item.ClaimedAt != nil {
+				claimed = append(claimed, dto.ExportUserClaim{WishItemID: item.ID, Title: item.Title, Payload: item.Payload, ClaimedAt: *item.ClaimedAt})
+			}
+		}
+	}
+
+	threads, err := ac.mailboxService.GetUserThreads(u.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="super-santa-export.json"`)
+	c.JSON(200, &dto.ExportUserResponse{
+		ExportedAt: time.Now().UTC(),
+		Profile:    *userResponse(u),
+		Group: dto.ExportUserGroup{
+			ID:           group.ID,
+			Name:         group.Name,
+			State:        group.State,
+			DrawRound:    group.DrawRound,
+			ExchangeDate: group.ExchangeDate,
+			JoinedAt:     u.CreatedAt,
+			IsAdmin:      u.IsAdmin,
+		},
+		NotificationPreferences: prefs,
+		Notifications:           notifications,
+		Claims:                  claimed,
+		Mailbox:                 threads,
 	})
 }
 
+// DeleteUser erases the account of the user
+func (ac *AuthController) DeleteUser(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	if err := ac.userService.EraseAccount(claims.GroupID, claims.Subject); err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserIsAdmin) {
+			c.JSON(403, gin.H{"error": "The admin can't delete their account, transfer the admin role first"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(204)
+}
+
 // Group Auth
 
 func (ac *AuthController) GetGroup(c *gin.Context) {
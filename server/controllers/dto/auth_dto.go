@@ -1,6 +1,7 @@
 package dto
 
 import (
+	"onxzy/super-santa-server/database/models"
 	"onxzy/super-santa-server/services/authService"
 	"time"
 )
@@ -10,16 +11,41 @@ type GetUserResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
-	Username string `json:"username"`
-	Email    string `json:"email"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	PendingEmail string `json:"pending_email,omitempty"` // Awaiting confirmation from the link mailed to it
 
-	GroupID string `json:"group_id"`
-	IsAdmin bool   `json:"is_admin"`
+	GroupID      string `json:"group_id"`
+	IsAdmin      bool   `json:"is_admin"`
+	Participates bool   `json:"participates"`
+	Team         string `json:"team"`
 
 	PublicKeySecret     string `json:"public_key_secret"`     // User public key encrypted with group secret
 	PrivateKeyEncrypted string `json:"private_key_encrypted"` // Encrypted user private key with password
 
-	Wishes string `json:"wishes"`
+	MessageKeySecret    string `json:"message_key_secret"`    // Public key of the mailbox encrypted with group secret, empty until the client creates it
+	MessageKeyEncrypted string `json:"message_key_encrypted"` // Private key of the mailbox encrypted with password
+
+	Wishes []models.WishItem `json:"wishes"`
+}
+
+// UpdateUserRequest changes the profile of the user, empty fields are left unchanged
+type UpdateUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email" binding:"omitempty,email"`
+
+	// Proof of the password, required to change the email. The session comes
+	// from POST /auth/me/challenge.
+	SessionID string `json:"session_id"`
+	UserAuth  *struct {
+		ClientPubKey string `json:"client_pub_key" binding:"required"`
+		ClientAuth   string `json:"client_auth" binding:"required"`
+	} `json:"user_auth"`
+}
+
+type UpdateUserResponse struct {
+	GetUserResponse
+	ServerAuth string `json:"server_auth,omitempty"` // Set when the request carried a proof of the password
 }
 
 type GetGroupAuthRequest struct {
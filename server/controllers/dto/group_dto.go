@@ -2,15 +2,25 @@ package dto
 
 import (
 	"onxzy/super-santa-server/database/models"
+	"time"
 )
 
 type CreateGroupRequest struct {
 	Name           string            `json:"name" binding:"required"`
 	SecretVerifier string            `json:"secret_verifier" binding:"required"`
+	ExchangeDate   *time.Time        `json:"exchange_date"`
 	Admin          CreateUserRequest `json:"admin" binding:"required"`
+
+	// Wish items are encrypted by the members under the group secret, can't be changed later
+	EncryptedWishes bool `json:"encrypted_wishes"`
+
+	AdminParticipates *bool `json:"admin_participates"` // The admin is part of the draw unless false
 }
 
-type GetGroupResponse = models.Group
+type GetGroupResponse struct {
+	*models.Group
+	Results []string `json:"results"` // Every draw result of the round, each encrypted to the key of its giver
+}
 
 type JoinGroupRequest struct {
 	GroupToken string            `json:"group_token" binding:"required"`
@@ -21,8 +31,34 @@ type JoinGroupResponse = models.Group
 
 type InitDrawResponse struct {
 	PublicKeysSecret []string `json:"public_keys_secret"`
+	GiftsPerPerson   int      `json:"gifts_per_person"`
+	TeamTags         []string `json:"team_tags,omitempty"` // Opaque tag of the team of each key for the rule of the group, empty for no team
 }
 
 type FinishDrawRequest struct {
-	PublicKeys []string `json:"public_keys" binding:"required"`
+	PublicKeys []string `json:"public_keys" binding:"required"` // Key of the giver of each member of the draw session, once per gift
+	TeamProofs []string `json:"team_proofs"`                    // Team tag of the giver of each key, required with a rule
+}
+
+type UpdateGroupSettingsRequest struct {
+	ExchangeDate *time.Time `json:"exchange_date"`
+	Budget       *int64     `json:"budget" binding:"omitempty,min=0"` // In minor units of Currency, e.g. cents
+	Currency     string     `json:"currency" binding:"required_with=Budget,omitempty,iso4217"`
+
+	GiftsPerPerson int              `json:"gifts_per_person" binding:"omitempty,min=1,max=10"`   // Left unchanged when omitted, only before the draw
+	DrawRule       *models.DrawRule `json:"draw_rule" binding:"omitempty,oneof='' outside_team"` // Left unchanged when omitted, empty for none, only before the draw
+}
+
+type UpdateGroupSettingsResponse = models.Group
+
+type TransferAdminRequest struct {
+	UserID string `json:"user_id" binding:"required"`
+}
+
+type SetParticipationRequest struct {
+	Participates *bool `json:"participates" binding:"required"`
+}
+
+type SetTeamRequest struct {
+	Team string `json:"team" binding:"max=64"` // Empty for no team
 }
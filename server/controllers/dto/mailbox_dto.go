@@ -0,0 +1,53 @@
+package dto
+
+import (
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/mailboxService"
+)
+
+// StartThreadRequest opens an anonymous thread with the recipient of the santa
+type StartThreadRequest struct {
+	RecipientID string `json:"recipient_id" binding:"required"`
+	ReplyKey    string `json:"reply_key" binding:"required,max=4096"`          // Public JWK the recipient encrypts their replies to
+	SenderKey   string `json:"sender_key" binding:"required,max=16384"`        // Reply private key and sender token, encrypted to the santa's own key
+	SenderToken string `json:"sender_token" binding:"required,min=32,max=128"` // Random secret the santa posts with
+	ThreadToken string `json:"thread_token" binding:"required,max=128"`        // Token of the santa's draw result, binds the thread to it
+	Payload     string `json:"payload" binding:"omitempty,max=16384"`          // First message, a santa may open the thread only to track their gift
+	AddressKey  string `json:"address_key" binding:"omitempty,max=4096"`       // Key of the recipient's address, encrypted to their message key
+}
+
+// PostMessageRequest adds a message to a thread, the santa sends their token
+type PostMessageRequest struct {
+	SenderToken string `json:"sender_token" binding:"omitempty,min=32,max=128"`
+	Payload     string `json:"payload" binding:"required,max=16384"`
+}
+
+// SetGiftStatusRequest updates the progress of the gift of a thread, the santa sends their token
+type SetGiftStatusRequest struct {
+	SenderToken string            `json:"sender_token" binding:"omitempty,min=32,max=128"`
+	Status      models.GiftStatus `json:"status" binding:"required,oneof=bought shipped received thanked"`
+}
+
+// SetAddressKeyRequest gives the recipient of a thread the key of their address, from the santa
+type SetAddressKeyRequest struct {
+	SenderToken string `json:"sender_token" binding:"required,min=32,max=128"`
+	ThreadToken string `json:"thread_token" binding:"required,max=128"` // Token of the santa's draw result the thread is bound to
+	AddressKey  string `json:"address_key" binding:"required,max=4096"` // Encrypted to the recipient's message key
+}
+
+// SetAddressRequest sets the shipping address of the recipient of a thread
+type SetAddressRequest struct {
+	Address string `json:"address" binding:"max=2048"` // Encrypted under the address key of the thread, empty to remove it
+}
+
+type GetThreadsResponse = []models.MailboxThread
+
+type StartThreadResponse = models.MailboxThread
+
+type PostMessageResponse = models.MailboxMessage
+
+type SetGiftStatusResponse = models.MailboxThread
+
+type SetAddressResponse = models.MailboxThread
+
+type GetGiftSummaryResponse = mailboxService.GiftSummary
@@ -0,0 +1,12 @@
+package dto
+
+import "onxzy/super-santa-server/services/notificationService"
+
+type GetNotificationPreferencesResponse = notificationService.Preferences
+
+type UpdateNotificationPreferencesRequest struct {
+	Delivery notificationService.Delivery       `json:"delivery" binding:"omitempty,oneof=immediate digest"`
+	Events   map[notificationService.Event]bool `json:"events"`
+}
+
+type UpdateNotificationPreferencesResponse = notificationService.Preferences
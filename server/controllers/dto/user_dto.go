@@ -1,5 +1,12 @@
 package dto
 
+import (
+	"encoding/json"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/notificationService"
+	"time"
+)
+
 type CreateUserRequest struct {
 	Username         string `json:"username" binding:"required"`
 	Email            string `json:"email" binding:"required,email"`
@@ -7,12 +14,71 @@ type CreateUserRequest struct {
 
 	PublicKeySecret     string `json:"public_key_secret" binding:"required"`
 	PrivateKeyEncrypted string `json:"private_key_encrypted" binding:"required"`
+
+	MessageKeySecret    string `json:"message_key_secret" binding:"required"`
+	MessageKeyEncrypted string `json:"message_key_encrypted" binding:"required"`
+}
+
+// WishItemRequest creates or replaces a wish item
+type WishItemRequest struct {
+	Title    string `json:"title" binding:"required_without=Payload,max=200"`
+	URL      string `json:"url" binding:"omitempty,max=2048,http_url"`
+	Price    *int64 `json:"price" binding:"omitempty,min=0"`      // In minor units of Currency, e.g. cents
+	Currency string `json:"currency" binding:"omitempty,iso4217"` // Defaults to the currency of the group
+	Priority int    `json:"priority" binding:"min=0,max=3"`
+	Notes    string `json:"notes" binding:"max=2000"`
+	Order    *int   `json:"order" binding:"omitempty,min=0"` // Added last, or left unchanged, when omitted
+	Payload  string `json:"payload" binding:"max=16384"`     // Title, URL and notes encrypted under the group secret, in groups with encrypted wishes
+}
+
+// UpdateMessageKeyRequest gives a key pair of the mailbox to a user who joined before it existed
+type UpdateMessageKeyRequest struct {
+	MessageKeySecret    string `json:"message_key_secret" binding:"required,max=4096"`
+	MessageKeyEncrypted string `json:"message_key_encrypted" binding:"required,max=4096"`
+}
+
+type GetWishItemsResponse = []models.WishItem
+
+type WishItemResponse = models.WishItem
+
+// ExportUserResponse is everything the server stores about a user
+type ExportUserResponse struct {
+	ExportedAt time.Time `json:"exported_at"`
+
+	Profile GetUserResponse `json:"profile"`
+	Group   ExportUserGroup `json:"group"`
+
+	NotificationPreferences *notificationService.Preferences `json:"notification_preferences"`
+	Notifications           []ExportUserNotification         `json:"notifications"`
+	Claims                  []ExportUserClaim                `json:"claims"`
+	Mailbox                 []models.MailboxThread           `json:"mailbox"` // Threads addressed to the user, the santa ones can't be told apart
+}
+
+// ExportUserGroup describes the membership of the user, accounts belong to a single group
+type ExportUserGroup struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	State        models.GroupState `json:"state"`
+	DrawRound    int               `json:"draw_round"`
+	ExchangeDate *time.Time        `json:"exchange_date"`
+	JoinedAt     time.Time         `json:"joined_at"`
+	IsAdmin      bool              `json:"is_admin"`
 }
 
-type UpdateWishesRequest struct {
-	Wishes string `json:"wishes" binding:"required"`
+// ExportUserClaim is a wish item of another member claimed by the user
+type ExportUserClaim struct {
+	WishItemID string    `json:"wish_item_id"`
+	Title      string    `json:"title"`
+	Payload    string    `json:"payload"` // Encrypted title, in groups with encrypted wishes
+	ClaimedAt  time.Time `json:"claimed_at"`
 }
 
-type UpdateWishesResponse struct {
-	Wishes string `json:"wishes"`
+type ExportUserNotification struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	Event     string          `json:"event"`
+	Subject   string          `json:"subject"`
+	Data      json.RawMessage `json:"data"` // Values the email was rendered with
+	Delivery  string          `json:"delivery"`
+	SentAt    *time.Time      `json:"sent_at"`
 }
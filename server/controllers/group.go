@@ -9,6 +9,7 @@ import (
 	"onxzy/super-santa-server/services/authService"
 	"onxzy/super-santa-server/services/groupService"
 	"onxzy/super-santa-server/services/userService"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,11 +34,23 @@ func (gc *GroupController) RegisterRoutes(router *gin.RouterGroup, authMiddlewar
 
 	authRouter := router.Group("").Use(authMiddleware.Auth)
 	authRouter.GET("", gc.GetGroup)
-	authRouter.PUT("/wishes", gc.UpdateWishes)
+	authRouter.GET("/wishes", gc.GetWishItems)
+	authRouter.POST("/wishes", gc.CreateWishItem)
+	authRouter.PUT("/wishes/:item_id", gc.UpdateWishItem)
+	authRouter.DELETE("/wishes/:item_id", gc.DeleteWishItem)
+	authRouter.POST("/wishes/:item_id/claim", gc.ClaimWishItem)
+	authRouter.DELETE("/wishes/:item_id/claim", gc.UnclaimWishItem)
+	authRouter.PUT("/settings", gc.UpdateSettings)
+	authRouter.GET("/calendar.ics", gc.GetCalendar)
 	authRouter.GET("/draw", gc.InitDraw)
 	authRouter.POST("/draw", gc.FinishDraw)
+	authRouter.DELETE("/draw", gc.CancelDraw)
+	authRouter.POST("/archive", gc.ArchiveGroup)
 	authRouter.DELETE("/user/:user_id", gc.DeleteUser)
 	authRouter.DELETE("/user", gc.LeaveGroup)
+	authRouter.PUT("/user/:user_id/participation", gc.SetParticipation)
+	authRouter.PUT("/user/:user_id/team", gc.SetTeam)
+	authRouter.PUT("/admin", gc.TransferAdmin)
 }
 
 // Create Group
@@ -53,9 +66,10 @@ func (gc *GroupController) CreateGroup(c *gin.Context) {
 	}
 
 	group := &models.Group{
-		Name:           req.Name,
-		SecretVerifier: req.SecretVerifier,
-		Results:        nil,
+		Name:            req.Name,
+		SecretVerifier:  req.SecretVerifier,
+		ExchangeDate:    req.ExchangeDate,
+		EncryptedWishes: req.EncryptedWishes,
 	}
 
 	admin := &models.User{
@@ -64,7 +78,10 @@ func (gc *GroupController) CreateGroup(c *gin.Context) {
 		PasswordVerifier:    req.Admin.PasswordVerifier,
 		PublicKeySecret:     req.Admin.PublicKeySecret,
 		PrivateKeyEncrypted: req.Admin.PrivateKeyEncrypted,
+		MessageKeySecret:    req.Admin.MessageKeySecret,
+		MessageKeyEncrypted: req.Admin.MessageKeyEncrypted,
 		IsAdmin:             true,
+		Participates:        req.AdminParticipates == nil || *req.AdminParticipates,
 	}
 
 	if err := gc.groupService.CreateGroup(group, admin); err != nil {
@@ -103,11 +120,32 @@ func (gc *GroupController) GetGroup(c *gin.Context) {
 	// Get group from service
 	group, err := gc.groupService.GetGroup(groupID)
 	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, group)
+	// Every result of the round is returned and the client keeps the one its key
+	// decrypts: asking for a single result would tie the user to their key
+	results, err := gc.groupService.GetResults(groupID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	payloads := make([]string, len(results))
+	for i, result := range results {
+		payloads[i] = result.Payload
+	}
+
+	group.ViewClaims(claims.Subject)
+	c.JSON(200, &dto.GetGroupResponse{
+		Group:   group,
+		Results: payloads,
+	})
 }
 
 func (gc *GroupController) JoinGroup(c *gin.Context) {
@@ -129,8 +167,11 @@ func (gc *GroupController) JoinGroup(c *gin.Context) {
 		PasswordVerifier:    req.User.PasswordVerifier,
 		PublicKeySecret:     req.User.PublicKeySecret,
 		PrivateKeyEncrypted: req.User.PrivateKeyEncrypted,
+		MessageKeySecret:    req.User.MessageKeySecret,
+		MessageKeyEncrypted: req.User.MessageKeyEncrypted,
 		GroupID:             groupID,
 		IsAdmin:             false,
+		Participates:        true,
 	}
 
 	err = gc.userService.CreateUser(user)
@@ -143,6 +184,13 @@ func (gc *GroupController) JoinGroup(c *gin.Context) {
 			c.JSON(409, gin.H{"error": "User already exists"})
 			return
 		}
+		if errors.Is(err, userService.ErrEmailAlreadyUsed) {
+			c.JSON(409, gin.H{"error": "Email already used"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
@@ -151,18 +199,10 @@ func (gc *GroupController) JoinGroup(c *gin.Context) {
 
 }
 
-func (gc *GroupController) UpdateWishes(c *gin.Context) {
+func (gc *GroupController) GetWishItems(c *gin.Context) {
 	claims := c.MustGet("claims").(*authService.AuthClaims)
-	userID := claims.Subject
 
-	var req dto.UpdateWishesRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Get user from service
-	user, err := gc.userService.GetUser(userID)
+	items, err := gc.userService.GetWishItems(claims.Subject)
 	if err != nil {
 		if errors.Is(err, userService.ErrUserNotFound) {
 			c.JSON(404, gin.H{"error": "User not found"})
@@ -172,81 +212,232 @@ func (gc *GroupController) UpdateWishes(c *gin.Context) {
 		return
 	}
 
-	// Update user
-	user.Wishes = req.Wishes
-	if err := gc.userService.UpdateUser(user); err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+	c.JSON(200, items)
+}
+
+func (gc *GroupController) CreateWishItem(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.WishItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, &dto.UpdateWishesResponse{
-		Wishes: user.Wishes,
-	})
+	item := wishItemFromRequest(&req)
+	if err := gc.userService.CreateWishItem(claims.GroupID, claims.Subject, item, req.Order); err != nil {
+		wishItemError(c, err)
+		return
+	}
+
+	c.JSON(201, item)
 }
 
-func (gc *GroupController) InitDraw(c *gin.Context) {
+func (gc *GroupController) UpdateWishItem(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.WishItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	item := wishItemFromRequest(&req)
+	item.ID = c.Param("item_id")
+	if err := gc.userService.UpdateWishItem(claims.GroupID, claims.Subject, item, req.Order); err != nil {
+		wishItemError(c, err)
+		return
+	}
+
+	c.JSON(200, item)
+}
+
+func (gc *GroupController) DeleteWishItem(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	if err := gc.userService.DeleteWishItem(claims.GroupID, claims.Subject, c.Param("item_id")); err != nil {
+		wishItemError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+func (gc *GroupController) ClaimWishItem(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	item, err := gc.userService.ClaimWishItem(claims.GroupID, claims.Subject, c.Param("item_id"))
+	if err != nil {
+		wishItemError(c, err)
+		return
+	}
+
+	c.JSON(200, item)
+}
+
+func (gc *GroupController) UnclaimWishItem(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	item, err := gc.userService.UnclaimWishItem(claims.GroupID, claims.Subject, c.Param("item_id"))
+	if err != nil {
+		wishItemError(c, err)
+		return
+	}
+
+	c.JSON(200, item)
+}
+
+func wishItemFromRequest(req *dto.WishItemRequest) *models.WishItem {
+	return &models.WishItem{
+		Title:    req.Title,
+		URL:      req.URL,
+		Price:    req.Price,
+		Currency: req.Currency,
+		Priority: req.Priority,
+		Notes:    req.Notes,
+		Payload:  req.Payload,
+	}
+}
+
+func wishItemError(c *gin.Context, err error) {
+	if errors.Is(err, groupService.ErrGroupNotFound) {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrUserNotFound) {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrWishItemNotFound) {
+		c.JSON(404, gin.H{"error": "Wish item not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrCurrencyRequired) || errors.Is(err, userService.ErrCurrencyMismatch) ||
+		errors.Is(err, userService.ErrWishPayloadRequired) || errors.Is(err, userService.ErrWishPayloadUnexpected) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, userService.ErrOverBudget) {
+		c.JSON(465, gin.H{"error": "Over budget"})
+		return
+	}
+	if errors.Is(err, userService.ErrOwnWishItem) {
+		c.JSON(403, gin.H{"error": "You can't claim your own wish"})
+		return
+	}
+	if errors.Is(err, userService.ErrWishItemClaimed) {
+		c.JSON(409, gin.H{"error": "Wish already claimed"})
+		return
+	}
+	if errors.Is(err, userService.ErrWishItemNotClaimedBy) {
+		c.JSON(409, gin.H{"error": "Wish not claimed by you"})
+		return
+	}
+	if groupStateError(c, err) {
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+func (gc *GroupController) UpdateSettings(c *gin.Context) {
 	claims := c.MustGet("claims").(*authService.AuthClaims)
 	groupID := claims.GroupID
 
-	if !claims.IsAdmin {
-		c.JSON(403, gin.H{"error": "Forbidden"})
+	var req dto.UpdateGroupSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Get group from service
-	group, err := gc.groupService.GetGroup(groupID)
+	group, err := gc.groupService.UpdateSettings(groupID, claims.Subject, &groupService.GroupSettings{
+		ExchangeDate: req.ExchangeDate,
+		Budget:       req.Budget,
+		Currency:     req.Currency,
+
+		GiftsPerPerson: req.GiftsPerPerson,
+		DrawRule:       req.DrawRule,
+	})
 	if err != nil {
 		if errors.Is(err, groupService.ErrGroupNotFound) {
 			c.JSON(404, gin.H{"error": "Group not found"})
 			return
 		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	if group.Results != nil {
-		c.JSON(409, gin.H{"error": "Draw already done"})
-		return
-	}
+	group.ViewClaims(claims.Subject)
+	c.JSON(200, group)
+}
 
-	publicKeys, err := gc.groupService.InitDraw(groupID)
+func (gc *GroupController) GetCalendar(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	calendar, err := gc.groupService.GetCalendar(groupID)
 	if err != nil {
-		if errors.Is(err, groupService.ErrNotEnoughUsers) {
-			c.JSON(460, gin.H{"error": "Not enough users"})
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, groupService.ErrNoExchangeDate) {
+			c.JSON(404, gin.H{"error": "No exchange date"})
 			return
 		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(200, &dto.InitDrawResponse{
-		PublicKeysSecret: publicKeys,
-	})
+	c.Header("Content-Disposition", `attachment; filename="secret-santa.ics"`)
+	c.Data(200, "text/calendar; charset=UTF-8", calendar)
 }
 
-func (gc *GroupController) FinishDraw(c *gin.Context) {
+func (gc *GroupController) InitDraw(c *gin.Context) {
 	claims := c.MustGet("claims").(*authService.AuthClaims)
 	groupID := claims.GroupID
 
-	if !claims.IsAdmin {
-		c.JSON(403, gin.H{"error": "Forbidden"})
-		return
-	}
-
-	group, err := gc.groupService.GetGroup(groupID)
+	order, err := gc.groupService.InitDraw(groupID, claims.Subject)
 	if err != nil {
 		if errors.Is(err, groupService.ErrGroupNotFound) {
 			c.JSON(404, gin.H{"error": "Group not found"})
 			return
 		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		if errors.Is(err, groupService.ErrNotEnoughUsers) {
+			c.JSON(460, gin.H{"error": "Not enough users"})
+			return
+		}
+		if errors.Is(err, groupService.ErrDrawImpossible) {
+			c.JSON(460, gin.H{"error": "No draw satisfies the team rule"})
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	if group.Results != nil {
-		c.JSON(409, gin.H{"error": "Draw already done"})
-		return
-	}
+	c.JSON(200, &dto.InitDrawResponse{
+		PublicKeysSecret: order.PublicKeys,
+		GiftsPerPerson:   order.GiftsPerPerson,
+		TeamTags:         order.TeamTags,
+	})
+}
+
+func (gc *GroupController) FinishDraw(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
 
 	var req dto.FinishDrawRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -254,13 +445,28 @@ func (gc *GroupController) FinishDraw(c *gin.Context) {
 		return
 	}
 
-	if _, err := gc.groupService.FinishDraw(groupID, req.PublicKeys); err != nil {
+	if err := gc.groupService.FinishDraw(groupID, claims.Subject, req.PublicKeys, req.TeamProofs); err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		if errors.Is(err, groupService.ErrDrawSessionNotFound) {
 			c.JSON(461, gin.H{"error": "Draw session not found"})
 			return
 		}
+		if errors.Is(err, groupService.ErrDrawSessionOutdated) {
+			c.JSON(462, gin.H{"error": "Group members changed, init draw again"})
+			return
+		}
 		var invalidPublicKeyError *groupService.InvalidPublicKeyError
-		if errors.As(err, &invalidPublicKeyError) {
+		if errors.As(err, &invalidPublicKeyError) || errors.Is(err, groupService.ErrInvalidTeamProofs) {
 			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
@@ -271,39 +477,64 @@ func (gc *GroupController) FinishDraw(c *gin.Context) {
 	c.Status(200)
 }
 
-func (gc *GroupController) DeleteUser(c *gin.Context) {
+func (gc *GroupController) CancelDraw(c *gin.Context) {
 	claims := c.MustGet("claims").(*authService.AuthClaims)
 	groupID := claims.GroupID
 
-	if !claims.IsAdmin {
-		c.JSON(403, gin.H{"error": "Forbidden"})
+	if err := gc.groupService.CancelDraw(groupID, claims.Subject); err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	userID := c.Param("user_id")
-	if userID == "" {
-		c.JSON(400, gin.H{"error": "user_id is required"})
-		return
-	}
+	c.Status(204)
+}
 
-	group, err := gc.groupService.GetGroup(groupID)
-	if err != nil {
+func (gc *GroupController) ArchiveGroup(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	if err := gc.groupService.ArchiveGroup(groupID, claims.Subject); err != nil {
 		if errors.Is(err, groupService.ErrGroupNotFound) {
 			c.JSON(404, gin.H{"error": "Group not found"})
 			return
 		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	if group.Results != nil {
-		c.JSON(409, gin.H{"error": "Draw already done"})
+	c.Status(204)
+}
+
+func (gc *GroupController) DeleteUser(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	userID := c.Param("user_id")
+	if userID == "" {
+		c.JSON(400, gin.H{"error": "user_id is required"})
 		return
 	}
 
-	err = gc.userService.DeleteUser(userID)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
+	if err := gc.userService.DeleteUser(groupID, claims.Subject, userID); err != nil {
+		gc.deleteUserError(c, err)
 		return
 	}
 
@@ -315,31 +546,158 @@ func (gc *GroupController) LeaveGroup(c *gin.Context) {
 	userID := claims.Subject
 	groupID := claims.GroupID
 
-	if claims.IsAdmin {
+	if err := gc.userService.LeaveGroup(groupID, userID); err != nil {
+		gc.deleteUserError(c, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+func (gc *GroupController) deleteUserError(c *gin.Context, err error) {
+	if errors.Is(err, groupService.ErrGroupNotFound) {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrUserNotFound) {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrUserIsAdmin) {
+		c.JSON(403, gin.H{"error": "The admin can't leave the group, transfer the admin role first"})
+		return
+	}
+	if errors.Is(err, userService.ErrNotAdmin) {
 		c.JSON(403, gin.H{"error": "Forbidden"})
 		return
 	}
+	if groupStateError(c, err) {
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
 
-	group, err := gc.groupService.GetGroup(groupID)
+func (gc *GroupController) TransferAdmin(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	var req dto.TransferAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	admin, err := gc.userService.TransferAdmin(groupID, claims.Subject, req.UserID)
 	if err != nil {
 		if errors.Is(err, groupService.ErrGroupNotFound) {
 			c.JSON(404, gin.H{"error": "Group not found"})
 			return
 		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	if group.Results != nil {
-		c.JSON(409, gin.H{"error": "Draw already done"})
+	admin.ViewClaims(claims.Subject)
+	c.JSON(200, admin)
+}
+
+// SetParticipation puts a member in or out of the draw
+func (gc *GroupController) SetParticipation(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	var req dto.SetParticipationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := gc.userService.SetParticipation(groupID, claims.Subject, c.Param("user_id"), *req.Participates)
+	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	err = gc.userService.DeleteUser(userID)
+	user.ViewClaims(claims.Subject)
+	c.JSON(200, user)
+}
+
+// SetTeam puts a member in a team, constraining the draw with the rule of the group
+func (gc *GroupController) SetTeam(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	groupID := claims.GroupID
+
+	var req dto.SetTeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := gc.userService.SetTeam(groupID, claims.Subject, c.Param("user_id"), strings.TrimSpace(req.Team))
 	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		if groupStateError(c, err) {
+			return
+		}
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.Status(204)
+	user.ViewClaims(claims.Subject)
+	c.JSON(200, user)
+}
+
+// groupStateError writes the response of an action disallowed in the current
+// state of the group, it returns false if err is not such an error
+func groupStateError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, groupService.ErrDrawNotStarted):
+		c.JSON(461, gin.H{"error": "Draw not started"})
+	case errors.Is(err, groupService.ErrDrawAlreadyDone):
+		c.JSON(409, gin.H{"error": "Draw already done"})
+	case errors.Is(err, groupService.ErrDrawInProgress):
+		c.JSON(463, gin.H{"error": "Draw in progress"})
+	case errors.Is(err, groupService.ErrGroupArchived):
+		c.JSON(464, gin.H{"error": "Group archived"})
+	default:
+		return false
+	}
+	return true
 }
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"onxzy/super-santa-server/middlewares"
+	"onxzy/super-santa-server/services"
+	"onxzy/super-santa-server/services/authService"
+	"onxzy/super-santa-server/services/groupService"
+	"onxzy/super-santa-server/services/mailService"
+	"onxzy/super-santa-server/services/userService"
+	"onxzy/super-santa-server/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MailController struct {
+	config       *utils.Config
+	mailService  *services.MailService
+	groupService *services.GroupService
+}
+
+func NewMailController(config *utils.Config, mailService *services.MailService, groupService *services.GroupService) *MailController {
+	return &MailController{
+		config:       config,
+		mailService:  mailService,
+		groupService: groupService,
+	}
+}
+
+func (mc *MailController) RegisterRoutes(router *gin.RouterGroup, authMiddleware *middlewares.AuthMiddleware) {
+	router.GET("/templates", mc.GetTemplates)
+
+	// Development only, sample data never leaks anything
+	if mc.config.Mail.Preview {
+		router.GET("/dev/preview/:template", mc.GetSamplePreview)
+	}
+
+	authRouter := router.Group("").Use(authMiddleware.Auth)
+	authRouter.GET("/preview/:template", mc.GetPreview)
+}
+
+func (mc *MailController) GetTemplates(c *gin.Context) {
+	c.JSON(200, mailService.Templates)
+}
+
+// Preview
+
+func (mc *MailController) GetSamplePreview(c *gin.Context) {
+	preview, err := mc.mailService.PreviewTemplate(c.Param("template"))
+	if err != nil {
+		mc.previewError(c, err)
+		return
+	}
+
+	mc.writePreview(c, preview)
+}
+
+// GetPreview renders a template for the admin's group, or with sample data when ?sample=true
+func (mc *MailController) GetPreview(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	group, admin, err := mc.groupService.GetGroupAdmin(claims.GroupID, claims.Subject)
+	if err != nil {
+		if errors.Is(err, groupService.ErrGroupNotFound) {
+			c.JSON(404, gin.H{"error": "Group not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrUserNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, userService.ErrNotAdmin) {
+			c.JSON(403, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("sample") == "true" {
+		mc.GetSamplePreview(c)
+		return
+	}
+
+	preview, err := mc.mailService.PreviewGroupTemplate(c.Param("template"), group, admin)
+	if err != nil {
+		mc.previewError(c, err)
+		return
+	}
+
+	mc.writePreview(c, preview)
+}
+
+func (mc *MailController) previewError(c *gin.Context, err error) {
+	if errors.Is(err, mailService.ErrTemplateNotFound) {
+		c.JSON(404, gin.H{"error": "Template not found"})
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
+
+// writePreview returns the rendered HTML, or the subject and HTML as JSON when ?format=json
+func (mc *MailController) writePreview(c *gin.Context, preview *mailService.Preview) {
+	if c.Query("format") == "json" {
+		c.JSON(200, preview)
+		return
+	}
+	c.Data(200, "text/html; charset=UTF-8", []byte(preview.HTML))
+}
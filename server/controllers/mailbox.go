@@ -0,0 +1,215 @@
+package controllers
+
+import (
+	"errors"
+	"onxzy/super-santa-server/controllers/dto"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/middlewares"
+	"onxzy/super-santa-server/services"
+	"onxzy/super-santa-server/services/authService"
+	"onxzy/super-santa-server/services/groupService"
+	"onxzy/super-santa-server/services/mailboxService"
+	"onxzy/super-santa-server/services/userService"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MailboxController struct {
+	mailboxService *services.MailboxService
+}
+
+func NewMailboxController(mailboxService *services.MailboxService) *MailboxController {
+	return &MailboxController{
+		mailboxService: mailboxService,
+	}
+}
+
+func (mc *MailboxController) RegisterRoutes(router *gin.RouterGroup, authMiddleware *middlewares.AuthMiddleware) {
+	authRouter := router.Group("").Use(authMiddleware.Auth)
+	authRouter.GET("/threads", mc.GetThreads)
+	authRouter.POST("/threads", mc.StartThread)
+	authRouter.POST("/threads/:thread_id/messages", mc.PostMessage)
+	authRouter.PUT("/threads/:thread_id/status", mc.SetGiftStatus)
+	authRouter.PUT("/threads/:thread_id/address-key", mc.SetAddressKey)
+	authRouter.PUT("/threads/:thread_id/address", mc.SetAddress)
+	authRouter.GET("/gifts", mc.GetGiftSummary)
+}
+
+// GetThreads lists the threads addressed to the member given as recipient_id,
+// the user's own inbox by default
+func (mc *MailboxController) GetThreads(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	recipientID := c.DefaultQuery("recipient_id", claims.Subject)
+	threads, err := mc.mailboxService.GetThreads(claims.GroupID, recipientID)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(200, threads)
+}
+
+func (mc *MailboxController) StartThread(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.StartThreadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread := &models.MailboxThread{
+		RecipientID: req.RecipientID,
+		ReplyKey:    req.ReplyKey,
+		SenderKey:   req.SenderKey,
+		AddressKey:  req.AddressKey,
+	}
+	if err := mc.mailboxService.StartThread(claims.GroupID, claims.Subject, thread, req.SenderToken, req.ThreadToken, req.Payload); err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(201, thread)
+}
+
+func (mc *MailboxController) PostMessage(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.PostMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := mc.mailboxService.PostMessage(claims.GroupID, claims.Subject, c.Param("thread_id"), req.SenderToken, req.Payload)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(201, message)
+}
+
+func (mc *MailboxController) SetGiftStatus(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.SetGiftStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread, err := mc.mailboxService.SetGiftStatus(claims.GroupID, claims.Subject, c.Param("thread_id"), req.SenderToken, req.Status)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(200, thread)
+}
+
+// SetAddressKey hands the recipient of a thread the key of their address, from the santa
+func (mc *MailboxController) SetAddressKey(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.SetAddressKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread, err := mc.mailboxService.SetAddressKey(claims.GroupID, claims.Subject, c.Param("thread_id"), req.SenderToken, req.ThreadToken, req.AddressKey)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(200, thread)
+}
+
+// SetAddress stores the encrypted shipping address of the recipient of a thread, or removes it when empty
+func (mc *MailboxController) SetAddress(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.SetAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	thread, err := mc.mailboxService.SetAddress(claims.GroupID, claims.Subject, c.Param("thread_id"), req.Address)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(200, thread)
+}
+
+// GetGiftSummary counts the gifts of the latest draw by progress, for the admin
+func (mc *MailboxController) GetGiftSummary(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+	summary, err := mc.mailboxService.GetGiftSummary(claims.GroupID, claims.Subject)
+	if err != nil {
+		mailboxError(c, err)
+		return
+	}
+
+	c.JSON(200, summary)
+}
+
+func mailboxError(c *gin.Context, err error) {
+	if errors.Is(err, groupService.ErrGroupNotFound) {
+		c.JSON(404, gin.H{"error": "Group not found"})
+		return
+	}
+	if errors.Is(err, userService.ErrUserNotFound) {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrThreadNotFound) {
+		c.JSON(404, gin.H{"error": "Thread not found"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrOwnThread) || errors.Is(err, mailboxService.ErrInvalidAddressKey) || errors.Is(err, mailboxService.ErrInvalidAddress) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrNotThreadMember) || errors.Is(err, mailboxService.ErrInvalidSenderToken) || errors.Is(err, userService.ErrNotAdmin) {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrInvalidThreadToken) {
+		c.JSON(403, gin.H{"error": "Invalid thread token"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrUnboundThread) {
+		c.JSON(403, gin.H{"error": "Thread bound to no draw result"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrThreadClosed) {
+		c.JSON(409, gin.H{"error": "Thread closed"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrThreadExists) {
+		c.JSON(409, gin.H{"error": "Thread already started"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrGiftStatusBackward) {
+		c.JSON(409, gin.H{"error": "Gift status can only move forward"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrAddressKeySet) {
+		c.JSON(409, gin.H{"error": "Address key already set"})
+		return
+	}
+	if errors.Is(err, mailboxService.ErrNoAddressKey) {
+		c.JSON(409, gin.H{"error": "No address key in the thread"})
+		return
+	}
+	if groupStateError(c, err) {
+		return
+	}
+	c.JSON(500, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"errors"
+	"html/template"
+	"onxzy/super-santa-server/controllers/dto"
+	"onxzy/super-santa-server/middlewares"
+	"onxzy/super-santa-server/services"
+	"onxzy/super-santa-server/services/authService"
+	"onxzy/super-santa-server/services/notificationService"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationController struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationController(notificationService *services.NotificationService) *NotificationController {
+	return &NotificationController{
+		notificationService: notificationService,
+	}
+}
+
+func (nc *NotificationController) RegisterRoutes(router *gin.RouterGroup, authMiddleware *middlewares.AuthMiddleware) {
+	router.GET("/unsubscribe", nc.GetUnsubscribe)
+	router.POST("/unsubscribe", nc.PostUnsubscribe)
+
+	authRouter := router.Group("").Use(authMiddleware.Auth)
+	authRouter.GET("", nc.GetPreferences)
+	authRouter.PUT("", nc.UpdatePreferences)
+}
+
+// Preferences
+
+func (nc *NotificationController) GetPreferences(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	prefs, err := nc.notificationService.GetPreferences(claims.Subject)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, prefs)
+}
+
+func (nc *NotificationController) UpdatePreferences(c *gin.Context) {
+	claims := c.MustGet("claims").(*authService.AuthClaims)
+
+	var req dto.UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	prefs, err := nc.notificationService.UpdatePreferences(claims.Subject, &notificationService.Preferences{
+		Delivery: req.Delivery,
+		Events:   req.Events,
+	})
+	if err != nil {
+		if errors.Is(err, notificationService.ErrUnknownEvent) || errors.Is(err, notificationService.ErrInvalidDelivery) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, prefs)
+}
+
+// Unsubscribe
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+  <head><meta charset="UTF-8" /><title>Unsubscribe</title></head>
+  <body style="font-family: Arial, sans-serif; text-align: center">
+    <h1>Unsubscribe from Secret Santa emails</h1>
+    <form method="POST" action="?token={{.}}">
+      <button type="submit">Unsubscribe</button>
+    </form>
+  </body>
+</html>`))
+
+// GetUnsubscribe only renders a confirmation page so link scanners can't unsubscribe users
+func (nc *NotificationController) GetUnsubscribe(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=UTF-8")
+	c.Status(200)
+	if err := unsubscribePage.Execute(c.Writer, token); err != nil {
+		c.Error(err)
+	}
+}
+
+// PostUnsubscribe handles both the confirmation form and RFC 8058 one-click requests
+func (nc *NotificationController) PostUnsubscribe(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(400, gin.H{"error": "token is required"})
+		return
+	}
+
+	if err := nc.notificationService.Unsubscribe(token); err != nil {
+		var invalidToken *notificationService.InvalidUnsubscribeTokenError
+		if errors.As(err, &invalidToken) {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Unsubscribed"})
+}
@@ -0,0 +1,358 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"onxzy/super-santa-server/database/migrations"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArchiveFormat is the version of the archive layout written by this build
+const ArchiveFormat = 1
+
+var (
+	ErrArchiveChecksum    = errors.New("archive checksum mismatch")
+	ErrDatabaseNotEmpty   = errors.New("database is not empty")
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrArchiveSchema      = errors.New("archive schema version doesn't match this build, upgrade the exporting instance first")
+)
+
+// Archive is a dump of every table of an instance
+type Archive struct {
+	Format        int             `json:"format"`
+	SchemaVersion int             `json:"schema_version"` // Migration version of the exporting instance
+	CreatedAt     time.Time       `json:"created_at"`
+	Checksum      string          `json:"checksum"` // Hex SHA-256 of the compact JSON encoding of data
+	Data          json.RawMessage `json:"data"`
+}
+
+// ArchiveData holds the rows of every table. Archive records mirror the tables
+// column by column, unlike the models whose JSON encoding hides secrets.
+type ArchiveData struct {
+	Groups                  []ArchiveGroup                  `json:"groups"`
+	Users                   []ArchiveUser                   `json:"users"`
+	WishItems               []ArchiveWishItem               `json:"wish_items"`
+	DrawResults             []ArchiveDrawResult             `json:"draw_results"`
+	MailboxThreads          []ArchiveMailboxThread          `json:"mailbox_threads"`
+	MailboxMessages         []ArchiveMailboxMessage         `json:"mailbox_messages"`
+	NotificationPreferences []ArchiveNotificationPreference `json:"notification_preferences"`
+	Notifications           []ArchiveNotification           `json:"notifications"`
+}
+
+type ArchiveGroup struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	Version   int        `json:"version"`
+
+	Name           string     `json:"name"`
+	SecretVerifier string     `json:"secret_verifier"`
+	ExchangeDate   *time.Time `json:"exchange_date"`
+	PurgeWarnedAt  *time.Time `json:"purge_warned_at"`
+	Budget         *int64     `json:"budget"`
+	Currency       string     `json:"currency"`
+
+	EncryptedWishes bool   `json:"encrypted_wishes"`
+	GiftsPerPerson  int    `json:"gifts_per_person"`
+	DrawRule        string `json:"draw_rule"`
+
+	State     string `json:"state"`
+	DrawRound int    `json:"draw_round"`
+}
+
+func (ArchiveGroup) TableName() string { return "groups" }
+
+type ArchiveUser struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+
+	Username         string `json:"username"` // Encrypted when encryption at rest is enabled
+	Email            string `json:"email"`
+	PasswordVerifier string `json:"password_verifier"`
+
+	PendingEmail        string     `json:"pending_email"`
+	EmailTokenHash      string     `json:"email_token_hash"`
+	EmailTokenExpiresAt *time.Time `json:"email_token_expires_at"`
+
+	UsernameIndex string `json:"username_index"`
+	EmailIndex    string `json:"email_index"`
+
+	GroupID      string `json:"group_id"`
+	IsAdmin      bool   `json:"is_admin"`
+	Participates bool   `json:"participates"`
+	Team         string `json:"team"`
+
+	PublicKeySecret     string `json:"public_key_secret"`
+	PrivateKeyEncrypted string `json:"private_key_encrypted"`
+
+	MessageKeySecret    string `json:"message_key_secret"`
+	MessageKeyEncrypted string `json:"message_key_encrypted"`
+}
+
+func (ArchiveUser) TableName() string { return "users" }
+
+type ArchiveWishItem struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID string `json:"user_id"`
+
+	Title    string `json:"title"` // Encrypted when encryption at rest is enabled
+	URL      string `json:"url"`
+	Price    *int64 `json:"price"`
+	Currency string `json:"currency"`
+	Priority int    `json:"priority"`
+	Notes    string `json:"notes"`
+	Position int    `json:"position"`
+	Payload  string `json:"payload"` // Encrypted by the client in groups with encrypted wishes
+
+	ClaimedByID *string    `json:"claimed_by_id"`
+	ClaimedAt   *time.Time `json:"claimed_at"`
+}
+
+func (ArchiveWishItem) TableName() string { return "wish_items" }
+
+type ArchiveDrawResult struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	GroupID string `json:"group_id"`
+	Round   int    `json:"round"`
+
+	RecipientID     string `json:"recipient_id"`
+	ThreadTokenHash string `json:"thread_token_hash"`
+
+	Payload string `json:"payload"`
+}
+
+func (ArchiveDrawResult) TableName() string { return "draw_results" }
+
+type ArchiveMailboxThread struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	GroupID     string  `json:"group_id"`
+	Round       int     `json:"round"`
+	RecipientID string  `json:"recipient_id"`
+	ResultID    *string `json:"result_id"`
+
+	ReplyKey        string `json:"reply_key"`
+	SenderKey       string `json:"sender_key"`
+	SenderTokenHash string `json:"sender_token_hash"`
+
+	GiftStatus   string     `json:"gift_status"`
+	GiftStatusAt *time.Time `json:"gift_status_at"`
+
+	AddressKey string `json:"address_key"`
+	Address    string `json:"address"`
+}
+
+func (ArchiveMailboxThread) TableName() string { return "mailbox_threads" }
+
+type ArchiveMailboxMessage struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	ThreadID  string `json:"thread_id"`
+	FromSanta bool   `json:"from_santa"`
+	Payload   string `json:"payload"`
+}
+
+func (ArchiveMailboxMessage) TableName() string { return "mailbox_messages" }
+
+type ArchiveNotificationPreference struct {
+	UserID    string    `json:"user_id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Delivery    string  `json:"delivery"`
+	MutedEvents *string `json:"muted_events"`
+}
+
+func (ArchiveNotificationPreference) TableName() string { return "notification_preferences" }
+
+type ArchiveNotification struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   string `json:"user_id"`
+	Event    string `json:"event"`
+	Subject  string `json:"subject"` // Encrypted when encryption at rest is enabled
+	Template string `json:"template"`
+	Data     string `json:"data"`
+
+	Delivery string     `json:"delivery"`
+	SentAt   *time.Time `json:"sent_at"`
+	Attempts int        `json:"attempts"`
+}
+
+func (ArchiveNotification) TableName() string { return "notifications" }
+
+// archiveTables lists a model of every archived table, in insertion order
+var archiveTables = []any{
+	&ArchiveGroup{},
+	&ArchiveUser{},
+	&ArchiveWishItem{},
+	&ArchiveDrawResult{},
+	&ArchiveMailboxThread{},
+	&ArchiveMailboxMessage{},
+	&ArchiveNotificationPreference{},
+	&ArchiveNotification{},
+}
+
+// Export writes every table to w as an archive, read from a single transaction
+func (db *DB) Export(w io.Writer) (*ArchiveData, error) {
+	if err := db.CheckSchema(); err != nil {
+		return nil, err
+	}
+
+	// SQLite transactions are serializable, other drivers need a consistent snapshot
+	var opts []*sql.TxOptions
+	if db.gorm.Dialector.Name() != DriverSQLite {
+		opts = append(opts, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	}
+
+	var data ArchiveData
+	err := db.gorm.Transaction(func(tx *gorm.DB) error {
+		for _, dest := range []any{&data.Groups, &data.Users, &data.WishItems, &data.DrawResults, &data.MailboxThreads, &data.MailboxMessages, &data.NotificationPreferences, &data.Notifications} {
+			if err := tx.Find(dest).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tables: %w", err)
+	}
+
+	raw, err := json.Marshal(&data)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(raw)
+
+	archive := Archive{
+		Format:        ArchiveFormat,
+		SchemaVersion: migrations.Latest(),
+		CreatedAt:     time.Now().UTC(),
+		Checksum:      hex.EncodeToString(checksum[:]),
+		Data:          raw,
+	}
+	if err := json.NewEncoder(w).Encode(&archive); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// ReadArchive decodes an archive and verifies its checksum
+func ReadArchive(r io.Reader) (*Archive, *ArchiveData, error) {
+	var archive Archive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode archive: %w", err)
+	}
+	if archive.Format != ArchiveFormat {
+		return nil, nil, fmt.Errorf("%w: %d", ErrUnsupportedArchive, archive.Format)
+	}
+
+	// Hash the compact form so that reformatting the file doesn't break the checksum
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, archive.Data); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode archive data: %w", err)
+	}
+	checksum := sha256.Sum256(compact.Bytes())
+	if hex.EncodeToString(checksum[:]) != archive.Checksum {
+		return nil, nil, ErrArchiveChecksum
+	}
+
+	var data ArchiveData
+	if err := json.Unmarshal(archive.Data, &data); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode archive data: %w", err)
+	}
+
+	return &archive, &data, nil
+}
+
+// Import restores an archive into an empty database, migrating it first if it has no schema
+func (db *DB) Import(r io.Reader) (*ArchiveData, error) {
+	archive, data, err := ReadArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	if archive.SchemaVersion != migrations.Latest() {
+		return nil, fmt.Errorf("%w (archive %d, build %d)", ErrArchiveSchema, archive.SchemaVersion, migrations.Latest())
+	}
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		if _, err := db.MigrateUp(0); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.CheckSchema(); err != nil {
+		return nil, err
+	}
+
+	err = db.gorm.Transaction(func(tx *gorm.DB) error {
+		for _, table := range archiveTables {
+			var count int64
+			if err := tx.Model(table).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrDatabaseNotEmpty
+			}
+		}
+
+		if err := createInBatches(tx, data.Groups); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.Users); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.WishItems); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.DrawResults); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.MailboxThreads); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.MailboxMessages); err != nil {
+			return err
+		}
+		if err := createInBatches(tx, data.NotificationPreferences); err != nil {
+			return err
+		}
+		return createInBatches(tx, data.Notifications)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func createInBatches[T any](tx *gorm.DB, rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(rows, 100).Error
+}
@@ -0,0 +1,98 @@
+package database_test
+
+import (
+	"bytes"
+	"errors"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func seedArchive(t *testing.T, db *database.DB) {
+	t.Helper()
+
+	groups := database.NewGroupStore(db)
+	group := &models.Group{
+		Name:           "North Pole",
+		SecretVerifier: "verifier.salt",
+		Users: []models.User{{
+			Username:         "santa",
+			Email:            "santa@example.com",
+			PasswordVerifier: "verifier.salt",
+			IsAdmin:          true,
+		}},
+	}
+	if err := groups.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	if _, err := groups.SaveDrawResults(group.ID, []models.DrawResult{{Payload: "for a"}}); err != nil {
+		t.Fatalf("SaveDrawResults: %v", err)
+	}
+	thread := &models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: group.Users[0].ID, Messages: []models.MailboxMessage{{FromSanta: true, Payload: "hello"}}}
+	if err := database.NewMailboxStore(db).CreateThread(thread); err != nil {
+		t.Fatalf("CreateThread: %v", err)
+	}
+
+	notifications := database.NewNotificationStore(db)
+	if err := notifications.SavePreference(&models.NotificationPreference{UserID: group.Users[0].ID, Delivery: "digest"}); err != nil {
+		t.Fatalf("SavePreference: %v", err)
+	}
+	if err := notifications.CreateNotification(&models.Notification{UserID: group.Users[0].ID, Event: "welcome"}); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source := openSQLite(t)
+	seedArchive(t, source)
+
+	var archive bytes.Buffer
+	exported, err := source.Export(&archive)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	target := openSQLite(t)
+	imported, err := target.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if !reflect.DeepEqual(exported, imported) {
+		t.Fatalf("expected the imported data to match the exported data")
+	}
+
+	var again bytes.Buffer
+	reexported, err := target.Export(&again)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(reexported.Groups) != 1 || len(reexported.Users) != 1 || len(reexported.DrawResults) != 1 ||
+		len(reexported.MailboxThreads) != 1 || len(reexported.MailboxMessages) != 1 ||
+		len(reexported.NotificationPreferences) != 1 || len(reexported.Notifications) != 1 {
+		t.Errorf("expected every row to be restored, got %+v", reexported)
+	}
+	if reexported.Users[0].PasswordVerifier != "verifier.salt" || reexported.Groups[0].DrawRound != 1 {
+		t.Errorf("expected secrets and draw state to be restored, got %+v %+v", reexported.Users[0], reexported.Groups[0])
+	}
+
+	if _, err := target.Import(bytes.NewReader(archive.Bytes())); !errors.Is(err, database.ErrDatabaseNotEmpty) {
+		t.Fatalf("expected ErrDatabaseNotEmpty, got %v", err)
+	}
+}
+
+func TestArchiveChecksum(t *testing.T) {
+	source := openSQLite(t)
+	seedArchive(t, source)
+
+	var archive bytes.Buffer
+	if _, err := source.Export(&archive); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	tampered := strings.Replace(archive.String(), "North Pole", "South Pole", 1)
+	if _, err := openSQLite(t).Import(strings.NewReader(tampered)); !errors.Is(err, database.ErrArchiveChecksum) {
+		t.Fatalf("expected ErrArchiveChecksum, got %v", err)
+	}
+}
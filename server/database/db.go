@@ -2,40 +2,132 @@ package database
 
 import (
 	"context"
+	"fmt"
+	"onxzy/super-santa-server/database/encryption"
 	"onxzy/super-santa-server/utils"
+	"time"
 
 	"go.uber.org/fx"
 	"go.uber.org/zap"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"moul.io/zapgorm2"
 )
 
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
 type DB struct {
 	gorm *gorm.DB
 }
 
-func NewDB(lc fx.Lifecycle, log *zap.Logger, config *utils.Config) *DB {
+func NewDB(lc fx.Lifecycle, log *zap.Logger, config *utils.Config) (*DB, error) {
+	db, err := OpenDB(log, config)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return db.Close()
+		},
+	})
+
+	return db, nil
+}
+
+// OpenDB opens and configures the connection pool of the configured driver
+func OpenDB(log *zap.Logger, config *utils.Config) (*DB, error) {
+	dialector, err := newDialector(config)
+	if err != nil {
+		return nil, err
+	}
 
 	logger := zapgorm2.New(log.Named("gorm"))
 	logger.IgnoreRecordNotFoundError = true
 
-	db, err := gorm.Open(sqlite.Open(config.DB.SQLitePath), &gorm.Config{
+	db, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger,
+		// Map driver specific errors, e.g. unique violations, to gorm errors
+		TranslateError: true,
 	})
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("failed to open %s database: %w", config.DB.Driver, err)
 	}
 
-	lc.Append(fx.Hook{
-		OnStop: func(ctx context.Context) error {
-			sqlDB, err := db.DB()
-			if err != nil {
-				return err
-			}
-			return sqlDB.Close()
-		},
-	})
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
 
-	return &DB{gorm: db}
+	pool := config.DB.Pool
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(time.Duration(pool.ConnMaxLifetime) * time.Second)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(time.Duration(pool.ConnMaxIdleTime) * time.Second)
+	}
+
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %w", config.DB.Driver, err)
+	}
+
+	keyring, err := encryption.NewKeyring(config.Encryption.Key, config.Encryption.KeyFile)
+	if err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to load encryption keys: %w", err)
+	}
+
+	// Sessions inherit the keyring from their context to encrypt the user columns
+	return &DB{gorm: db.WithContext(encryption.NewContext(context.Background(), keyring))}, nil
+}
+
+func newDialector(config *utils.Config) (gorm.Dialector, error) {
+	switch config.DB.Driver {
+	case DriverSQLite, "":
+		dsn := config.DB.DSN
+		if dsn == "" {
+			// Transactions take the write lock when they begin and wait for it,
+			// so that concurrent units of work are serialized instead of failing
+			dsn = config.DB.SQLitePath + "?_busy_timeout=5000&_txlock=immediate"
+		}
+		return sqlite.Open(dsn), nil
+	case DriverPostgres:
+		if config.DB.DSN == "" {
+			return nil, fmt.Errorf("db.dsn is required for the %s driver", config.DB.Driver)
+		}
+		return postgres.Open(config.DB.DSN), nil
+	case DriverMySQL:
+		if config.DB.DSN == "" {
+			return nil, fmt.Errorf("db.dsn is required for the %s driver", config.DB.Driver)
+		}
+		return mysql.Open(config.DB.DSN), nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", config.DB.Driver)
+	}
+}
+
+// keyring returns the keys encrypting the user columns, nil when encryption is disabled
+func (db *DB) keyring() *encryption.Keyring {
+	return encryption.FromContext(db.gorm.Statement.Context)
+}
+
+func (db *DB) Close() error {
+	sqlDB, err := db.gorm.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
 }
@@ -0,0 +1,261 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks the encrypted values, values without it are plaintext
+const prefix = "enc:v1:"
+
+var (
+	ErrNoKeyring  = errors.New("value is encrypted but no encryption key is configured")
+	ErrUnknownKey = errors.New("value is encrypted with an unknown key")
+	ErrMalformed  = errors.New("malformed encrypted value")
+)
+
+type key struct {
+	id       string
+	kek      cipher.AEAD // Wraps the data keys
+	indexKey []byte      // Keys the blind indexes
+}
+
+// Keyring holds the key encryption keys. The first key, the primary one,
+// encrypts new values, the others are only used to decrypt older values.
+// A nil keyring disables encryption.
+type Keyring struct {
+	keys []key
+}
+
+// parseKey parses a key formatted as "<id>:<base64 encoded 32 bytes>"
+func parseKey(s string) (key, error) {
+	id, encoded, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found || id == "" {
+		return key{}, errors.New("key must be formatted as <id>:<base64 key>")
+	}
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return key{}, fmt.Errorf("key %q is not valid base64: %w", id, err)
+	}
+	if len(secret) != 32 {
+		return key{}, fmt.Errorf("key %q must be 32 bytes long, got %d", id, len(secret))
+	}
+
+	kek, err := newAEAD(secret)
+	if err != nil {
+		return key{}, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("blind-index"))
+	return key{id: id, kek: kek, indexKey: mac.Sum(nil)}, nil
+}
+
+// NewKeyring builds a keyring from a primary key and a file holding one key
+// per line, blank lines and lines starting with # are ignored. Both are optional,
+// the keyring is nil when no key is given.
+func NewKeyring(primary string, keyFile string) (*Keyring, error) {
+	var encoded []string
+	if primary != "" {
+		encoded = append(encoded, primary)
+	}
+	if keyFile != "" {
+		file, err := os.Open(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open key file: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			encoded = append(encoded, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	keyring := &Keyring{}
+	ids := make(map[string]bool, len(encoded))
+	for _, s := range encoded {
+		k, err := parseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		if ids[k.id] {
+			return nil, fmt.Errorf("duplicated key id %q", k.id)
+		}
+		ids[k.id] = true
+		keyring.keys = append(keyring.keys, k)
+	}
+	return keyring, nil
+}
+
+// PrimaryKeyID returns the id of the key encrypting new values, empty when encryption is disabled
+func (k *Keyring) PrimaryKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.keys[0].id
+}
+
+// IsEncrypted tells if a stored value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the primary key.
+// The additional data, e.g. the column name, must be given again to open the value.
+func (k *Keyring) Seal(plaintext string, additionalData string) (string, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	primary := k.keys[0]
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := seal(primary.kek, dataKey, []byte(primary.id))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + primary.id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a value sealed by Seal, plaintext values are returned as is
+func (k *Keyring) Open(value string, additionalData string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if k == nil {
+		return "", ErrNoKeyring
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	var kek cipher.AEAD
+	for _, candidate := range k.keys {
+		if candidate.id == parts[0] {
+			kek = candidate.kek
+		}
+	}
+	if kek == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek, wrappedKey, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, []byte(additionalData))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the deterministic hash stored next to an encrypted value
+// so that it can be looked up. Without a keyring it is an unkeyed hash.
+func (k *Keyring) BlindIndex(value string) string {
+	if k == nil {
+		return unkeyedIndex(value)
+	}
+	return keyedIndex(k.keys[0].indexKey, value)
+}
+
+// BlindIndexes returns every index value may be stored with: one per key and
+// the unkeyed one, until the rows are rotated to the primary key
+func (k *Keyring) BlindIndexes(value string) []string {
+	if k == nil {
+		return []string{unkeyedIndex(value)}
+	}
+	indexes := make([]string, 0, len(k.keys)+1)
+	for _, key := range k.keys {
+		indexes = append(indexes, keyedIndex(key.indexKey, value))
+	}
+	return append(indexes, unkeyedIndex(value))
+}
+
+func keyedIndex(indexKey []byte, value string) string {
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func unkeyedIndex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns the nonce followed by the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
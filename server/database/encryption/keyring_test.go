@@ -0,0 +1,81 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func newKey(t *testing.T, id string) string {
+	t.Helper()
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(secret)
+}
+
+func TestKeyring(t *testing.T) {
+	oldKey, newKeyValue := newKey(t, "old"), newKey(t, "new")
+
+	old, err := NewKeyring(oldKey, "")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	sealed, err := old.Seal("santa@example.com", "email")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !IsEncrypted(sealed) {
+		t.Fatalf("expected an encrypted value, got %q", sealed)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte("# Retired keys\n"+oldKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewKeyring(newKeyValue, keyFile)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if rotated.PrimaryKeyID() != "new" {
+		t.Errorf("expected the config key to be primary, got %q", rotated.PrimaryKeyID())
+	}
+	if plaintext, err := rotated.Open(sealed, "email"); err != nil || plaintext != "santa@example.com" {
+		t.Errorf("expected older values to decrypt, got %q, %v", plaintext, err)
+	}
+	if _, err := rotated.Open(sealed, "username"); err == nil {
+		t.Errorf("expected a value moved to another column to be rejected")
+	}
+	if !slices.Contains(rotated.BlindIndexes("santa@example.com"), old.BlindIndex("santa@example.com")) {
+		t.Errorf("expected lookups to match values indexed with an older key")
+	}
+
+	newOnly, err := NewKeyring(newKeyValue, "")
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	if _, err := newOnly.Open(sealed, "email"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	var disabled *Keyring
+	if _, err := disabled.Open(sealed, "email"); !errors.Is(err, ErrNoKeyring) {
+		t.Errorf("expected ErrNoKeyring, got %v", err)
+	}
+	if value, err := disabled.Seal("plain", "email"); err != nil || value != "plain" {
+		t.Errorf("expected values to be stored in plaintext without keys, got %q, %v", value, err)
+	}
+}
+
+func TestNewKeyringRejectsInvalidKeys(t *testing.T) {
+	for _, key := range []string{"nokey", ":c2VjcmV0", "short:c2VjcmV0", "bad:***"} {
+		if _, err := NewKeyring(key, ""); err == nil {
+			t.Errorf("expected %q to be rejected", key)
+		}
+	}
+}
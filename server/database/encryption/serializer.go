@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying keyring, gorm sessions created with it
+// encrypt and decrypt the columns tagged with serializer:encrypted
+func NewContext(ctx context.Context, keyring *Keyring) context.Context {
+	return context.WithValue(ctx, contextKey{}, keyring)
+}
+
+// FromContext returns the keyring of ctx, nil when encryption is disabled
+func FromContext(ctx context.Context) *Keyring {
+	keyring, _ := ctx.Value(contextKey{}).(*Keyring)
+	return keyring
+}
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+}
+
+// Serializer encrypts string columns with the keyring of the statement context.
+// The column name is authenticated so that values can't be swapped between columns.
+type Serializer struct{}
+
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into an encrypted column", dbValue)
+	}
+
+	plaintext, err := FromContext(ctx).Open(stored, field.DBName)
+	if err != nil {
+		return fmt.Errorf("column %s: %w", field.DBName, err)
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted column %s must be a string, got %T", field.DBName, fieldValue)
+	}
+	return FromContext(ctx).Seal(plaintext, field.DBName)
+}
@@ -0,0 +1,144 @@
+package database_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func openEncryptedSQLite(t *testing.T, path string, key string, keyFile string) *database.DB {
+	t.Helper()
+	config := &utils.Config{}
+	config.DB.Driver = database.DriverSQLite
+	config.DB.SQLitePath = path
+	config.Encryption.Key = key
+	config.Encryption.KeyFile = keyFile
+
+	db, err := database.OpenDB(zap.NewNop(), config)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	return db
+}
+
+func encryptionKey(t *testing.T, id string) string {
+	t.Helper()
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(secret)
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Rows written before encryption is enabled stay readable
+	plain := openEncryptedSQLite(t, path, "", "")
+	group := &models.Group{Name: "North Pole", Users: []models.User{{Username: "santa", Email: "santa@example.com", IsAdmin: true}}}
+	if err := database.NewGroupStore(plain).CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	if err := database.NewWishStore(plain).CreateWishItem(&models.WishItem{UserID: group.Users[0].ID, Title: "A sleigh"}); err != nil {
+		t.Fatalf("CreateWishItem: %v", err)
+	}
+	if err := database.NewNotificationStore(plain).CreateNotification(&models.Notification{UserID: group.Users[0].ID, Subject: "North Pole is drawn", Data: `{"GroupName":"North Pole"}`}); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+	plain.Close()
+
+	firstKey := encryptionKey(t, "first")
+	db := openEncryptedSQLite(t, path, firstKey, "")
+	users := database.NewUserStore(db)
+	if _, err := users.GetGroupUserByEmail(group.ID, "santa@example.com"); err != nil {
+		t.Fatalf("expected plaintext rows to be found, got %v", err)
+	}
+	if err := users.CreateUser(&models.User{GroupID: group.ID, Username: "santa", Email: "other@example.com"}); !errors.Is(err, database.ErrUserAlreadyExists) {
+		t.Fatalf("expected a username taken by a plaintext row to be rejected, got %v", err)
+	}
+	rudolph := &models.User{GroupID: group.ID, Username: "rudolph", Email: "rudolph@example.com"}
+	if err := users.CreateUser(rudolph); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := database.NewWishStore(db).CreateWishItem(&models.WishItem{UserID: rudolph.ID, Title: "A red nose", URL: "https://example.com/nose"}); err != nil {
+		t.Fatalf("CreateWishItem: %v", err)
+	}
+	if err := database.NewNotificationStore(db).CreateNotification(&models.Notification{UserID: rudolph.ID, Subject: "Rudolph joined", Data: `{"Username":"rudolph"}`}); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+	rotatedUsers, rotatedItems, rotatedNotifications, err := db.RotateEncryption()
+	if err != nil || rotatedUsers != 2 || rotatedItems != 2 || rotatedNotifications != 2 {
+		t.Fatalf("expected 2 users, 2 wish items and 2 notifications rotated, got %d, %d and %d, %v", rotatedUsers, rotatedItems, rotatedNotifications, err)
+	}
+
+	archive, err := db.Export(&strings.Builder{})
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	for _, user := range archive.Users {
+		if !strings.HasPrefix(user.Username, "enc:") || !strings.HasPrefix(user.Email, "enc:") {
+			t.Errorf("expected every user column to be encrypted, got %+v", user)
+		}
+	}
+	for _, item := range archive.WishItems {
+		if !strings.HasPrefix(item.Title, "enc:") || !strings.HasPrefix(item.Notes, "enc:") {
+			t.Errorf("expected every wish item column to be encrypted, got %+v", item)
+		}
+	}
+	for _, notification := range archive.Notifications {
+		if !strings.HasPrefix(notification.Subject, "enc:") || !strings.HasPrefix(notification.Data, "enc:") {
+			t.Errorf("expected every notification to be encrypted, got %+v", notification)
+		}
+	}
+	notifications, err := database.NewNotificationStore(db).GetUserNotifications(group.Users[0].ID)
+	if err != nil {
+		t.Fatalf("GetUserNotifications: %v", err)
+	}
+	if len(notifications) != 1 || notifications[0].Subject != "North Pole is drawn" || notifications[0].Data != `{"GroupName":"North Pole"}` {
+		t.Errorf("expected the notification decrypted, got %+v", notifications)
+	}
+	db.Close()
+
+	// A new primary key reads the rows of the retired one until they are rotated
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(keyFile, []byte(firstKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secondKey := encryptionKey(t, "second")
+	db = openEncryptedSQLite(t, path, secondKey, keyFile)
+	users = database.NewUserStore(db)
+	user, err := users.GetGroupUserByEmail(group.ID, "rudolph@example.com")
+	if err != nil {
+		t.Fatalf("GetGroupUserByEmail: %v", err)
+	}
+	if user, err = users.GetUser(user.ID); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if user.Username != "rudolph" || len(user.WishItems) != 1 || user.WishItems[0].URL != "https://example.com/nose" {
+		t.Errorf("expected decrypted columns, got %+v", user)
+	}
+	if _, _, _, err := db.RotateEncryption(); err != nil {
+		t.Fatalf("RotateEncryption: %v", err)
+	}
+	db.Close()
+
+	// Once rotated, the retired key is no longer needed
+	db = openEncryptedSQLite(t, path, secondKey, "")
+	if _, err := database.NewUserStore(db).GetGroupUserByEmail(group.ID, "santa@example.com"); err != nil {
+		t.Fatalf("expected rotated rows to be found with the new key only, got %v", err)
+	}
+}
@@ -1,11 +1,9 @@
 package database
 
 import (
-	"context"
 	"errors"
 	"onxzy/super-santa-server/database/models"
 
-	"go.uber.org/fx"
 	"gorm.io/gorm"
 )
 
@@ -17,19 +15,8 @@ var (
 	ErrGroupNotFound = errors.New("group not found")
 )
 
-func NewGroupStore(lc fx.Lifecycle, db *DB) *GroupStore {
-	s := &GroupStore{db: db}
-
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			if err := db.gorm.AutoMigrate(&models.Group{}); err != nil {
-				return err
-			}
-			return nil
-		},
-	})
-
-	return s
+func NewGroupStore(db *DB) *GroupStore {
+	return &GroupStore{db: db}
 }
 
 func (s *GroupStore) CreateGroup(group *models.Group) error {
@@ -38,7 +25,7 @@ func (s *GroupStore) CreateGroup(group *models.Group) error {
 
 func (s *GroupStore) GetGroup(id string) (*models.Group, error) {
 	var group models.Group
-	if err := s.db.gorm.Preload("Users").Where("ID = ?", id).First(&group).Error; err != nil {
+	if err := s.db.gorm.Preload("Users").Preload("Users.WishItems", wishItemsOrder).Where("ID = ?", id).First(&group).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrGroupNotFound
 		}
@@ -49,11 +36,38 @@ func (s *GroupStore) GetGroup(id string) (*models.Group, error) {
 
 func (s *GroupStore) UpdateGroup(group models.Group) error {
 	group.Users = nil // Clear the Users field to avoid updating it
-	return s.db.gorm.Save(group).Error
+	// Version, state and draw round are only changed by LockGroup, SetGroupState
+	// and SaveDrawResults, a stale copy of the group must not roll them back
+	return s.db.gorm.Omit("version", "state", "draw_round").Save(group).Error
 }
 
 func (s *GroupStore) DeleteGroup(id string) error {
-	return s.db.gorm.Delete(&models.Group{}, id).Error
+	return s.db.gorm.Delete(&models.Group{}, "id = ?", id).Error
+}
+
+// LockGroup bumps the version of a group and returns it with its users.
+// The update holds the row lock until the end of the unit of work, so concurrent
+// operations locking the same group wait for each other.
+func (s *GroupStore) LockGroup(id string) (*models.Group, error) {
+	result := s.db.gorm.Model(&models.Group{}).Where("id = ?", id).UpdateColumn("version", gorm.Expr("version + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrGroupNotFound
+	}
+	return s.GetGroup(id)
+}
+
+func (s *GroupStore) SetGroupState(id string, state models.GroupState) error {
+	result := s.db.gorm.Model(&models.Group{}).Where("id = ?", id).Update("state", state)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
 }
 
 func (s *GroupStore) GetAllGroups() ([]models.Group, error) {
@@ -63,3 +77,40 @@ func (s *GroupStore) GetAllGroups() ([]models.Group, error) {
 	}
 	return groups, nil
 }
+
+// SaveDrawResults stores the results of a new draw round and returns its number
+func (s *GroupStore) SaveDrawResults(groupID string, results []models.DrawResult) (round int, err error) {
+	err = s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		var group models.Group
+		if err := tx.Select("id", "draw_round").Where("id = ?", groupID).First(&group).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrGroupNotFound
+			}
+			return err
+		}
+
+		round = group.DrawRound + 1
+		for i := range results {
+			results[i].GroupID = groupID
+			results[i].Round = round
+		}
+
+		if err := tx.Create(&results).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Group{}).Where("id = ?", groupID).Update("draw_round", round).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return round, nil
+}
+
+// GetDrawResults returns every result of a round
+func (s *GroupStore) GetDrawResults(groupID string, round int) ([]models.DrawResult, error) {
+	var results []models.DrawResult
+	if err := s.db.gorm.Where("group_id = ? AND round = ?", groupID, round).Order("created_at").Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
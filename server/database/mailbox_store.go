@@ -0,0 +1,117 @@
+package database
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MailboxStore struct {
+	db *DB
+}
+
+var (
+	ErrThreadNotFound = errors.New("mailbox thread not found")
+)
+
+func NewMailboxStore(db *DB) *MailboxStore {
+	return &MailboxStore{db: db}
+}
+
+// mailboxMessagesOrder sorts the messages of a thread from the oldest
+func mailboxMessagesOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("created_at")
+}
+
+// CreateThread creates a thread along with its first messages
+func (s *MailboxStore) CreateThread(thread *models.MailboxThread) error {
+	return s.db.gorm.Create(thread).Error
+}
+
+func (s *MailboxStore) GetThread(id string) (*models.MailboxThread, error) {
+	var thread models.MailboxThread
+	if err := s.db.gorm.Preload("Messages", mailboxMessagesOrder).Where("id = ?", id).First(&thread).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrThreadNotFound
+		}
+		return nil, err
+	}
+	return &thread, nil
+}
+
+// GetRecipientThreads returns the threads addressed to a user, of every round, the latest first
+func (s *MailboxStore) GetRecipientThreads(recipientID string) ([]models.MailboxThread, error) {
+	threads := make([]models.MailboxThread, 0)
+	if err := s.db.gorm.Preload("Messages", mailboxMessagesOrder).
+		Where("recipient_id = ?", recipientID).
+		Order("updated_at DESC").
+		Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// GetRoundThreads returns the threads of a draw round of a group, without their messages
+func (s *MailboxStore) GetRoundThreads(groupID string, round int) ([]models.MailboxThread, error) {
+	threads := make([]models.MailboxThread, 0)
+	if err := s.db.gorm.Where("group_id = ? AND round = ?", groupID, round).Find(&threads).Error; err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// SetGiftStatus sets the gift status of a thread. The update time of the thread
+// is left alone, it only follows the messages.
+func (s *MailboxStore) SetGiftStatus(threadID string, status models.GiftStatus) error {
+	result := s.db.gorm.Model(&models.MailboxThread{}).Where("id = ?", threadID).UpdateColumns(map[string]any{
+		"gift_status":    status,
+		"gift_status_at": time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrThreadNotFound
+	}
+	return nil
+}
+
+// SetAddress sets the address key and the address of a thread, leaving its update time alone
+func (s *MailboxStore) SetAddress(threadID string, addressKey string, address string) error {
+	result := s.db.gorm.Model(&models.MailboxThread{}).Where("id = ?", threadID).UpdateColumns(map[string]any{
+		"address_key": addressKey,
+		"address":     address,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrThreadNotFound
+	}
+	return nil
+}
+
+// AddMessage adds a message to its thread and bumps the update time of the thread
+func (s *MailboxStore) AddMessage(message *models.MailboxMessage) error {
+	return s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.MailboxThread{}).Where("id = ?", message.ThreadID).UpdateColumn("updated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrThreadNotFound
+		}
+		return tx.Create(message).Error
+	})
+}
+
+// deleteRecipientThreads deletes the threads addressed to a user with their messages
+func deleteRecipientThreads(tx *gorm.DB, recipientID string) error {
+	threads := tx.Model(&models.MailboxThread{}).Select("id").Where("recipient_id = ?", recipientID)
+	if err := tx.Where("thread_id IN (?)", threads).Delete(&models.MailboxMessage{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("recipient_id = ?", recipientID).Delete(&models.MailboxThread{}).Error
+}
@@ -0,0 +1,590 @@
+// Package memory implements the database repositories in memory, for tests
+package memory
+
+import (
+	"maps"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Store holds groups, users, wish items and mailbox threads in maps and mirrors the behaviour of the
+// SQL stores, including the model hooks (group existence, single admin).
+type Store struct {
+	txMu        sync.Mutex // Serializes units of work
+	mu          sync.RWMutex
+	groups      map[string]models.Group // Stored without users
+	users       map[string]models.User  // Stored without wish items
+	wishItems   map[string]models.WishItem
+	drawResults map[string][]models.DrawResult  // By group ID
+	threads     map[string]models.MailboxThread // Stored with their messages
+}
+
+var (
+	_ database.GroupRepository   = (*Store)(nil)
+	_ database.UserRepository    = (*Store)(nil)
+	_ database.WishRepository    = (*Store)(nil)
+	_ database.MailboxRepository = (*Store)(nil)
+	_ database.UnitOfWork        = (*Store)(nil)
+)
+
+func NewStore() *Store {
+	return &Store{
+		groups:      make(map[string]models.Group),
+		users:       make(map[string]models.User),
+		wishItems:   make(map[string]models.WishItem),
+		drawResults: make(map[string][]models.DrawResult),
+		threads:     make(map[string]models.MailboxThread),
+	}
+}
+
+// Unit of work
+
+func (s *Store) Groups() database.GroupRepository    { return s }
+func (s *Store) Users() database.UserRepository      { return s }
+func (s *Store) Wishes() database.WishRepository     { return s }
+func (s *Store) Mailbox() database.MailboxRepository { return s }
+
+// Do runs fn with the store itself, restoring the previous state if fn fails
+func (s *Store) Do(fn func(repos database.Repositories) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.RLock()
+	groups := maps.Clone(s.groups)
+	users := maps.Clone(s.users)
+	wishItems := maps.Clone(s.wishItems)
+	drawResults := make(map[string][]models.DrawResult, len(s.drawResults))
+	for groupID, results := range s.drawResults {
+		drawResults[groupID] = slices.Clone(results)
+	}
+	threads := maps.Clone(s.threads)
+	s.mu.RUnlock()
+
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		s.groups, s.users, s.wishItems, s.drawResults, s.threads = groups, users, wishItems, drawResults, threads
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Group
+
+func (s *Store) CreateGroup(group *models.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	group.ID = uuid.NewString()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+	if group.State == "" {
+		group.State = models.GroupStateOpen
+	}
+	if group.GiftsPerPerson == 0 {
+		group.GiftsPerPerson = 1
+	}
+
+	// Validate users before inserting anything, like the SQL transaction would
+	usernames := make(map[string]bool)
+	admins := 0
+	for _, user := range group.Users {
+		if usernames[user.Username] {
+			return database.ErrUserAlreadyExists
+		}
+		usernames[user.Username] = true
+		if user.IsAdmin {
+			admins++
+		}
+	}
+	if admins > 1 {
+		return models.ErrGroupAlreadyHasAdmin
+	}
+
+	stored := *group
+	stored.Users = nil
+	s.groups[group.ID] = stored
+
+	for i := range group.Users {
+		user := &group.Users[i]
+		user.ID = uuid.NewString()
+		user.GroupID = group.ID
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		s.users[user.ID] = withoutWishItems(*user)
+	}
+
+	return nil
+}
+
+func (s *Store) GetGroup(id string) (*models.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	group, exists := s.groups[id]
+	if !exists || group.DeletedAt.Valid {
+		return nil, database.ErrGroupNotFound
+	}
+
+	group.Users = s.groupUsers(id)
+	return &group, nil
+}
+
+func (s *Store) UpdateGroup(group models.Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group.Users = nil
+	group.UpdatedAt = time.Now()
+	if group.CreatedAt.IsZero() {
+		group.CreatedAt = group.UpdatedAt
+	}
+	if stored, exists := s.groups[group.ID]; exists {
+		group.Version = stored.Version
+		group.State = stored.State
+		group.DrawRound = stored.DrawRound
+	}
+	s.groups[group.ID] = group
+	return nil
+}
+
+func (s *Store) DeleteGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Soft delete, like gorm does for models with a DeletedAt field
+	if group, exists := s.groups[id]; exists && !group.DeletedAt.Valid {
+		group.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		s.groups[id] = group
+	}
+	return nil
+}
+
+func (s *Store) LockGroup(id string) (*models.Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[id]
+	if !exists || group.DeletedAt.Valid {
+		return nil, database.ErrGroupNotFound
+	}
+
+	group.Version++
+	s.groups[id] = group
+
+	group.Users = s.groupUsers(id)
+	return &group, nil
+}
+
+func (s *Store) SetGroupState(id string, state models.GroupState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[id]
+	if !exists || group.DeletedAt.Valid {
+		return database.ErrGroupNotFound
+	}
+
+	group.State = state
+	group.UpdatedAt = time.Now()
+	s.groups[id] = group
+	return nil
+}
+
+func (s *Store) GetAllGroups() ([]models.Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]models.Group, 0, len(s.groups))
+	for _, group := range s.groups {
+		if !group.DeletedAt.Valid {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].CreatedAt.Before(groups[j].CreatedAt) })
+	return groups, nil
+}
+
+func (s *Store) SaveDrawResults(groupID string, results []models.DrawResult) (round int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	group, exists := s.groups[groupID]
+	if !exists || group.DeletedAt.Valid {
+		return 0, database.ErrGroupNotFound
+	}
+
+	now := time.Now()
+	round = group.DrawRound + 1
+	for i := range results {
+		results[i].ID = uuid.NewString()
+		results[i].CreatedAt = now
+		results[i].GroupID = groupID
+		results[i].Round = round
+	}
+	s.drawResults[groupID] = append(s.drawResults[groupID], results...)
+
+	group.DrawRound = round
+	group.UpdatedAt = now
+	s.groups[groupID] = group
+	return round, nil
+}
+
+func (s *Store) GetDrawResults(groupID string, round int) ([]models.DrawResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []models.DrawResult
+	for _, result := range s.drawResults[groupID] {
+		if result.Round == round {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// User
+
+func (s *Store) CreateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if group, exists := s.groups[user.GroupID]; !exists || group.DeletedAt.Valid {
+		return database.ErrGroupNotFound
+	}
+	for _, other := range s.users {
+		if other.GroupID == user.GroupID && other.Username == user.Username {
+			return database.ErrUserAlreadyExists
+		}
+		if other.GroupID == user.GroupID && other.Email == user.Email {
+			return database.ErrEmailAlreadyUsed
+		}
+	}
+	if user.IsAdmin && s.hasOtherAdmin(user.GroupID, "") {
+		return models.ErrGroupAlreadyHasAdmin
+	}
+
+	now := time.Now()
+	user.ID = uuid.NewString()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	s.users[user.ID] = withoutWishItems(*user)
+	return nil
+}
+
+func (s *Store) GetUser(id string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.users[id]
+	if !exists {
+		return nil, database.ErrUserNotFound
+	}
+	user.WishItems = s.userWishItems(id)
+	return &user, nil
+}
+
+func (s *Store) GetGroupUserByEmail(groupID string, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.GroupID == groupID && user.Email == email {
+			user.WishItems = s.userWishItems(user.ID)
+			return &user, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (s *Store) GetGroupUsers(groupID string) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.groupUsers(groupID), nil
+}
+
+func (s *Store) UpdateUser(user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.users {
+		if other.ID != user.ID && other.GroupID == user.GroupID && other.Username == user.Username {
+			return database.ErrUserAlreadyExists
+		}
+		if other.ID != user.ID && other.GroupID == user.GroupID && other.Email == user.Email {
+			return database.ErrEmailAlreadyUsed
+		}
+	}
+	if user.IsAdmin && s.hasOtherAdmin(user.GroupID, user.ID) {
+		return models.ErrGroupAlreadyHasAdmin
+	}
+
+	user.UpdatedAt = time.Now()
+	s.users[user.ID] = withoutWishItems(*user)
+	return nil
+}
+
+func (s *Store) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, id)
+	for itemID, item := range s.wishItems {
+		if item.UserID == id {
+			delete(s.wishItems, itemID)
+		} else if item.ClaimedByID != nil && *item.ClaimedByID == id {
+			item.ClaimedByID, item.ClaimedAt = nil, nil
+			s.wishItems[itemID] = item
+		}
+	}
+	for threadID, thread := range s.threads {
+		if thread.RecipientID == id {
+			delete(s.threads, threadID)
+		}
+	}
+	return nil
+}
+
+// Wish
+
+func (s *Store) GetUserWishItems(userID string) ([]models.WishItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userWishItems(userID), nil
+}
+
+func (s *Store) GetWishItem(id string) (*models.WishItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, exists := s.wishItems[id]
+	if !exists {
+		return nil, database.ErrWishItemNotFound
+	}
+	return &item, nil
+}
+
+func (s *Store) CreateWishItem(item *models.WishItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	item.ID = uuid.NewString()
+	item.CreatedAt = now
+	item.UpdatedAt = now
+	s.wishItems[item.ID] = *item
+	return nil
+}
+
+func (s *Store) UpdateWishItem(item *models.WishItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.wishItems[item.ID]
+	if !exists {
+		return database.ErrWishItemNotFound
+	}
+
+	item.CreatedAt = stored.CreatedAt
+	item.UserID = stored.UserID
+	item.ClaimedByID, item.ClaimedAt = stored.ClaimedByID, stored.ClaimedAt
+	item.UpdatedAt = time.Now()
+	s.wishItems[item.ID] = *item
+	return nil
+}
+
+func (s *Store) SetWishItemClaim(id string, claimedByID *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, exists := s.wishItems[id]
+	if !exists {
+		return database.ErrWishItemNotFound
+	}
+
+	item.ClaimedByID, item.ClaimedAt = nil, nil
+	if claimedByID != nil {
+		now := time.Now()
+		claimedBy := *claimedByID
+		item.ClaimedByID, item.ClaimedAt = &claimedBy, &now
+	}
+	s.wishItems[id] = item
+	return nil
+}
+
+func (s *Store) DeleteWishItem(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.wishItems[id]; !exists {
+		return database.ErrWishItemNotFound
+	}
+	delete(s.wishItems, id)
+	return nil
+}
+
+// Mailbox
+
+func (s *Store) CreateThread(thread *models.MailboxThread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	thread.ID = uuid.NewString()
+	thread.CreatedAt = now
+	thread.UpdatedAt = now
+	for i := range thread.Messages {
+		thread.Messages[i].ID = uuid.NewString()
+		thread.Messages[i].CreatedAt = now
+		thread.Messages[i].ThreadID = thread.ID
+	}
+	stored := *thread
+	stored.Messages = slices.Clone(thread.Messages)
+	s.threads[thread.ID] = stored
+	return nil
+}
+
+func (s *Store) GetThread(id string) (*models.MailboxThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	thread, exists := s.threads[id]
+	if !exists {
+		return nil, database.ErrThreadNotFound
+	}
+	thread.Messages = slices.Clone(thread.Messages)
+	return &thread, nil
+}
+
+func (s *Store) GetRecipientThreads(recipientID string) ([]models.MailboxThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threads := make([]models.MailboxThread, 0)
+	for _, thread := range s.threads {
+		if thread.RecipientID == recipientID {
+			thread.Messages = slices.Clone(thread.Messages)
+			threads = append(threads, thread)
+		}
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].UpdatedAt.After(threads[j].UpdatedAt) })
+	return threads, nil
+}
+
+func (s *Store) GetRoundThreads(groupID string, round int) ([]models.MailboxThread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	threads := make([]models.MailboxThread, 0)
+	for _, thread := range s.threads {
+		if thread.GroupID == groupID && thread.Round == round {
+			thread.Messages = nil
+			threads = append(threads, thread)
+		}
+	}
+	return threads, nil
+}
+
+func (s *Store) AddMessage(message *models.MailboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thread, exists := s.threads[message.ThreadID]
+	if !exists {
+		return database.ErrThreadNotFound
+	}
+
+	now := time.Now()
+	message.ID = uuid.NewString()
+	message.CreatedAt = now
+	thread.UpdatedAt = now
+	thread.Messages = append(slices.Clone(thread.Messages), *message)
+	s.threads[thread.ID] = thread
+	return nil
+}
+
+func (s *Store) SetGiftStatus(threadID string, status models.GiftStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thread, exists := s.threads[threadID]
+	if !exists {
+		return database.ErrThreadNotFound
+	}
+
+	now := time.Now()
+	thread.GiftStatus = status
+	thread.GiftStatusAt = &now
+	s.threads[threadID] = thread
+	return nil
+}
+
+func (s *Store) SetAddress(threadID string, addressKey string, address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thread, exists := s.threads[threadID]
+	if !exists {
+		return database.ErrThreadNotFound
+	}
+
+	thread.AddressKey, thread.Address = addressKey, address
+	s.threads[threadID] = thread
+	return nil
+}
+
+// groupUsers returns the users of a group in creation order, the caller must hold the lock
+func (s *Store) groupUsers(groupID string) []models.User {
+	users := make([]models.User, 0)
+	for _, user := range s.users {
+		if user.GroupID == groupID {
+			user.WishItems = s.userWishItems(user.ID)
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].CreatedAt.Before(users[j].CreatedAt) })
+	return users
+}
+
+// userWishItems returns the wish items of a user by position, the caller must hold the lock
+func (s *Store) userWishItems(userID string) []models.WishItem {
+	items := make([]models.WishItem, 0)
+	for _, item := range s.wishItems {
+		if item.UserID == userID {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Position != items[j].Position {
+			return items[i].Position < items[j].Position
+		}
+		return items[i].CreatedAt.Before(items[j].CreatedAt)
+	})
+	return items
+}
+
+// withoutWishItems returns user without its wish items, which are stored apart
+func withoutWishItems(user models.User) models.User {
+	user.WishItems = nil
+	return user
+}
+
+// hasOtherAdmin tells if the group has an admin other than userID, the caller must hold the lock
+func (s *Store) hasOtherAdmin(groupID string, userID string) bool {
+	for _, other := range s.users {
+		if other.GroupID == groupID && other.IsAdmin && other.ID != userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package memory_test
+
+import (
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/memory"
+	"onxzy/super-santa-server/database/storetest"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (database.GroupRepository, database.UserRepository) {
+		store := memory.NewStore()
+		return store, store
+	})
+}
+
+func TestWishes(t *testing.T) {
+	storetest.RunWishes(t, func(t *testing.T) (database.GroupRepository, database.UserRepository, database.WishRepository) {
+		store := memory.NewStore()
+		return store, store, store
+	})
+}
+
+func TestMailbox(t *testing.T) {
+	storetest.RunMailbox(t, func(t *testing.T) (database.GroupRepository, database.UserRepository, database.MailboxRepository) {
+		store := memory.NewStore()
+		return store, store, store
+	})
+}
+
+func TestUnitOfWork(t *testing.T) {
+	storetest.RunUnitOfWork(t, func(t *testing.T) (database.UnitOfWork, database.GroupRepository, database.UserRepository) {
+		store := memory.NewStore()
+		return store, store, store
+	})
+}
@@ -0,0 +1,186 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"onxzy/super-santa-server/database/migrations"
+	"onxzy/super-santa-server/utils"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string { return "schema_migrations" }
+
+var (
+	ErrUnknownMigration = errors.New("unknown migration version")
+)
+
+type SchemaVersionError struct {
+	Current  int
+	Expected int
+}
+
+func (e *SchemaVersionError) Error() string {
+	if e.Current > e.Expected {
+		return fmt.Sprintf("database schema version %d is newer than this build (%d), upgrade the server", e.Current, e.Expected)
+	}
+	return fmt.Sprintf("database schema version %d is behind this build (%d), run the migrate command", e.Current, e.Expected)
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+func (db *DB) ensureSchemaTable() error {
+	return db.gorm.AutoMigrate(&SchemaMigration{})
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an empty database
+func (db *DB) SchemaVersion() (int, error) {
+	if !db.gorm.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, nil
+	}
+
+	var version int
+	if err := db.gorm.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// CheckSchema refuses to run against an unmigrated or newer database
+func (db *DB) CheckSchema() error {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != migrations.Latest() {
+		return &SchemaVersionError{Current: version, Expected: migrations.Latest()}
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration up to target, 0 meaning the latest
+func (db *DB) MigrateUp(target int) (applied []migrations.Migration, err error) {
+	if target == 0 {
+		target = migrations.Latest()
+	}
+	if target > migrations.Latest() {
+		return nil, ErrUnknownMigration
+	}
+
+	if err := db.ensureSchemaTable(); err != nil {
+		return nil, err
+	}
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > migrations.Latest() {
+		return nil, &SchemaVersionError{Current: current, Expected: migrations.Latest()}
+	}
+
+	for _, m := range migrations.All {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+
+		if err := db.gorm.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts applied migrations until the schema is at target version
+func (db *DB) MigrateDown(target int) (reverted []migrations.Migration, err error) {
+	if target < 0 {
+		return nil, ErrUnknownMigration
+	}
+
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > migrations.Latest() {
+		return nil, &SchemaVersionError{Current: current, Expected: migrations.Latest()}
+	}
+
+	for i := len(migrations.All) - 1; i >= 0; i-- {
+		m := migrations.All[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+
+		if err := db.gorm.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", m.Version).Error
+		}); err != nil {
+			return reverted, fmt.Errorf("reverting migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+// MigrationStatus lists every known migration with its applied date
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	applied := make(map[int]time.Time)
+	if db.gorm.Migrator().HasTable(&SchemaMigration{}) {
+		var rows []SchemaMigration
+		if err := db.gorm.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			applied[row.Version] = row.AppliedAt
+		}
+	}
+
+	status := make([]MigrationStatus, len(migrations.All))
+	for i, m := range migrations.All {
+		status[i] = MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, exists := applied[m.Version]; exists {
+			status[i].AppliedAt = &appliedAt
+		}
+	}
+	return status, nil
+}
+
+// EnsureSchema runs at startup: it applies pending migrations when db.auto_migrate
+// is set and otherwise refuses to start on a schema that doesn't match this build.
+func EnsureSchema(db *DB, config *utils.Config, log *zap.Logger) error {
+	log = log.Named("migrate")
+
+	if config.DB.AutoMigrate {
+		applied, err := db.MigrateUp(0)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			log.Info("Migration applied", zap.Int("version", m.Version), zap.String("name", m.Name))
+		}
+	}
+
+	return db.CheckSchema()
+}
@@ -0,0 +1,45 @@
+package database_test
+
+import (
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/utils"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Dropping a column on SQLite recreates the table without its indexes, the
+// schema must still end up with them once every migration is applied
+func TestSQLiteMigrationsKeepIndexes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	config := &utils.Config{}
+	config.DB.Driver = database.DriverSQLite
+	config.DB.SQLitePath = path
+
+	db, err := database.OpenDB(zap.NewNop(), config)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if _, err := db.MigrateDown(1); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp again: %v", err)
+	}
+
+	raw, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if !raw.Migrator().HasIndex("groups", "idx_groups_deleted_at") {
+		t.Errorf("expected the index on deleted groups to be restored")
+	}
+}
@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Schema as created by AutoMigrate before versioned migrations.
+// AutoMigrate is idempotent, so databases created by older releases are adopted as is.
+
+type groupV1 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Name           string
+	SecretVerifier string
+	ExchangeDate   *time.Time
+
+	Results string `gorm:"type:text"`
+
+	Users []userV1 `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+func (groupV1) TableName() string { return "groups" }
+
+type userV1 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	Username         string `gorm:"uniqueIndex:idx_username_group"`
+	Email            string
+	PasswordVerifier string
+
+	GroupID string `gorm:"uniqueIndex:idx_username_group"`
+	IsAdmin bool
+
+	PublicKeySecret     string
+	PrivateKeyEncrypted string
+
+	Wishes string
+}
+
+func (userV1) TableName() string { return "users" }
+
+type notificationPreferenceV1 struct {
+	UserID    string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Delivery    string
+	MutedEvents string `gorm:"type:text"`
+}
+
+func (notificationPreferenceV1) TableName() string { return "notification_preferences" }
+
+type notificationV1 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	UserID   string `gorm:"index"`
+	Event    string
+	Subject  string
+	Template string
+	Data     string `gorm:"type:text"`
+
+	Delivery string
+	SentAt   *time.Time
+}
+
+func (notificationV1) TableName() string { return "notifications" }
+
+var baseline = Migration{
+	Version: 1,
+	Name:    "baseline",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&groupV1{}, &userV1{}, &notificationPreferenceV1{}, &notificationV1{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&notificationV1{}, &notificationPreferenceV1{}, &userV1{}, &groupV1{})
+	},
+}
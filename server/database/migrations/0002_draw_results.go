@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Draw results move from the newline-joined groups.results column to one row per giver
+
+type groupV2 struct {
+	ID string `gorm:"primaryKey"`
+
+	DrawRound   int            `gorm:"not null;default:0"`
+	DrawResults []drawResultV2 `gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+func (groupV2) TableName() string { return "groups" }
+
+type drawResultV2 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	GroupID string `gorm:"index:idx_draw_result_group_round"`
+	Round   int    `gorm:"index:idx_draw_result_group_round"`
+	KeyTag  string `gorm:"index"`
+
+	Payload string `gorm:"type:text"`
+}
+
+func (drawResultV2) TableName() string { return "draw_results" }
+
+type legacyResults struct {
+	ID      string
+	Results string
+}
+
+var drawResults = Migration{
+	Version: 2,
+	Name:    "draw_results",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&groupV2{}, &drawResultV2{}); err != nil {
+			return err
+		}
+
+		var groups []legacyResults
+		if err := tx.Table("groups").Select("id, results").Where("results IS NOT NULL AND results <> ''").Scan(&groups).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, group := range groups {
+			// Legacy results have no key tag, clients try to decrypt each of them
+			payloads := strings.Split(group.Results, "\n")
+			rows := make([]drawResultV2, len(payloads))
+			for i, payload := range payloads {
+				rows[i] = drawResultV2{ID: uuid.NewString(), CreatedAt: now, GroupID: group.ID, Round: 1, Payload: payload}
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return err
+			}
+			if err := tx.Table("groups").Where("id = ?", group.ID).Update("draw_round", 1).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Migrator().DropColumn(&groupV1{}, "Results")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&groupV1{}, "Results"); err != nil {
+			return err
+		}
+
+		var groups []groupV2
+		if err := tx.Where("draw_round > 0").Find(&groups).Error; err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			var rows []drawResultV2
+			if err := tx.Where("group_id = ? AND round = ?", group.ID, group.DrawRound).Find(&rows).Error; err != nil {
+				return err
+			}
+			payloads := make([]string, len(rows))
+			for i, row := range rows {
+				payloads[i] = row.Payload
+			}
+			if err := tx.Table("groups").Where("id = ?", group.ID).Update("results", strings.Join(payloads, "\n")).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Migrator().DropTable(&drawResultV2{}); err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&groupV2{}, "DrawRound")
+	},
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Groups get a version, bumped by every unit of work locking the group
+
+type groupV3 struct {
+	ID string `gorm:"primaryKey"`
+
+	Version int `gorm:"not null;default:0"`
+}
+
+func (groupV3) TableName() string { return "groups" }
+
+var groupVersion = Migration{
+	Version: 3,
+	Name:    "group_version",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&groupV3{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&groupV3{}, "Version")
+	},
+}
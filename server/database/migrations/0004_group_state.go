@@ -0,0 +1,27 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Groups get a state, drawn for groups which already have results
+
+type groupV4 struct {
+	ID string `gorm:"primaryKey"`
+
+	State string `gorm:"not null;default:'open'"`
+}
+
+func (groupV4) TableName() string { return "groups" }
+
+var groupState = Migration{
+	Version: 4,
+	Name:    "group_state",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&groupV4{}); err != nil {
+			return err
+		}
+		return tx.Table("groups").Where("draw_round > 0").Update("state", "drawn").Error
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&groupV4{}, "State")
+	},
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Groups remember when their admin was warned of the upcoming retention purge
+
+type groupV5 struct {
+	ID string `gorm:"primaryKey"`
+
+	PurgeWarnedAt *time.Time
+}
+
+func (groupV5) TableName() string { return "groups" }
+
+var purgeWarning = Migration{
+	Version: 5,
+	Name:    "purge_warning",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&groupV5{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&groupV5{}, "PurgeWarnedAt")
+	},
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Dropping a column on SQLite recreates the table without its indexes. Migrations
+// 0002 to 0005 drop columns of groups with the plain migrator, in the draw results
+// migration and in their downs, which loses the index on deleted groups: restore it.
+// Later migrations use dropColumn, which keeps the indexes.
+
+var sqliteIndexes = Migration{
+	Version: 6,
+	Name:    "sqlite_indexes",
+	Up: func(tx *gorm.DB) error {
+		if tx.Migrator().HasIndex(&groupV1{}, "idx_groups_deleted_at") {
+			return nil
+		}
+		return tx.Migrator().CreateIndex(&groupV1{}, "idx_groups_deleted_at")
+	},
+	Down: func(tx *gorm.DB) error {
+		return nil // The index belongs to the baseline schema
+	},
+}
@@ -0,0 +1,100 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"gorm.io/gorm"
+)
+
+// Users get blind indexes so that their username and email can be encrypted.
+// The unique username constraint moves to the username index. Existing rows are
+// plaintext and get unkeyed indexes, `encryption rotate` encrypts and re-indexes them.
+// Reverting requires plaintext rows.
+
+type userV7Columns struct {
+	ID string `gorm:"primaryKey"`
+
+	UsernameIndex string `gorm:"size:64"`
+	EmailIndex    string `gorm:"size:64"`
+}
+
+func (userV7Columns) TableName() string { return "users" }
+
+type userV7 struct {
+	ID       string `gorm:"primaryKey"`
+	Username string `gorm:"type:text"`
+	Email    string `gorm:"type:text"`
+
+	UsernameIndex string `gorm:"size:64;uniqueIndex:idx_username_index_group"`
+	EmailIndex    string `gorm:"size:64;index:idx_email_index_group"`
+	GroupID       string `gorm:"uniqueIndex:idx_username_index_group;index:idx_email_index_group"`
+}
+
+func (userV7) TableName() string { return "users" }
+
+func unkeyedIndexV7(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+var userBlindIndex = Migration{
+	Version: 7,
+	Name:    "user_blind_index",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&userV7Columns{}); err != nil {
+			return err
+		}
+
+		var users []struct {
+			ID       string
+			Username string
+			Email    string
+		}
+		if err := tx.Table("users").Select("id", "username", "email").Find(&users).Error; err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := tx.Table("users").Where("id = ?", user.ID).Updates(map[string]any{
+				"username_index": unkeyedIndexV7(user.Username),
+				"email_index":    unkeyedIndexV7(user.Email),
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Migrator().DropIndex(&userV1{}, "idx_username_group"); err != nil {
+			return err
+		}
+		if tx.Dialector.Name() == "mysql" {
+			// The username was a varchar to be indexed, ciphertexts are longer
+			if err := tx.Migrator().AlterColumn(&userV7{}, "Username"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().CreateIndex(&userV7{}, "idx_username_index_group"); err != nil {
+			return err
+		}
+		return tx.Migrator().CreateIndex(&userV7{}, "idx_email_index_group")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropIndex(&userV7{}, "idx_email_index_group"); err != nil {
+			return err
+		}
+		if err := tx.Migrator().DropIndex(&userV7{}, "idx_username_index_group"); err != nil {
+			return err
+		}
+		if tx.Dialector.Name() == "mysql" {
+			if err := tx.Migrator().AlterColumn(&userV1{}, "Username"); err != nil {
+				return err
+			}
+		}
+		if err := tx.Migrator().CreateIndex(&userV1{}, "idx_username_group"); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &userV7Columns{}, "UsernameIndex"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &userV7Columns{}, "EmailIndex")
+	},
+}
@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The free-text users.wishes column moves to one row per wish item, the text
+// becomes the first item of each user. Groups get an optional gift budget.
+// Reverting joins the items back into text.
+
+type groupV8 struct {
+	ID string `gorm:"primaryKey"`
+
+	Budget   *int64
+	Currency string `gorm:"size:3"`
+}
+
+func (groupV8) TableName() string { return "groups" }
+
+type userV8 struct {
+	ID string `gorm:"primaryKey"`
+
+	WishItems []wishItemV8 `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+func (userV8) TableName() string { return "users" }
+
+type wishItemV8 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	UserID string `gorm:"index"`
+
+	Title    string `gorm:"type:text;serializer:encrypted"`
+	URL      string `gorm:"type:text;serializer:encrypted"`
+	Price    *int64
+	Currency string `gorm:"size:3"`
+	Priority int    `gorm:"not null;default:0"`
+	Notes    string `gorm:"type:text;serializer:encrypted"`
+	Position int    `gorm:"not null;default:0"`
+}
+
+func (wishItemV8) TableName() string { return "wish_items" }
+
+// legacyWishes reads and writes users.wishes, encrypted when encryption at rest is enabled
+type legacyWishes struct {
+	ID     string `gorm:"primaryKey"`
+	Wishes string `gorm:"serializer:encrypted"`
+}
+
+func (legacyWishes) TableName() string { return "users" }
+
+// wishTitleLengthV8 is the maximum length of a wish item title, in characters
+const wishTitleLengthV8 = 200
+
+// wishItemFromText makes the first line of text the title, the other lines
+// the notes. Titles too long are cut and the whole text is kept in the notes.
+func wishItemFromText(text string) (title string, notes string) {
+	title, notes, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	title, notes = strings.TrimSpace(title), strings.TrimSpace(notes)
+	if utf8.RuneCountInString(title) > wishTitleLengthV8 {
+		return string([]rune(title)[:wishTitleLengthV8]), strings.TrimSpace(text)
+	}
+	return title, notes
+}
+
+var wishItems = Migration{
+	Version: 8,
+	Name:    "wish_items",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&groupV8{}, &userV8{}, &wishItemV8{}); err != nil {
+			return err
+		}
+
+		var users []legacyWishes
+		if err := tx.Where("wishes IS NOT NULL AND wishes <> ''").Find(&users).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, user := range users {
+			if strings.TrimSpace(user.Wishes) == "" {
+				continue
+			}
+			title, notes := wishItemFromText(user.Wishes)
+			item := wishItemV8{ID: uuid.NewString(), CreatedAt: now, UpdatedAt: now, UserID: user.ID, Title: title, Notes: notes}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+
+		return dropColumn(tx, &userV1{}, "Wishes")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.Migrator().AddColumn(&userV1{}, "Wishes"); err != nil {
+			return err
+		}
+
+		var items []wishItemV8
+		if err := tx.Order("user_id, position, created_at").Find(&items).Error; err != nil {
+			return err
+		}
+
+		wishes := make(map[string][]string)
+		var userIDs []string
+		for _, item := range items {
+			if _, exists := wishes[item.UserID]; !exists {
+				userIDs = append(userIDs, item.UserID)
+			}
+			lines := []string{item.Title}
+			if item.URL != "" {
+				lines = append(lines, item.URL)
+			}
+			if item.Notes != "" {
+				lines = append(lines, item.Notes)
+			}
+			wishes[item.UserID] = append(wishes[item.UserID], strings.Join(lines, "\n"))
+		}
+		for _, userID := range userIDs {
+			user := legacyWishes{ID: userID, Wishes: strings.Join(wishes[userID], "\n\n")}
+			if err := tx.Model(&user).Select("wishes").Updates(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Migrator().DropTable(&wishItemV8{}); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &groupV8{}, "Budget"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &groupV8{}, "Currency")
+	},
+}
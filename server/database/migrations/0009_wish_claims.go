@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Wish items can be claimed by the member buying them
+
+type wishItemV9 struct {
+	ID string `gorm:"primaryKey"`
+
+	ClaimedByID *string `gorm:"index"`
+	ClaimedAt   *time.Time
+}
+
+func (wishItemV9) TableName() string { return "wish_items" }
+
+var wishClaims = Migration{
+	Version: 9,
+	Name:    "wish_claims",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&wishItemV9{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &wishItemV9{}, "ClaimedByID"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &wishItemV9{}, "ClaimedAt")
+	},
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Groups can keep wish items end-to-end encrypted under the group secret, the
+// client encrypts them into an opaque payload
+
+type groupV10 struct {
+	ID string `gorm:"primaryKey"`
+
+	EncryptedWishes bool `gorm:"not null;default:false"`
+}
+
+func (groupV10) TableName() string { return "groups" }
+
+type wishItemV10 struct {
+	ID string `gorm:"primaryKey"`
+
+	Payload string `gorm:"type:text"`
+}
+
+func (wishItemV10) TableName() string { return "wish_items" }
+
+var encryptedWishes = Migration{
+	Version: 10,
+	Name:    "encrypted_wishes",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&groupV10{}, &wishItemV10{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &wishItemV10{}, "Payload"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &groupV10{}, "EncryptedWishes")
+	},
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Anonymous threads between members and their santa
+
+type mailboxThreadV11 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	GroupID     string `gorm:"index:idx_mailbox_thread_group_round"`
+	Round       int    `gorm:"index:idx_mailbox_thread_group_round"`
+	RecipientID string `gorm:"index"`
+
+	ReplyKey        string `gorm:"type:text"`
+	SenderKey       string `gorm:"type:text"`
+	SenderTokenHash string `gorm:"size:64"`
+
+	Messages []mailboxMessageV11 `gorm:"foreignKey:ThreadID;constraint:OnDelete:CASCADE"`
+}
+
+func (mailboxThreadV11) TableName() string { return "mailbox_threads" }
+
+type mailboxMessageV11 struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	ThreadID  string `gorm:"index"`
+	FromSanta bool   `gorm:"not null;default:false"`
+	Payload   string `gorm:"type:text"`
+}
+
+func (mailboxMessageV11) TableName() string { return "mailbox_messages" }
+
+var mailbox = Migration{
+	Version: 11,
+	Name:    "mailbox",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&mailboxThreadV11{}, &mailboxMessageV11{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&mailboxMessageV11{}, &mailboxThreadV11{})
+	},
+}
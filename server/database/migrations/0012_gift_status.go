@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Santas track the progress of their gift on their mailbox thread
+
+type mailboxThreadV12 struct {
+	ID string `gorm:"primaryKey"`
+
+	GiftStatus   string `gorm:"size:16;not null;default:''"`
+	GiftStatusAt *time.Time
+}
+
+func (mailboxThreadV12) TableName() string { return "mailbox_threads" }
+
+var giftStatus = Migration{
+	Version: 12,
+	Name:    "gift_status",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&mailboxThreadV12{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &mailboxThreadV12{}, "GiftStatus"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &mailboxThreadV12{}, "GiftStatusAt")
+	},
+}
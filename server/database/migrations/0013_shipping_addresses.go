@@ -0,0 +1,40 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Members can share a shipping address readable by their santa only, under a
+// key the draw gives to both of them
+
+type userV13 struct {
+	ID string `gorm:"primaryKey"`
+
+	Address    string `gorm:"type:text"`
+	AddressKey string `gorm:"type:text"`
+}
+
+func (userV13) TableName() string { return "users" }
+
+type drawResultV13 struct {
+	ID string `gorm:"primaryKey"`
+
+	AddressKey string `gorm:"type:text"`
+}
+
+func (drawResultV13) TableName() string { return "draw_results" }
+
+var shippingAddresses = Migration{
+	Version: 13,
+	Name:    "shipping_addresses",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV13{}, &drawResultV13{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &userV13{}, "Address"); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &userV13{}, "AddressKey"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &drawResultV13{}, "AddressKey")
+	},
+}
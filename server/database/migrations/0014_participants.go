@@ -0,0 +1,25 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Members can stay out of the draw, organisers who run the group without being
+// paired. Existing members keep participating.
+
+type userV14 struct {
+	ID string `gorm:"primaryKey"`
+
+	Participates bool `gorm:"not null;default:true"`
+}
+
+func (userV14) TableName() string { return "users" }
+
+var participants = Migration{
+	Version: 14,
+	Name:    "participants",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV14{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return dropColumn(tx, &userV14{}, "Participates")
+	},
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Groups can draw several gifts per person, each to a different member
+
+type groupV15 struct {
+	ID string `gorm:"primaryKey"`
+
+	GiftsPerPerson int `gorm:"not null;default:1"`
+}
+
+func (groupV15) TableName() string { return "groups" }
+
+var giftsPerPerson = Migration{
+	Version: 15,
+	Name:    "gifts_per_person",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&groupV15{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return dropColumn(tx, &groupV15{}, "GiftsPerPerson")
+	},
+}
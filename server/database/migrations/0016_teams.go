@@ -0,0 +1,36 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Members can be put in teams, and groups can require givers to pick someone
+// outside their team. Existing members have no team and groups no rule.
+
+type userV16 struct {
+	ID string `gorm:"primaryKey"`
+
+	Team string `gorm:"size:64;not null;default:''"`
+}
+
+func (userV16) TableName() string { return "users" }
+
+type groupV16 struct {
+	ID string `gorm:"primaryKey"`
+
+	DrawRule string `gorm:"size:32;not null;default:''"`
+}
+
+func (groupV16) TableName() string { return "groups" }
+
+var teams = Migration{
+	Version: 16,
+	Name:    "teams",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV16{}, &groupV16{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &groupV16{}, "DrawRule"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &userV16{}, "Team")
+	},
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// A new email is kept pending until it is confirmed with a link sent to it.
+// Existing members have no pending email.
+
+type userV17 struct {
+	ID string `gorm:"primaryKey"`
+
+	PendingEmail        string `gorm:"serializer:encrypted"`
+	EmailTokenHash      string `gorm:"size:64"`
+	EmailTokenExpiresAt *time.Time
+}
+
+func (userV17) TableName() string { return "users" }
+
+var pendingEmail = Migration{
+	Version: 17,
+	Name:    "pending_email",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV17{})
+	},
+	Down: func(tx *gorm.DB) error {
+		for _, field := range []string{"EmailTokenExpiresAt", "EmailTokenHash", "PendingEmail"} {
+			if err := dropColumn(tx, &userV17{}, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
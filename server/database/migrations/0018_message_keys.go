@@ -0,0 +1,30 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Messages are encrypted to a key pair of their own, so publishing it doesn't
+// publish the key the draw encrypts results to. Existing members get one from
+// their client at their next login.
+
+type userV18 struct {
+	ID string `gorm:"primaryKey"`
+
+	MessageKeySecret    string `gorm:"type:text"`
+	MessageKeyEncrypted string `gorm:"type:text"`
+}
+
+func (userV18) TableName() string { return "users" }
+
+var messageKeys = Migration{
+	Version: 18,
+	Name:    "message_keys",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&userV18{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &userV18{}, "MessageKeyEncrypted"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &userV18{}, "MessageKeySecret")
+	},
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Shipping addresses move to the threads: the santa hands their recipient a key
+// through their thread, so the draw no longer learns who gives to whom. Addresses
+// set under the keys of the draw are dropped, members set them again.
+
+type mailboxThreadV19 struct {
+	ID string `gorm:"primaryKey"`
+
+	AddressKey string `gorm:"type:text"`
+	Address    string `gorm:"type:text"`
+}
+
+func (mailboxThreadV19) TableName() string { return "mailbox_threads" }
+
+var threadAddresses = Migration{
+	Version: 19,
+	Name:    "thread_addresses",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&mailboxThreadV19{}); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &userV13{}, "Address"); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &userV13{}, "AddressKey"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &drawResultV13{}, "AddressKey")
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&userV13{}, &drawResultV13{}); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &mailboxThreadV19{}, "Address"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &mailboxThreadV19{}, "AddressKey")
+	},
+}
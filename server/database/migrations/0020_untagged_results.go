@@ -0,0 +1,25 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Draw results are no longer looked up by the key they are encrypted to: members get
+// every result of the round and try their own key, so no request ties a key to a result
+
+type drawResultV20 struct {
+	ID string `gorm:"primaryKey"`
+
+	KeyTag string `gorm:"index"`
+}
+
+func (drawResultV20) TableName() string { return "draw_results" }
+
+var untaggedResults = Migration{
+	Version: 20,
+	Name:    "untagged_results",
+	Up: func(tx *gorm.DB) error {
+		return dropColumn(tx, &drawResultV20{}, "KeyTag")
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&drawResultV20{})
+	},
+}
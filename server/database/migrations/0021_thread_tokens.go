@@ -0,0 +1,40 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Each draw result carries a thread token for its giver: a thread starts only with the
+// token of a result, once per result. Threads of earlier rounds stay unbound.
+
+type drawResultV21 struct {
+	ID string `gorm:"primaryKey"`
+
+	RecipientID     string `gorm:"index"`
+	ThreadTokenHash string `gorm:"size:64"`
+}
+
+func (drawResultV21) TableName() string { return "draw_results" }
+
+type mailboxThreadV21 struct {
+	ID string `gorm:"primaryKey"`
+
+	ResultID *string `gorm:"uniqueIndex"`
+}
+
+func (mailboxThreadV21) TableName() string { return "mailbox_threads" }
+
+var threadTokens = Migration{
+	Version: 21,
+	Name:    "thread_tokens",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&drawResultV21{}, &mailboxThreadV21{})
+	},
+	Down: func(tx *gorm.DB) error {
+		if err := dropColumn(tx, &mailboxThreadV21{}, "ResultID"); err != nil {
+			return err
+		}
+		if err := dropColumn(tx, &drawResultV21{}, "RecipientID"); err != nil {
+			return err
+		}
+		return dropColumn(tx, &drawResultV21{}, "ThreadTokenHash")
+	},
+}
@@ -0,0 +1,24 @@
+package migrations
+
+import "gorm.io/gorm"
+
+// Immediate notifications count their failed deliveries, retries stop after a few attempts.
+
+type notificationV22 struct {
+	ID string `gorm:"primaryKey"`
+
+	Attempts int `gorm:"not null;default:0"`
+}
+
+func (notificationV22) TableName() string { return "notifications" }
+
+var notificationAttempts = Migration{
+	Version: 22,
+	Name:    "notification_attempts",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&notificationV22{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return dropColumn(tx, &notificationV22{}, "Attempts")
+	},
+}
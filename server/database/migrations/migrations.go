@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned schema change.
+// Migrations must not use the application models: they describe the schema
+// with their own frozen structs so that later model changes can't alter them.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// All lists every migration in version order
+var All = []Migration{
+	baseline,
+	drawResults,
+	groupVersion,
+	groupState,
+	purgeWarning,
+	sqliteIndexes,
+	userBlindIndex,
+	wishItems,
+	wishClaims,
+	encryptedWishes,
+	mailbox,
+	giftStatus,
+	shippingAddresses,
+	participants,
+	giftsPerPerson,
+	teams,
+	pendingEmail,
+	messageKeys,
+	threadAddresses,
+	untaggedResults,
+	threadTokens,
+	notificationAttempts,
+}
+
+// Latest is the schema version expected by this build
+func Latest() int {
+	return All[len(All)-1].Version
+}
+
+// dropColumn drops the column of field. SQLite can't drop a column in place,
+// the table is recreated without its indexes: they are created again, except
+// those on the dropped column.
+func dropColumn(tx *gorm.DB, model any, field string) error {
+	if tx.Dialector.Name() != "sqlite" {
+		return tx.Migrator().DropColumn(model, field)
+	}
+
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(model); err != nil {
+		return err
+	}
+	column := field
+	if f := stmt.Schema.LookUpField(field); f != nil {
+		column = f.DBName
+	}
+
+	var indexes []string
+	if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", stmt.Table).
+		Scan(&indexes).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Migrator().DropColumn(model, field); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if strings.Contains(index, "`"+column+"`") {
+			continue
+		}
+		if err := tx.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
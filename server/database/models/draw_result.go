@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DrawResult is the receiver of one giver, encrypted to the giver's public key.
+// The server never learns who the giver is: members fetch every result of the
+// round and keep the one their private key decrypts.
+type DrawResult struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
+	CreatedAt time.Time `json:"created_at"`
+
+	GroupID string `gorm:"index:idx_draw_result_group_round" json:"-"`
+	Round   int    `gorm:"index:idx_draw_result_group_round" json:"round"` // Draw round of the group, starting at 1
+
+	// The recipient is no secret to the server, which encrypts the result. The thread
+	// token in the payload lets the giver start the thread of this result, once.
+	RecipientID     string `gorm:"index" json:"-"`
+	ThreadTokenHash string `gorm:"size:64" json:"-"` // SHA-256 of the thread token, empty before thread tokens
+
+	Payload string `gorm:"type:text" json:"payload"` // Compact JWE of the receiver's user ID and the thread token, as JSON
+}
+
+func (result *DrawResult) BeforeCreate(tx *gorm.DB) (err error) {
+	// UUID version 4
+	result.ID = uuid.NewString()
+	return
+}
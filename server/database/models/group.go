@@ -1,44 +1,90 @@
 package models
 
 import (
-	"database/sql/driver"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-type Results []string
+type GroupState string
 
-func (r *Results) Scan(src any) error {
-	*r = strings.Split(src.(string), "\n")
-	return nil
-}
+const (
+	GroupStateOpen     GroupState = "open"     // Members can join and leave
+	GroupStateDrawing  GroupState = "drawing"  // Draw initiated, membership is locked
+	GroupStateDrawn    GroupState = "drawn"    // Draw done, results are available
+	GroupStateArchived GroupState = "archived" // Read-only
+)
 
-func (r Results) Value() (driver.Value, error) {
-	if len(r) == 0 {
-		return nil, nil
-	}
-	return strings.Join(r, "\n"), nil
-}
+type DrawRule string
+
+const (
+	DrawRuleNone        DrawRule = ""             // Members give to anyone but themselves
+	DrawRuleOutsideTeam DrawRule = "outside_team" // Members give outside their team, members without a team to anyone
+)
 
 type Group struct {
 	ID        string         `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"-"`
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Version   int            `json:"-" gorm:"not null;default:0"` // Bumped by every unit of work locking the group
+
+	Name           string     `json:"name"`
+	SecretVerifier string     `json:"-"`             // SRP Verifier for group's secret
+	ExchangeDate   *time.Time `json:"exchange_date"` // Day of the gift exchange, optional
+	PurgeWarnedAt  *time.Time `json:"-"`             // When the admin was warned of the retention purge
+	Budget         *int64     `json:"budget"`        // Maximum price of a gift in minor units of Currency, optional
+	Currency       string     `json:"currency" gorm:"size:3"`
 
-	Name           string `json:"name"`
-	SecretVerifier string `json:"-"` // SRP Verifier for group's secret
+	// Wish items are encrypted by the members under the group secret, chosen at creation.
+	// Only the title, URL and notes are: the price, currency and priority stay in
+	// plaintext so that the server can check prices against the budget.
+	EncryptedWishes bool `json:"encrypted_wishes" gorm:"not null;default:false"`
 
-	Results Results `json:"results" gorm:"type:text"` // Results of the draw
+	// Each participant gives this many gifts, to as many different members
+	GiftsPerPerson int `json:"gifts_per_person" gorm:"not null;default:1"`
+	// Who members may give to according to their teams, anyone by default
+	DrawRule DrawRule `json:"draw_rule" gorm:"size:32;not null;default:''"`
+
+	State       GroupState   `json:"state" gorm:"not null;default:'open'"`
+	DrawRound   int          `json:"draw_round" gorm:"not null;default:0"` // Number of the latest draw, 0 until the first draw
+	DrawResults []DrawResult `json:"-" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
 
 	Users []User `json:"users" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
 }
 
+// IsDrawn tells if the group has draw results, archived groups may have some
+func (group *Group) IsDrawn() bool {
+	return group.DrawRound > 0
+}
+
+// Participants returns the members taking part in the draw
+func (group *Group) Participants() []User {
+	participants := make([]User, 0, len(group.Users))
+	for _, user := range group.Users {
+		if user.Participates {
+			participants = append(participants, user)
+		}
+	}
+	return participants
+}
+
+// ViewClaims sets the claim state of the wish items of the members seen by viewerID
+func (group *Group) ViewClaims(viewerID string) {
+	for i := range group.Users {
+		group.Users[i].ViewClaims(viewerID)
+	}
+}
+
 func (group *Group) BeforeCreate(tx *gorm.DB) (err error) {
 	// UUID version 4
 	group.ID = uuid.NewString()
+	if group.State == "" {
+		group.State = GroupStateOpen
+	}
+	if group.GiftsPerPerson == 0 {
+		group.GiftsPerPerson = 1
+	}
 	return
 }
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GiftStatus is the progress of a gift, set by the santa up to shipped, then by the recipient
+type GiftStatus string
+
+const (
+	GiftStatusNone     GiftStatus = ""
+	GiftStatusBought   GiftStatus = "bought"
+	GiftStatusShipped  GiftStatus = "shipped"
+	GiftStatusReceived GiftStatus = "received"
+	GiftStatusThanked  GiftStatus = "thanked"
+)
+
+// GiftStatuses lists the statuses in the order a gift goes through them
+var GiftStatuses = []GiftStatus{GiftStatusBought, GiftStatusShipped, GiftStatusReceived, GiftStatusThanked}
+
+// SetBySanta reports whether the status is set by the santa rather than the recipient
+func (status GiftStatus) SetBySanta() bool {
+	return status == GiftStatusBought || status == GiftStatusShipped
+}
+
+// MailboxThread is an anonymous conversation between a member and their santa.
+// The server never learns who the santa is: they start the thread with a reply
+// key and post with a token only they know, both sealed in SenderKey.
+type MailboxThread struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"` // Time of the latest message
+
+	GroupID     string  `gorm:"index:idx_mailbox_thread_group_round" json:"-"`
+	Round       int     `gorm:"index:idx_mailbox_thread_group_round" json:"round"` // Draw round the thread belongs to
+	RecipientID string  `gorm:"index" json:"recipient_id"`
+	ResultID    *string `gorm:"uniqueIndex" json:"result_id"` // Draw result the santa proved with its thread token, nil for threads started before thread tokens
+
+	ReplyKey        string `gorm:"type:text" json:"reply_key"`  // Public JWK the recipient encrypts their replies to
+	SenderKey       string `gorm:"type:text" json:"sender_key"` // Reply private key and token, encrypted by the santa to their own key
+	SenderTokenHash string `gorm:"size:64" json:"-"`            // SHA-256 of the token the santa posts with
+
+	GiftStatus   GiftStatus `gorm:"size:16;not null;default:''" json:"gift_status"` // Progress of the santa's gift
+	GiftStatusAt *time.Time `json:"gift_status_at"`
+
+	// Shipping address of the recipient for this santa, under a key the santa derives
+	// from their token and hands over encrypted to the recipient. The server can open neither.
+	AddressKey string `gorm:"type:text" json:"address_key"` // Compact JWE of the address key, encrypted to the recipient's message key
+	Address    string `gorm:"type:text" json:"address"`     // Compact JWE, alg dir and enc A256GCM
+
+	Messages []MailboxMessage `gorm:"foreignKey:ThreadID;constraint:OnDelete:CASCADE" json:"messages"` // Preloaded by creation time
+}
+
+// MailboxMessage is a message of a thread, encrypted by the client to both ends of the thread
+type MailboxMessage struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
+	CreatedAt time.Time `json:"created_at"`
+
+	ThreadID  string `gorm:"index" json:"-"`
+	FromSanta bool   `gorm:"not null;default:false" json:"from_santa"`
+	Payload   string `gorm:"type:text" json:"payload"` // JWE in general JSON serialization
+}
+
+func (thread *MailboxThread) BeforeCreate(tx *gorm.DB) (err error) {
+	// UUID version 4
+	thread.ID = uuid.NewString()
+	return
+}
+
+func (message *MailboxMessage) BeforeCreate(tx *gorm.DB) (err error) {
+	// UUID version 4
+	message.ID = uuid.NewString()
+	return
+}
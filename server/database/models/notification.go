@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type EventList []string
+
+func (e *EventList) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*e = nil
+	case string:
+		*e = splitEventList(v)
+	case []byte:
+		*e = splitEventList(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into EventList", src)
+	}
+	return nil
+}
+
+func (e EventList) Value() (driver.Value, error) {
+	if len(e) == 0 {
+		return nil, nil
+	}
+	return strings.Join(e, ","), nil
+}
+
+func (e EventList) Contains(event string) bool {
+	for _, v := range e {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+func splitEventList(s string) EventList {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+type NotificationPreference struct {
+	UserID    string    `gorm:"primaryKey" json:"-"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Delivery    string    `json:"delivery"`                      // "immediate" or "digest"
+	MutedEvents EventList `json:"muted_events" gorm:"type:text"` // Events the user unsubscribed from
+}
+
+type Notification struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
+	CreatedAt time.Time `json:"created_at"`
+
+	UserID   string `json:"-" gorm:"index"`
+	Event    string `json:"event"`
+	Subject  string `json:"subject" gorm:"serializer:encrypted"` // Names the group and other members, like the data
+	Template string `json:"-"`
+	Data     string `json:"-" gorm:"type:text;serializer:encrypted"` // JSON encoded template data
+
+	Delivery string     `json:"delivery"`
+	SentAt   *time.Time `json:"sent_at"`                     // Nil until delivered, pending notifications are sent with the next digests
+	Attempts int        `json:"-" gorm:"not null;default:0"` // Failed deliveries of an immediate notification
+}
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) (err error) {
+	// UUID version 4
+	n.ID = uuid.NewString()
+	return
+}
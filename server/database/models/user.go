@@ -2,29 +2,66 @@ package models
 
 import (
 	"errors"
+	"onxzy/super-santa-server/database/encryption"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrGroupDoesNotExist    = errors.New("group does not exist")
+	ErrGroupAlreadyHasAdmin = errors.New("group already has an admin")
+)
+
 type User struct {
 	ID        string         `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"-"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
-	Username         string `json:"username" gorm:"uniqueIndex:idx_username_group"` // Username unique within group
-	Email            string `json:"email"`
+	Username         string `json:"username" gorm:"serializer:encrypted"` // Username unique within group
+	Email            string `json:"email" gorm:"serializer:encrypted"`
 	PasswordVerifier string `json:"-"` // Password verifier for SRP
 
-	GroupID string `json:"-" gorm:"uniqueIndex:idx_username_group"` // Foreign key to group
+	// A new email waits for its confirmation from the link sent to it,
+	// logins keep using Email until then
+	PendingEmail        string     `json:"-" gorm:"serializer:encrypted"`
+	EmailTokenHash      string     `json:"-" gorm:"size:64"` // Hex SHA-256 of the secret of the confirmation link
+	EmailTokenExpiresAt *time.Time `json:"-"`
+
+	// Blind indexes of the username and email, which may be encrypted, set by BeforeSave
+	UsernameIndex string `json:"-" gorm:"size:64;uniqueIndex:idx_username_index_group"`
+	EmailIndex    string `json:"-" gorm:"size:64;index:idx_email_index_group"`
+
+	GroupID string `json:"-" gorm:"uniqueIndex:idx_username_index_group;index:idx_email_index_group"` // Foreign key to group
 	IsAdmin bool   `json:"is_admin"`
 
+	Participates bool   `json:"participates"`                            // Members who don't participate are left out of the draw, like an organiser
+	Team         string `json:"team" gorm:"size:64;not null;default:''"` // Set by the admin, constrains the draw with the rule of the group
+
 	PublicKeySecret     string `json:"-"` // User public key encrypted with group secret
 	PrivateKeyEncrypted string `json:"-"` // Encrypted user private key with password
 
-	Wishes string `json:"wishes"`
+	// Key pair of the mailbox, apart from the key of the draw which must stay unknown
+	// to the other members. Empty for members who haven't logged in since it exists.
+	MessageKeySecret    string `json:"message_key_secret" gorm:"type:text"` // Public key encrypted with group secret, members encrypt messages to it
+	MessageKeyEncrypted string `json:"-" gorm:"type:text"`                  // Private key encrypted with password
+
+	WishItems []WishItem `json:"wishes" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"` // Preloaded by position
+}
+
+// ViewClaims sets the claim state of the wish items seen by viewerID
+func (u *User) ViewClaims(viewerID string) {
+	for i := range u.WishItems {
+		u.WishItems[i].ViewClaim(viewerID)
+	}
+}
+
+// SetBlindIndexes indexes the username and email with the primary key of keyring
+func (u *User) SetBlindIndexes(keyring *encryption.Keyring) {
+	u.UsernameIndex = keyring.BlindIndex(u.Username)
+	u.EmailIndex = keyring.BlindIndex(u.Email)
 }
 
 // BeforeCreate hook to generate UUID
@@ -38,14 +75,16 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 		return err
 	}
 	if count == 0 {
-		return errors.New("group does not exist")
+		return ErrGroupDoesNotExist
 	}
 
 	return
 }
 
-// BeforeSave hook to enforce one admin per group
+// BeforeSave hook to index the user and enforce one admin per group
 func (u *User) BeforeSave(tx *gorm.DB) error {
+	u.SetBlindIndexes(encryption.FromContext(tx.Statement.Context))
+
 	if u.IsAdmin {
 		var count int64
 		// Check if another admin exists for this group
@@ -53,7 +92,7 @@ func (u *User) BeforeSave(tx *gorm.DB) error {
 			return err
 		}
 		if count > 0 {
-			return errors.New("group already has an admin")
+			return ErrGroupAlreadyHasAdmin
 		}
 	}
 	return nil
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WishPriorityMax is the highest priority of a wish item, 0 being the lowest
+const WishPriorityMax = 3
+
+// WishItem is a gift idea of a user's wishlist
+type WishItem struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // ID is a UUID v4 string
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID string `gorm:"index" json:"-"`
+
+	Title    string `gorm:"type:text;serializer:encrypted" json:"title"`
+	URL      string `gorm:"type:text;serializer:encrypted" json:"url"`
+	Price    *int64 `json:"price"`                              // In minor units of Currency, e.g. cents
+	Currency string `gorm:"size:3" json:"currency"`             // ISO 4217 code, set along with Price
+	Priority int    `gorm:"not null;default:0" json:"priority"` // From 0 to WishPriorityMax
+	Notes    string `gorm:"type:text;serializer:encrypted" json:"notes"`
+	Position int    `gorm:"not null;default:0" json:"order"` // Items are listed by ascending position
+
+	// Title, URL and notes encrypted by the client under the group secret, opaque to the server.
+	// Set instead of them in groups with encrypted wishes.
+	Payload string `gorm:"type:text" json:"payload"`
+
+	// Member buying the item, never shown: members only see the claim state through ViewClaim
+	ClaimedByID *string    `gorm:"index" json:"-"`
+	ClaimedAt   *time.Time `json:"-"`
+
+	Claimed     bool `gorm:"-" json:"claimed"`       // Claimed by someone, always false for the owner
+	ClaimedByMe bool `gorm:"-" json:"claimed_by_me"` // Claimed by the viewer
+}
+
+// ViewClaim sets the claim state seen by viewerID, the owner of the item doesn't see it
+func (item *WishItem) ViewClaim(viewerID string) {
+	if item.UserID == viewerID {
+		item.Claimed, item.ClaimedByMe = false, false
+		return
+	}
+	item.Claimed = item.ClaimedByID != nil
+	item.ClaimedByMe = item.Claimed && *item.ClaimedByID == viewerID
+}
+
+func (item *WishItem) BeforeCreate(tx *gorm.DB) (err error) {
+	// UUID version 4
+	item.ID = uuid.NewString()
+	return
+}
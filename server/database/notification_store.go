@@ -0,0 +1,100 @@
+package database
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationStore struct {
+	db *DB
+}
+
+var (
+	ErrNotificationPreferenceNotFound = errors.New("notification preference not found")
+)
+
+func NewNotificationStore(db *DB) *NotificationStore {
+	return &NotificationStore{db: db}
+}
+
+// Preferences
+
+func (s *NotificationStore) GetPreference(userID string) (*models.NotificationPreference, error) {
+	var pref models.NotificationPreference
+	if err := s.db.gorm.Where("user_id = ?", userID).First(&pref).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotificationPreferenceNotFound
+		}
+		return nil, err
+	}
+	return &pref, nil
+}
+
+func (s *NotificationStore) SavePreference(pref *models.NotificationPreference) error {
+	return s.db.gorm.Save(pref).Error
+}
+
+// Notifications
+
+func (s *NotificationStore) CreateNotification(notification *models.Notification) error {
+	return s.db.gorm.Create(notification).Error
+}
+
+func (s *NotificationStore) GetPendingNotifications(delivery string) ([]models.Notification, error) {
+	var notifications []models.Notification
+	if err := s.db.gorm.Where("delivery = ? AND sent_at IS NULL", delivery).Order("created_at").Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// GetRetryableNotifications returns the pending notifications created after since
+// that failed fewer than maxAttempts times.
+func (s *NotificationStore) GetRetryableNotifications(delivery string, maxAttempts int, since time.Time) ([]models.Notification, error) {
+	var notifications []models.Notification
+	if err := s.db.gorm.Where("delivery = ? AND sent_at IS NULL AND attempts < ? AND created_at > ?", delivery, maxAttempts, since).Order("created_at").Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// RecordNotificationFailure counts a failed delivery of a notification
+func (s *NotificationStore) RecordNotificationFailure(id string) error {
+	return s.db.gorm.Model(&models.Notification{}).Where("id = ?", id).Update("attempts", gorm.Expr("attempts + 1")).Error
+}
+
+func (s *NotificationStore) GetUserNotifications(userID string) ([]models.Notification, error) {
+	var notifications []models.Notification
+	if err := s.db.gorm.Where("user_id = ?", userID).Order("created_at").Find(&notifications).Error; err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (s *NotificationStore) MarkNotificationsSent(ids []string, sentAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return s.db.gorm.Model(&models.Notification{}).Where("id IN ?", ids).Update("sent_at", sentAt).Error
+}
+
+// AnonymiseUserNotifications detaches the notification history from an erased user.
+// Pending notifications are dropped, sent ones only keep their event, delivery and dates.
+func (s *NotificationStore) AnonymiseUserNotifications(userID string) error {
+	return s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.NotificationPreference{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND sent_at IS NULL", userID).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Notification{}).Where("user_id = ?", userID).Updates(map[string]any{
+			"user_id": "",
+			"subject": "",
+			"data":    "",
+		}).Error
+	})
+}
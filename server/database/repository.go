@@ -0,0 +1,68 @@
+package database
+
+import "onxzy/super-santa-server/database/models"
+
+// GroupRepository is implemented by GroupStore and by the in-memory store used in tests
+type GroupRepository interface {
+	CreateGroup(group *models.Group) error
+	GetGroup(id string) (*models.Group, error)
+	UpdateGroup(group models.Group) error
+	DeleteGroup(id string) error
+	GetAllGroups() ([]models.Group, error)
+	LockGroup(id string) (*models.Group, error)
+	SetGroupState(id string, state models.GroupState) error
+	SaveDrawResults(groupID string, results []models.DrawResult) (round int, err error)
+	GetDrawResults(groupID string, round int) ([]models.DrawResult, error)
+}
+
+// UserRepository is implemented by UserStore and by the in-memory store used in tests
+type UserRepository interface {
+	CreateUser(user *models.User) error
+	GetUser(id string) (*models.User, error)
+	GetGroupUserByEmail(groupID string, email string) (*models.User, error)
+	GetGroupUsers(groupID string) ([]models.User, error)
+	UpdateUser(user *models.User) error
+	DeleteUser(id string) error
+}
+
+// WishRepository is implemented by WishStore and by the in-memory store used in tests
+type WishRepository interface {
+	GetUserWishItems(userID string) ([]models.WishItem, error)
+	GetWishItem(id string) (*models.WishItem, error)
+	CreateWishItem(item *models.WishItem) error
+	UpdateWishItem(item *models.WishItem) error
+	DeleteWishItem(id string) error
+	SetWishItemClaim(id string, claimedByID *string) error
+}
+
+// MailboxRepository is implemented by MailboxStore and by the in-memory store used in tests
+type MailboxRepository interface {
+	CreateThread(thread *models.MailboxThread) error
+	GetThread(id string) (*models.MailboxThread, error)
+	GetRecipientThreads(recipientID string) ([]models.MailboxThread, error)
+	GetRoundThreads(groupID string, round int) ([]models.MailboxThread, error)
+	AddMessage(message *models.MailboxMessage) error
+	SetGiftStatus(threadID string, status models.GiftStatus) error
+	SetAddress(threadID string, addressKey string, address string) error
+}
+
+// Repositories gives access to the repositories bound to a unit of work
+type Repositories interface {
+	Groups() GroupRepository
+	Users() UserRepository
+	Wishes() WishRepository
+	Mailbox() MailboxRepository
+}
+
+// UnitOfWork runs multi-step operations atomically. Operations on a group
+// should start with LockGroup so that they are serialized with each other.
+type UnitOfWork interface {
+	Do(fn func(repos Repositories) error) error
+}
+
+var (
+	_ GroupRepository   = (*GroupStore)(nil)
+	_ UserRepository    = (*UserStore)(nil)
+	_ WishRepository    = (*WishStore)(nil)
+	_ MailboxRepository = (*MailboxStore)(nil)
+)
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"onxzy/super-santa-server/database/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetentionStore finds and hard-deletes the rows which outlived the retention policy
+type RetentionStore struct {
+	db *DB
+}
+
+func NewRetentionStore(db *DB) *RetentionStore {
+	return &RetentionStore{db: db}
+}
+
+// GetGroupsExchangedBefore returns the live groups, with their users, whose exchange date is before cutoff
+func (s *RetentionStore) GetGroupsExchangedBefore(cutoff time.Time) ([]models.Group, error) {
+	var groups []models.Group
+	if err := s.db.gorm.Preload("Users").Where("exchange_date < ?", cutoff).Order("exchange_date").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// GetGroupsDeletedBefore returns the soft-deleted groups deleted before cutoff
+func (s *RetentionStore) GetGroupsDeletedBefore(cutoff time.Time) ([]models.Group, error) {
+	var groups []models.Group
+	if err := s.db.gorm.Unscoped().Where("deleted_at < ?", cutoff).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// GetUsersDeletedBefore returns the soft-deleted users deleted before cutoff
+func (s *RetentionStore) GetUsersDeletedBefore(cutoff time.Time) ([]models.User, error) {
+	var users []models.User
+	if err := s.db.gorm.Unscoped().Where("deleted_at < ?", cutoff).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (s *RetentionStore) MarkPurgeWarned(groupID string, at time.Time) error {
+	return s.db.gorm.Model(&models.Group{}).Where("id = ?", groupID).UpdateColumn("purge_warned_at", at).Error
+}
+
+// PurgeGroup hard-deletes a group, soft-deleted or not, with its users, their wish items, draw results, mailbox and notifications
+func (s *RetentionStore) PurgeGroup(groupID string) error {
+	return s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		users := tx.Unscoped().Model(&models.User{}).Select("id").Where("group_id = ?", groupID)
+		if err := tx.Where("user_id IN (?)", users).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", users).Delete(&models.NotificationPreference{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id IN (?)", users).Delete(&models.WishItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("group_id = ?", groupID).Delete(&models.User{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", groupID).Delete(&models.DrawResult{}).Error; err != nil {
+			return err
+		}
+		threads := tx.Model(&models.MailboxThread{}).Select("id").Where("group_id = ?", groupID)
+		if err := tx.Where("thread_id IN (?)", threads).Delete(&models.MailboxMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", groupID).Delete(&models.MailboxThread{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", groupID).Delete(&models.Group{}).Error
+	})
+}
+
+// PurgeUser hard-deletes a user with their wish items, the threads addressed to them and notifications
+func (s *RetentionStore) PurgeUser(userID string) error {
+	return s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.Notification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.NotificationPreference{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.WishItem{}).Error; err != nil {
+			return err
+		}
+		if err := releaseClaims(tx, userID); err != nil {
+			return err
+		}
+		if err := deleteRecipientThreads(tx, userID); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id = ?", userID).Delete(&models.User{}).Error
+	})
+}
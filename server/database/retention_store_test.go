@@ -0,0 +1,68 @@
+package database_test
+
+import (
+	"io"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"testing"
+	"time"
+)
+
+func TestRetentionStore(t *testing.T) {
+	db := openSQLite(t)
+	groups := database.NewGroupStore(db)
+	retention := database.NewRetentionStore(db)
+
+	exchanged := time.Now().AddDate(0, -2, 0)
+	old := &models.Group{
+		Name:         "Last year",
+		ExchangeDate: &exchanged,
+		Users:        []models.User{{Username: "santa", Email: "santa@example.com", IsAdmin: true}},
+	}
+	current := &models.Group{Name: "This year", Users: []models.User{{Username: "elf", Email: "elf@example.com", IsAdmin: true}}}
+	for _, group := range []*models.Group{old, current} {
+		if err := groups.CreateGroup(group); err != nil {
+			t.Fatalf("CreateGroup: %v", err)
+		}
+	}
+	if _, err := groups.SaveDrawResults(old.ID, []models.DrawResult{{Payload: "for a"}}); err != nil {
+		t.Fatalf("SaveDrawResults: %v", err)
+	}
+	if err := database.NewNotificationStore(db).CreateNotification(&models.Notification{UserID: old.Users[0].ID, Event: "welcome"}); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+
+	found, err := retention.GetGroupsExchangedBefore(time.Now().AddDate(0, -1, 0))
+	if err != nil {
+		t.Fatalf("GetGroupsExchangedBefore: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != old.ID || len(found[0].Users) != 1 {
+		t.Fatalf("expected the old group with its users, got %+v", found)
+	}
+
+	if err := retention.PurgeGroup(old.ID); err != nil {
+		t.Fatalf("PurgeGroup: %v", err)
+	}
+	data, err := db.Export(io.Discard)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(data.Groups) != 1 || data.Groups[0].ID != current.ID || len(data.Users) != 1 ||
+		len(data.DrawResults) != 0 || len(data.Notifications) != 0 {
+		t.Fatalf("expected only the current group to remain, got %+v", data)
+	}
+
+	if err := groups.DeleteGroup(current.ID); err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+	deleted, err := retention.GetGroupsDeletedBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetGroupsDeletedBefore: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != current.ID {
+		t.Fatalf("expected the soft-deleted group, got %+v", deleted)
+	}
+	if deleted, err := retention.GetGroupsDeletedBefore(time.Now().Add(-time.Minute)); err != nil || len(deleted) != 0 {
+		t.Fatalf("expected no group deleted before a minute ago, got %+v, %v", deleted, err)
+	}
+}
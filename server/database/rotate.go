@@ -0,0 +1,67 @@
+package database
+
+import (
+	"onxzy/super-santa-server/database/models"
+
+	"gorm.io/gorm"
+)
+
+// RotateEncryption re-encrypts the user, wish item and notification columns with
+// the primary key and re-indexes the users, in a single transaction. Plaintext rows
+// are encrypted, so it also encrypts an existing database once keys are configured.
+// Every key the rows are encrypted with must still be in the keyring.
+func (db *DB) RotateEncryption() (users int, wishItems int, notifications int, err error) {
+	if err := db.CheckSchema(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	keyring := db.keyring()
+	err = db.gorm.Transaction(func(tx *gorm.DB) error {
+		var userRows []models.User
+		err := tx.Unscoped().FindInBatches(&userRows, 100, func(_ *gorm.DB, _ int) error {
+			for i := range userRows {
+				userRows[i].SetBlindIndexes(keyring)
+				// Columns are updated without hooks so that the rows keep their update time
+				if err := tx.Unscoped().Model(&userRows[i]).
+					Select("username", "email", "pending_email", "username_index", "email_index").
+					UpdateColumns(&userRows[i]).Error; err != nil {
+					return err
+				}
+			}
+			users += len(userRows)
+			return nil
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var itemRows []models.WishItem
+		err = tx.FindInBatches(&itemRows, 100, func(_ *gorm.DB, _ int) error {
+			for i := range itemRows {
+				if err := tx.Model(&itemRows[i]).Select("title", "url", "notes").UpdateColumns(&itemRows[i]).Error; err != nil {
+					return err
+				}
+			}
+			wishItems += len(itemRows)
+			return nil
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var notificationRows []models.Notification
+		return tx.FindInBatches(&notificationRows, 100, func(_ *gorm.DB, _ int) error {
+			for i := range notificationRows {
+				if err := tx.Model(&notificationRows[i]).Select("subject", "data").UpdateColumns(&notificationRows[i]).Error; err != nil {
+					return err
+				}
+			}
+			notifications += len(notificationRows)
+			return nil
+		}).Error
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return users, wishItems, notifications, nil
+}
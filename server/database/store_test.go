@@ -0,0 +1,56 @@
+package database_test
+
+import (
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/storetest"
+	"onxzy/super-santa-server/utils"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func openSQLite(t *testing.T) *database.DB {
+	config := &utils.Config{}
+	config.DB.Driver = database.DriverSQLite
+	config.DB.SQLitePath = filepath.Join(t.TempDir(), "test.db")
+
+	db, err := database.OpenDB(zap.NewNop(), config)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	return db
+}
+
+func TestSQLiteStores(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) (database.GroupRepository, database.UserRepository) {
+		db := openSQLite(t)
+		return database.NewGroupStore(db), database.NewUserStore(db)
+	})
+}
+
+func TestSQLiteWishStore(t *testing.T) {
+	storetest.RunWishes(t, func(t *testing.T) (database.GroupRepository, database.UserRepository, database.WishRepository) {
+		db := openSQLite(t)
+		return database.NewGroupStore(db), database.NewUserStore(db), database.NewWishStore(db)
+	})
+}
+
+func TestSQLiteMailboxStore(t *testing.T) {
+	storetest.RunMailbox(t, func(t *testing.T) (database.GroupRepository, database.UserRepository, database.MailboxRepository) {
+		db := openSQLite(t)
+		return database.NewGroupStore(db), database.NewUserStore(db), database.NewMailboxStore(db)
+	})
+}
+
+func TestSQLiteUnitOfWork(t *testing.T) {
+	storetest.RunUnitOfWork(t, func(t *testing.T) (database.UnitOfWork, database.GroupRepository, database.UserRepository) {
+		db := openSQLite(t)
+		return database.NewTransactionManager(db), database.NewGroupStore(db), database.NewUserStore(db)
+	})
+}
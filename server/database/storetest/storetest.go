@@ -0,0 +1,986 @@
+// Package storetest holds the contract shared by every repository implementation
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns empty repositories, both views of the same storage
+type Factory func(t *testing.T) (database.GroupRepository, database.UserRepository)
+
+// UnitOfWorkFactory returns an empty unit of work along with repositories outside of it
+type UnitOfWorkFactory func(t *testing.T) (database.UnitOfWork, database.GroupRepository, database.UserRepository)
+
+// Run checks the repositories returned by newRepositories against the contract
+func Run(t *testing.T, newRepositories Factory) {
+	t.Run("CreateGroup", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		if group.ID == "" || group.Users[0].ID == "" {
+			t.Fatal("expected IDs to be generated")
+		}
+		if group.Users[0].GroupID != group.ID {
+			t.Fatalf("expected admin group ID %q, got %q", group.ID, group.Users[0].GroupID)
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if got.Name != "North Pole" {
+			t.Errorf("expected name %q, got %q", "North Pole", got.Name)
+		}
+		if got.State != models.GroupStateOpen {
+			t.Errorf("expected state %q, got %q", models.GroupStateOpen, got.State)
+		}
+		if got.GiftsPerPerson != 1 {
+			t.Errorf("expected one gift per person by default, got %d", got.GiftsPerPerson)
+		}
+		if len(got.Users) != 1 || !got.Users[0].IsAdmin {
+			t.Errorf("expected the admin to be preloaded, got %+v", got.Users)
+		}
+	})
+
+	t.Run("GetGroupNotFound", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		if _, err := groups.GetGroup("missing"); !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UpdateGroup", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		exchangeDate := time.Date(2025, time.December, 24, 0, 0, 0, 0, time.UTC)
+
+		group.Name = "South Pole"
+		group.ExchangeDate = &exchangeDate
+		group.GiftsPerPerson = 2
+		group.DrawRule = models.DrawRuleOutsideTeam
+		group.Users = nil
+		if err := groups.UpdateGroup(*group); err != nil {
+			t.Fatalf("UpdateGroup: %v", err)
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if got.Name != "South Pole" || got.ExchangeDate == nil || !got.ExchangeDate.Equal(exchangeDate) {
+			t.Errorf("expected updated name and exchange date, got %q %v", got.Name, got.ExchangeDate)
+		}
+		if got.GiftsPerPerson != 2 {
+			t.Errorf("expected 2 gifts per person, got %d", got.GiftsPerPerson)
+		}
+		if got.DrawRule != models.DrawRuleOutsideTeam {
+			t.Errorf("expected the outside team rule, got %q", got.DrawRule)
+		}
+		if len(got.Users) != 1 {
+			t.Errorf("expected users to be left untouched, got %d", len(got.Users))
+		}
+	})
+
+	t.Run("SetGroupState", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		if err := groups.SetGroupState(group.ID, models.GroupStateDrawing); err != nil {
+			t.Fatalf("SetGroupState: %v", err)
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if got.State != models.GroupStateDrawing {
+			t.Errorf("expected state %q, got %q", models.GroupStateDrawing, got.State)
+		}
+
+		if err := groups.SetGroupState("missing", models.GroupStateDrawing); !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+	})
+
+	t.Run("UpdateGroupKeepsDrawState", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		if _, err := groups.SaveDrawResults(group.ID, []models.DrawResult{{Payload: "for a"}}); err != nil {
+			t.Fatalf("SaveDrawResults: %v", err)
+		}
+		if err := groups.SetGroupState(group.ID, models.GroupStateDrawn); err != nil {
+			t.Fatalf("SetGroupState: %v", err)
+		}
+
+		// group is a copy from before the draw
+		if err := groups.UpdateGroup(*group); err != nil {
+			t.Fatalf("UpdateGroup: %v", err)
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if got.DrawRound != 1 || got.State != models.GroupStateDrawn {
+			t.Errorf("expected a stale update to keep the draw state, got round %d and state %q", got.DrawRound, got.State)
+		}
+	})
+
+	t.Run("LockGroup", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		first, err := groups.LockGroup(group.ID)
+		if err != nil {
+			t.Fatalf("LockGroup: %v", err)
+		}
+		if len(first.Users) != 1 {
+			t.Errorf("expected users to be loaded, got %d", len(first.Users))
+		}
+
+		second, err := groups.LockGroup(group.ID)
+		if err != nil {
+			t.Fatalf("LockGroup: %v", err)
+		}
+		if second.Version != first.Version+1 {
+			t.Errorf("expected version to be bumped from %d, got %d", first.Version, second.Version)
+		}
+
+		if _, err := groups.LockGroup("missing"); !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteGroup", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		kept := createGroup(t, groups, "Kept")
+		deleted := createGroup(t, groups, "Deleted")
+
+		if err := groups.DeleteGroup(deleted.ID); err != nil {
+			t.Fatalf("DeleteGroup: %v", err)
+		}
+		if _, err := groups.GetGroup(deleted.ID); !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+
+		all, err := groups.GetAllGroups()
+		if err != nil {
+			t.Fatalf("GetAllGroups: %v", err)
+		}
+		if len(all) != 1 || all[0].ID != kept.ID {
+			t.Errorf("expected only the kept group, got %+v", all)
+		}
+	})
+
+	t.Run("SaveDrawResults", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		round, err := groups.SaveDrawResults(group.ID, []models.DrawResult{
+			{Payload: "for a"},
+			{Payload: "for b"},
+		})
+		if err != nil {
+			t.Fatalf("SaveDrawResults: %v", err)
+		}
+		if round != 1 {
+			t.Errorf("expected round 1, got %d", round)
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if !got.IsDrawn() || got.DrawRound != 1 {
+			t.Errorf("expected group to be drawn at round 1, got %d", got.DrawRound)
+		}
+
+		round, err = groups.SaveDrawResults(group.ID, []models.DrawResult{{Payload: "for a again"}})
+		if err != nil {
+			t.Fatalf("SaveDrawResults: %v", err)
+		}
+		if round != 2 {
+			t.Errorf("expected round 2, got %d", round)
+		}
+
+		if _, err := groups.SaveDrawResults("missing", []models.DrawResult{{Payload: "for a"}}); !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+	})
+
+	t.Run("GetDrawResults", func(t *testing.T) {
+		groups, _ := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		if _, err := groups.SaveDrawResults(group.ID, []models.DrawResult{
+			{RecipientID: "b", ThreadTokenHash: "hash of a", Payload: "for a"},
+			{RecipientID: "a", ThreadTokenHash: "hash of b", Payload: "for b"},
+		}); err != nil {
+			t.Fatalf("SaveDrawResults: %v", err)
+		}
+
+		results, err := groups.GetDrawResults(group.ID, 1)
+		if err != nil {
+			t.Fatalf("GetDrawResults: %v", err)
+		}
+		if len(results) != 2 || results[0].Round != 1 || results[1].Round != 1 {
+			t.Errorf("expected both results of the round, got %+v", results)
+		}
+		hashes := map[string]string{"for a": "hash of a", "for b": "hash of b"}
+		for _, result := range results {
+			if result.RecipientID == "" || result.ThreadTokenHash != hashes[result.Payload] {
+				t.Errorf("expected the recipient and thread token hash to be stored, got %+v", result)
+			}
+		}
+
+		results, err = groups.GetDrawResults(group.ID, 2)
+		if err != nil {
+			t.Fatalf("GetDrawResults: %v", err)
+		}
+		if len(results) != 0 {
+			t.Errorf("expected no result for another round, got %+v", results)
+		}
+	})
+
+	t.Run("CreateUser", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		user := createUser(t, users, group.ID, "rudolph")
+		if user.ID == "" {
+			t.Fatal("expected ID to be generated")
+		}
+
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if len(got.Users) != 2 {
+			t.Errorf("expected 2 users, got %d", len(got.Users))
+		}
+	})
+
+	t.Run("CreateUserGroupNotFound", func(t *testing.T) {
+		_, users := newRepositories(t)
+		err := users.CreateUser(&models.User{Username: "rudolph", GroupID: "missing"})
+		if !errors.Is(err, database.ErrGroupNotFound) {
+			t.Fatalf("expected ErrGroupNotFound, got %v", err)
+		}
+	})
+
+	t.Run("CreateUserDuplicateUsername", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		other := createGroup(t, groups, "South Pole")
+
+		createUser(t, users, group.ID, "rudolph")
+		err := users.CreateUser(&models.User{Username: "rudolph", Email: "other@example.com", GroupID: group.ID})
+		if !errors.Is(err, database.ErrUserAlreadyExists) {
+			t.Fatalf("expected ErrUserAlreadyExists, got %v", err)
+		}
+
+		// Usernames are only unique within a group
+		createUser(t, users, other.ID, "rudolph")
+	})
+
+	t.Run("CreateUserDuplicateEmail", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		other := createGroup(t, groups, "South Pole")
+
+		createUser(t, users, group.ID, "rudolph")
+		err := users.CreateUser(&models.User{Username: "dasher", Email: "rudolph@example.com", GroupID: group.ID})
+		if !errors.Is(err, database.ErrEmailAlreadyUsed) {
+			t.Fatalf("expected ErrEmailAlreadyUsed, got %v", err)
+		}
+
+		// Emails are only unique within a group
+		createUser(t, users, other.ID, "rudolph")
+	})
+
+	t.Run("CreateUserSecondAdmin", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+
+		err := users.CreateUser(&models.User{Username: "rudolph", GroupID: group.ID, IsAdmin: true})
+		if !errors.Is(err, models.ErrGroupAlreadyHasAdmin) {
+			t.Fatalf("expected ErrGroupAlreadyHasAdmin, got %v", err)
+		}
+	})
+
+	t.Run("GetUser", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		got, err := users.GetUser(user.ID)
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if got.Username != "rudolph" || got.GroupID != group.ID {
+			t.Errorf("unexpected user %+v", got)
+		}
+
+		if _, err := users.GetUser("missing"); !errors.Is(err, database.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("GetGroupUserByEmail", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		other := createGroup(t, groups, "South Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		got, err := users.GetGroupUserByEmail(group.ID, user.Email)
+		if err != nil {
+			t.Fatalf("GetGroupUserByEmail: %v", err)
+		}
+		if got.ID != user.ID {
+			t.Errorf("expected user %q, got %q", user.ID, got.ID)
+		}
+
+		if _, err := users.GetGroupUserByEmail(other.ID, user.Email); !errors.Is(err, database.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound in another group, got %v", err)
+		}
+	})
+
+	t.Run("GetGroupUsers", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		createUser(t, users, group.ID, "rudolph")
+		createUser(t, users, group.ID, "dasher")
+
+		got, err := users.GetGroupUsers(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroupUsers: %v", err)
+		}
+		if len(got) != 3 {
+			t.Errorf("expected 3 users, got %d", len(got))
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		user.Email = "red.nose@example.com"
+		user.Participates = false
+		user.Team = "Reindeers"
+		if err := users.UpdateUser(user); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+
+		got, err := users.GetUser(user.ID)
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if got.Email != "red.nose@example.com" {
+			t.Errorf("expected email to be updated, got %q", got.Email)
+		}
+		if got.Participates {
+			t.Errorf("expected the user to no longer participate")
+		}
+		if got.Team != "Reindeers" {
+			t.Errorf("expected the team to be updated, got %q", got.Team)
+		}
+	})
+
+	t.Run("UpdateUserDuplicateUsername", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		createUser(t, users, group.ID, "dasher")
+
+		user.Username = "dasher"
+		if err := users.UpdateUser(user); !errors.Is(err, database.ErrUserAlreadyExists) {
+			t.Fatalf("expected ErrUserAlreadyExists, got %v", err)
+		}
+	})
+
+	t.Run("UpdateUserDuplicateEmail", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		createUser(t, users, group.ID, "dasher")
+
+		user.Email = "dasher@example.com"
+		if err := users.UpdateUser(user); !errors.Is(err, database.ErrEmailAlreadyUsed) {
+			t.Fatalf("expected ErrEmailAlreadyUsed, got %v", err)
+		}
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		groups, users := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		if err := users.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := users.GetUser(user.ID); !errors.Is(err, database.ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+
+		// The username is free again
+		createUser(t, users, group.ID, "rudolph")
+	})
+}
+
+// WishFactory returns empty repositories, all views of the same storage
+type WishFactory func(t *testing.T) (database.GroupRepository, database.UserRepository, database.WishRepository)
+
+// RunWishes checks the wish repository returned by newRepositories against the contract
+func RunWishes(t *testing.T, newRepositories WishFactory) {
+	t.Run("CreateWishItem", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		price := int64(2500)
+
+		item := &models.WishItem{UserID: user.ID, Title: "A red nose", URL: "https://example.com/nose", Price: &price, Currency: "EUR", Priority: 3}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+		if item.ID == "" {
+			t.Fatal("expected the ID to be generated")
+		}
+
+		got, err := wishes.GetWishItem(item.ID)
+		if err != nil {
+			t.Fatalf("GetWishItem: %v", err)
+		}
+		if got.Title != "A red nose" || got.Price == nil || *got.Price != price || got.Currency != "EUR" || got.Priority != 3 {
+			t.Errorf("expected the stored item, got %+v", got)
+		}
+	})
+
+	t.Run("EncryptedWishItem", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := &models.Group{Name: "North Pole", SecretVerifier: "verifier.salt", EncryptedWishes: true}
+		if err := groups.CreateGroup(group); err != nil {
+			t.Fatalf("CreateGroup: %v", err)
+		}
+		user := createUser(t, users, group.ID, "rudolph")
+
+		item := &models.WishItem{UserID: user.ID, Payload: "iv.key.iv.ciphertext"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+
+		stored, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if !stored.EncryptedWishes {
+			t.Error("expected the group to keep encrypted wishes")
+		}
+		got, err := wishes.GetWishItem(item.ID)
+		if err != nil {
+			t.Fatalf("GetWishItem: %v", err)
+		}
+		if got.Payload != "iv.key.iv.ciphertext" || got.Title != "" {
+			t.Errorf("expected the payload to be stored as is, got %+v", got)
+		}
+	})
+
+	t.Run("GetWishItemNotFound", func(t *testing.T) {
+		_, _, wishes := newRepositories(t)
+		if _, err := wishes.GetWishItem("missing"); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected ErrWishItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("WishItemsOrder", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		for _, item := range []models.WishItem{{Title: "Second", Position: 1}, {Title: "First", Position: 0}, {Title: "Third", Position: 1}} {
+			item.UserID = user.ID
+			if err := wishes.CreateWishItem(&item); err != nil {
+				t.Fatalf("CreateWishItem: %v", err)
+			}
+			time.Sleep(time.Millisecond) // Items at the same position are sorted by creation time
+		}
+
+		items, err := wishes.GetUserWishItems(user.ID)
+		if err != nil {
+			t.Fatalf("GetUserWishItems: %v", err)
+		}
+		if titles := wishTitles(items); titles != "First,Second,Third" {
+			t.Errorf("expected items by position, got %s", titles)
+		}
+
+		// Users come with their items, in the same order
+		got, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		for _, member := range got.Users {
+			if member.ID == user.ID && wishTitles(member.WishItems) != "First,Second,Third" {
+				t.Errorf("expected the group to preload the items, got %s", wishTitles(member.WishItems))
+			}
+		}
+		gotUser, err := users.GetUser(user.ID)
+		if err != nil {
+			t.Fatalf("GetUser: %v", err)
+		}
+		if titles := wishTitles(gotUser.WishItems); titles != "First,Second,Third" {
+			t.Errorf("expected the user to preload the items, got %s", titles)
+		}
+
+		// Updating the user leaves the items alone
+		gotUser.WishItems = gotUser.WishItems[:1]
+		if err := users.UpdateUser(gotUser); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		if items, _ := wishes.GetUserWishItems(user.ID); len(items) != 3 {
+			t.Errorf("expected 3 items after updating the user, got %d", len(items))
+		}
+	})
+
+	t.Run("UpdateWishItem", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		item := &models.WishItem{UserID: user.ID, Title: "A red nose", Notes: "Shiny"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+
+		// Every column is replaced, including with zero values
+		updated := &models.WishItem{ID: item.ID, UserID: user.ID, Title: "A brighter nose", Position: 2}
+		if err := wishes.UpdateWishItem(updated); err != nil {
+			t.Fatalf("UpdateWishItem: %v", err)
+		}
+
+		got, err := wishes.GetWishItem(item.ID)
+		if err != nil {
+			t.Fatalf("GetWishItem: %v", err)
+		}
+		if got.Title != "A brighter nose" || got.Notes != "" || got.Position != 2 || got.UserID != user.ID {
+			t.Errorf("expected the item to be replaced, got %+v", got)
+		}
+
+		if err := wishes.UpdateWishItem(&models.WishItem{ID: "missing", Title: "Nothing"}); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected ErrWishItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteWishItem", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		item := &models.WishItem{UserID: user.ID, Title: "A red nose"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+
+		if err := wishes.DeleteWishItem(item.ID); err != nil {
+			t.Fatalf("DeleteWishItem: %v", err)
+		}
+		if _, err := wishes.GetWishItem(item.ID); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected ErrWishItemNotFound, got %v", err)
+		}
+		if err := wishes.DeleteWishItem(item.ID); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected ErrWishItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetWishItemClaim", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		item := &models.WishItem{UserID: group.Users[0].ID, Title: "A sleigh"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+
+		if err := wishes.SetWishItemClaim(item.ID, &user.ID); err != nil {
+			t.Fatalf("SetWishItemClaim: %v", err)
+		}
+		got, err := wishes.GetWishItem(item.ID)
+		if err != nil {
+			t.Fatalf("GetWishItem: %v", err)
+		}
+		if got.ClaimedByID == nil || *got.ClaimedByID != user.ID || got.ClaimedAt == nil {
+			t.Fatalf("expected the item to be claimed by %q, got %v", user.ID, got.ClaimedByID)
+		}
+		if !got.UpdatedAt.Equal(item.UpdatedAt) {
+			t.Errorf("expected the claim to leave the update time alone, got %v instead of %v", got.UpdatedAt, item.UpdatedAt)
+		}
+
+		// Updating the item keeps the claim
+		if err := wishes.UpdateWishItem(&models.WishItem{ID: item.ID, UserID: item.UserID, Title: "A faster sleigh"}); err != nil {
+			t.Fatalf("UpdateWishItem: %v", err)
+		}
+		if got, _ := wishes.GetWishItem(item.ID); got.ClaimedByID == nil {
+			t.Error("expected the claim to be kept by UpdateWishItem")
+		}
+
+		if err := wishes.SetWishItemClaim(item.ID, nil); err != nil {
+			t.Fatalf("SetWishItemClaim: %v", err)
+		}
+		if got, _ := wishes.GetWishItem(item.ID); got.ClaimedByID != nil || got.ClaimedAt != nil {
+			t.Errorf("expected the claim to be released, got %v", got.ClaimedByID)
+		}
+
+		if err := wishes.SetWishItemClaim("missing", &user.ID); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected ErrWishItemNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteUserReleasesClaims", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		item := &models.WishItem{UserID: group.Users[0].ID, Title: "A sleigh"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+		if err := wishes.SetWishItemClaim(item.ID, &user.ID); err != nil {
+			t.Fatalf("SetWishItemClaim: %v", err)
+		}
+
+		if err := users.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		got, err := wishes.GetWishItem(item.ID)
+		if err != nil {
+			t.Fatalf("GetWishItem: %v", err)
+		}
+		if got.ClaimedByID != nil {
+			t.Errorf("expected the claim of the deleted user to be released, got %v", *got.ClaimedByID)
+		}
+	})
+
+	t.Run("DeleteUserDeletesWishItems", func(t *testing.T) {
+		groups, users, wishes := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		item := &models.WishItem{UserID: user.ID, Title: "A red nose"}
+		if err := wishes.CreateWishItem(item); err != nil {
+			t.Fatalf("CreateWishItem: %v", err)
+		}
+
+		if err := users.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := wishes.GetWishItem(item.ID); !errors.Is(err, database.ErrWishItemNotFound) {
+			t.Fatalf("expected the item to be deleted with its user, got %v", err)
+		}
+	})
+}
+
+// MailboxFactory returns empty repositories, all views of the same storage
+type MailboxFactory func(t *testing.T) (database.GroupRepository, database.UserRepository, database.MailboxRepository)
+
+// RunMailbox checks the mailbox repository returned by newRepositories against the contract
+func RunMailbox(t *testing.T, newRepositories MailboxFactory) {
+	t.Run("CreateThread", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+
+		resultID := "result"
+		thread := &models.MailboxThread{
+			GroupID: group.ID, Round: 1, RecipientID: user.ID, ResultID: &resultID, ReplyKey: "reply", SenderKey: "sender", SenderTokenHash: "hash",
+			Messages: []models.MailboxMessage{{FromSanta: true, Payload: "What size?"}},
+		}
+		if err := mailbox.CreateThread(thread); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+		if thread.ID == "" || thread.Messages[0].ID == "" {
+			t.Fatal("expected the IDs to be generated")
+		}
+
+		got, err := mailbox.GetThread(thread.ID)
+		if err != nil {
+			t.Fatalf("GetThread: %v", err)
+		}
+		if got.RecipientID != user.ID || got.ResultID == nil || *got.ResultID != resultID || got.SenderTokenHash != "hash" || len(got.Messages) != 1 || got.Messages[0].Payload != "What size?" || !got.Messages[0].FromSanta {
+			t.Errorf("expected the stored thread, got %+v", got)
+		}
+	})
+
+	t.Run("GetThreadNotFound", func(t *testing.T) {
+		_, _, mailbox := newRepositories(t)
+		if _, err := mailbox.GetThread("missing"); !errors.Is(err, database.ErrThreadNotFound) {
+			t.Fatalf("expected ErrThreadNotFound, got %v", err)
+		}
+	})
+
+	t.Run("AddMessage", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		thread := &models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: user.ID, Messages: []models.MailboxMessage{{FromSanta: true, Payload: "first"}}}
+		if err := mailbox.CreateThread(thread); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		if err := mailbox.AddMessage(&models.MailboxMessage{ThreadID: thread.ID, Payload: "second"}); err != nil {
+			t.Fatalf("AddMessage: %v", err)
+		}
+		got, err := mailbox.GetThread(thread.ID)
+		if err != nil {
+			t.Fatalf("GetThread: %v", err)
+		}
+		if len(got.Messages) != 2 || got.Messages[0].Payload != "first" || got.Messages[1].Payload != "second" || got.Messages[1].FromSanta {
+			t.Errorf("expected the messages in order, got %+v", got.Messages)
+		}
+		if !got.UpdatedAt.After(thread.UpdatedAt) {
+			t.Errorf("expected the thread update time to follow the latest message")
+		}
+
+		if err := mailbox.AddMessage(&models.MailboxMessage{ThreadID: "missing", Payload: "lost"}); !errors.Is(err, database.ErrThreadNotFound) {
+			t.Fatalf("expected ErrThreadNotFound, got %v", err)
+		}
+	})
+
+	t.Run("GetRecipientThreads", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		for _, recipientID := range []string{user.ID, group.Users[0].ID, user.ID} {
+			if err := mailbox.CreateThread(&models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: recipientID}); err != nil {
+				t.Fatalf("CreateThread: %v", err)
+			}
+		}
+
+		threads, err := mailbox.GetRecipientThreads(user.ID)
+		if err != nil {
+			t.Fatalf("GetRecipientThreads: %v", err)
+		}
+		if len(threads) != 2 || threads[0].RecipientID != user.ID || threads[1].RecipientID != user.ID {
+			t.Errorf("expected the two threads addressed to the user, got %+v", threads)
+		}
+	})
+
+	t.Run("SetGiftStatus", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		thread := &models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: user.ID}
+		if err := mailbox.CreateThread(thread); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+		if err := mailbox.CreateThread(&models.MailboxThread{GroupID: group.ID, Round: 2, RecipientID: user.ID}); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+
+		if err := mailbox.SetGiftStatus(thread.ID, models.GiftStatusShipped); err != nil {
+			t.Fatalf("SetGiftStatus: %v", err)
+		}
+		threads, err := mailbox.GetRoundThreads(group.ID, 1)
+		if err != nil {
+			t.Fatalf("GetRoundThreads: %v", err)
+		}
+		if len(threads) != 1 || threads[0].GiftStatus != models.GiftStatusShipped || threads[0].GiftStatusAt == nil {
+			t.Errorf("expected the shipped thread of the round, got %+v", threads)
+		}
+
+		if err := mailbox.SetGiftStatus("missing", models.GiftStatusBought); !errors.Is(err, database.ErrThreadNotFound) {
+			t.Fatalf("expected ErrThreadNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetAddress", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		thread := &models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: user.ID}
+		if err := mailbox.CreateThread(thread); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+
+		if err := mailbox.SetAddress(thread.ID, "sealed key", "sealed address"); err != nil {
+			t.Fatalf("SetAddress: %v", err)
+		}
+		got, err := mailbox.GetThread(thread.ID)
+		if err != nil {
+			t.Fatalf("GetThread: %v", err)
+		}
+		if got.AddressKey != "sealed key" || got.Address != "sealed address" {
+			t.Errorf("expected the address to be updated, got %q and %q", got.AddressKey, got.Address)
+		}
+
+		if err := mailbox.SetAddress("missing", "sealed key", ""); !errors.Is(err, database.ErrThreadNotFound) {
+			t.Fatalf("expected ErrThreadNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeleteUserDeletesThreads", func(t *testing.T) {
+		groups, users, mailbox := newRepositories(t)
+		group := createGroup(t, groups, "North Pole")
+		user := createUser(t, users, group.ID, "rudolph")
+		thread := &models.MailboxThread{GroupID: group.ID, Round: 1, RecipientID: user.ID, Messages: []models.MailboxMessage{{FromSanta: true, Payload: "hello"}}}
+		if err := mailbox.CreateThread(thread); err != nil {
+			t.Fatalf("CreateThread: %v", err)
+		}
+
+		if err := users.DeleteUser(user.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := mailbox.GetThread(thread.ID); !errors.Is(err, database.ErrThreadNotFound) {
+			t.Fatalf("expected the thread to be deleted with its recipient, got %v", err)
+		}
+	})
+}
+
+// RunUnitOfWork checks that units of work commit, roll back and serialize operations on a group
+func RunUnitOfWork(t *testing.T, newUnitOfWork UnitOfWorkFactory) {
+	t.Run("Commit", func(t *testing.T) {
+		uow, groups, users := newUnitOfWork(t)
+		group := createGroup(t, groups, "North Pole")
+
+		var user *models.User
+		err := uow.Do(func(repos database.Repositories) error {
+			if _, err := repos.Groups().LockGroup(group.ID); err != nil {
+				return err
+			}
+			user = createUser(t, repos.Users(), group.ID, "rudolph")
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+
+		if _, err := users.GetUser(user.ID); err != nil {
+			t.Fatalf("expected the user to be committed, got %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		uow, groups, users := newUnitOfWork(t)
+		group := createGroup(t, groups, "North Pole")
+		before, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+
+		errAbort := errors.New("abort")
+		var user *models.User
+		err = uow.Do(func(repos database.Repositories) error {
+			if _, err := repos.Groups().LockGroup(group.ID); err != nil {
+				return err
+			}
+			user = createUser(t, repos.Users(), group.ID, "rudolph")
+			if _, err := repos.Groups().SaveDrawResults(group.ID, []models.DrawResult{{Payload: "for a"}}); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("expected the error of fn, got %v", err)
+		}
+
+		if _, err := users.GetUser(user.ID); !errors.Is(err, database.ErrUserNotFound) {
+			t.Fatalf("expected the user to be rolled back, got %v", err)
+		}
+		after, err := groups.GetGroup(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroup: %v", err)
+		}
+		if after.Version != before.Version || after.IsDrawn() {
+			t.Errorf("expected the group to be rolled back, got version %d and draw round %d", after.Version, after.DrawRound)
+		}
+	})
+
+	t.Run("Serialized", func(t *testing.T) {
+		uow, groups, users := newUnitOfWork(t)
+		group := createGroup(t, groups, "North Pole")
+
+		// Each unit of work names its user after the number of members it reads,
+		// so interleaved units of work would pick the same username and conflict.
+		const workers = 8
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- uow.Do(func(repos database.Repositories) error {
+					locked, err := repos.Groups().LockGroup(group.ID)
+					if err != nil {
+						return err
+					}
+					return repos.Users().CreateUser(&models.User{
+						Username: fmt.Sprintf("elf-%d", len(locked.Users)),
+						Email:    fmt.Sprintf("elf-%d@example.com", len(locked.Users)),
+						GroupID:  group.ID,
+					})
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("Do: %v", err)
+			}
+		}
+
+		got, err := users.GetGroupUsers(group.ID)
+		if err != nil {
+			t.Fatalf("GetGroupUsers: %v", err)
+		}
+		if len(got) != workers+1 {
+			t.Errorf("expected %d users, got %d", workers+1, len(got))
+		}
+	})
+}
+
+func createGroup(t *testing.T, groups database.GroupRepository, name string) *models.Group {
+	t.Helper()
+
+	group := &models.Group{
+		Name:           name,
+		SecretVerifier: "verifier.salt",
+		Users: []models.User{{
+			Username:     "santa",
+			Email:        "santa@example.com",
+			IsAdmin:      true,
+			Participates: true,
+		}},
+	}
+	if err := groups.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	return group
+}
+
+func wishTitles(items []models.WishItem) string {
+	titles := make([]string, len(items))
+	for i, item := range items {
+		titles[i] = item.Title
+	}
+	return strings.Join(titles, ",")
+}
+
+func createUser(t *testing.T, users database.UserRepository, groupID string, username string) *models.User {
+	t.Helper()
+
+	user := &models.User{
+		Username:     username,
+		Email:        username + "@example.com",
+		GroupID:      groupID,
+		Participates: true,
+	}
+	if err := users.CreateUser(user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
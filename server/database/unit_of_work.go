@@ -0,0 +1,39 @@
+package database
+
+import "gorm.io/gorm"
+
+// TransactionManager implements UnitOfWork with database transactions
+type TransactionManager struct {
+	db *DB
+}
+
+var _ UnitOfWork = (*TransactionManager)(nil)
+
+func NewTransactionManager(db *DB) *TransactionManager {
+	return &TransactionManager{db: db}
+}
+
+type txRepositories struct {
+	groups  *GroupStore
+	users   *UserStore
+	wishes  *WishStore
+	mailbox *MailboxStore
+}
+
+func (r *txRepositories) Groups() GroupRepository    { return r.groups }
+func (r *txRepositories) Users() UserRepository      { return r.users }
+func (r *txRepositories) Wishes() WishRepository     { return r.wishes }
+func (r *txRepositories) Mailbox() MailboxRepository { return r.mailbox }
+
+// Do runs fn in a transaction, committed when fn returns nil and rolled back otherwise
+func (m *TransactionManager) Do(fn func(repos Repositories) error) error {
+	return m.db.gorm.Transaction(func(tx *gorm.DB) error {
+		txDB := &DB{gorm: tx}
+		return fn(&txRepositories{
+			groups:  NewGroupStore(txDB),
+			users:   NewUserStore(txDB),
+			wishes:  NewWishStore(txDB),
+			mailbox: NewMailboxStore(txDB),
+		})
+	})
+}
@@ -1,13 +1,11 @@
 package database
 
 import (
-	"context"
 	"errors"
 	"onxzy/super-santa-server/database/models"
-	"strings"
 
-	"go.uber.org/fx"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserStore struct {
@@ -17,34 +15,27 @@ type UserStore struct {
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrEmailAlreadyUsed  = errors.New("email already used in the group")
 )
 
-func NewUserStore(lc fx.Lifecycle, db *DB) *UserStore {
-	s := &UserStore{db: db}
-
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			if err := db.gorm.AutoMigrate(&models.User{}); err != nil {
-				return err
-			}
-			return nil
-		},
-	})
-
-	return s
+func NewUserStore(db *DB) *UserStore {
+	return &UserStore{db: db}
 }
 
 // User
 
 func (s *UserStore) CreateUser(user *models.User) error {
+	if err := s.checkUsername(user); err != nil {
+		return err
+	}
+	if err := s.checkEmail(user); err != nil {
+		return err
+	}
 	if err := s.db.gorm.Create(user).Error; err != nil {
 		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return ErrUserAlreadyExists
 		}
-		if strings.Contains(err.Error(), "UNIQUE constraint") {
-			return ErrUserAlreadyExists
-		}
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, models.ErrGroupDoesNotExist) {
 			return ErrGroupNotFound
 		}
 		return err
@@ -54,7 +45,7 @@ func (s *UserStore) CreateUser(user *models.User) error {
 
 func (s *UserStore) GetUser(id string) (*models.User, error) {
 	var user models.User
-	if err := s.db.gorm.Where("ID = ?", id).First(&user).Error; err != nil {
+	if err := s.db.gorm.Preload("WishItems", wishItemsOrder).Where("ID = ?", id).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -65,7 +56,8 @@ func (s *UserStore) GetUser(id string) (*models.User, error) {
 
 func (s *UserStore) GetGroupUserByEmail(groupID string, email string) (*models.User, error) {
 	var user models.User
-	if err := s.db.gorm.Where("email = ? AND group_id = ?", email, groupID).First(&user).Error; err != nil {
+	indexes := s.db.keyring().BlindIndexes(email)
+	if err := s.db.gorm.Where("email_index IN ? AND group_id = ?", indexes, groupID).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrUserNotFound
 		}
@@ -76,16 +68,82 @@ func (s *UserStore) GetGroupUserByEmail(groupID string, email string) (*models.U
 
 func (s *UserStore) GetGroupUsers(groupID string) ([]models.User, error) {
 	var users []models.User
-	if err := s.db.gorm.Where("group_id = ?", groupID).Find(&users).Error; err != nil {
+	if err := s.db.gorm.Preload("WishItems", wishItemsOrder).Where("group_id = ?", groupID).Find(&users).Error; err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
 func (s *UserStore) UpdateUser(user *models.User) error {
-	return s.db.gorm.Save(user).Error
+	if err := s.checkUsername(user); err != nil {
+		return err
+	}
+	if err := s.checkEmail(user); err != nil {
+		return err
+	}
+	// Wish items are saved through the wish store
+	if err := s.db.gorm.Omit(clause.Associations).Save(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrUserAlreadyExists
+		}
+		return err
+	}
+	return nil
 }
 
 func (s *UserStore) DeleteUser(id string) error {
-	return s.db.gorm.Unscoped().Where("ID = ?", id).Delete(&models.User{}).Error
+	return s.db.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", id).Delete(&models.WishItem{}).Error; err != nil {
+			return err
+		}
+		if err := releaseClaims(tx, id); err != nil {
+			return err
+		}
+		if err := deleteRecipientThreads(tx, id); err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("ID = ?", id).Delete(&models.User{}).Error
+	})
+}
+
+// checkUsername rejects a username already taken in the group. The unique index
+// only catches duplicates indexed with the same key, rows indexed with an older
+// key remain until they are rotated.
+func (s *UserStore) checkUsername(user *models.User) error {
+	keyring := s.db.keyring()
+	if keyring == nil {
+		return nil
+	}
+
+	var count int64
+	if err := s.db.gorm.Model(&models.User{}).
+		Where("group_id = ? AND username_index IN ? AND id <> ?", user.GroupID, keyring.BlindIndexes(user.Username), user.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrUserAlreadyExists
+	}
+	return nil
+}
+
+// checkEmail rejects an email already used in the group, logins look members up
+// by email. Its index isn't unique, groups may hold duplicates from before this check.
+func (s *UserStore) checkEmail(user *models.User) error {
+	var count int64
+	if err := s.db.gorm.Model(&models.User{}).
+		Where("group_id = ? AND email_index IN ? AND id <> ?", user.GroupID, s.db.keyring().BlindIndexes(user.Email), user.ID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEmailAlreadyUsed
+	}
+	return nil
+}
+
+// releaseClaims releases the wish items claimed by a user
+func releaseClaims(tx *gorm.DB, userID string) error {
+	return tx.Model(&models.WishItem{}).Where("claimed_by_id = ?", userID).
+		UpdateColumns(map[string]any{"claimed_by_id": nil, "claimed_at": nil}).Error
 }
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type WishStore struct {
+	db *DB
+}
+
+var (
+	ErrWishItemNotFound = errors.New("wish item not found")
+)
+
+func NewWishStore(db *DB) *WishStore {
+	return &WishStore{db: db}
+}
+
+// wishItemsOrder sorts the wish items of a user as they are displayed
+func wishItemsOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("position, created_at")
+}
+
+func (s *WishStore) GetUserWishItems(userID string) ([]models.WishItem, error) {
+	items := make([]models.WishItem, 0)
+	if err := s.db.gorm.Scopes(wishItemsOrder).Where("user_id = ?", userID).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *WishStore) GetWishItem(id string) (*models.WishItem, error) {
+	var item models.WishItem
+	if err := s.db.gorm.Where("id = ?", id).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWishItemNotFound
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (s *WishStore) CreateWishItem(item *models.WishItem) error {
+	return s.db.gorm.Create(item).Error
+}
+
+// UpdateWishItem replaces the columns of an item, except its claim
+func (s *WishStore) UpdateWishItem(item *models.WishItem) error {
+	result := s.db.gorm.Model(item).Select("*").Omit("id", "created_at", "user_id", "claimed_by_id", "claimed_at").Updates(item)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWishItemNotFound
+	}
+	return nil
+}
+
+func (s *WishStore) DeleteWishItem(id string) error {
+	result := s.db.gorm.Where("id = ?", id).Delete(&models.WishItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWishItemNotFound
+	}
+	return nil
+}
+
+// SetWishItemClaim claims an item for claimedByID, or releases it when nil
+func (s *WishStore) SetWishItemClaim(id string, claimedByID *string) error {
+	var claimedAt *time.Time
+	if claimedByID != nil {
+		now := time.Now()
+		claimedAt = &now
+	}
+
+	// The update time is left alone, the owner would notice it change
+	result := s.db.gorm.Model(&models.WishItem{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"claimed_by_id": claimedByID,
+		"claimed_at":    claimedAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWishItemNotFound
+	}
+	return nil
+}
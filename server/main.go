@@ -9,6 +9,7 @@ import (
 	"onxzy/super-santa-server/middlewares"
 	"onxzy/super-santa-server/services"
 	"onxzy/super-santa-server/utils"
+	"os"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -21,19 +22,34 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	app := fx.New(
 		fx.Provide(
 			utils.InitZap,
 			utils.InitConfig,
 			database.NewDB,
-			database.NewGroupStore,
-			database.NewUserStore,
+			fx.Annotate(database.NewGroupStore, fx.As(new(database.GroupRepository))),
+			fx.Annotate(database.NewUserStore, fx.As(new(database.UserRepository))),
+			fx.Annotate(database.NewMailboxStore, fx.As(new(database.MailboxRepository))),
+			fx.Annotate(database.NewTransactionManager, fx.As(new(database.UnitOfWork))),
+			database.NewNotificationStore,
+			database.NewRetentionStore,
+			services.NewNotificationService,
 			services.NewMailService,
 			services.NewGroupService,
 			services.NewUserService,
 			services.NewAuthService,
+			services.NewBackupService,
+			services.NewRetentionService,
+			services.NewMailboxService,
 			controllers.NewAuthController,
 			controllers.NewGroupController,
+			controllers.NewNotificationController,
+			controllers.NewMailController,
+			controllers.NewMailboxController,
 			middlewares.NewAuthMiddleware,
 			validator.New,
 			server,
@@ -41,7 +57,8 @@ func main() {
 		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
 			return &fxevent.ZapLogger{Logger: log.Named("fx")}
 		}),
-		fx.Invoke(func(*gin.Engine) {}),
+		fx.Invoke(database.EnsureSchema),
+		fx.Invoke(func(*gin.Engine, *services.BackupService, *services.RetentionService) {}),
 	)
 	app.Run()
 }
@@ -52,6 +69,9 @@ func server(
 	authController *controllers.AuthController,
 	authMiddleware *middlewares.AuthMiddleware,
 	groupController *controllers.GroupController,
+	notificationController *controllers.NotificationController,
+	mailController *controllers.MailController,
+	mailboxController *controllers.MailboxController,
 	log *zap.Logger,
 ) *gin.Engine {
 
@@ -71,6 +91,9 @@ func server(
 	apiRouter := router.Group("/api/v1")
 	authController.RegisterRoutes(apiRouter.Group("/auth"), authMiddleware)
 	groupController.RegisterRoutes(apiRouter.Group("/group"), authMiddleware)
+	notificationController.RegisterRoutes(apiRouter.Group("/notifications"), authMiddleware)
+	mailController.RegisterRoutes(apiRouter.Group("/mail"), authMiddleware)
+	mailboxController.RegisterRoutes(apiRouter.Group("/mailbox"), authMiddleware)
 	srv := &http.Server{Addr: config.Host.Listen + ":" + config.Host.Port, Handler: router} // define a web server
 
 	lc.Append(fx.Hook{
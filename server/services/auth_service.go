@@ -22,11 +22,11 @@ type AuthService struct {
 	loginSessionStore map[string]authService.LoginSession
 
 	config     *utils.Config
-	groupStore *database.GroupStore
-	userStore  *database.UserStore
+	groupStore database.GroupRepository
+	userStore  database.UserRepository
 }
 
-func NewAuthService(config *utils.Config, groupStore *database.GroupStore, userStore *database.UserStore) *AuthService {
+func NewAuthService(config *utils.Config, groupStore database.GroupRepository, userStore database.UserRepository) *AuthService {
 	srpInstance, _ := srp.NewSRP("rfc5054.2048", sha256.New, nil)
 	return &AuthService{
 		srp:               srpInstance,
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type BackupService struct {
+	config *utils.Config
+	db     *database.DB
+	logger *zap.Logger
+}
+
+func NewBackupService(lc fx.Lifecycle, config *utils.Config, db *database.DB, logger *zap.Logger) *BackupService {
+	s := &BackupService{
+		config: config,
+		db:     db,
+		logger: logger.Named("backup-service"),
+	}
+
+	if config.Backup.Interval <= 0 {
+		return s
+	}
+
+	stop := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.runBackups(stop)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stop)
+			return nil
+		},
+	})
+
+	return s
+}
+
+func (s *BackupService) runBackups(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(s.config.Backup.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			path, err := s.Backup()
+			if err != nil {
+				s.logger.Error("Failed to back up the database", zap.Error(err))
+				continue
+			}
+			s.logger.Info("Database backed up", zap.String("path", path))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Backup exports the database to a new archive of the backup directory and prunes old archives
+func (s *BackupService) Backup() (path string, err error) {
+	if err := os.MkdirAll(s.config.Backup.Dir, 0o700); err != nil {
+		return "", err
+	}
+
+	// Archives hold verifiers and encrypted keys, keep them private
+	path = filepath.Join(s.config.Backup.Dir, fmt.Sprintf("backup-%s.json", time.Now().UTC().Format("20060102T150405Z")))
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.db.Export(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	// Only complete archives get the backup name
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if err := s.prune(); err != nil {
+		s.logger.Warn("Failed to prune old backups", zap.Error(err))
+	}
+
+	return path, nil
+}
+
+// prune removes the oldest archives beyond backup.keep
+func (s *BackupService) prune() error {
+	if s.config.Backup.Keep <= 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(filepath.Join(s.config.Backup.Dir, "backup-*.json"))
+	if err != nil {
+		return err
+	}
+	// Timestamped names sort chronologically
+	slices.Sort(backups)
+
+	for len(backups) > s.config.Backup.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/groupService"
+)
+
+// drawOrder shuffles the participants of a draw and checks that the rule of the group
+// lets each of them give giftsPerPerson gifts to as many others, with everyone receiving
+// as many gifts. The admin's client picks the givers, the server never learns them.
+func drawOrder(users []models.User, rule models.DrawRule, giftsPerPerson int) error {
+	if err := shuffle(users); err != nil {
+		return err
+	}
+	if !drawFeasible(users, rule, giftsPerPerson) {
+		return groupService.ErrDrawImpossible // 460
+	}
+	return nil
+}
+
+// drawFeasible tells if a draw exists, i.e. if the graph of who rule lets give to whom
+// has a subgraph where every member gives and receives exactly gifts times. Such a
+// subgraph splits into one perfect matching per gift, as the admin's client does.
+//
+// It is a maximum flow from the givers, with a capacity of gifts each, to the
+// receivers, with a capacity of gifts each, through the allowed pairs.
+func drawFeasible(users []models.User, rule models.DrawRule, gifts int) bool {
+	n := len(users)
+	source, sink := 2*n, 2*n+1
+	network := newFlowNetwork(2*n + 2)
+	for giver := range users {
+		network.addEdge(source, giver, gifts)
+		network.addEdge(n+giver, sink, gifts)
+		for receiver := range users {
+			if giver != receiver && canGive(rule, users[giver].Team, users[receiver].Team) {
+				network.addEdge(giver, n+receiver, 1)
+			}
+		}
+	}
+	return network.maxFlow(source, sink) == n*gifts
+}
+
+// canGive tells if rule lets a member of giverTeam give to a different member of receiverTeam
+func canGive(rule models.DrawRule, giverTeam string, receiverTeam string) bool {
+	switch rule {
+	case models.DrawRuleOutsideTeam:
+		return giverTeam == "" || giverTeam != receiverTeam
+	default:
+		return true
+	}
+}
+
+// teamTags returns an opaque tag of the team of each user for the rule of the group, or
+// nil without a rule. Tags are keyed for the draw session so they don't carry over to
+// another draw, and members without a team get an empty tag.
+func teamTags(users []models.User, rule models.DrawRule) ([]string, error) {
+	if rule != models.DrawRuleOutsideTeam {
+		return nil, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate team tag key: %w", err)
+	}
+	tags := make([]string, len(users))
+	for i, user := range users {
+		if user.Team == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(user.Team))
+		tags[i] = hex.EncodeToString(mac.Sum(nil))
+	}
+	return tags, nil
+}
+
+// flowNetwork is a directed graph with capacities, for Dinic's maximum flow
+type flowNetwork struct {
+	edges [][]flowEdge
+	level []int
+	next  []int
+}
+
+type flowEdge struct {
+	to, capacity, reverse int
+}
+
+func newFlowNetwork(nodes int) *flowNetwork {
+	return &flowNetwork{
+		edges: make([][]flowEdge, nodes),
+		level: make([]int, nodes),
+		next:  make([]int, nodes),
+	}
+}
+
+func (f *flowNetwork) addEdge(from, to, capacity int) {
+	f.edges[from] = append(f.edges[from], flowEdge{to: to, capacity: capacity, reverse: len(f.edges[to])})
+	f.edges[to] = append(f.edges[to], flowEdge{to: from, reverse: len(f.edges[from]) - 1})
+}
+
+// maxFlow returns the value of a maximum flow from source to sink, consuming the capacities
+func (f *flowNetwork) maxFlow(source, sink int) int {
+	total := 0
+	for f.levels(source, sink) {
+		clear(f.next)
+		for pushed := f.push(source, sink, math.MaxInt); pushed > 0; pushed = f.push(source, sink, math.MaxInt) {
+			total += pushed
+		}
+	}
+	return total
+}
+
+// levels sets the distance of each node from source in the residual graph,
+// and tells if sink is reachable
+func (f *flowNetwork) levels(source, sink int) bool {
+	for i := range f.level {
+		f.level[i] = -1
+	}
+	f.level[source] = 0
+	queue := []int{source}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, edge := range f.edges[node] {
+			if edge.capacity > 0 && f.level[edge.to] < 0 {
+				f.level[edge.to] = f.level[node] + 1
+				queue = append(queue, edge.to)
+			}
+		}
+	}
+	return f.level[sink] >= 0
+}
+
+// push sends up to limit along a path of increasing levels and returns the amount sent
+func (f *flowNetwork) push(node, sink, limit int) int {
+	if node == sink {
+		return limit
+	}
+	for ; f.next[node] < len(f.edges[node]); f.next[node]++ {
+		edge := &f.edges[node][f.next[node]]
+		if edge.capacity == 0 || f.level[edge.to] != f.level[node]+1 {
+			continue
+		}
+		if pushed := f.push(edge.to, sink, min(limit, edge.capacity)); pushed > 0 {
+			edge.capacity -= pushed
+			f.edges[edge.to][edge.reverse].capacity += pushed
+			return pushed
+		}
+	}
+	return 0
+}
+
+// shuffle is a Fisher-Yates shuffle using crypto/rand for secure randomness
+func shuffle(users []models.User) error {
+	for i := len(users) - 1; i > 0; i-- {
+		var randomBytes [8]byte
+		if _, err := rand.Read(randomBytes[:]); err != nil {
+			return fmt.Errorf("failed to generate random number: %w", err)
+		}
+
+		// Convert bytes to an integer and get random index in range [0, i]
+		var randomInt uint64
+		for k, b := range randomBytes {
+			randomInt |= uint64(b) << (8 * k)
+		}
+		j := int(randomInt % uint64(i+1))
+
+		// Swap elements
+		users[i], users[j] = users[j], users[i]
+	}
+	return nil
+}
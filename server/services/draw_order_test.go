@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/groupService"
+	"testing"
+)
+
+// layoutUsers returns a user per letter of layout, the letter being their team and "." no team
+func layoutUsers(layout string) []models.User {
+	users := make([]models.User, len(layout))
+	for i, team := range layout {
+		users[i].ID = string(rune('a' + i))
+		if team != '.' {
+			users[i].Team = string(team)
+		}
+	}
+	return users
+}
+
+func TestDrawOrder(t *testing.T) {
+	tests := []struct {
+		layout   string
+		rule     models.DrawRule
+		gifts    int
+		solvable bool
+	}{
+		{"AABB", models.DrawRuleOutsideTeam, 1, true},
+		{"AABB", models.DrawRuleOutsideTeam, 2, true},
+		{"AABB", models.DrawRuleOutsideTeam, 3, false},
+		{"AABB", models.DrawRuleNone, 3, true},
+		{"AAB", models.DrawRuleOutsideTeam, 1, false},
+		{"AAB", models.DrawRuleNone, 2, true},
+		{"AAAABC", models.DrawRuleOutsideTeam, 1, false},
+		{"AAAABB", models.DrawRuleOutsideTeam, 2, false},
+		{"AAAB.", models.DrawRuleOutsideTeam, 2, false},
+		{"AABBC", models.DrawRuleOutsideTeam, 3, false},
+		{"AAABC", models.DrawRuleOutsideTeam, 2, false},
+		{"AA..", models.DrawRuleOutsideTeam, 2, true},
+		{"AA..", models.DrawRuleOutsideTeam, 3, false},
+		{"AAABCD", models.DrawRuleOutsideTeam, 1, true},
+		{"AAABCD", models.DrawRuleOutsideTeam, 3, true},
+		{"AAABBC", models.DrawRuleOutsideTeam, 2, true},
+		{"AAABBC", models.DrawRuleOutsideTeam, 3, true},
+		{"AAABBB", models.DrawRuleOutsideTeam, 3, true},
+		{"AABBCC", models.DrawRuleOutsideTeam, 3, true},
+		{"AAB..", models.DrawRuleOutsideTeam, 3, true},
+		{"AAA...", models.DrawRuleOutsideTeam, 3, true},
+	}
+	for _, test := range tests {
+		users := layoutUsers(test.layout)
+		err := drawOrder(users, test.rule, test.gifts)
+		if test.solvable && err != nil {
+			t.Errorf("%s with %d gifts: expected a draw, got %v", test.layout, test.gifts, err)
+		}
+		if !test.solvable && !errors.Is(err, groupService.ErrDrawImpossible) {
+			t.Errorf("%s with %d gifts: expected ErrDrawImpossible, got %v", test.layout, test.gifts, err)
+		}
+		if !sameMembers(users, userIDs(layoutUsers(test.layout))) {
+			t.Errorf("%s: expected the order to keep the participants", test.layout)
+		}
+	}
+}
+
+func userIDs(users []models.User) []string {
+	ids := make([]string, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func TestTeamTags(t *testing.T) {
+	users := layoutUsers("AAB.")
+
+	tags, err := teamTags(users, models.DrawRuleNone)
+	if err != nil || tags != nil {
+		t.Errorf("expected no tags without a rule, got %v, %v", tags, err)
+	}
+
+	tags, err = teamTags(users, models.DrawRuleOutsideTeam)
+	if err != nil {
+		t.Fatalf("teamTags: %v", err)
+	}
+	if tags[0] == "" || tags[0] != tags[1] || tags[0] == tags[2] || tags[3] != "" {
+		t.Errorf("expected one tag per team and none without a team, got %v", tags)
+	}
+	if tags[0] == "A" {
+		t.Errorf("expected the tags not to be the team names")
+	}
+
+	again, err := teamTags(users, models.DrawRuleOutsideTeam)
+	if err != nil {
+		t.Fatalf("teamTags: %v", err)
+	}
+	if again[0] == tags[0] {
+		t.Errorf("expected the tags to change between draw sessions")
+	}
+}
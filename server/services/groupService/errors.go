@@ -5,7 +5,15 @@ import "errors"
 var (
 	ErrGroupNotFound       = errors.New("group not found")
 	ErrNotEnoughUsers      = errors.New("not enough users")
+	ErrDrawImpossible      = errors.New("no draw satisfies the rule of the group")
+	ErrInvalidTeamProofs   = errors.New("team proofs do not satisfy the rule of the group")
 	ErrDrawSessionNotFound = errors.New("draw session not found")
+	ErrDrawSessionOutdated = errors.New("group members changed since the draw was initiated")
+	ErrDrawAlreadyDone     = errors.New("draw already done")
+	ErrDrawInProgress      = errors.New("draw in progress")
+	ErrDrawNotStarted      = errors.New("draw not started")
+	ErrGroupArchived       = errors.New("group archived")
+	ErrNoExchangeDate      = errors.New("group has no exchange date")
 )
 
 type InvalidPublicKeyError struct {
@@ -0,0 +1,76 @@
+package groupService
+
+import (
+	"fmt"
+	"onxzy/super-santa-server/database/models"
+	"slices"
+)
+
+// Action is a mutation of a group, allowed only in some states
+type Action string
+
+const (
+	ActionJoin           Action = "join"
+	ActionLeave          Action = "leave" // Also covers the removal of a member by the admin
+	ActionEraseAccount   Action = "erase_account"
+	ActionUpdateWishes   Action = "update_wishes"
+	ActionUpdateAddress  Action = "update_address"
+	ActionUpdateProfile  Action = "update_profile"
+	ActionClaimWish      Action = "claim_wish" // Also covers releasing a claim
+	ActionSendMessage    Action = "send_message"
+	ActionTrackGift      Action = "track_gift"
+	ActionUpdateSettings Action = "update_settings"
+	ActionUpdateDrawMode Action = "update_draw_mode"
+	ActionTransferAdmin  Action = "transfer_admin"
+	ActionParticipation  Action = "participation"
+	ActionUpdateTeam     Action = "update_team"
+	ActionInitDraw       Action = "init_draw"
+	ActionFinishDraw     Action = "finish_draw"
+	ActionCancelDraw     Action = "cancel_draw"
+	ActionArchive        Action = "archive"
+)
+
+// allowedStates lists the states in which each action is allowed.
+// Transitions: open|drawn -> drawing (init, a redraw from drawn), drawing -> drawing
+// (init again), drawing -> open|drawn (cancel, back to the previous draw if any),
+// drawing -> drawn (finish), open|drawn -> archived.
+var allowedStates = map[Action][]models.GroupState{
+	ActionJoin:           {models.GroupStateOpen},
+	ActionLeave:          {models.GroupStateOpen},
+	ActionEraseAccount:   {models.GroupStateOpen, models.GroupStateArchived}, // Erasing a drawn member would break the assignments
+	ActionUpdateWishes:   {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionUpdateAddress:  {models.GroupStateDrawn}, // Addresses are encrypted under a key of the latest draw
+	ActionUpdateProfile:  {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionClaimWish:      {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionSendMessage:    {models.GroupStateDrawn}, // Threads pair members with their santa of the latest draw
+	ActionTrackGift:      {models.GroupStateDrawn},
+	ActionUpdateSettings: {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionUpdateDrawMode: {models.GroupStateOpen}, // The draw session and the results depend on it
+	ActionTransferAdmin:  {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionParticipation:  {models.GroupStateOpen}, // Participants are the members of the draw
+	ActionUpdateTeam:     {models.GroupStateOpen}, // Teams constrain the draw
+	ActionInitDraw:       {models.GroupStateOpen, models.GroupStateDrawing, models.GroupStateDrawn},
+	ActionFinishDraw:     {models.GroupStateDrawing},
+	ActionCancelDraw:     {models.GroupStateDrawing},
+	ActionArchive:        {models.GroupStateOpen, models.GroupStateDrawn},
+}
+
+// CheckAction returns nil if action is allowed in state, or the error explaining why it is not
+func CheckAction(state models.GroupState, action Action) error {
+	if slices.Contains(allowedStates[action], state) {
+		return nil
+	}
+
+	switch state {
+	case models.GroupStateOpen:
+		return ErrDrawNotStarted
+	case models.GroupStateDrawing:
+		return ErrDrawInProgress
+	case models.GroupStateDrawn:
+		return ErrDrawAlreadyDone
+	case models.GroupStateArchived:
+		return ErrGroupArchived
+	default:
+		return fmt.Errorf("unknown group state %q", state)
+	}
+}
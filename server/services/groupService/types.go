@@ -1,10 +1,33 @@
 package groupService
 
+import (
+	"onxzy/super-santa-server/database/models"
+	"time"
+)
+
 type GroupInfo struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
 type DrawSession struct {
-	UserIDs []string `json:"user_ids"`
+	UserIDs        []string `json:"user_ids"`
+	GiftsPerPerson int      `json:"gifts_per_person"`
+	TeamTags       []string `json:"team_tags"` // Nil without a rule
+}
+
+// DrawOrder is the order of the participants sent to the admin's client for a draw
+type DrawOrder struct {
+	PublicKeys     []string // Public key secret of each participant
+	GiftsPerPerson int
+	TeamTags       []string // Opaque tag of the team of each participant for the rule of the group, nil without a rule
+}
+
+type GroupSettings struct {
+	ExchangeDate *time.Time `json:"exchange_date"`
+	Budget       *int64     `json:"budget"`   // In minor units of Currency
+	Currency     string     `json:"currency"` // ISO 4217 code
+
+	GiftsPerPerson int              `json:"gifts_per_person"` // Left unchanged when 0
+	DrawRule       *models.DrawRule `json:"draw_rule"`        // Left unchanged when nil
 }
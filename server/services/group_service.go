@@ -1,13 +1,20 @@
 package services
 
 import (
+	"crypto"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"onxzy/super-santa-server/database"
 	"onxzy/super-santa-server/database/models"
 	"onxzy/super-santa-server/services/groupService"
+	"onxzy/super-santa-server/services/userService"
+	"onxzy/super-santa-server/utils"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwe"
@@ -16,15 +23,20 @@ import (
 )
 
 type GroupService struct {
-	groupStore       *database.GroupStore
+	config           *utils.Config
+	groupStore       database.GroupRepository
+	uow              database.UnitOfWork
 	drawSessionStore map[string]groupService.DrawSession
+	drawSessionMu    sync.Mutex
 	mailService      *MailService
 	logger           *zap.Logger
 }
 
-func NewGroupService(groupStore *database.GroupStore, mailService *MailService, logger *zap.Logger) *GroupService {
+func NewGroupService(config *utils.Config, groupStore database.GroupRepository, uow database.UnitOfWork, mailService *MailService, logger *zap.Logger) *GroupService {
 	return &GroupService{
+		config:           config,
 		groupStore:       groupStore,
+		uow:              uow,
 		drawSessionStore: make(map[string]groupService.DrawSession),
 		mailService:      mailService,
 		logger:           logger.Named("group-service"),
@@ -43,6 +55,7 @@ func (s *GroupService) CreateGroup(group *models.Group, admin *models.User) erro
 	if err := s.groupStore.CreateGroup(group); err != nil {
 		return err
 	}
+	admin = &group.Users[0] // The stored copy holds the generated ID
 
 	// Send email notification to admin
 	if err := s.mailService.SendGroupCreationNotification(group, admin); err != nil {
@@ -83,110 +96,477 @@ func (s *GroupService) GetGroupInfo(groupID string) (*groupService.GroupInfo, er
 	}, nil
 }
 
-func (s *GroupService) InitDraw(groupID string) (publicKeys []string, err error) {
+// UpdateSettings changes the settings of the group on behalf of adminID
+func (s *GroupService) UpdateSettings(groupID string, adminID string, settings *groupService.GroupSettings) (*models.Group, error) {
+	var group *models.Group
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateSettings); err != nil {
+			return err
+		}
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err
+		}
+
+		if !sameDate(group.ExchangeDate, settings.ExchangeDate) {
+			group.PurgeWarnedAt = nil // The retention purge moves with the exchange date
+		}
+		if settings.GiftsPerPerson != 0 && settings.GiftsPerPerson != group.GiftsPerPerson {
+			if err := groupService.CheckAction(group.State, groupService.ActionUpdateDrawMode); err != nil {
+				return err
+			}
+			group.GiftsPerPerson = settings.GiftsPerPerson
+		}
+		if settings.DrawRule != nil && *settings.DrawRule != group.DrawRule {
+			if err := groupService.CheckAction(group.State, groupService.ActionUpdateDrawMode); err != nil {
+				return err
+			}
+			group.DrawRule = *settings.DrawRule
+		}
+		group.ExchangeDate = settings.ExchangeDate
+		// Items already priced above a new budget are kept, the budget is checked when items are written
+		group.Budget = settings.Budget
+		group.Currency = settings.Currency
+
+		if err := repos.Groups().UpdateGroup(*group); err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// GetCalendar returns the gift exchange as an iCalendar file
+func (s *GroupService) GetCalendar(groupID string) ([]byte, error) {
 	group, err := s.GetGroup(groupID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get users from the group
-	users := group.Users
-	if len(users) < 3 {
-		return nil, groupService.ErrNotEnoughUsers // 460
+	if group.ExchangeDate == nil {
+		return nil, groupService.ErrNoExchangeDate
 	}
 
-	// Shuffle the users list
-	// Fisher-Yates shuffle implementation using crypto/rand for secure randomness
-	for i := len(users) - 1; i > 0; i-- {
-		var randomBytes [8]byte
-		if _, err := rand.Read(randomBytes[:]); err != nil {
-			return nil, fmt.Errorf("failed to generate random number: %w", err) // 500
+	return utils.BuildICS(groupCalendarEvent(group, s.config.Host.AppURL)), nil
+}
+
+// groupCalendarEvent describes the gift exchange of a group, the group must have an exchange date
+func groupCalendarEvent(group *models.Group, appURL string) utils.CalendarEvent {
+	groupURL := appURL + "/group/" + group.ID
+	return utils.CalendarEvent{
+		UID:         group.ID + "@super-santa",
+		Summary:     fmt.Sprintf("Secret Santa: %s", group.Name),
+		Description: fmt.Sprintf("Gift exchange of the Secret Santa group %s.\n%s", group.Name, groupURL),
+		URL:         groupURL,
+		Date:        *group.ExchangeDate,
+	}
+}
+
+// InitDraw locks the membership of the group and returns the public keys of its
+// participants in a random order, with the tags of their teams when the group has
+// a rule, once it checked that a draw satisfies the rule. It can be called again while the draw is in progress,
+// e.g. when the previous session was lost, and after a draw to redraw: the results
+// of the previous round stay in place until the new one is finished.
+func (s *GroupService) InitDraw(groupID string, adminID string) (*groupService.DrawOrder, error) {
+	var users []models.User
+	var group *models.Group
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionInitDraw); err != nil {
+			return err // 464
+		}
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err // 403
 		}
 
-		// Convert bytes to an integer and get random index in range [0, i]
-		var randomInt uint64
-		for k, b := range randomBytes {
-			randomInt |= uint64(b) << (8 * k)
+		// Each participant gives to GiftsPerPerson others
+		users = group.Participants()
+		if len(users) < 3 || len(users) <= group.GiftsPerPerson {
+			return groupService.ErrNotEnoughUsers // 460
+		}
+		if err := drawOrder(users, group.DrawRule, group.GiftsPerPerson); err != nil {
+			return err // 460
 		}
-		j := int(randomInt % uint64(i+1))
 
-		// Swap elements
-		users[i], users[j] = users[j], users[i]
+		return repos.Groups().SetGroupState(groupID, models.GroupStateDrawing)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	// Create a list of user IDs for the draw session
-	userIDs := make([]string, len(users))
-	for i, user := range users {
-		userIDs[i] = user.ID
+	tags, err := teamTags(users, group.DrawRule)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create and store the draw session
-	s.drawSessionStore[groupID] = groupService.DrawSession{
-		UserIDs: userIDs,
+	// Create the draw session and the list of public key secrets
+	session := groupService.DrawSession{
+		UserIDs:        make([]string, len(users)),
+		GiftsPerPerson: group.GiftsPerPerson,
+		TeamTags:       tags,
 	}
-
-	// Return a list of public key secrets
 	publicKeySecrets := make([]string, len(users))
 	for i, user := range users {
+		session.UserIDs[i] = user.ID
 		publicKeySecrets[i] = user.PublicKeySecret
 	}
 
-	return publicKeySecrets, nil
+	s.drawSessionMu.Lock()
+	s.drawSessionStore[groupID] = session
+	s.drawSessionMu.Unlock()
+
+	return &groupService.DrawOrder{
+		PublicKeys:     publicKeySecrets,
+		GiftsPerPerson: group.GiftsPerPerson,
+		TeamTags:       tags,
+	}, nil
 }
 
-func (s *GroupService) FinishDraw(groupID string, publicKeys []string) (results []string, err error) {
+// drawPayload is what a result tells its giver: their recipient, and the token that lets
+// them, and only them, start the thread of this gift
+type drawPayload struct {
+	UserID      string `json:"user_id"`
+	ThreadToken string `json:"thread_token"`
+}
+
+// newThreadToken returns the secret a giver starts the thread of a result with
+func newThreadToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate thread token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// FinishDraw encrypts to publicKeys[g*n+i] the result of the i-th of the n members of
+// the draw session for their g-th gift. The keys don't tell the server who the givers
+// are. With a rule, teamProofs[g*n+i] is the team tag of the same giver: the server
+// checks the rule against the tags without learning more than the team of each giver.
+// Each result keeps the hash of its thread token, the only way to start its thread.
+func (s *GroupService) FinishDraw(groupID string, adminID string, publicKeys []string, teamProofs []string) error {
+	// Only the admin may consume the draw session
 	group, err := s.GetGroup(groupID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if _, err := groupAdmin(group, adminID); err != nil {
+		return err // 403
 	}
 
+	s.drawSessionMu.Lock()
 	session, exists := s.drawSessionStore[groupID]
+	delete(s.drawSessionStore, groupID)
+	s.drawSessionMu.Unlock()
+
 	if !exists {
-		return nil, groupService.ErrDrawSessionNotFound // 461
+		return groupService.ErrDrawSessionNotFound // 461
 	}
 
-	defer delete(s.drawSessionStore, groupID)
-
-	if len(publicKeys) != len(session.UserIDs) {
-		return nil, &groupService.InvalidPublicKeyError{Err: errors.New("public keys do not match user IDs")} // 400
+	// The keys of the givers of each gift follow those of the previous gift
+	members, gifts := len(session.UserIDs), session.GiftsPerPerson
+	if len(publicKeys) != members*gifts {
+		return &groupService.InvalidPublicKeyError{Err: errors.New("public keys do not match user IDs")} // 400
+	}
+	if session.TeamTags != nil && len(teamProofs) != len(publicKeys) {
+		return groupService.ErrInvalidTeamProofs // 400
 	}
 
-	results = make([]string, len(session.UserIDs))
-	for i, userID := range session.UserIDs {
+	results := make([]models.DrawResult, len(publicKeys))
+	giverTags := make([]string, len(publicKeys))
+	var keyTags map[string]bool
+	keyProofs := make(map[string]string, members)
+	for gift := range gifts {
+		// Every gift pairs each member with one giver
+		giftTags := make(map[string]bool, members)
+		for i, userID := range session.UserIDs {
+			index := gift*members + i
+
+			pubKey, keyTag, err := parseDrawKey(publicKeys[index])
+			if err != nil {
+				return err // 400
+			}
+			if session.TeamTags != nil {
+				// A giver keeps their team for every gift, and a team doesn't give to itself
+				proof, exists := keyProofs[keyTag]
+				if exists && proof != teamProofs[index] {
+					return groupService.ErrInvalidTeamProofs // 400
+				}
+				keyProofs[keyTag] = teamProofs[index]
+				if !canGive(models.DrawRuleOutsideTeam, teamProofs[index], session.TeamTags[i]) {
+					return groupService.ErrInvalidTeamProofs // 400
+				}
+			}
+			if giftTags[keyTag] {
+				return &groupService.InvalidPublicKeyError{Err: errors.New("duplicated public key")} // 400
+			}
+			if keyTags != nil && !keyTags[keyTag] {
+				return &groupService.InvalidPublicKeyError{Err: errors.New("gifts have different givers")} // 400
+			}
+			for previous := i; previous < index; previous += members {
+				if giverTags[previous] == keyTag {
+					return &groupService.InvalidPublicKeyError{Err: errors.New("a member receives two gifts from the same giver")} // 400
+				}
+			}
+			giftTags[keyTag] = true
+			giverTags[index] = keyTag
+
+			threadToken, err := newThreadToken()
+			if err != nil {
+				return err // 500
+			}
+			payload, err := json.Marshal(drawPayload{UserID: userID, ThreadToken: threadToken})
+			if err != nil {
+				return err // 500
+			}
+			encrypted, err := jwe.Encrypt(payload, jwe.WithKey(jwa.RSA_OAEP_256(), pubKey))
+			if err != nil {
+				return fmt.Errorf("failed to encrypt user ID: %w", err) // 500
+			}
+
+			results[index] = models.DrawResult{
+				RecipientID:     userID,
+				ThreadTokenHash: hashToken(threadToken),
+				Payload:         string(encrypted),
+			}
+		}
+		if keyTags == nil {
+			keyTags = giftTags
+		}
+		// The givers of a gift are the members of the session, so are their teams
+		if session.TeamTags != nil && !sameTags(teamProofs[gift*members:(gift+1)*members], session.TeamTags) {
+			return groupService.ErrInvalidTeamProofs // 400
+		}
+	}
 
-		pubKey, err := jwk.ParseKey([]byte(publicKeys[i]))
+	// Encryption is done beforehand, the unit of work only checks that the
+	// members are still those of the session and saves the results
+	err = s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
 		if err != nil {
-			return nil, &groupService.InvalidPublicKeyError{Err: fmt.Errorf("failed to parse public key: %w", err)} // 400
+			return err
+		}
+
+		if err := groupService.CheckAction(group.State, groupService.ActionFinishDraw); err != nil {
+			return err // 409, 461, 464
+		}
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err // 403
+		}
+		// Membership is locked while drawing, this guards against sessions of another round
+		if !sameMembers(group.Participants(), session.UserIDs) {
+			return groupService.ErrDrawSessionOutdated // 462
 		}
 
-		if pubKey.KeyType() != jwa.RSA() {
-			return nil, &groupService.InvalidPublicKeyError{Err: errors.New("invalid public key type")} // 400
+		group.DrawRound, err = repos.Groups().SaveDrawResults(groupID, results)
+		if err != nil {
+			return err
 		}
+		group.State = models.GroupStateDrawn
+		return repos.Groups().SetGroupState(groupID, models.GroupStateDrawn)
+	})
+	if err != nil {
+		return err
+	}
 
-		if alg, exist := pubKey.Algorithm(); !exist || alg != jwa.RSA_OAEP_256() {
-			return nil, &groupService.InvalidPublicKeyError{Err: errors.New("invalid public key algorithm")} // 400
+	if err := s.mailService.SendDrawCompletionNotification(group, group.Participants()); err != nil {
+		s.logger.Error("Failed to send draw completion emails",
+			zap.String("groupID", groupID),
+			zap.Error(err))
+	}
+
+	return nil
+}
+
+// CancelDraw stops the draw in progress: a redraw goes back to the results of the
+// previous round, a first draw unlocks the membership of the group
+func (s *GroupService) CancelDraw(groupID string, adminID string) error {
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionCancelDraw); err != nil {
+			return err
+		}
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err
+		}
+		if group.IsDrawn() {
+			return repos.Groups().SetGroupState(groupID, models.GroupStateDrawn)
 		}
+		return repos.Groups().SetGroupState(groupID, models.GroupStateOpen)
+	})
+	if err != nil {
+		return err
+	}
 
-		encrypted, err := jwe.Encrypt([]byte(userID), jwe.WithKey(jwa.RSA_OAEP_256(), pubKey))
+	s.drawSessionMu.Lock()
+	delete(s.drawSessionStore, groupID)
+	s.drawSessionMu.Unlock()
+
+	return nil
+}
+
+// ArchiveGroup makes a group read-only, on behalf of adminID
+func (s *GroupService) ArchiveGroup(groupID string, adminID string) error {
+	return s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to encrypt user ID: %w", err) // 500
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionArchive); err != nil {
+			return err
+		}
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err
 		}
+		return repos.Groups().SetGroupState(groupID, models.GroupStateArchived)
+	})
+}
 
-		results[i] = string(encrypted)
+// lockGroup locks the group within a unit of work and maps its errors
+func lockGroup(repos database.Repositories, groupID string) (*models.Group, error) {
+	group, err := repos.Groups().LockGroup(groupID)
+	if err != nil {
+		if errors.Is(err, database.ErrGroupNotFound) {
+			return nil, groupService.ErrGroupNotFound
+		}
+		return nil, err
 	}
+	return group, nil
+}
 
-	group.Results = results
-	// Create a copy of the group before updating
-	groupCopy := *group
-	if err := s.groupStore.UpdateGroup(groupCopy); err != nil {
-		return nil, fmt.Errorf("failed to update group: %w", err)
+// groupMember returns the member userID of a locked group
+func groupMember(group *models.Group, userID string) (*models.User, error) {
+	for i := range group.Users {
+		if group.Users[i].ID == userID {
+			return &group.Users[i], nil
+		}
 	}
+	return nil, userService.ErrUserNotFound
+}
 
-	if err := s.mailService.SendDrawCompletionNotification(group, group.Users); err != nil {
-		s.logger.Error("Failed to send draw completion emails",
-			zap.String("groupID", groupID),
-			zap.Error(err))
+// groupAdmin returns the member adminID of group if they are its admin. The admin
+// flag of the token may be stale, admin actions check the current admin with it.
+func groupAdmin(group *models.Group, adminID string) (*models.User, error) {
+	admin, err := groupMember(group, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if !admin.IsAdmin {
+		return nil, userService.ErrNotAdmin
 	}
+	return admin, nil
+}
 
-	return results, nil
+// GetGroupAdmin returns the group and its member adminID, if they are its admin
+func (s *GroupService) GetGroupAdmin(groupID string, adminID string) (*models.Group, *models.User, error) {
+	group, err := s.GetGroup(groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	admin, err := groupAdmin(group, adminID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, admin, nil
+}
+
+// sameMembers tells if users are exactly the users identified by userIDs
+func sameMembers(users []models.User, userIDs []string) bool {
+	if len(users) != len(userIDs) {
+		return false
+	}
+	members := make(map[string]bool, len(users))
+	for _, user := range users {
+		members[user.ID] = true
+	}
+	for _, userID := range userIDs {
+		if !members[userID] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTags tells if proofs hold the tags of the session, each as many times
+func sameTags(proofs []string, tags []string) bool {
+	counts := make(map[string]int, len(tags))
+	for _, tag := range tags {
+		counts[tag]++
+	}
+	for _, proof := range proofs {
+		counts[proof]--
+		if counts[proof] < 0 {
+			return false
+		}
+	}
+	return len(proofs) == len(tags)
+}
+
+// GetResults returns every result of the latest draw. Each member keeps the one their
+// private key decrypts, so no request tells which result belongs to whom.
+func (s *GroupService) GetResults(groupID string) ([]models.DrawResult, error) {
+	group, err := s.GetGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !group.IsDrawn() {
+		return nil, nil
+	}
+
+	return s.groupStore.GetDrawResults(groupID, group.DrawRound)
+}
+
+// parseDrawKey parses a public key sent for a draw and computes its tag
+func parseDrawKey(raw string) (jwk.Key, string, error) {
+	key, err := jwk.ParseKey([]byte(raw))
+	if err != nil {
+		return nil, "", &groupService.InvalidPublicKeyError{Err: fmt.Errorf("failed to parse public key: %w", err)}
+	}
+
+	if key.KeyType() != jwa.RSA() {
+		return nil, "", &groupService.InvalidPublicKeyError{Err: errors.New("invalid public key type")}
+	}
+
+	if alg, exist := key.Algorithm(); !exist || alg != jwa.RSA_OAEP_256() {
+		return nil, "", &groupService.InvalidPublicKeyError{Err: errors.New("invalid public key algorithm")}
+	}
+
+	keyTag, err := KeyTag(key)
+	if err != nil {
+		return nil, "", &groupService.InvalidPublicKeyError{Err: fmt.Errorf("failed to compute key thumbprint: %w", err)}
+	}
+	return key, keyTag, nil
+}
+
+// KeyTag identifies a public key by its RFC 7638 thumbprint, base64url encoded
+func KeyTag(key jwk.Key) (string, error) {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// sameDate tells if two optional dates are the same instant
+func sameDate(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
 }
@@ -0,0 +1,361 @@
+package services
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/groupService"
+	"onxzy/super-santa-server/services/mailboxService"
+	"onxzy/super-santa-server/services/userService"
+	"strconv"
+	"testing"
+)
+
+func (s *testServices) groupState(t *testing.T, groupID string) *models.Group {
+	t.Helper()
+
+	group, err := s.groups.GetGroup(groupID)
+	if err != nil {
+		t.Fatalf("GetGroup: %v", err)
+	}
+	return group
+}
+
+func TestCancelFirstDrawReopensGroup(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+
+	if _, err := s.groups.InitDraw(group.ID, adminOf(t, group)); err != nil {
+		t.Fatalf("InitDraw: %v", err)
+	}
+	if err := s.groups.CancelDraw(group.ID, adminOf(t, group)); err != nil {
+		t.Fatalf("CancelDraw: %v", err)
+	}
+	if state := s.groupState(t, group.ID).State; state != models.GroupStateOpen {
+		t.Errorf("expected state %q, got %q", models.GroupStateOpen, state)
+	}
+}
+
+func TestRedraw(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	keys, privateKeys := drawKeyPairs(t, 3)
+
+	s.draw(t, group, keys)
+	group = s.groupState(t, group.ID)
+	if group.State != models.GroupStateDrawn || group.DrawRound != 1 {
+		t.Fatalf("expected round 1 drawn, got round %d %q", group.DrawRound, group.State)
+	}
+
+	result := s.openResults(t, group.ID, privateKeys)[0][0]
+	recipient := result.UserID
+	santa := otherMember(t, group, recipient)
+	thread := &models.MailboxThread{RecipientID: recipient, ReplyKey: "reply", SenderKey: "sender", AddressKey: envelope("RSA-OAEP-256")}
+	if err := s.mailbox.StartThread(group.ID, santa, thread, "token", result.ThreadToken, "hello"); err != nil {
+		t.Fatalf("StartThread: %v", err)
+	}
+	if _, err := s.mailbox.SetAddress(group.ID, recipient, thread.ID, envelope("dir")); err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+
+	// A cancelled redraw goes back to the previous round
+	if _, err := s.groups.InitDraw(group.ID, adminOf(t, group)); err != nil {
+		t.Fatalf("InitDraw: %v", err)
+	}
+	if state := s.groupState(t, group.ID).State; state != models.GroupStateDrawing {
+		t.Fatalf("expected state %q, got %q", models.GroupStateDrawing, state)
+	}
+	if err := s.groups.CancelDraw(group.ID, adminOf(t, group)); err != nil {
+		t.Fatalf("CancelDraw: %v", err)
+	}
+	group = s.groupState(t, group.ID)
+	if group.State != models.GroupStateDrawn || group.DrawRound != 1 {
+		t.Fatalf("expected round 1 drawn after cancel, got round %d %q", group.DrawRound, group.State)
+	}
+	if _, err := s.mailbox.PostMessage(group.ID, recipient, thread.ID, "", "reply"); err != nil {
+		t.Errorf("expected the thread to stay open after a cancelled redraw, got %v", err)
+	}
+
+	s.draw(t, group, keys)
+	group = s.groupState(t, group.ID)
+	if group.State != models.GroupStateDrawn || group.DrawRound != 2 {
+		t.Fatalf("expected round 2 drawn, got round %d %q", group.DrawRound, group.State)
+	}
+
+	results, err := s.groups.GetResults(group.ID)
+	if err != nil {
+		t.Fatalf("GetResults: %v", err)
+	}
+	if len(results) != 3 || results[0].Round != 2 {
+		t.Errorf("expected the results of round 2 only, got %+v", results)
+	}
+
+	// Threads and addresses belong to the previous round
+	if _, err := s.mailbox.PostMessage(group.ID, recipient, thread.ID, "", "reply"); !errors.Is(err, mailboxService.ErrThreadClosed) {
+		t.Errorf("expected ErrThreadClosed, got %v", err)
+	}
+	if _, err := s.mailbox.SetAddress(group.ID, recipient, thread.ID, envelope("dir")); !errors.Is(err, mailboxService.ErrThreadClosed) {
+		t.Errorf("expected ErrThreadClosed for the address, got %v", err)
+	}
+	threads, err := s.mailbox.GetThreads(group.ID, recipient)
+	if err != nil {
+		t.Fatalf("GetThreads: %v", err)
+	}
+	if len(threads) != 0 {
+		t.Errorf("expected no thread, and so no address, in round 2, got %d", len(threads))
+	}
+}
+
+// With several gifts per person, every member gives to and receives from as many
+// different members, never themself
+func TestDrawSeveralGifts(t *testing.T) {
+	for _, gifts := range []int{2, 3} {
+		t.Run(strconv.Itoa(gifts), func(t *testing.T) {
+			s := newTestServices(t)
+			n := gifts + 2
+			group := s.createGroup(t, n)
+			admin := adminOf(t, group)
+			if _, err := s.groups.UpdateSettings(group.ID, admin, &groupService.GroupSettings{GiftsPerPerson: gifts}); err != nil {
+				t.Fatalf("UpdateSettings: %v", err)
+			}
+			publicKeys, privateKeys := drawKeyPairs(t, n)
+
+			// finish inits a draw and finishes it, the g-th gift of the i-th member of
+			// the order coming from the member shifts[g] places further
+			finish := func(shifts ...int) error {
+				order, err := s.groups.InitDraw(group.ID, admin)
+				if err != nil {
+					t.Fatalf("InitDraw: %v", err)
+				}
+				if order.GiftsPerPerson != gifts {
+					t.Fatalf("expected %d gifts per person, got %d", gifts, order.GiftsPerPerson)
+				}
+				var keys []string
+				for _, shift := range shifts {
+					for i := range n {
+						owner, _ := strconv.Atoi(order.PublicKeys[(i+shift)%n])
+						keys = append(keys, publicKeys[owner])
+					}
+				}
+				return s.groups.FinishDraw(group.ID, admin, keys, nil)
+			}
+			var invalidPublicKeyError *groupService.InvalidPublicKeyError
+
+			// One key per member and per gift
+			if err := finish(1); !errors.As(err, &invalidPublicKeyError) {
+				t.Errorf("expected InvalidPublicKeyError with the keys of a single gift, got %v", err)
+			}
+			// Each gift needs a different shift, a repeated one gives twice to the same members
+			shifts := make([]int, gifts)
+			for g := range shifts {
+				shifts[g] = g + 1
+			}
+			repeated := append(shifts[:gifts-1:gifts-1], 1)
+			if err := finish(repeated...); !errors.As(err, &invalidPublicKeyError) {
+				t.Errorf("expected InvalidPublicKeyError when a member receives twice from the same giver, got %v", err)
+			}
+			if err := finish(shifts...); err != nil {
+				t.Fatalf("FinishDraw: %v", err)
+			}
+
+			// Every member gets the whole round and keeps the results their key decrypts
+			results, err := s.groups.GetResults(group.ID)
+			if err != nil {
+				t.Fatalf("GetResults: %v", err)
+			}
+			if len(results) != gifts*len(group.Users) {
+				t.Fatalf("expected %d results, got %d", gifts*len(group.Users), len(results))
+			}
+
+			received := make(map[string]int)
+			threadTokens := make(map[string]bool)
+			for owner, user := range group.Users {
+				recipients := make(map[string]bool)
+				for _, payload := range s.openResults(t, group.ID, privateKeys[owner:owner+1])[0] {
+					if payload.UserID == user.ID {
+						t.Errorf("expected %s not to give to themself", user.Username)
+					}
+					if recipients[payload.UserID] {
+						t.Errorf("expected %s to give once to each recipient", user.Username)
+					}
+					if payload.ThreadToken == "" || threadTokens[payload.ThreadToken] {
+						t.Errorf("expected a thread token of its own in each result")
+					}
+					recipients[payload.UserID] = true
+					threadTokens[payload.ThreadToken] = true
+					received[payload.UserID]++
+				}
+				if len(recipients) != gifts {
+					t.Errorf("expected %s to give %d gifts, got %d", user.Username, gifts, len(recipients))
+				}
+			}
+			for _, user := range group.Users {
+				if received[user.ID] != gifts {
+					t.Errorf("expected %s to receive %d gifts, got %d", user.Username, gifts, received[user.ID])
+				}
+			}
+		})
+	}
+}
+
+// With a rule, the server checks the team of each giver given by the admin's client
+func TestFinishDrawChecksTeamProofs(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 4)
+	admin := adminOf(t, group)
+	for i, team := range []string{"A", "A", "B", "B"} {
+		if _, err := s.users.SetTeam(group.ID, admin, group.Users[i].ID, team); err != nil {
+			t.Fatalf("SetTeam: %v", err)
+		}
+	}
+	rule := models.DrawRuleOutsideTeam
+	if _, err := s.groups.UpdateSettings(group.ID, admin, &groupService.GroupSettings{DrawRule: &rule}); err != nil {
+		t.Fatalf("UpdateSettings: %v", err)
+	}
+	keys := drawKeys(t, 4)
+
+	// finish inits a draw and finishes it, each member receiving from the other team when
+	// crossTeams and from their own otherwise, the givers proving the teams of proofOf
+	finish := func(crossTeams bool, proofOf func(tags []string, giver int) string) error {
+		order, err := s.groups.InitDraw(group.ID, admin)
+		if err != nil {
+			t.Fatalf("InitDraw: %v", err)
+		}
+		tags := order.TeamTags
+		if len(tags) != 4 {
+			t.Fatalf("expected 4 team tags, got %v", tags)
+		}
+		var first, second []int
+		for i, tag := range tags {
+			if tag == tags[0] {
+				first = append(first, i)
+			} else {
+				second = append(second, i)
+			}
+		}
+		givers := make([]int, 4)
+		for j := range 2 {
+			if crossTeams {
+				givers[first[j]], givers[second[j]] = second[j], first[j]
+			} else {
+				givers[first[j]], givers[second[j]] = first[1-j], second[1-j]
+			}
+		}
+
+		publicKeys := make([]string, 4)
+		var proofs []string
+		for i, giver := range givers {
+			publicKeys[i] = keys[giver]
+			if proofOf != nil {
+				proofs = append(proofs, proofOf(tags, giver))
+			}
+		}
+		return s.groups.FinishDraw(group.ID, admin, publicKeys, proofs)
+	}
+	honest := func(tags []string, giver int) string { return tags[giver] }
+
+	if err := finish(true, nil); !errors.Is(err, groupService.ErrInvalidTeamProofs) {
+		t.Errorf("expected ErrInvalidTeamProofs without proofs, got %v", err)
+	}
+	if err := finish(false, honest); !errors.Is(err, groupService.ErrInvalidTeamProofs) {
+		t.Errorf("expected ErrInvalidTeamProofs when giving within a team, got %v", err)
+	}
+	noTeam := func(tags []string, giver int) string { return "" }
+	if err := finish(false, noTeam); !errors.Is(err, groupService.ErrInvalidTeamProofs) {
+		t.Errorf("expected ErrInvalidTeamProofs when the proofs are not the teams of the session, got %v", err)
+	}
+	if err := finish(true, honest); err != nil {
+		t.Fatalf("FinishDraw: %v", err)
+	}
+	if state := s.groupState(t, group.ID).State; state != models.GroupStateDrawn {
+		t.Errorf("expected state %q, got %q", models.GroupStateDrawn, state)
+	}
+}
+
+func TestDrawnGroupActions(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	s.draw(t, group, drawKeys(t, 3))
+
+	if err := s.groups.CancelDraw(group.ID, adminOf(t, group)); !errors.Is(err, groupService.ErrDrawAlreadyDone) {
+		t.Errorf("CancelDraw: expected ErrDrawAlreadyDone, got %v", err)
+	}
+	if err := s.groups.FinishDraw(group.ID, adminOf(t, group), drawKeys(t, 3), nil); !errors.Is(err, groupService.ErrDrawSessionNotFound) {
+		t.Errorf("FinishDraw: expected ErrDrawSessionNotFound, got %v", err)
+	}
+}
+
+// Admin actions check the stored admin, the admin flag of a token outlives a transfer
+func TestAdminActionsCheckStoredAdmin(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 4)
+	formerAdmin := adminOf(t, group)
+	var newAdmin, member string
+	for _, user := range group.Users {
+		if user.ID == formerAdmin {
+			continue
+		}
+		if newAdmin == "" {
+			newAdmin = user.ID
+		} else if member == "" {
+			member = user.ID
+		}
+	}
+	if _, err := s.users.TransferAdmin(group.ID, formerAdmin, newAdmin); err != nil {
+		t.Fatalf("TransferAdmin: %v", err)
+	}
+
+	actions := map[string]func() error{
+		"UpdateSettings": func() error {
+			_, err := s.groups.UpdateSettings(group.ID, formerAdmin, &groupService.GroupSettings{})
+			return err
+		},
+		"InitDraw": func() error {
+			_, err := s.groups.InitDraw(group.ID, formerAdmin)
+			return err
+		},
+		"FinishDraw": func() error {
+			return s.groups.FinishDraw(group.ID, formerAdmin, nil, nil)
+		},
+		"ArchiveGroup": func() error {
+			return s.groups.ArchiveGroup(group.ID, formerAdmin)
+		},
+		"DeleteUser": func() error {
+			return s.users.DeleteUser(group.ID, formerAdmin, member)
+		},
+		"GetGiftSummary": func() error {
+			_, err := s.mailbox.GetGiftSummary(group.ID, formerAdmin)
+			return err
+		},
+		"GetGroupAdmin": func() error {
+			_, _, err := s.groups.GetGroupAdmin(group.ID, formerAdmin)
+			return err
+		},
+	}
+	for name, action := range actions {
+		if err := action(); !errors.Is(err, userService.ErrNotAdmin) {
+			t.Errorf("%s: expected ErrNotAdmin, got %v", name, err)
+		}
+	}
+
+	// The draw session of the new admin can't be cancelled by the former one
+	if _, err := s.groups.InitDraw(group.ID, newAdmin); err != nil {
+		t.Fatalf("InitDraw: %v", err)
+	}
+	if err := s.groups.CancelDraw(group.ID, formerAdmin); !errors.Is(err, userService.ErrNotAdmin) {
+		t.Errorf("CancelDraw: expected ErrNotAdmin, got %v", err)
+	}
+	if err := s.groups.CancelDraw(group.ID, newAdmin); err != nil {
+		t.Fatalf("CancelDraw: %v", err)
+	}
+
+	// The former admin is a member like the others and may leave, not the new one
+	if err := s.users.LeaveGroup(group.ID, newAdmin); !errors.Is(err, userService.ErrUserIsAdmin) {
+		t.Errorf("LeaveGroup: expected ErrUserIsAdmin, got %v", err)
+	}
+	if err := s.users.LeaveGroup(group.ID, formerAdmin); err != nil {
+		t.Errorf("LeaveGroup: %v", err)
+	}
+	if err := s.users.DeleteUser(group.ID, newAdmin, member); err != nil {
+		t.Errorf("DeleteUser: %v", err)
+	}
+}
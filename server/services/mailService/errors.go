@@ -0,0 +1,33 @@
+package mailService
+
+import "errors"
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrMailDisabled     = errors.New("email sending is disabled")
+)
+
+type InvalidTemplateError struct {
+	Template string
+	Err      error
+}
+
+func (e *InvalidTemplateError) Error() string {
+	return "invalid email template " + e.Template + ": " + e.Err.Error()
+}
+
+func (e *InvalidTemplateError) Unwrap() error {
+	return e.Err
+}
+
+type InvalidDKIMConfigError struct {
+	Err error
+}
+
+func (e *InvalidDKIMConfigError) Error() string {
+	return "invalid DKIM configuration: " + e.Err.Error()
+}
+
+func (e *InvalidDKIMConfigError) Unwrap() error {
+	return e.Err
+}
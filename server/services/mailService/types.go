@@ -0,0 +1,131 @@
+package mailService
+
+import "onxzy/super-santa-server/services/notificationService"
+
+const (
+	TemplateGroupCreated      = "group_created"
+	TemplateUserJoinedAdmin   = "user_joined_admin"
+	TemplateUserJoinedWelcome = "user_joined_welcome"
+	TemplateDrawComplete      = "draw_complete"
+	TemplateDigest            = "digest"
+	TemplatePurgeWarning      = "purge_warning"
+	TemplateMailboxMessage    = "mailbox_message"
+	TemplateGiftStatus        = "gift_status"
+	TemplateEmailConfirm      = "email_confirm"
+)
+
+// Templates lists every template the mail service needs, the server refuses to start if one is missing
+var Templates = []string{
+	TemplateGroupCreated,
+	TemplateUserJoinedAdmin,
+	TemplateUserJoinedWelcome,
+	TemplateDrawComplete,
+	TemplateDigest,
+	TemplatePurgeWarning,
+	TemplateMailboxMessage,
+	TemplateGiftStatus,
+	TemplateEmailConfirm,
+}
+
+// EventTemplates maps each notification event to its dedicated template
+var EventTemplates = map[notificationService.Event]string{
+	notificationService.EventGroupCreated: TemplateGroupCreated,
+	notificationService.EventUserJoined:   TemplateUserJoinedAdmin,
+	notificationService.EventWelcome:      TemplateUserJoinedWelcome,
+	notificationService.EventDrawComplete: TemplateDrawComplete,
+	notificationService.EventPurgeWarning: TemplatePurgeWarning,
+	notificationService.EventMailbox:      TemplateMailboxMessage,
+	notificationService.EventGiftStatus:   TemplateGiftStatus,
+}
+
+// SampleData holds representative data for each template.
+// Templates are executed against it at startup so that a reference to a key
+// the service never provides is caught before any email is sent.
+var SampleData = map[string]map[string]any{
+	TemplateGroupCreated: {
+		"AdminName":      "Santa",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateUserJoinedAdmin: {
+		"AdminName":      "Santa",
+		"UserName":       "Rudolph",
+		"UserEmail":      "rudolph@example.com",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateUserJoinedWelcome: {
+		"UserName":       "Rudolph",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateDrawComplete: {
+		"UserName":       "Rudolph",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplatePurgeWarning: {
+		"AdminName":      "Santa",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"PurgeDate":      "January 31, 2026",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateMailboxMessage: {
+		"UserName":       "Rudolph",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateGiftStatus: {
+		"UserName":       "Rudolph",
+		"GroupName":      "North Pole",
+		"GroupID":        "00000000-0000-0000-0000-000000000000",
+		"Status":         "shipped",
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+	TemplateEmailConfirm: {
+		"UserName":   "Rudolph",
+		"Email":      "rudolph@example.com",
+		"GroupName":  "North Pole",
+		"ConfirmURL": "http://localhost:8080/api/v1/auth/email/confirm",
+		"AppURL":     "http://localhost:3000",
+	},
+	TemplateDigest: {
+		"UserName": "Rudolph",
+		"Items": []map[string]any{
+			{
+				"Subject": "Secret Santa Draw Complete",
+				"Data": map[string]any{
+					"GroupName": "North Pole",
+					"GroupID":   "00000000-0000-0000-0000-000000000000",
+				},
+			},
+		},
+		"AppURL":         "http://localhost:3000",
+		"UnsubscribeURL": "http://localhost:8080/api/v1/notifications/unsubscribe",
+	},
+}
+
+type Preview struct {
+	Template string `json:"template"`
+	Subject  string `json:"subject"`
+	HTML     string `json:"html"`
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
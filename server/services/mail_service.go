@@ -2,52 +2,227 @@ package services
 
 import (
 	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"onxzy/super-santa-server/database"
 	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/mailService"
+	"onxzy/super-santa-server/services/notificationService"
+	"onxzy/super-santa-server/templates"
 	"onxzy/super-santa-server/utils"
+	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/emersion/go-msgauth/dkim"
+	"github.com/google/uuid"
+	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 type MailService struct {
-	config *utils.Config
-	logger *zap.Logger
+	config              *utils.Config
+	templates           map[string]*template.Template
+	dkimOptions         *dkim.SignOptions // Nil when DKIM signing is disabled
+	notificationService *NotificationService
+	notificationStore   *database.NotificationStore
+	userStore           database.UserRepository
+	logger              *zap.Logger
 }
 
-func NewMailService(config *utils.Config, logger *zap.Logger) *MailService {
-	return &MailService{
-		config: config,
-		logger: logger.Named("mail-service"),
+func NewMailService(lc fx.Lifecycle, config *utils.Config, notificationService *NotificationService, notificationStore *database.NotificationStore, userStore database.UserRepository, logger *zap.Logger) (*MailService, error) {
+	s := &MailService{
+		config:              config,
+		notificationService: notificationService,
+		notificationStore:   notificationStore,
+		userStore:           userStore,
+		logger:              logger.Named("mail-service"),
 	}
+
+	// Fail at startup rather than when the first email is sent
+	templates, err := s.loadTemplates()
+	if err != nil {
+		return nil, err
+	}
+	s.templates = templates
+
+	dkimOptions, err := s.loadDKIM()
+	if err != nil {
+		return nil, err
+	}
+	s.dkimOptions = dkimOptions
+
+	if s.config.Mail.DigestInterval <= 0 {
+		return nil, fmt.Errorf("mail.digest_interval must be a positive number of seconds, got %d", s.config.Mail.DigestInterval)
+	}
+
+	stop := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.runDigest(stop)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stop)
+			return nil
+		},
+	})
+
+	return s, nil
+}
+
+// loadTemplates parses every required template, preferring the override
+// directory when configured and falling back to the embedded templates.
+func (s *MailService) loadTemplates() (map[string]*template.Template, error) {
+	loaded := make(map[string]*template.Template, len(mailService.Templates))
+
+	for _, name := range mailService.Templates {
+		fileName := name + ".html"
+
+		var content []byte
+		source := "embedded"
+		if s.config.Mail.TemplatesDir != "" {
+			overridePath := filepath.Join(s.config.Mail.TemplatesDir, fileName)
+			data, err := os.ReadFile(overridePath)
+			if err == nil {
+				content = data
+				source = overridePath
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, &mailService.InvalidTemplateError{Template: name, Err: err}
+			}
+		}
+		if content == nil {
+			data, err := fs.ReadFile(templates.Emails, path.Join("emails", fileName))
+			if err != nil {
+				return nil, &mailService.InvalidTemplateError{Template: name, Err: mailService.ErrTemplateNotFound}
+			}
+			content = data
+		}
+
+		tmpl, err := template.New(name).Parse(string(content))
+		if err != nil {
+			return nil, &mailService.InvalidTemplateError{Template: name, Err: err}
+		}
+
+		// Dry run against sample data, referencing a key the service never provides is an error
+		check, err := tmpl.Clone()
+		if err != nil {
+			return nil, &mailService.InvalidTemplateError{Template: name, Err: err}
+		}
+		if err := check.Option("missingkey=error").Execute(io.Discard, mailService.SampleData[name]); err != nil {
+			return nil, &mailService.InvalidTemplateError{Template: name, Err: err}
+		}
+
+		s.logger.Debug("Email template loaded", zap.String("template", name), zap.String("source", source))
+		loaded[name] = tmpl
+	}
+
+	return loaded, nil
+}
+
+// loadDKIM reads the DKIM signing key, signing is enabled only when the whole mail.dkim section is set
+func (s *MailService) loadDKIM() (*dkim.SignOptions, error) {
+	dkimConfig := s.config.Mail.DKIM
+	if dkimConfig.Selector == "" && dkimConfig.Domain == "" && dkimConfig.PrivateKeyPath == "" {
+		return nil, nil
+	}
+	if dkimConfig.Selector == "" || dkimConfig.Domain == "" || dkimConfig.PrivateKeyPath == "" {
+		return nil, &mailService.InvalidDKIMConfigError{Err: errors.New("selector, domain and private_key_path are all required")}
+	}
+
+	keyPEM, err := os.ReadFile(dkimConfig.PrivateKeyPath)
+	if err != nil {
+		return nil, &mailService.InvalidDKIMConfigError{Err: err}
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, &mailService.InvalidDKIMConfigError{Err: errors.New("private key is not PEM encoded")}
+	}
+
+	var signer crypto.Signer
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		signer, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var key any
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if signer, ok = key.(crypto.Signer); !ok {
+				err = errors.New("unsupported private key type")
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, &mailService.InvalidDKIMConfigError{Err: err}
+	}
+
+	s.logger.Info("DKIM signing enabled",
+		zap.String("domain", dkimConfig.Domain),
+		zap.String("selector", dkimConfig.Selector))
+
+	return &dkim.SignOptions{
+		Domain:   dkimConfig.Domain,
+		Selector: dkimConfig.Selector,
+		Signer:   signer,
+		HeaderKeys: []string{
+			"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+			"List-Unsubscribe", "List-Unsubscribe-Post",
+		},
+	}, nil
+}
+
+// render executes a template loaded at startup
+func (s *MailService) render(templateName string, data map[string]any) ([]byte, error) {
+	tmpl, exists := s.templates[templateName]
+	if !exists {
+		return nil, &mailService.InvalidTemplateError{Template: templateName, Err: mailService.ErrTemplateNotFound}
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
 }
 
 type MailData struct {
-	ToDisplay []string
-	ToMail    []string
-	Subject   string
-	Data      map[string]any
+	ToDisplay   []string
+	ToMail      []string
+	Subject     string
+	Headers     map[string]string // Extra headers, e.g. List-Unsubscribe
+	Data        map[string]any
+	Attachments []mailService.Attachment
 }
 
 func (s *MailService) sendMail(templateName string, mailData *MailData) error {
 	if !s.config.Mail.Enabled {
 		s.logger.Debug("Email sending is disabled in config, skipping",
 			zap.String("template", templateName))
-		return nil
+		return mailService.ErrMailDisabled
 	}
 
-	// Load template
-	templatePath := filepath.Join(s.config.Mail.TemplatesDir, templateName+".html")
-	tmpl, err := template.ParseFiles(templatePath)
+	body, err := s.render(templateName, mailData.Data)
 	if err != nil {
-		s.logger.Error("Failed to parse email template", zap.String("template", templateName), zap.Error(err))
-		return fmt.Errorf("failed to parse email template: %w", err)
-	}
-
-	var body bytes.Buffer
-	if err := tmpl.Execute(&body, mailData.Data); err != nil {
 		s.logger.Error("Failed to execute email template", zap.String("template", templateName), zap.Error(err))
 		return fmt.Errorf("failed to execute email template: %w", err)
 	}
@@ -56,60 +231,434 @@ func (s *MailService) sendMail(templateName string, mailData *MailData) error {
 	smtpConfig := s.config.Mail.SMTP
 	auth := smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, smtpConfig.Host)
 
-	// Build the email
+	message, err := s.buildMessage(mailData, body)
+	if err != nil {
+		s.logger.Error("Failed to build email", zap.String("template", templateName), zap.Error(err))
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	// Send email
+	addr := fmt.Sprintf("%s:%d", smtpConfig.Host, smtpConfig.Port)
+	if err := smtp.SendMail(addr, auth, smtpConfig.FromEmail, mailData.ToMail, message); err != nil {
+		s.logger.Error("Failed to send email", zap.Strings("to", mailData.ToMail), zap.Error(err))
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	s.logger.Info("Email sent successfully", zap.Strings("to", mailData.ToMail), zap.String("subject", mailData.Subject))
+	return nil
+}
+
+// buildMessage assembles the MIME message, switching to multipart/mixed when there are attachments
+func (s *MailService) buildMessage(mailData *MailData, body []byte) ([]byte, error) {
+	smtpConfig := s.config.Mail.SMTP
+
 	headers := make(map[string]string)
 	headers["From"] = fmt.Sprintf("%s <%s>", smtpConfig.FromName, smtpConfig.FromEmail)
 	headers["To"] = mailData.ToDisplay[0] // For display purposes
 	headers["Subject"] = mailData.Subject
+	headers["Date"] = time.Now().Format(time.RFC1123Z)
+	headers["Message-ID"] = fmt.Sprintf("<%s@%s>", uuid.NewString(), messageIDDomain(smtpConfig.FromEmail))
 	headers["MIME-Version"] = "1.0"
 	headers["Content-Type"] = "text/html; charset=UTF-8"
+	for k, v := range mailData.Headers {
+		headers[k] = v
+	}
+
+	var content bytes.Buffer
+	if len(mailData.Attachments) == 0 {
+		content.Write(body)
+	} else {
+		writer := multipart.NewWriter(&content)
+		headers["Content-Type"] = "multipart/mixed; boundary=" + writer.Boundary()
+
+		htmlPart, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"text/html; charset=UTF-8"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		htmlPart.Write(body)
+
+		for _, attachment := range mailData.Attachments {
+			part, err := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {attachment.ContentType},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			// Base64 lines must not exceed 76 characters
+			encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+			for len(encoded) > 76 {
+				part.Write([]byte(encoded[:76] + "\r\n"))
+				encoded = encoded[76:]
+			}
+			part.Write([]byte(encoded + "\r\n"))
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	}
 
 	var message bytes.Buffer
 	for k, v := range headers {
 		message.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
 	}
 	message.WriteString("\r\n")
-	message.Write(body.Bytes())
+	message.Write(content.Bytes())
 
-	// Send email
-	addr := fmt.Sprintf("%s:%d", smtpConfig.Host, smtpConfig.Port)
-	if err := smtp.SendMail(addr, auth, smtpConfig.FromEmail, mailData.ToMail, message.Bytes()); err != nil {
-		s.logger.Error("Failed to send email", zap.Strings("to", mailData.ToMail), zap.Error(err))
-		return fmt.Errorf("failed to send email: %w", err)
+	if s.dkimOptions == nil {
+		return message.Bytes(), nil
 	}
 
-	s.logger.Info("Email sent successfully", zap.Strings("to", mailData.ToMail), zap.String("subject", mailData.Subject))
-	return nil
+	var signed bytes.Buffer
+	if err := dkim.Sign(&signed, &message, s.dkimOptions); err != nil {
+		return nil, fmt.Errorf("failed to sign email: %w", err)
+	}
+	return signed.Bytes(), nil
 }
 
-// sendMailToUser is a helper function to send an email to a single user
-func (s *MailService) sendMailToUser(templateName string, user models.User, subject string, data map[string]interface{}) {
-	mailData := &MailData{
-		ToMail:    []string{user.Email},
-		ToDisplay: []string{fmt.Sprintf("%s <%s>", user.Username, user.Email)},
-		Subject:   subject,
-		Data:      data,
+// messageIDDomain returns the domain part of the sender address, used to build Message-IDs
+func messageIDDomain(fromEmail string) string {
+	if _, domain, found := strings.Cut(fromEmail, "@"); found && domain != "" {
+		return domain
 	}
+	return "super-santa"
+}
 
-	if err := s.sendMail(templateName, mailData); err != nil {
-		s.logger.Error("Failed to send individual email",
-			zap.String("template", templateName),
-			zap.String("to", user.Email),
+// unsubscribeURL builds the one-click unsubscribe link for a user and event
+func (s *MailService) unsubscribeURL(userID string, event notificationService.Event) string {
+	token := s.notificationService.CreateUnsubscribeToken(userID, event)
+	return strings.TrimRight(s.config.Host.ApiURL, "/") + "/notifications/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// unsubscribeHeaders returns the RFC 2369 and RFC 8058 one-click unsubscribe headers
+func (s *MailService) unsubscribeHeaders(unsubscribeURL string) map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// sendMailToUser is a helper function to send an email to a single user.
+// It honours the user's notification preferences: muted events are dropped and
+// digest users get the notification queued for the next digest.
+func (s *MailService) sendMailToUser(event notificationService.Event, user models.User, subject string, data map[string]any, attachments ...mailService.Attachment) {
+	templateName := mailService.EventTemplates[event]
+
+	delivery, err := s.notificationService.GetDelivery(user.ID, event)
+	if err != nil {
+		s.logger.Error("Failed to get notification preferences",
+			zap.String("userID", user.ID),
+			zap.String("event", string(event)),
+			zap.Error(err))
+		return
+	}
+	if delivery == notificationService.DeliveryNone {
+		s.logger.Debug("Event muted by user, skipping",
+			zap.String("userID", user.ID),
+			zap.String("event", string(event)))
+		return
+	}
+
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		s.logger.Error("Failed to encode notification data", zap.String("event", string(event)), zap.Error(err))
+		return
+	}
+	notification := &models.Notification{
+		UserID:   user.ID,
+		Event:    string(event),
+		Subject:  subject,
+		Template: templateName,
+		Data:     string(encodedData),
+		Delivery: string(delivery),
+	}
+
+	if delivery == notificationService.DeliveryImmediate {
+		unsubscribeURL := s.unsubscribeURL(user.ID, event)
+		data["UnsubscribeURL"] = unsubscribeURL
+
+		mailData := &MailData{
+			ToMail:      []string{user.Email},
+			ToDisplay:   []string{fmt.Sprintf("%s <%s>", user.Username, user.Email)},
+			Subject:     subject,
+			Headers:     s.unsubscribeHeaders(unsubscribeURL),
+			Data:        data,
+			Attachments: attachments,
+		}
+
+		// Undelivered notifications are recorded as pending and retried with the digests
+		if err := s.sendMail(templateName, mailData); err == nil {
+			sentAt := time.Now()
+			notification.SentAt = &sentAt
+		} else if !errors.Is(err, mailService.ErrMailDisabled) {
+			notification.Attempts = 1
+			s.logger.Error("Failed to send individual email",
+				zap.String("template", templateName),
+				zap.String("to", user.Email),
+				zap.Error(err))
+		}
+	}
+
+	if err := s.notificationStore.CreateNotification(notification); err != nil {
+		s.logger.Error("Failed to record notification",
+			zap.String("userID", user.ID),
+			zap.String("event", string(event)),
 			zap.Error(err))
 	}
 }
 
+// Digest
+
+func (s *MailService) runDigest(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(s.config.Mail.DigestInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.RetryNotifications(); err != nil {
+				s.logger.Error("Failed to retry notifications", zap.Error(err))
+			}
+			if err := s.SendDigests(); err != nil {
+				s.logger.Error("Failed to send digests", zap.Error(err))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+const (
+	maxNotificationAttempts = 5                  // Failed deliveries after which a notification is given up
+	maxNotificationAge      = 7 * 24 * time.Hour // Older pending notifications are not retried anymore
+)
+
+// RetryNotifications sends again the immediate notifications that were not delivered,
+// e.g. while email sending was disabled. Attachments are not stored and are left out.
+// A notification is given up after maxNotificationAttempts failures or once older than maxNotificationAge.
+func (s *MailService) RetryNotifications() error {
+	if !s.config.Mail.Enabled {
+		return nil // Kept pending until email sending is enabled
+	}
+
+	pending, err := s.notificationStore.GetRetryableNotifications(string(notificationService.DeliveryImmediate), maxNotificationAttempts, time.Now().Add(-maxNotificationAge))
+	if err != nil {
+		return err
+	}
+
+	for _, notification := range pending {
+		user, err := s.userStore.GetUser(notification.UserID)
+		if err != nil {
+			s.logger.Error("Failed to get notification recipient", zap.String("userID", notification.UserID), zap.Error(err))
+			continue
+		}
+
+		var data map[string]any
+		if err := json.Unmarshal([]byte(notification.Data), &data); err != nil {
+			s.logger.Warn("Skipping malformed notification", zap.String("notificationID", notification.ID), zap.Error(err))
+			continue
+		}
+		unsubscribeURL := s.unsubscribeURL(user.ID, notificationService.Event(notification.Event))
+		data["UnsubscribeURL"] = unsubscribeURL
+
+		mailData := &MailData{
+			ToMail:    []string{user.Email},
+			ToDisplay: []string{fmt.Sprintf("%s <%s>", user.Username, user.Email)},
+			Subject:   notification.Subject,
+			Headers:   s.unsubscribeHeaders(unsubscribeURL),
+			Data:      data,
+		}
+		if err := s.sendMail(notification.Template, mailData); err != nil {
+			s.logger.Error("Failed to resend notification", zap.String("notificationID", notification.ID), zap.Error(err))
+			if err := s.notificationStore.RecordNotificationFailure(notification.ID); err != nil {
+				s.logger.Error("Failed to record notification failure", zap.String("notificationID", notification.ID), zap.Error(err))
+			} else if notification.Attempts+1 >= maxNotificationAttempts {
+				s.logger.Warn("Giving up notification", zap.String("notificationID", notification.ID), zap.Int("attempts", notification.Attempts+1))
+			}
+			continue
+		}
+
+		if err := s.notificationStore.MarkNotificationsSent([]string{notification.ID}, time.Now()); err != nil {
+			s.logger.Error("Failed to mark notification as sent", zap.String("notificationID", notification.ID), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// SendDigests sends one email per user gathering every queued digest notification.
+// Nothing is marked as sent while email sending is disabled.
+func (s *MailService) SendDigests() error {
+	if !s.config.Mail.Enabled {
+		return nil
+	}
+
+	pending, err := s.notificationStore.GetPendingNotifications(string(notificationService.DeliveryDigest))
+	if err != nil {
+		return err
+	}
+
+	byUser := make(map[string][]models.Notification)
+	for _, notification := range pending {
+		byUser[notification.UserID] = append(byUser[notification.UserID], notification)
+	}
+
+	for userID, notifications := range byUser {
+		user, err := s.userStore.GetUser(userID)
+		if err != nil {
+			s.logger.Error("Failed to get digest recipient", zap.String("userID", userID), zap.Error(err))
+			continue
+		}
+
+		items := make([]map[string]any, 0, len(notifications))
+		ids := make([]string, 0, len(notifications))
+		for _, notification := range notifications {
+			var data map[string]any
+			if err := json.Unmarshal([]byte(notification.Data), &data); err != nil {
+				s.logger.Warn("Skipping malformed digest notification", zap.String("notificationID", notification.ID), zap.Error(err))
+			}
+			items = append(items, map[string]any{
+				"Subject":   notification.Subject,
+				"CreatedAt": notification.CreatedAt,
+				"Data":      data,
+			})
+			ids = append(ids, notification.ID)
+		}
+
+		unsubscribeURL := s.unsubscribeURL(user.ID, notificationService.EventAll)
+		mailData := &MailData{
+			ToMail:    []string{user.Email},
+			ToDisplay: []string{fmt.Sprintf("%s <%s>", user.Username, user.Email)},
+			Subject:   fmt.Sprintf("Secret Santa Digest (%d updates)", len(items)),
+			Headers:   s.unsubscribeHeaders(unsubscribeURL),
+			Data: map[string]any{
+				"UserName":       user.Username,
+				"Items":          items,
+				"AppURL":         s.config.Host.AppURL,
+				"UnsubscribeURL": unsubscribeURL,
+			},
+		}
+
+		if err := s.sendMail(mailService.TemplateDigest, mailData); err != nil {
+			s.logger.Error("Failed to send digest", zap.String("userID", userID), zap.Error(err))
+			continue
+		}
+
+		if err := s.notificationStore.MarkNotificationsSent(ids, time.Now()); err != nil {
+			s.logger.Error("Failed to mark digest notifications as sent", zap.String("userID", userID), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Mail contents, shared by the send paths and the preview
+
+func (s *MailService) drawCompleteMail(group *models.Group, user *models.User) (string, map[string]any) {
+	return "Secret Santa Draw Complete", map[string]any{
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"AppURL":    s.config.Host.AppURL,
+		"UserName":  user.Username, // Personalize with username
+	}
+}
+
+func (s *MailService) groupCreatedMail(group *models.Group, admin *models.User) (string, map[string]any) {
+	return fmt.Sprintf("Secret Santa Group '%s' Created", group.Name), map[string]any{
+		"AdminName": admin.Username,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+func (s *MailService) userJoinedAdminMail(group *models.Group, newUser *models.User, admin *models.User) (string, map[string]any) {
+	return fmt.Sprintf("New User Joined '%s'", group.Name), map[string]any{
+		"AdminName": admin.Username,
+		"UserName":  newUser.Username,
+		"UserEmail": newUser.Email,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+func (s *MailService) welcomeMail(group *models.Group, newUser *models.User) (string, map[string]any) {
+	return fmt.Sprintf("Welcome to Secret Santa Group '%s'", group.Name), map[string]any{
+		"UserName":  newUser.Username,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+func (s *MailService) purgeWarningMail(group *models.Group, admin *models.User, purgeAt time.Time) (string, map[string]any) {
+	return fmt.Sprintf("Secret Santa Group '%s' Will Be Deleted", group.Name), map[string]any{
+		"AdminName": admin.Username,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"PurgeDate": purgeAt.Format("January 2, 2006"),
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+// mailboxMessageMail tells a member they have a message, nothing in it may hint at the santa
+func (s *MailService) mailboxMessageMail(group *models.Group, recipient *models.User) (string, map[string]any) {
+	return "A Message From Your Secret Santa", map[string]any{
+		"UserName":  recipient.Username,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+// giftStatusMail tells a member how far their santa got with their gift
+func (s *MailService) giftStatusMail(group *models.Group, recipient *models.User, status models.GiftStatus) (string, map[string]any) {
+	subject := "Your Secret Santa Bought Your Gift"
+	if status == models.GiftStatusShipped {
+		subject = "Your Secret Santa Gift Is On Its Way"
+	}
+	return subject, map[string]any{
+		"UserName":  recipient.Username,
+		"GroupName": group.Name,
+		"GroupID":   group.ID,
+		"Status":    string(status),
+		"AppURL":    s.config.Host.AppURL,
+	}
+}
+
+// emailConfirmMail asks a member to confirm their new email, with the link carrying token
+func (s *MailService) emailConfirmMail(group *models.Group, user *models.User, token string) (string, map[string]any) {
+	return "Confirm Your New Email Address", map[string]any{
+		"UserName":   user.Username,
+		"Email":      user.PendingEmail,
+		"GroupName":  group.Name,
+		"ConfirmURL": strings.TrimRight(s.config.Host.ApiURL, "/") + "/auth/email/confirm?token=" + url.QueryEscape(token),
+		"AppURL":     s.config.Host.AppURL,
+	}
+}
+
 func (s *MailService) SendDrawCompletionNotification(group *models.Group, users []models.User) error {
+	// Attach the gift exchange to the calendar when the date is known
+	var attachments []mailService.Attachment
+	if group.ExchangeDate != nil {
+		attachments = append(attachments, mailService.Attachment{
+			Filename:    "secret-santa.ics",
+			ContentType: "text/calendar; charset=UTF-8; method=PUBLISH",
+			Data:        utils.BuildICS(groupCalendarEvent(group, s.config.Host.AppURL)),
+		})
+	}
+
 	// Send individual emails to each user using goroutines
 	for _, user := range users {
+		subject, data := s.drawCompleteMail(group, &user)
 		// Send in a goroutine to avoid waiting
-		go s.sendMailToUser("draw_complete", user,
-			"Secret Santa Draw Complete", map[string]any{
-				"GroupName": group.Name,
-				"GroupID":   group.ID,
-				"AppURL":    s.config.Host.AppURL,
-				"UserName":  user.Username, // Personalize with username
-			})
+		go s.sendMailToUser(notificationService.EventDrawComplete, user, subject, data, attachments...)
 	}
 
 	s.logger.Info("Draw completion notification process started",
@@ -119,14 +668,8 @@ func (s *MailService) SendDrawCompletionNotification(group *models.Group, users
 }
 
 func (s *MailService) SendGroupCreationNotification(group *models.Group, admin *models.User) error {
-
-	go s.sendMailToUser("group_created", *admin,
-		fmt.Sprintf("Secret Santa Group '%s' Created", group.Name), map[string]any{
-			"AdminName": admin.Username,
-			"GroupName": group.Name,
-			"GroupID":   group.ID,
-			"AppURL":    s.config.Host.AppURL,
-		})
+	subject, data := s.groupCreatedMail(group, admin)
+	go s.sendMailToUser(notificationService.EventGroupCreated, *admin, subject, data)
 
 	s.logger.Info("Group creation notification process started",
 		zap.String("groupName", group.Name),
@@ -135,23 +678,11 @@ func (s *MailService) SendGroupCreationNotification(group *models.Group, admin *
 }
 
 func (s *MailService) SendUserJoinedNotification(group *models.Group, newUser *models.User, admin *models.User) error {
-	go s.sendMailToUser("group_created", *admin,
-		fmt.Sprintf("New User Joined '%s'", group.Name), map[string]any{
-			"AdminName": admin.Username,
-			"UserName":  newUser.Username,
-			"UserEmail": newUser.Email,
-			"GroupName": group.Name,
-			"GroupID":   group.ID,
-			"AppURL":    s.config.Host.AppURL,
-		})
+	subject, data := s.userJoinedAdminMail(group, newUser, admin)
+	go s.sendMailToUser(notificationService.EventUserJoined, *admin, subject, data)
 
-	go s.sendMailToUser("user_joined_welcome", *newUser,
-		fmt.Sprintf("Welcome to Secret Santa Group '%s'", group.Name), map[string]any{
-			"UserName":  newUser.Username,
-			"GroupName": group.Name,
-			"GroupID":   group.ID,
-			"AppURL":    s.config.Host.AppURL,
-		})
+	subject, data = s.welcomeMail(group, newUser)
+	go s.sendMailToUser(notificationService.EventWelcome, *newUser, subject, data)
 
 	s.logger.Info("User joined notification process started",
 		zap.String("groupName", group.Name),
@@ -159,3 +690,127 @@ func (s *MailService) SendUserJoinedNotification(group *models.Group, newUser *m
 		zap.String("adminEmail", admin.Email))
 	return nil
 }
+
+// SendPurgeWarning warns the admin that their group will be purged at purgeAt.
+// Unlike the other notifications it is sent synchronously, from the retention job.
+func (s *MailService) SendPurgeWarning(group *models.Group, admin *models.User, purgeAt time.Time) {
+	subject, data := s.purgeWarningMail(group, admin, purgeAt)
+	s.sendMailToUser(notificationService.EventPurgeWarning, *admin, subject, data)
+}
+
+// SendMailboxNotification tells the recipient of a thread that their santa wrote to them
+func (s *MailService) SendMailboxNotification(group *models.Group, recipient *models.User) {
+	subject, data := s.mailboxMessageMail(group, recipient)
+	go s.sendMailToUser(notificationService.EventMailbox, *recipient, subject, data)
+}
+
+// SendGiftStatusNotification tells the recipient of a thread that their santa updated the gift status
+func (s *MailService) SendGiftStatusNotification(group *models.Group, recipient *models.User, status models.GiftStatus) {
+	subject, data := s.giftStatusMail(group, recipient, status)
+	go s.sendMailToUser(notificationService.EventGiftStatus, *recipient, subject, data)
+}
+
+// SendEmailConfirmation sends the link confirming the pending email of user to that
+// email. It is not a notification: preferences don't apply and nothing is recorded.
+func (s *MailService) SendEmailConfirmation(group *models.Group, user *models.User, token string) {
+	subject, data := s.emailConfirmMail(group, user, token)
+	mailData := &MailData{
+		ToMail:    []string{user.PendingEmail},
+		ToDisplay: []string{fmt.Sprintf("%s <%s>", user.Username, user.PendingEmail)},
+		Subject:   subject,
+		Data:      data,
+	}
+	go func() {
+		if err := s.sendMail(mailService.TemplateEmailConfirm, mailData); err != nil && !errors.Is(err, mailService.ErrMailDisabled) {
+			s.logger.Error("Failed to send email confirmation", zap.String("userID", user.ID), zap.Error(err))
+		}
+	}()
+}
+
+// Preview
+
+// PreviewTemplate renders a template with the sample data it is validated against at startup
+func (s *MailService) PreviewTemplate(templateName string) (*mailService.Preview, error) {
+	data, exists := mailService.SampleData[templateName]
+	if !exists {
+		return nil, mailService.ErrTemplateNotFound
+	}
+
+	return s.preview(templateName, "[Preview] "+templateName, data)
+}
+
+// PreviewGroupTemplate renders a template with the data of a real group. Templates sent to
+// the admin are rendered for the admin, the ones sent to members for another member.
+func (s *MailService) PreviewGroupTemplate(templateName string, group *models.Group, admin *models.User) (*mailService.Preview, error) {
+	// Any other member stands in for the recipient and the new user, a sample one in a group of one
+	sample := mailService.SampleData[mailService.TemplateUserJoinedAdmin]
+	member := &models.User{Username: sample["UserName"].(string), Email: sample["UserEmail"].(string)}
+	for i := range group.Users {
+		if group.Users[i].ID != admin.ID {
+			member = &group.Users[i]
+			break
+		}
+	}
+
+	var subject string
+	var data map[string]any
+	var event notificationService.Event
+	switch templateName {
+	case mailService.TemplateGroupCreated:
+		event = notificationService.EventGroupCreated
+		subject, data = s.groupCreatedMail(group, admin)
+	case mailService.TemplateUserJoinedAdmin:
+		event = notificationService.EventUserJoined
+		subject, data = s.userJoinedAdminMail(group, member, admin)
+	case mailService.TemplateUserJoinedWelcome:
+		event = notificationService.EventWelcome
+		subject, data = s.welcomeMail(group, member)
+	case mailService.TemplateDrawComplete:
+		event = notificationService.EventDrawComplete
+		subject, data = s.drawCompleteMail(group, member)
+	case mailService.TemplatePurgeWarning:
+		event = notificationService.EventPurgeWarning
+		subject, data = s.purgeWarningMail(group, admin, time.Now().AddDate(0, 0, s.config.Retention.WarningDays))
+	case mailService.TemplateMailboxMessage:
+		event = notificationService.EventMailbox
+		subject, data = s.mailboxMessageMail(group, member)
+	case mailService.TemplateGiftStatus:
+		event = notificationService.EventGiftStatus
+		subject, data = s.giftStatusMail(group, member, models.GiftStatusShipped)
+	case mailService.TemplateEmailConfirm:
+		pending := *member
+		pending.PendingEmail = member.Email
+		subject, data = s.emailConfirmMail(group, &pending, "preview")
+		return s.preview(templateName, subject, data)
+	case mailService.TemplateDigest:
+		event = notificationService.EventAll
+		drawSubject, drawData := s.drawCompleteMail(group, member)
+		subject = fmt.Sprintf("Secret Santa Digest (%d updates)", 1)
+		data = map[string]any{
+			"UserName": member.Username,
+			"Items":    []map[string]any{{"Subject": drawSubject, "Data": drawData}},
+			"AppURL":   s.config.Host.AppURL,
+		}
+	default:
+		return nil, mailService.ErrTemplateNotFound
+	}
+
+	// The preview is only ever shown to the admin, so only their own unsubscribe link is
+	// safe to render: the link of the member would let the admin unsubscribe them
+	data["UnsubscribeURL"] = s.unsubscribeURL(admin.ID, event)
+
+	return s.preview(templateName, subject, data)
+}
+
+func (s *MailService) preview(templateName string, subject string, data map[string]any) (*mailService.Preview, error) {
+	body, err := s.render(templateName, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mailService.Preview{
+		Template: templateName,
+		Subject:  subject,
+		HTML:     string(body),
+	}, nil
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/mailService"
+	"onxzy/super-santa-server/services/notificationService"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queueNotification records an undelivered notification for userID, created at createdAt
+func (s *testServices) queueNotification(t *testing.T, userID string, delivery notificationService.Delivery, createdAt time.Time) *models.Notification {
+	t.Helper()
+
+	notification := &models.Notification{
+		CreatedAt: createdAt,
+		UserID:    userID,
+		Event:     string(notificationService.EventMailbox),
+		Subject:   "New message",
+		Template:  mailService.TemplateMailboxMessage,
+		Data:      "{}",
+		Delivery:  string(delivery),
+	}
+	if err := s.notificationStore.CreateNotification(notification); err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+	return notification
+}
+
+// notification reloads the notification id from the history of userID
+func (s *testServices) notification(t *testing.T, userID string, id string) models.Notification {
+	t.Helper()
+
+	history, err := s.notificationStore.GetUserNotifications(userID)
+	if err != nil {
+		t.Fatalf("GetUserNotifications: %v", err)
+	}
+	for _, notification := range history {
+		if notification.ID == id {
+			return notification
+		}
+	}
+	t.Fatalf("notification %s not found", id)
+	return models.Notification{}
+}
+
+// notifyMailbox sends the mailbox notification of group to user, as the mailbox service does
+func (s *testServices) notifyMailbox(group *models.Group, user models.User) {
+	subject, data := s.mail.mailboxMessageMail(group, &user)
+	s.mail.sendMailToUser(notificationService.EventMailbox, user, subject, data)
+}
+
+// history returns the notifications recorded for userID
+func (s *testServices) history(t *testing.T, userID string) []models.Notification {
+	t.Helper()
+
+	history, err := s.notifications.GetHistory(userID)
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	return history
+}
+
+func TestSendDigestsGathersPendingNotifications(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	digestUser, immediateUser := group.Users[1], group.Users[2]
+	server := s.startSMTP(t)
+
+	if _, err := s.notifications.UpdatePreferences(digestUser.ID, &notificationService.Preferences{Delivery: notificationService.DeliveryDigest}); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+	s.notifyMailbox(group, digestUser)
+	s.notifyMailbox(group, digestUser)
+	s.notifyMailbox(group, immediateUser)
+
+	if sent := server.sent(); len(sent) != 1 || sent[0] != immediateUser.Email {
+		t.Fatalf("expected only the immediate notification to be sent, got %v", sent)
+	}
+	for _, notification := range s.history(t, digestUser.ID) {
+		if notification.SentAt != nil || notification.Delivery != string(notificationService.DeliveryDigest) {
+			t.Fatalf("expected digest notifications to wait for the digest, got %+v", notification)
+		}
+	}
+
+	if err := s.mail.SendDigests(); err != nil {
+		t.Fatalf("SendDigests: %v", err)
+	}
+	if sent := server.sent(); len(sent) != 2 || sent[1] != digestUser.Email {
+		t.Fatalf("expected one digest for both notifications, got %v", sent)
+	}
+	history := s.history(t, digestUser.ID)
+	if len(history) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(history))
+	}
+	for _, notification := range history {
+		if notification.SentAt == nil {
+			t.Errorf("expected notification %s to be marked as sent", notification.ID)
+		}
+	}
+
+	if err := s.mail.SendDigests(); err != nil {
+		t.Fatalf("SendDigests: %v", err)
+	}
+	if sent := server.sent(); len(sent) != 2 {
+		t.Errorf("expected no digest without pending notifications, got %v", sent)
+	}
+}
+
+func TestUndeliveredNotificationsStayPending(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	immediateUser, digestUser := group.Users[1], group.Users[2]
+
+	if _, err := s.notifications.UpdatePreferences(digestUser.ID, &notificationService.Preferences{Delivery: notificationService.DeliveryDigest}); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+
+	// Email sending is disabled: the notifications are recorded without counting a failure
+	s.notifyMailbox(group, immediateUser)
+	s.notifyMailbox(group, digestUser)
+	if err := s.mail.RetryNotifications(); err != nil {
+		t.Fatalf("RetryNotifications: %v", err)
+	}
+	if err := s.mail.SendDigests(); err != nil {
+		t.Fatalf("SendDigests: %v", err)
+	}
+	for _, user := range []models.User{immediateUser, digestUser} {
+		history := s.history(t, user.ID)
+		if len(history) != 1 || history[0].SentAt != nil || history[0].Attempts != 0 {
+			t.Fatalf("expected one pending notification for %s, got %+v", user.Username, history)
+		}
+	}
+
+	server := s.startSMTP(t)
+	if err := s.mail.RetryNotifications(); err != nil {
+		t.Fatalf("RetryNotifications: %v", err)
+	}
+	if err := s.mail.SendDigests(); err != nil {
+		t.Fatalf("SendDigests: %v", err)
+	}
+	if sent := server.sent(); len(sent) != 2 {
+		t.Fatalf("expected both notifications to be sent once enabled, got %v", sent)
+	}
+	for _, user := range []models.User{immediateUser, digestUser} {
+		if history := s.history(t, user.ID); history[0].SentAt == nil {
+			t.Errorf("expected the notification of %s to be marked as sent", user.Username)
+		}
+	}
+
+	// A failed delivery is kept pending and counted
+	server.fail.Store(true)
+	s.notifyMailbox(group, immediateUser)
+	history := s.history(t, immediateUser.ID)
+	if failed := history[len(history)-1]; failed.SentAt != nil || failed.Attempts != 1 {
+		t.Errorf("expected a pending notification with one attempt, got %+v", failed)
+	}
+	if err := s.mail.RetryNotifications(); err != nil {
+		t.Fatalf("RetryNotifications: %v", err)
+	}
+	if failed := s.notification(t, immediateUser.ID, history[len(history)-1].ID); failed.Attempts != 2 {
+		t.Errorf("expected 2 attempts after a failed retry, got %d", failed.Attempts)
+	}
+	if sent := server.sent(); len(sent) != 2 {
+		t.Errorf("expected no new email, got %v", sent)
+	}
+}
+
+func TestRetryNotificationsGivesUp(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+	user := group.Users[1]
+	server := s.startSMTP(t)
+
+	failing := s.queueNotification(t, user.ID, notificationService.DeliveryImmediate, time.Now())
+	server.fail.Store(true)
+	for range maxNotificationAttempts + 1 {
+		if err := s.mail.RetryNotifications(); err != nil {
+			t.Fatalf("RetryNotifications: %v", err)
+		}
+	}
+	if attempts := s.notification(t, user.ID, failing.ID).Attempts; attempts != maxNotificationAttempts {
+		t.Errorf("expected %d attempts, got %d", maxNotificationAttempts, attempts)
+	}
+
+	expired := s.queueNotification(t, user.ID, notificationService.DeliveryImmediate, time.Now().Add(-maxNotificationAge-time.Hour))
+	fresh := s.queueNotification(t, user.ID, notificationService.DeliveryImmediate, time.Now())
+	server.fail.Store(false)
+	if err := s.mail.RetryNotifications(); err != nil {
+		t.Fatalf("RetryNotifications: %v", err)
+	}
+
+	if sent := server.sent(); len(sent) != 1 || sent[0] != user.Email {
+		t.Fatalf("expected only the fresh notification to be sent, got %v", sent)
+	}
+	for _, given := range []*models.Notification{failing, expired} {
+		if s.notification(t, user.ID, given.ID).SentAt != nil {
+			t.Errorf("expected notification %s to be given up", given.ID)
+		}
+	}
+	if s.notification(t, user.ID, fresh.ID).SentAt == nil {
+		t.Errorf("expected the fresh notification to be sent")
+	}
+}
+
+func TestPreviewGroupTemplateRecipient(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	admin, member := group.Users[0], group.Users[1]
+
+	for templateName, recipient := range map[string]string{
+		mailService.TemplateGroupCreated:      admin.Username,
+		mailService.TemplateUserJoinedWelcome: member.Username,
+		mailService.TemplateMailboxMessage:    member.Username,
+		mailService.TemplateGiftStatus:        member.Username,
+		mailService.TemplateEmailConfirm:      member.Username,
+		mailService.TemplateDigest:            member.Username,
+	} {
+		preview, err := s.mail.PreviewGroupTemplate(templateName, group, &admin)
+		if err != nil {
+			t.Fatalf("%s: PreviewGroupTemplate: %v", templateName, err)
+		}
+		if !strings.Contains(preview.HTML, recipient) {
+			t.Errorf("%s: expected the preview to address %s", templateName, recipient)
+		}
+	}
+
+	// A group of one previews the member templates for a sample member
+	alone := s.createGroup(t, 1)
+	preview, err := s.mail.PreviewGroupTemplate(mailService.TemplateUserJoinedWelcome, alone, &alone.Users[0])
+	if err != nil {
+		t.Fatalf("PreviewGroupTemplate: %v", err)
+	}
+	sample := mailService.SampleData[mailService.TemplateUserJoinedAdmin]["UserName"].(string)
+	if !strings.Contains(preview.HTML, sample) || strings.Contains(preview.HTML, alone.Users[0].Username) {
+		t.Errorf("expected the preview to address the sample member %s", sample)
+	}
+}
@@ -0,0 +1,20 @@
+package mailboxService
+
+import "errors"
+
+var (
+	ErrThreadNotFound     = errors.New("mailbox thread not found")
+	ErrOwnThread          = errors.New("members can't write to themselves")
+	ErrNotThreadMember    = errors.New("only the recipient and their santa can write in a thread")
+	ErrThreadClosed       = errors.New("thread belongs to a previous draw")
+	ErrInvalidSenderToken = errors.New("invalid sender token")
+	ErrInvalidThreadToken = errors.New("thread token matches no result of the recipient in the latest draw")
+	ErrThreadExists       = errors.New("a thread was already started for this draw result")
+	ErrUnboundThread      = errors.New("thread was started before thread tokens and is bound to no draw result")
+	ErrGiftStatusBackward = errors.New("gift status can only move forward")
+
+	ErrInvalidAddressKey = errors.New("address key must be a compact JWE with alg RSA-OAEP-256 and enc A256GCM")
+	ErrAddressKeySet     = errors.New("the santa already gave an address key in the thread")
+	ErrInvalidAddress    = errors.New("address must be a compact JWE with alg dir and enc A256GCM")
+	ErrNoAddressKey      = errors.New("the santa gave no address key in the thread yet")
+)
@@ -0,0 +1,15 @@
+package mailboxService
+
+// GiftSummary counts the gifts of the latest draw by progress, for the admin.
+// Each recipient is counted once per gift, at the most advanced statuses of
+// their threads, so nothing tells who gives to whom. Only threads bound to a
+// draw result count, one per result.
+type GiftSummary struct {
+	Round     int `json:"round"`
+	Gifts     int `json:"gifts"`      // Gifts per person for each member of the draw
+	NotBought int `json:"not_bought"` // Gifts without a status yet
+	Bought    int `json:"bought"`
+	Shipped   int `json:"shipped"`
+	Received  int `json:"received"`
+	Thanked   int `json:"thanked"`
+}
@@ -0,0 +1,534 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/groupService"
+	"onxzy/super-santa-server/services/mailboxService"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MailboxService carries anonymous messages between members and their santa.
+// The santa is never recorded: they prove they started a thread with a token
+// whose hash is stored, so nothing links a thread to them but their own key.
+type MailboxService struct {
+	groupStore   database.GroupRepository
+	mailboxStore database.MailboxRepository
+	uow          database.UnitOfWork
+	mailService  *MailService
+	logger       *zap.Logger
+}
+
+func NewMailboxService(groupStore database.GroupRepository, mailboxStore database.MailboxRepository, uow database.UnitOfWork, mailService *MailService, logger *zap.Logger) *MailboxService {
+	return &MailboxService{
+		groupStore:   groupStore,
+		mailboxStore: mailboxStore,
+		uow:          uow,
+		mailService:  mailService,
+		logger:       logger.Named("mailbox-service"),
+	}
+}
+
+// hashToken hashes the secret tokens the server checks without storing them,
+// the sender tokens of threads and the email confirmation tokens
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func checkSenderToken(thread *models.MailboxThread, senderToken string) error {
+	if subtle.ConstantTimeCompare([]byte(hashToken(senderToken)), []byte(thread.SenderTokenHash)) != 1 {
+		return mailboxService.ErrInvalidSenderToken
+	}
+	return nil
+}
+
+// currentThread gets a thread of the group, still open in its latest draw
+func currentThread(repos database.Repositories, group *models.Group, threadID string) (*models.MailboxThread, error) {
+	thread, err := repos.Mailbox().GetThread(threadID)
+	if err != nil {
+		if errors.Is(err, database.ErrThreadNotFound) {
+			return nil, mailboxService.ErrThreadNotFound
+		}
+		return nil, err
+	}
+	if thread.GroupID != group.ID {
+		return nil, mailboxService.ErrThreadNotFound
+	}
+	if thread.Round != group.DrawRound {
+		return nil, mailboxService.ErrThreadClosed
+	}
+	return thread, nil
+}
+
+// GetThreads returns the threads addressed to recipientID in the latest draw.
+// Any member may list them, a santa finds their own by decrypting the sender keys.
+func (s *MailboxService) GetThreads(groupID string, recipientID string) ([]models.MailboxThread, error) {
+	group, err := s.groupStore.GetGroup(groupID)
+	if err != nil {
+		if errors.Is(err, database.ErrGroupNotFound) {
+			return nil, groupService.ErrGroupNotFound
+		}
+		return nil, err
+	}
+	if _, err := groupMember(group, recipientID); err != nil {
+		return nil, err
+	}
+
+	threads, err := s.mailboxStore.GetRecipientThreads(recipientID)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]models.MailboxThread, 0, len(threads))
+	for _, thread := range threads {
+		if thread.GroupID == groupID && thread.Round == group.DrawRound {
+			current = append(current, thread)
+		}
+	}
+	return current, nil
+}
+
+// GetUserThreads returns every thread addressed to userID, of every draw
+func (s *MailboxService) GetUserThreads(userID string) ([]models.MailboxThread, error) {
+	return s.mailboxStore.GetRecipientThreads(userID)
+}
+
+// threadResult finds the result of the latest draw for recipientID whose thread token
+// is threadToken. Only the giver of a result has decrypted its token.
+func threadResult(repos database.Repositories, group *models.Group, recipientID string, threadToken string) (*models.DrawResult, error) {
+	results, err := repos.Groups().GetDrawResults(group.ID, group.DrawRound)
+	if err != nil {
+		return nil, err
+	}
+	hash := []byte(hashToken(threadToken))
+	for _, result := range results {
+		if result.RecipientID == recipientID && subtle.ConstantTimeCompare(hash, []byte(result.ThreadTokenHash)) == 1 {
+			return &result, nil
+		}
+	}
+	return nil, mailboxService.ErrInvalidThreadToken
+}
+
+// checkThreadToken checks that thread is bound to the result of the latest draw whose
+// thread token is threadToken, that is that its santa is the giver of the result
+func checkThreadToken(repos database.Repositories, group *models.Group, thread *models.MailboxThread, threadToken string) error {
+	if thread.ResultID == nil {
+		return mailboxService.ErrUnboundThread
+	}
+	result, err := threadResult(repos, group, thread.RecipientID, threadToken)
+	if err != nil {
+		return err
+	}
+	if result.ID != *thread.ResultID {
+		return mailboxService.ErrInvalidThreadToken
+	}
+	return nil
+}
+
+// StartThread opens a thread from the santa of the recipient with its first message,
+// if any: a santa may open it only to track their gift or to give an address key.
+// The thread token of the santa's result proves they give to the recipient, and binds
+// the thread to that result. senderID is only checked to be a member of the group,
+// it is not stored.
+func (s *MailboxService) StartThread(groupID string, senderID string, thread *models.MailboxThread, senderToken string, threadToken string, payload string) error {
+	if thread.AddressKey != "" && !checkEnvelope(thread.AddressKey, "RSA-OAEP-256") {
+		return mailboxService.ErrInvalidAddressKey
+	}
+
+	var group *models.Group
+	var recipient *models.User
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionSendMessage); err != nil {
+			return err
+		}
+
+		if _, err := groupMember(group, senderID); err != nil {
+			return err
+		}
+		recipient, err = groupMember(group, thread.RecipientID)
+		if err != nil {
+			return err
+		}
+		if recipient.ID == senderID {
+			return mailboxService.ErrOwnThread
+		}
+
+		result, err := threadResult(repos, group, recipient.ID, threadToken)
+		if err != nil {
+			return err
+		}
+		threads, err := repos.Mailbox().GetRoundThreads(group.ID, group.DrawRound)
+		if err != nil {
+			return err
+		}
+		for _, other := range threads {
+			if other.ResultID != nil && *other.ResultID == result.ID {
+				return mailboxService.ErrThreadExists
+			}
+		}
+
+		thread.GroupID = group.ID
+		thread.Round = group.DrawRound
+		thread.ResultID = &result.ID
+		thread.SenderTokenHash = hashToken(senderToken)
+		thread.Messages = []models.MailboxMessage{}
+		if payload != "" {
+			thread.Messages = []models.MailboxMessage{{FromSanta: true, Payload: payload}}
+		}
+		return repos.Mailbox().CreateThread(thread)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(thread.Messages) > 0 {
+		s.mailService.SendMailboxNotification(group, recipient)
+	}
+	return nil
+}
+
+// PostMessage adds a message to a thread. The santa posts with the sender token
+// of the thread, the recipient without it.
+func (s *MailboxService) PostMessage(groupID string, userID string, threadID string, senderToken string, payload string) (*models.MailboxMessage, error) {
+	var group *models.Group
+	var recipient *models.User
+	message := &models.MailboxMessage{ThreadID: threadID, Payload: payload}
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionSendMessage); err != nil {
+			return err
+		}
+		if _, err := groupMember(group, userID); err != nil {
+			return err
+		}
+
+		thread, err := currentThread(repos, group, threadID)
+		if err != nil {
+			return err
+		}
+
+		if senderToken != "" {
+			if err := checkSenderToken(thread, senderToken); err != nil {
+				return err
+			}
+			message.FromSanta = true
+		} else if thread.RecipientID != userID {
+			return mailboxService.ErrNotThreadMember
+		}
+
+		if message.FromSanta {
+			recipient, err = groupMember(group, thread.RecipientID)
+			if err != nil {
+				return err
+			}
+		}
+		return repos.Mailbox().AddMessage(message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Replies can't be notified, the server doesn't know who the santa is
+	if message.FromSanta {
+		s.mailService.SendMailboxNotification(group, recipient)
+	}
+	return message, nil
+}
+
+// SetGiftStatus moves the gift of a thread forward. Only the santa sets bought and
+// shipped, with the sender token of the thread, and only the recipient sets received
+// and thanked, without it. Steps may be skipped but never undone. Only threads bound to
+// a draw result track a gift, any member could have started the others.
+func (s *MailboxService) SetGiftStatus(groupID string, userID string, threadID string, senderToken string, status models.GiftStatus) (*models.MailboxThread, error) {
+	var group *models.Group
+	var recipient *models.User
+	var thread *models.MailboxThread
+	var changed bool
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionTrackGift); err != nil {
+			return err
+		}
+		if _, err := groupMember(group, userID); err != nil {
+			return err
+		}
+
+		thread, err = currentThread(repos, group, threadID)
+		if err != nil {
+			return err
+		}
+		if thread.ResultID == nil {
+			return mailboxService.ErrUnboundThread
+		}
+
+		if status.SetBySanta() {
+			if senderToken == "" {
+				return mailboxService.ErrNotThreadMember
+			}
+			if err := checkSenderToken(thread, senderToken); err != nil {
+				return err
+			}
+			recipient, err = groupMember(group, thread.RecipientID)
+			if err != nil {
+				return err
+			}
+		} else if senderToken != "" || thread.RecipientID != userID {
+			// The santa can't tell the gift arrived in place of the recipient
+			return mailboxService.ErrNotThreadMember
+		}
+
+		current := slices.Index(models.GiftStatuses, thread.GiftStatus)
+		next := slices.Index(models.GiftStatuses, status)
+		if next < current {
+			return mailboxService.ErrGiftStatusBackward
+		}
+		if next == current {
+			return nil
+		}
+
+		if err := repos.Mailbox().SetGiftStatus(thread.ID, status); err != nil {
+			if errors.Is(err, database.ErrThreadNotFound) {
+				return mailboxService.ErrThreadNotFound
+			}
+			return err
+		}
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return thread, nil
+	}
+
+	now := time.Now()
+	thread.GiftStatus = status
+	thread.GiftStatusAt = &now
+
+	if status.SetBySanta() {
+		s.mailService.SendGiftStatusNotification(group, recipient, status)
+	}
+	return thread, nil
+}
+
+// SetAddressKey gives the recipient of a thread the key to encrypt their address to
+// the santa, encrypted to the recipient's message key. Only the santa gives it, once:
+// the address encrypted under it would be lost. The thread token proves again that the
+// santa gives to the recipient, as the address goes to whoever holds the key.
+func (s *MailboxService) SetAddressKey(groupID string, userID string, threadID string, senderToken string, threadToken string, addressKey string) (*models.MailboxThread, error) {
+	if !checkEnvelope(addressKey, "RSA-OAEP-256") {
+		return nil, mailboxService.ErrInvalidAddressKey
+	}
+
+	var thread *models.MailboxThread
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateAddress); err != nil {
+			return err
+		}
+		if _, err := groupMember(group, userID); err != nil {
+			return err
+		}
+
+		thread, err = currentThread(repos, group, threadID)
+		if err != nil {
+			return err
+		}
+		if err := checkSenderToken(thread, senderToken); err != nil {
+			return err
+		}
+		if err := checkThreadToken(repos, group, thread, threadToken); err != nil {
+			return err
+		}
+		if thread.AddressKey != "" {
+			return mailboxService.ErrAddressKeySet
+		}
+
+		thread.AddressKey = addressKey
+		return setThreadAddress(repos, thread)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+// SetAddress stores the shipping address of the recipient of a thread, encrypted
+// under the address key their santa gave in it. An empty address removes it.
+// Threads started before thread tokens take no address, anyone could have started them.
+func (s *MailboxService) SetAddress(groupID string, userID string, threadID string, address string) (*models.MailboxThread, error) {
+	if address != "" && !checkEnvelope(address, "dir") {
+		return nil, mailboxService.ErrInvalidAddress
+	}
+
+	var thread *models.MailboxThread
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateAddress); err != nil {
+			return err
+		}
+		if _, err := groupMember(group, userID); err != nil {
+			return err
+		}
+
+		thread, err = currentThread(repos, group, threadID)
+		if err != nil {
+			return err
+		}
+		if thread.RecipientID != userID {
+			return mailboxService.ErrNotThreadMember
+		}
+		if thread.ResultID == nil {
+			return mailboxService.ErrUnboundThread
+		}
+		if thread.AddressKey == "" {
+			return mailboxService.ErrNoAddressKey
+		}
+
+		thread.Address = address
+		return setThreadAddress(repos, thread)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return thread, nil
+}
+
+func setThreadAddress(repos database.Repositories, thread *models.MailboxThread) error {
+	if err := repos.Mailbox().SetAddress(thread.ID, thread.AddressKey, thread.Address); err != nil {
+		if errors.Is(err, database.ErrThreadNotFound) {
+			return mailboxService.ErrThreadNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// envelopeMaxLength is the maximum length of the ciphertext of an address or its key, in bytes
+const envelopeMaxLength = 1024
+
+// checkEnvelope checks that value is a compact JWE with alg and enc A256GCM,
+// the server can't check anything else
+func checkEnvelope(value string, alg string) bool {
+	parts := strings.Split(value, ".")
+	if len(parts) != 5 {
+		return false
+	}
+
+	decoded := make([][]byte, len(parts))
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return false
+		}
+	}
+
+	var header map[string]any
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return false
+	}
+	if len(header) != 2 || header["alg"] != alg || header["enc"] != "A256GCM" {
+		return false
+	}
+
+	// Direct encryption has no encrypted key
+	encryptedKey, iv, ciphertext, tag := decoded[1], decoded[2], decoded[3], decoded[4]
+	if (alg == "dir") != (len(encryptedKey) == 0) {
+		return false
+	}
+	return len(iv) == 12 && len(tag) == 16 && len(ciphertext) > 0 && len(ciphertext) <= envelopeMaxLength
+}
+
+// GetGiftSummary counts the gifts of the latest draw of the group by progress, for its admin
+func (s *MailboxService) GetGiftSummary(groupID string, adminID string) (*mailboxService.GiftSummary, error) {
+	group, err := s.groupStore.GetGroup(groupID)
+	if err != nil {
+		if errors.Is(err, database.ErrGroupNotFound) {
+			return nil, groupService.ErrGroupNotFound
+		}
+		return nil, err
+	}
+	if _, err := groupAdmin(group, adminID); err != nil {
+		return nil, err
+	}
+
+	summary := &mailboxService.GiftSummary{Round: group.DrawRound}
+	if group.DrawRound == 0 {
+		return summary, nil
+	}
+
+	threads, err := s.mailboxStore.GetRoundThreads(group.ID, group.DrawRound)
+	if err != nil {
+		return nil, err
+	}
+
+	// Statuses of the threads of each recipient, as indexes in GiftStatuses. A gift is
+	// a draw result, tracked by the one thread bound to it.
+	progress := make(map[string][]int, len(group.Users))
+	counted := make(map[string]bool, len(threads))
+	for _, thread := range threads {
+		if thread.ResultID == nil || counted[*thread.ResultID] {
+			continue
+		}
+		counted[*thread.ResultID] = true
+		if step := slices.Index(models.GiftStatuses, thread.GiftStatus); step >= 0 {
+			progress[thread.RecipientID] = append(progress[thread.RecipientID], step)
+		}
+	}
+
+	participants := group.Participants()
+	summary.Gifts = len(participants) * group.GiftsPerPerson
+	for _, user := range participants {
+		// A recipient counts as many gifts as each participant gives, at the
+		// most advanced statuses of their threads
+		steps := progress[user.ID]
+		slices.Sort(steps)
+		slices.Reverse(steps)
+		steps = steps[:min(len(steps), group.GiftsPerPerson)]
+
+		summary.NotBought += group.GiftsPerPerson - len(steps)
+		for _, step := range steps {
+			switch models.GiftStatuses[step] {
+			case models.GiftStatusBought:
+				summary.Bought++
+			case models.GiftStatusShipped:
+				summary.Shipped++
+			case models.GiftStatusReceived:
+				summary.Received++
+			case models.GiftStatusThanked:
+				summary.Thanked++
+			}
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/mailboxService"
+	"testing"
+)
+
+// startGiftThread draws a group of 3 and starts the thread of a result, with the sender
+// token "token", and returns the thread token of the result
+func startGiftThread(t *testing.T, s *testServices) (group *models.Group, santa string, recipient string, thread *models.MailboxThread, threadToken string) {
+	t.Helper()
+
+	group = s.createGroup(t, 3)
+	result := s.drawPayloads(t, group)[0][0]
+	recipient = result.UserID
+	santa = otherMember(t, group, recipient)
+
+	thread = &models.MailboxThread{RecipientID: recipient, ReplyKey: "reply", SenderKey: "sender"}
+	if err := s.mailbox.StartThread(group.ID, santa, thread, "token", result.ThreadToken, ""); err != nil {
+		t.Fatalf("StartThread: %v", err)
+	}
+	return group, santa, recipient, thread, result.ThreadToken
+}
+
+// Only the giver of a result starts its thread, once per result and round
+func TestStartThreadNeedsThreadToken(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	keys, privateKeys := drawKeyPairs(t, 3)
+	s.draw(t, group, keys)
+
+	payloads := s.openResults(t, group.ID, privateKeys)
+	result, otherResult := payloads[0][0], payloads[1][0]
+	santa := otherMember(t, group, result.UserID)
+	start := func(recipientID string, threadToken string) error {
+		thread := &models.MailboxThread{RecipientID: recipientID, ReplyKey: "reply", SenderKey: "sender"}
+		return s.mailbox.StartThread(group.ID, santa, thread, "token", threadToken, "hello")
+	}
+
+	if err := start(result.UserID, "guessed"); !errors.Is(err, mailboxService.ErrInvalidThreadToken) {
+		t.Errorf("expected ErrInvalidThreadToken without the token, got %v", err)
+	}
+	if err := start(result.UserID, otherResult.ThreadToken); !errors.Is(err, mailboxService.ErrInvalidThreadToken) {
+		t.Errorf("expected ErrInvalidThreadToken with the token of another recipient, got %v", err)
+	}
+	if err := start(result.UserID, result.ThreadToken); err != nil {
+		t.Fatalf("StartThread: %v", err)
+	}
+	if err := start(result.UserID, result.ThreadToken); !errors.Is(err, mailboxService.ErrThreadExists) {
+		t.Errorf("expected ErrThreadExists for a second thread of the result, got %v", err)
+	}
+
+	threads, err := s.mailbox.GetThreads(group.ID, result.UserID)
+	if err != nil {
+		t.Fatalf("GetThreads: %v", err)
+	}
+	if len(threads) != 1 || threads[0].ResultID == nil {
+		t.Errorf("expected one thread bound to the result, got %+v", threads)
+	}
+
+	// Tokens don't carry over to the next draw
+	s.draw(t, group, keys)
+	if err := start(result.UserID, result.ThreadToken); !errors.Is(err, mailboxService.ErrInvalidThreadToken) {
+		t.Errorf("expected ErrInvalidThreadToken with a token of the previous round, got %v", err)
+	}
+}
+
+func TestSetGiftStatusMovesForward(t *testing.T) {
+	s := newTestServices(t)
+	group, santa, recipient, thread, _ := startGiftThread(t, s)
+
+	steps := []struct {
+		userID string
+		token  string
+		status models.GiftStatus
+	}{
+		{santa, "token", models.GiftStatusBought},
+		{santa, "token", models.GiftStatusShipped},
+		{recipient, "", models.GiftStatusReceived},
+		{recipient, "", models.GiftStatusThanked},
+	}
+	for _, step := range steps {
+		updated, err := s.mailbox.SetGiftStatus(group.ID, step.userID, thread.ID, step.token, step.status)
+		if err != nil {
+			t.Fatalf("SetGiftStatus(%s): %v", step.status, err)
+		}
+		if updated.GiftStatus != step.status {
+			t.Errorf("expected status %q, got %q", step.status, updated.GiftStatus)
+		}
+	}
+}
+
+func TestSetGiftStatusSkipsSteps(t *testing.T) {
+	s := newTestServices(t)
+	group, _, recipient, thread, _ := startGiftThread(t, s)
+
+	if _, err := s.mailbox.SetGiftStatus(group.ID, recipient, thread.ID, "", models.GiftStatusReceived); err != nil {
+		t.Fatalf("SetGiftStatus: %v", err)
+	}
+	// Setting the current status again changes nothing
+	if _, err := s.mailbox.SetGiftStatus(group.ID, recipient, thread.ID, "", models.GiftStatusReceived); err != nil {
+		t.Errorf("expected the same status to be accepted, got %v", err)
+	}
+}
+
+func TestSetGiftStatusRejectsBackward(t *testing.T) {
+	s := newTestServices(t)
+	group, santa, recipient, thread, _ := startGiftThread(t, s)
+
+	if _, err := s.mailbox.SetGiftStatus(group.ID, recipient, thread.ID, "", models.GiftStatusReceived); err != nil {
+		t.Fatalf("SetGiftStatus: %v", err)
+	}
+	for _, status := range []models.GiftStatus{models.GiftStatusBought, models.GiftStatusShipped} {
+		_, err := s.mailbox.SetGiftStatus(group.ID, santa, thread.ID, "token", status)
+		if !errors.Is(err, mailboxService.ErrGiftStatusBackward) {
+			t.Errorf("%s after received: expected ErrGiftStatusBackward, got %v", status, err)
+		}
+	}
+}
+
+func TestSetGiftStatusRoles(t *testing.T) {
+	s := newTestServices(t)
+	group, santa, recipient, thread, _ := startGiftThread(t, s)
+	other := otherMember(t, group, santa, recipient)
+
+	tests := []struct {
+		name   string
+		userID string
+		token  string
+		status models.GiftStatus
+		err    error
+	}{
+		{"santa sets received", santa, "token", models.GiftStatusReceived, mailboxService.ErrNotThreadMember},
+		{"santa sets thanked", santa, "token", models.GiftStatusThanked, mailboxService.ErrNotThreadMember},
+		{"recipient sets shipped", recipient, "", models.GiftStatusShipped, mailboxService.ErrNotThreadMember},
+		{"recipient sets bought", recipient, "", models.GiftStatusBought, mailboxService.ErrNotThreadMember},
+		{"wrong sender token", santa, "other", models.GiftStatusShipped, mailboxService.ErrInvalidSenderToken},
+		{"other member sets received", other, "", models.GiftStatusReceived, mailboxService.ErrNotThreadMember},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.mailbox.SetGiftStatus(group.ID, tt.userID, thread.ID, tt.token, tt.status)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestThreadAddress(t *testing.T) {
+	s := newTestServices(t)
+	group, santa, recipient, thread, threadToken := startGiftThread(t, s)
+	other := otherMember(t, group, santa, recipient)
+
+	if _, err := s.mailbox.SetAddress(group.ID, recipient, thread.ID, envelope("dir")); !errors.Is(err, mailboxService.ErrNoAddressKey) {
+		t.Errorf("expected ErrNoAddressKey before the santa gives a key, got %v", err)
+	}
+
+	// Only the santa gives the key, once
+	if _, err := s.mailbox.SetAddressKey(group.ID, santa, thread.ID, "token", threadToken, envelope("dir")); !errors.Is(err, mailboxService.ErrInvalidAddressKey) {
+		t.Errorf("expected ErrInvalidAddressKey, got %v", err)
+	}
+	if _, err := s.mailbox.SetAddressKey(group.ID, other, thread.ID, "other", threadToken, envelope("RSA-OAEP-256")); !errors.Is(err, mailboxService.ErrInvalidSenderToken) {
+		t.Errorf("expected ErrInvalidSenderToken, got %v", err)
+	}
+	if _, err := s.mailbox.SetAddressKey(group.ID, santa, thread.ID, "token", "guessed", envelope("RSA-OAEP-256")); !errors.Is(err, mailboxService.ErrInvalidThreadToken) {
+		t.Errorf("expected ErrInvalidThreadToken, got %v", err)
+	}
+	if _, err := s.mailbox.SetAddressKey(group.ID, santa, thread.ID, "token", threadToken, envelope("RSA-OAEP-256")); err != nil {
+		t.Fatalf("SetAddressKey: %v", err)
+	}
+	if _, err := s.mailbox.SetAddressKey(group.ID, santa, thread.ID, "token", threadToken, envelope("RSA-OAEP-256")); !errors.Is(err, mailboxService.ErrAddressKeySet) {
+		t.Errorf("expected ErrAddressKeySet, got %v", err)
+	}
+
+	// Only the recipient sets the address
+	if _, err := s.mailbox.SetAddress(group.ID, other, thread.ID, envelope("dir")); !errors.Is(err, mailboxService.ErrNotThreadMember) {
+		t.Errorf("expected ErrNotThreadMember, got %v", err)
+	}
+	if _, err := s.mailbox.SetAddress(group.ID, recipient, thread.ID, "address"); !errors.Is(err, mailboxService.ErrInvalidAddress) {
+		t.Errorf("expected ErrInvalidAddress, got %v", err)
+	}
+	updated, err := s.mailbox.SetAddress(group.ID, recipient, thread.ID, envelope("dir"))
+	if err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+	if updated.Address != envelope("dir") || updated.AddressKey != envelope("RSA-OAEP-256") {
+		t.Errorf("expected the address and its key to be stored, got %q and %q", updated.Address, updated.AddressKey)
+	}
+
+	if updated, err = s.mailbox.SetAddress(group.ID, recipient, thread.ID, ""); err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+	if updated.Address != "" {
+		t.Errorf("expected the address to be removed, got %q", updated.Address)
+	}
+}
+
+// A thread started before thread tokens may come from any member, it takes no address
+func TestUnboundThreadTakesNoAddress(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	result := s.drawPayloads(t, group)[0][0]
+	group = s.groupState(t, group.ID)
+	santa := otherMember(t, group, result.UserID)
+
+	thread := &models.MailboxThread{
+		GroupID: group.ID, Round: group.DrawRound, RecipientID: result.UserID,
+		ReplyKey: "reply", SenderKey: "sender", SenderTokenHash: hashToken("token"),
+	}
+	if err := s.mailboxStore.CreateThread(thread); err != nil {
+		t.Fatalf("CreateThread: %v", err)
+	}
+
+	if _, err := s.mailbox.SetAddressKey(group.ID, santa, thread.ID, "token", result.ThreadToken, envelope("RSA-OAEP-256")); !errors.Is(err, mailboxService.ErrUnboundThread) {
+		t.Errorf("expected ErrUnboundThread for the address key, got %v", err)
+	}
+	thread.AddressKey = envelope("RSA-OAEP-256")
+	if err := s.mailboxStore.SetAddress(thread.ID, thread.AddressKey, ""); err != nil {
+		t.Fatalf("SetAddress: %v", err)
+	}
+	if _, err := s.mailbox.SetAddress(group.ID, result.UserID, thread.ID, envelope("dir")); !errors.Is(err, mailboxService.ErrUnboundThread) {
+		t.Errorf("expected ErrUnboundThread for the address, got %v", err)
+	}
+}
+
+// The summary counts the gift of each result once, from the thread bound to it
+func TestGiftSummaryCountsResults(t *testing.T) {
+	s := newTestServices(t)
+	group, santa, recipient, thread, _ := startGiftThread(t, s)
+	group = s.groupState(t, group.ID)
+
+	if _, err := s.mailbox.SetGiftStatus(group.ID, santa, thread.ID, "token", models.GiftStatusBought); err != nil {
+		t.Fatalf("SetGiftStatus: %v", err)
+	}
+
+	// Another member claims to be a santa of the recipient with a thread bound to nothing
+	unbound := &models.MailboxThread{
+		GroupID: group.ID, Round: group.DrawRound, RecipientID: recipient,
+		ReplyKey: "reply", SenderKey: "sender", SenderTokenHash: hashToken("other"),
+	}
+	if err := s.mailboxStore.CreateThread(unbound); err != nil {
+		t.Fatalf("CreateThread: %v", err)
+	}
+	if _, err := s.mailbox.SetGiftStatus(group.ID, santa, unbound.ID, "other", models.GiftStatusShipped); !errors.Is(err, mailboxService.ErrUnboundThread) {
+		t.Errorf("expected ErrUnboundThread from the santa, got %v", err)
+	}
+	if _, err := s.mailbox.SetGiftStatus(group.ID, recipient, unbound.ID, "", models.GiftStatusThanked); !errors.Is(err, mailboxService.ErrUnboundThread) {
+		t.Errorf("expected ErrUnboundThread from the recipient, got %v", err)
+	}
+	if err := s.mailboxStore.SetGiftStatus(unbound.ID, models.GiftStatusThanked); err != nil {
+		t.Fatalf("SetGiftStatus: %v", err)
+	}
+
+	summary, err := s.mailbox.GetGiftSummary(group.ID, adminOf(t, group))
+	if err != nil {
+		t.Fatalf("GetGiftSummary: %v", err)
+	}
+	if summary.Gifts != 3 || summary.NotBought != 2 || summary.Bought != 1 || summary.Thanked != 0 {
+		t.Errorf("expected 1 gift bought of 3 and the unbound thread ignored, got %+v", summary)
+	}
+}
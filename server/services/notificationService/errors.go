@@ -0,0 +1,16 @@
+package notificationService
+
+import "errors"
+
+var (
+	ErrUnknownEvent    = errors.New("unknown event")
+	ErrInvalidDelivery = errors.New("invalid delivery mode")
+)
+
+type InvalidUnsubscribeTokenError struct {
+	Err error
+}
+
+func (e *InvalidUnsubscribeTokenError) Error() string {
+	return "invalid unsubscribe token: " + e.Err.Error()
+}
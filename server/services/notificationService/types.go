@@ -0,0 +1,39 @@
+package notificationService
+
+type Event string
+
+const (
+	EventGroupCreated Event = "group_created"
+	EventUserJoined   Event = "user_joined"
+	EventWelcome      Event = "welcome"
+	EventDrawComplete Event = "draw_complete"
+	EventPurgeWarning Event = "purge_warning"
+	EventMailbox      Event = "mailbox_message" // Message from the santa of the user
+	EventGiftStatus   Event = "gift_status"     // Progress of the gift of the santa of the user
+
+	EventAll Event = "*" // Only valid in unsubscribe tokens
+)
+
+// Events lists every event a user can subscribe to
+var Events = []Event{
+	EventGroupCreated,
+	EventUserJoined,
+	EventWelcome,
+	EventDrawComplete,
+	EventPurgeWarning,
+	EventMailbox,
+	EventGiftStatus,
+}
+
+type Delivery string
+
+const (
+	DeliveryImmediate Delivery = "immediate"
+	DeliveryDigest    Delivery = "digest"
+	DeliveryNone      Delivery = "none" // Event muted by the user
+)
+
+type Preferences struct {
+	Delivery Delivery       `json:"delivery"`
+	Events   map[Event]bool `json:"events"`
+}
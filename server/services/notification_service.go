@@ -0,0 +1,223 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/notificationService"
+	"onxzy/super-santa-server/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// unsubscribeTokenLifetime is how long the unsubscribe link of an email stays valid
+const unsubscribeTokenLifetime = 60 * 24 * time.Hour
+
+type NotificationService struct {
+	config            *utils.Config
+	notificationStore *database.NotificationStore
+	userStore         database.UserRepository
+	logger            *zap.Logger
+}
+
+func NewNotificationService(config *utils.Config, notificationStore *database.NotificationStore, userStore database.UserRepository, logger *zap.Logger) *NotificationService {
+	return &NotificationService{
+		config:            config,
+		notificationStore: notificationStore,
+		userStore:         userStore,
+		logger:            logger.Named("notification-service"),
+	}
+}
+
+func (s *NotificationService) getPreference(userID string) (*models.NotificationPreference, error) {
+	pref, err := s.notificationStore.GetPreference(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotificationPreferenceNotFound) {
+			// Users without preferences get every email immediately
+			return &models.NotificationPreference{
+				UserID:   userID,
+				Delivery: string(notificationService.DeliveryImmediate),
+			}, nil
+		}
+		return nil, err
+	}
+	return pref, nil
+}
+
+func (s *NotificationService) GetPreferences(userID string) (*notificationService.Preferences, error) {
+	pref, err := s.getPreference(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := &notificationService.Preferences{
+		Delivery: notificationService.Delivery(pref.Delivery),
+		Events:   make(map[notificationService.Event]bool, len(notificationService.Events)),
+	}
+	for _, event := range notificationService.Events {
+		prefs.Events[event] = !pref.MutedEvents.Contains(string(event))
+	}
+	return prefs, nil
+}
+
+// UpdatePreferences applies the given preferences, events missing from the map are left untouched
+func (s *NotificationService) UpdatePreferences(userID string, prefs *notificationService.Preferences) (*notificationService.Preferences, error) {
+	pref, err := s.getPreference(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefs.Delivery != "" {
+		if prefs.Delivery != notificationService.DeliveryImmediate && prefs.Delivery != notificationService.DeliveryDigest {
+			return nil, notificationService.ErrInvalidDelivery
+		}
+		pref.Delivery = string(prefs.Delivery)
+	}
+
+	for event := range prefs.Events {
+		if !isKnownEvent(event) {
+			return nil, notificationService.ErrUnknownEvent
+		}
+	}
+
+	muted := make(models.EventList, 0, len(notificationService.Events))
+	for _, event := range notificationService.Events {
+		enabled, exists := prefs.Events[event]
+		if !exists {
+			enabled = !pref.MutedEvents.Contains(string(event))
+		}
+		if !enabled {
+			muted = append(muted, string(event))
+		}
+	}
+	pref.MutedEvents = muted
+
+	if err := s.notificationStore.SavePreference(pref); err != nil {
+		return nil, err
+	}
+
+	return s.GetPreferences(userID)
+}
+
+// GetDelivery tells how an event should be delivered to a user
+func (s *NotificationService) GetDelivery(userID string, event notificationService.Event) (notificationService.Delivery, error) {
+	pref, err := s.getPreference(userID)
+	if err != nil {
+		return "", err
+	}
+	if pref.MutedEvents.Contains(string(event)) {
+		return notificationService.DeliveryNone, nil
+	}
+	return notificationService.Delivery(pref.Delivery), nil
+}
+
+func (s *NotificationService) GetHistory(userID string) ([]models.Notification, error) {
+	return s.notificationStore.GetUserNotifications(userID)
+}
+
+// ForgetUser removes the preferences of an erased user and anonymises their history
+func (s *NotificationService) ForgetUser(userID string) error {
+	return s.notificationStore.AnonymiseUserNotifications(userID)
+}
+
+// Unsubscribe
+
+func (s *NotificationService) unsubscribeKey() []byte {
+	// Derive a dedicated key so unsubscribe tokens can never be mistaken for JWTs
+	mac := hmac.New(sha256.New, []byte(s.config.Auth.JWT.Secret))
+	mac.Write([]byte("unsubscribe"))
+	return mac.Sum(nil)
+}
+
+// CreateUnsubscribeToken signs the user, the event and the expiry of an unsubscribe link
+func (s *NotificationService) CreateUnsubscribeToken(userID string, event notificationService.Event) string {
+	expiresAt := time.Now().Add(unsubscribeTokenLifetime).Unix()
+	payload := userID + ":" + string(event) + ":" + strconv.FormatInt(expiresAt, 10)
+
+	mac := hmac.New(sha256.New, s.unsubscribeKey())
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *NotificationService) verifyUnsubscribeToken(token string) (userID string, event notificationService.Event, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: errors.New("malformed token")}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: err}
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: err}
+	}
+
+	mac := hmac.New(sha256.New, s.unsubscribeKey())
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: errors.New("bad signature")}
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 || fields[0] == "" || (!isKnownEvent(notificationService.Event(fields[1])) && notificationService.Event(fields[1]) != notificationService.EventAll) {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: errors.New("bad payload")}
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: err}
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", "", &notificationService.InvalidUnsubscribeTokenError{Err: errors.New("expired token")}
+	}
+
+	return fields[0], notificationService.Event(fields[1]), nil
+}
+
+// Unsubscribe mutes the event carried by a signed unsubscribe token.
+// The token is refused once expired or when its user was deleted.
+func (s *NotificationService) Unsubscribe(token string) error {
+	userID, event, err := s.verifyUnsubscribeToken(token)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.userStore.GetUser(userID); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return &notificationService.InvalidUnsubscribeTokenError{Err: err}
+		}
+		return err
+	}
+
+	muted := map[notificationService.Event]bool{event: false}
+	if event == notificationService.EventAll {
+		muted = make(map[notificationService.Event]bool, len(notificationService.Events))
+		for _, e := range notificationService.Events {
+			muted[e] = false
+		}
+	}
+
+	if _, err := s.UpdatePreferences(userID, &notificationService.Preferences{Events: muted}); err != nil {
+		return err
+	}
+
+	s.logger.Info("User unsubscribed", zap.String("userID", userID), zap.String("event", string(event)))
+	return nil
+}
+
+func isKnownEvent(event notificationService.Event) bool {
+	for _, e := range notificationService.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"onxzy/super-santa-server/services/notificationService"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signUnsubscribeToken signs an unsubscribe token expiring at expiresAt
+func (s *testServices) signUnsubscribeToken(userID string, event notificationService.Event, expiresAt time.Time) string {
+	payload := userID + ":" + string(event) + ":" + strconv.FormatInt(expiresAt.Unix(), 10)
+
+	mac := hmac.New(sha256.New, s.notifications.unsubscribeKey())
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestUnsubscribeTokenRoundTrip(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	user := group.Users[1]
+
+	if err := s.notifications.Unsubscribe(s.notifications.CreateUnsubscribeToken(user.ID, notificationService.EventMailbox)); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	prefs, err := s.notifications.GetPreferences(user.ID)
+	if err != nil {
+		t.Fatalf("GetPreferences: %v", err)
+	}
+	for _, event := range notificationService.Events {
+		if prefs.Events[event] != (event != notificationService.EventMailbox) {
+			t.Errorf("expected only %s to be muted, got %s enabled=%v", notificationService.EventMailbox, event, prefs.Events[event])
+		}
+	}
+
+	if err := s.notifications.Unsubscribe(s.notifications.CreateUnsubscribeToken(user.ID, notificationService.EventAll)); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if prefs, err = s.notifications.GetPreferences(user.ID); err != nil {
+		t.Fatalf("GetPreferences: %v", err)
+	}
+	for _, event := range notificationService.Events {
+		if prefs.Events[event] {
+			t.Errorf("expected %s to be muted", event)
+		}
+	}
+
+	other := group.Users[2]
+	token := s.notifications.CreateUnsubscribeToken(other.ID, notificationService.EventMailbox)
+	if err := s.userStore.DeleteUser(other.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	for name, token := range map[string]string{
+		"tampered": s.notifications.CreateUnsubscribeToken(user.ID, notificationService.EventMailbox) + "x",
+		"expired":  s.signUnsubscribeToken(user.ID, notificationService.EventMailbox, time.Now().Add(-time.Minute)),
+		"deleted":  token,
+	} {
+		var invalidToken *notificationService.InvalidUnsubscribeTokenError
+		if err := s.notifications.Unsubscribe(token); !errors.As(err, &invalidToken) {
+			t.Errorf("%s: expected InvalidUnsubscribeTokenError, got %v", name, err)
+		}
+	}
+	if _, err := s.notificationStore.GetPreference(other.ID); err == nil {
+		t.Errorf("expected no preferences to be saved for a deleted user")
+	}
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/utils"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const day = 24 * time.Hour
+
+// RetentionService purges the groups whose gift exchange is long past and the
+// soft-deleted rows, following the retention section of the config
+type RetentionService struct {
+	config         *utils.Config
+	retentionStore *database.RetentionStore
+	mailService    *MailService
+	logger         *zap.Logger
+}
+
+func NewRetentionService(lc fx.Lifecycle, config *utils.Config, retentionStore *database.RetentionStore, mailService *MailService, logger *zap.Logger) *RetentionService {
+	s := &RetentionService{
+		config:         config,
+		retentionStore: retentionStore,
+		mailService:    mailService,
+		logger:         logger.Named("retention-service"),
+	}
+
+	if config.Retention.Interval <= 0 {
+		return s
+	}
+
+	stop := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go s.runRetention(stop)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(stop)
+			return nil
+		},
+	})
+
+	return s
+}
+
+func (s *RetentionService) runRetention(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(s.config.Retention.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Purge(time.Now()); err != nil {
+				s.logger.Error("Failed to apply the retention policy", zap.Error(err))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Purge applies the retention policy as of now. In dry run mode it only logs
+// the warnings it would send and the rows it would delete.
+func (s *RetentionService) Purge(now time.Time) error {
+	if err := s.purgeExchangedGroups(now); err != nil {
+		return err
+	}
+	return s.purgeDeleted(now)
+}
+
+// purgeExchangedGroups warns the admins of the groups about to be purged and
+// purges the groups whose admin was warned long enough ago
+func (s *RetentionService) purgeExchangedGroups(now time.Time) error {
+	policy := s.config.Retention
+	if policy.ExchangeDays <= 0 {
+		return nil
+	}
+	keep := time.Duration(policy.ExchangeDays) * day
+	notice := time.Duration(policy.WarningDays) * day
+
+	groups, err := s.retentionStore.GetGroupsExchangedBefore(now.Add(notice - keep))
+	if err != nil {
+		return err
+	}
+
+	for i := range groups {
+		group := &groups[i]
+		purgeAt := group.ExchangeDate.Add(keep)
+
+		if policy.WarningDays > 0 {
+			if group.PurgeWarnedAt == nil {
+				// Admins always get the full notice, even when the job was off
+				s.warn(group, latest(purgeAt, now.Add(notice)), now)
+				continue
+			}
+			purgeAt = latest(purgeAt, group.PurgeWarnedAt.Add(notice))
+		}
+		if now.Before(purgeAt) {
+			continue
+		}
+
+		s.purgeGroup(group, "exchange date passed")
+	}
+	return nil
+}
+
+func (s *RetentionService) warn(group *models.Group, purgeAt time.Time, now time.Time) {
+	logger := s.logger.With(zap.String("groupID", group.ID), zap.Time("purgeAt", purgeAt))
+	if s.config.Retention.DryRun {
+		logger.Info("Dry run: would warn the admin of the group purge")
+		return
+	}
+
+	for i := range group.Users {
+		if group.Users[i].IsAdmin {
+			s.mailService.SendPurgeWarning(group, &group.Users[i], purgeAt)
+		}
+	}
+	if err := s.retentionStore.MarkPurgeWarned(group.ID, now); err != nil {
+		logger.Error("Failed to record the purge warning", zap.Error(err))
+		return
+	}
+	logger.Info("Admin warned of the group purge")
+}
+
+// purgeDeleted hard-deletes the groups and users soft-deleted long enough ago
+func (s *RetentionService) purgeDeleted(now time.Time) error {
+	policy := s.config.Retention
+	if policy.DeletedDays <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-time.Duration(policy.DeletedDays) * day)
+
+	groups, err := s.retentionStore.GetGroupsDeletedBefore(cutoff)
+	if err != nil {
+		return err
+	}
+	for i := range groups {
+		s.purgeGroup(&groups[i], "soft-deleted")
+	}
+
+	users, err := s.retentionStore.GetUsersDeletedBefore(cutoff)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		logger := s.logger.With(zap.String("userID", user.ID), zap.String("groupID", user.GroupID))
+		if policy.DryRun {
+			logger.Info("Dry run: would purge the soft-deleted user")
+			continue
+		}
+		if err := s.retentionStore.PurgeUser(user.ID); err != nil {
+			logger.Error("Failed to purge user", zap.Error(err))
+			continue
+		}
+		logger.Info("Soft-deleted user purged")
+	}
+	return nil
+}
+
+func (s *RetentionService) purgeGroup(group *models.Group, reason string) {
+	logger := s.logger.With(zap.String("groupID", group.ID), zap.String("reason", reason))
+	if s.config.Retention.DryRun {
+		logger.Info("Dry run: would purge the group")
+		return
+	}
+
+	if err := s.retentionStore.PurgeGroup(group.ID); err != nil {
+		logger.Error("Failed to purge group", zap.Error(err))
+		return
+	}
+	logger.Info("Group purged")
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/textproto"
+	"onxzy/super-santa-server/database"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/utils"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+// testServices wires the services on a migrated SQLite database, with mail disabled until startSMTP
+type testServices struct {
+	config            *utils.Config
+	groupStore        *database.GroupStore
+	userStore         *database.UserStore
+	mailboxStore      *database.MailboxStore
+	notificationStore *database.NotificationStore
+	groups            *GroupService
+	users             *UserService
+	mailbox           *MailboxService
+	mail              *MailService
+	notifications     *NotificationService
+}
+
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+
+	config := &utils.Config{}
+	config.DB.Driver = database.DriverSQLite
+	config.DB.SQLitePath = filepath.Join(t.TempDir(), "test.db")
+	config.Mail.DigestInterval = 3600
+
+	logger := zap.NewNop()
+	db, err := database.OpenDB(logger, config)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(0); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	groupStore := database.NewGroupStore(db)
+	userStore := database.NewUserStore(db)
+	mailboxStore := database.NewMailboxStore(db)
+	uow := database.NewTransactionManager(db)
+	notificationStore := database.NewNotificationStore(db)
+	notificationService := NewNotificationService(config, notificationStore, userStore, logger)
+	mailService, err := NewMailService(fxtest.NewLifecycle(t), config, notificationService, notificationStore, userStore, logger)
+	if err != nil {
+		t.Fatalf("NewMailService: %v", err)
+	}
+
+	return &testServices{
+		config:            config,
+		groupStore:        groupStore,
+		userStore:         userStore,
+		mailboxStore:      mailboxStore,
+		notificationStore: notificationStore,
+		groups:            NewGroupService(config, groupStore, uow, mailService, logger),
+		users:             NewUserService(userStore, uow, mailService, notificationService, logger),
+		mailbox:           NewMailboxService(groupStore, mailboxStore, uow, mailService, logger),
+		mail:              mailService,
+		notifications:     notificationService,
+	}
+}
+
+// fakeSMTP is a minimal SMTP server recording the recipients of the messages it accepts
+type fakeSMTP struct {
+	fail atomic.Bool // Reject every message while set
+
+	mu         sync.Mutex
+	recipients []string
+}
+
+// startSMTP enables email sending towards a fake SMTP server
+func (s *testServices) startSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSMTP{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	s.config.Mail.Enabled = true
+	s.config.Mail.SMTP.Host = "127.0.0.1"
+	s.config.Mail.SMTP.Port = listener.Addr().(*net.TCPAddr).Port
+	s.config.Mail.SMTP.FromEmail = "santa@example.com"
+	return server
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	var recipients []string
+	text.PrintfLine("220 localhost ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			text.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			if f.fail.Load() {
+				text.PrintfLine("550 Rejected")
+				continue
+			}
+			recipients = nil
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			recipients = append(recipients, strings.Trim(line[strings.Index(line, ":")+1:], "<> "))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			if _, err := text.ReadDotBytes(); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.recipients = append(f.recipients, recipients...)
+			f.mu.Unlock()
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+// sent returns the recipients of every accepted message
+func (f *fakeSMTP) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.recipients)
+}
+
+// createGroup creates a group of n participants, the first one being its admin.
+// The public key secret of each user is their index, to find them in a draw order.
+func (s *testServices) createGroup(t *testing.T, n int) *models.Group {
+	t.Helper()
+
+	group := &models.Group{
+		Name:           "group",
+		SecretVerifier: "verifier.salt",
+	}
+	for i := range n {
+		name := "user" + strconv.Itoa(i)
+		group.Users = append(group.Users, models.User{
+			Username:     name,
+			Email:        name + "@example.com",
+			IsAdmin:      i == 0,
+			Participates: true,
+
+			PublicKeySecret: strconv.Itoa(i),
+		})
+	}
+	if err := s.groupStore.CreateGroup(group); err != nil {
+		t.Fatalf("CreateGroup: %v", err)
+	}
+	return group
+}
+
+// adminOf returns the ID of the admin of group
+func adminOf(t *testing.T, group *models.Group) string {
+	t.Helper()
+
+	for _, user := range group.Users {
+		if user.IsAdmin {
+			return user.ID
+		}
+	}
+	t.Fatalf("group %s has no admin", group.ID)
+	return ""
+}
+
+func (s *testServices) setState(t *testing.T, group *models.Group, state models.GroupState) {
+	t.Helper()
+
+	if err := s.groupStore.SetGroupState(group.ID, state); err != nil {
+		t.Fatalf("SetGroupState: %v", err)
+	}
+	group.State = state
+}
+
+// drawKeys returns n public keys as the clients send them for a draw
+func drawKeys(t *testing.T, n int) []string {
+	t.Helper()
+
+	keys, _ := drawKeyPairs(t, n)
+	return keys
+}
+
+// drawKeyPairs returns n public keys as the clients send them for a draw, with their private keys
+func drawKeyPairs(t *testing.T, n int) ([]string, []*rsa.PrivateKey) {
+	t.Helper()
+
+	keys := make([]string, n)
+	privateKeys := make([]*rsa.PrivateKey, n)
+	for i := range keys {
+		private, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			t.Fatalf("GenerateKey: %v", err)
+		}
+		privateKeys[i] = private
+		key, err := jwk.Import(private.Public())
+		if err != nil {
+			t.Fatalf("Import: %v", err)
+		}
+		if err := key.Set(jwk.AlgorithmKey, jwa.RSA_OAEP_256()); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		raw, err := json.Marshal(key)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		keys[i] = string(raw)
+	}
+	return keys, privateKeys
+}
+
+// draw runs a whole draw of group, the g-th gift of the i-th member of the order
+// being given by the owner of keys[(i+g+1) mod n]
+func (s *testServices) draw(t *testing.T, group *models.Group, keys []string) {
+	t.Helper()
+
+	order, err := s.groups.InitDraw(group.ID, adminOf(t, group))
+	if err != nil {
+		t.Fatalf("InitDraw: %v", err)
+	}
+	n := len(order.PublicKeys)
+	publicKeys := make([]string, 0, n*order.GiftsPerPerson)
+	for gift := range order.GiftsPerPerson {
+		for i := range n {
+			publicKeys = append(publicKeys, keys[(i+gift+1)%n])
+		}
+	}
+	if err := s.groups.FinishDraw(group.ID, adminOf(t, group), publicKeys, nil); err != nil {
+		t.Fatalf("FinishDraw: %v", err)
+	}
+}
+
+// drawPayloads runs a whole draw of group with a key per member, and returns the
+// payloads each key opens
+func (s *testServices) drawPayloads(t *testing.T, group *models.Group) [][]drawPayload {
+	t.Helper()
+
+	keys, privateKeys := drawKeyPairs(t, len(group.Users))
+	s.draw(t, group, keys)
+	return s.openResults(t, group.ID, privateKeys)
+}
+
+// openResults decrypts the results of the latest draw of the group as their givers do,
+// and returns the payloads each private key opens
+func (s *testServices) openResults(t *testing.T, groupID string, privateKeys []*rsa.PrivateKey) [][]drawPayload {
+	t.Helper()
+
+	results, err := s.groups.GetResults(groupID)
+	if err != nil {
+		t.Fatalf("GetResults: %v", err)
+	}
+	payloads := make([][]drawPayload, len(privateKeys))
+	for owner, privateKey := range privateKeys {
+		for _, result := range results {
+			plaintext, err := jwe.Decrypt([]byte(result.Payload), jwe.WithKey(jwa.RSA_OAEP_256(), privateKey))
+			if err != nil {
+				continue
+			}
+			var payload drawPayload
+			if err := json.Unmarshal(plaintext, &payload); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			payloads[owner] = append(payloads[owner], payload)
+		}
+	}
+	return payloads
+}
+
+// otherMember returns a member of group who is none of userIDs
+func otherMember(t *testing.T, group *models.Group, userIDs ...string) string {
+	t.Helper()
+
+	for _, user := range group.Users {
+		if !slices.Contains(userIDs, user.ID) {
+			return user.ID
+		}
+	}
+	t.Fatalf("group %s has no other member", group.ID)
+	return ""
+}
+
+// envelope returns a compact JWE with alg and enc A256GCM, as sealed by the clients
+func envelope(alg string) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "enc": "A256GCM"})
+	encryptedKey := make([]byte, 128)
+	if alg == "dir" {
+		encryptedKey = nil
+	}
+	parts := [][]byte{header, encryptedKey, make([]byte, 12), []byte("sealed"), make([]byte, 16)}
+
+	encoded := make([]string, len(parts))
+	for i, part := range parts {
+		encoded[i] = base64.RawURLEncoding.EncodeToString(part)
+	}
+	return strings.Join(encoded, ".")
+}
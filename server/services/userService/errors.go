@@ -5,4 +5,21 @@ import "github.com/pkg/errors"
 var (
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrUserIsAdmin       = errors.New("user is the group admin")
+	ErrNotAdmin          = errors.New("user is not the group admin")
+	ErrEmailAlreadyUsed  = errors.New("email already used by another member of the group")
+	ErrInvalidEmailToken = errors.New("invalid or expired email confirmation token")
+	ErrMessageKeySet     = errors.New("the user already has a message key")
+
+	ErrWishItemNotFound = errors.New("wish item not found")
+	ErrCurrencyRequired = errors.New("a price requires a currency")
+	ErrCurrencyMismatch = errors.New("price currency doesn't match the group budget currency")
+	ErrOverBudget       = errors.New("price exceeds the group budget")
+
+	ErrWishPayloadRequired   = errors.New("the group has encrypted wishes, title, url and notes must be sent in the payload")
+	ErrWishPayloadUnexpected = errors.New("the group doesn't have encrypted wishes, the payload must be empty")
+
+	ErrOwnWishItem          = errors.New("members can't claim their own wish items")
+	ErrWishItemClaimed      = errors.New("wish item already claimed")
+	ErrWishItemNotClaimedBy = errors.New("wish item isn't claimed by the user")
 )
@@ -1,28 +1,39 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"onxzy/super-santa-server/database"
 	"onxzy/super-santa-server/database/models"
 	"onxzy/super-santa-server/services/groupService"
 	"onxzy/super-santa-server/services/userService"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// emailTokenLifetime is how long the link confirming a new email stays valid
+const emailTokenLifetime = 48 * time.Hour
+
 type UserService struct {
-	userStore   *database.UserStore
-	groupStore  *database.GroupStore
-	mailService *MailService
-	logger      *zap.Logger
+	userStore           database.UserRepository
+	uow                 database.UnitOfWork
+	mailService         *MailService
+	notificationService *NotificationService
+	logger              *zap.Logger
 }
 
-func NewUserService(userStore *database.UserStore, groupStore *database.GroupStore, mailService *MailService, logger *zap.Logger) *UserService {
+func NewUserService(userStore database.UserRepository, uow database.UnitOfWork, mailService *MailService, notificationService *NotificationService, logger *zap.Logger) *UserService {
 	return &UserService{
-		userStore:   userStore,
-		groupStore:  groupStore,
-		mailService: mailService,
-		logger:      logger.Named("user-service"),
+		userStore:           userStore,
+		uow:                 uow,
+		mailService:         mailService,
+		notificationService: notificationService,
+		logger:              logger.Named("user-service"),
 	}
 }
 
@@ -39,18 +50,31 @@ func (s *UserService) GetUser(userID string) (*models.User, error) {
 }
 
 func (s *UserService) CreateUser(user *models.User) error {
-	group, err := s.groupStore.GetGroup(user.GroupID)
-	if err != nil {
-		if errors.Is(err, database.ErrGroupNotFound) {
-			return groupService.ErrGroupNotFound
+	// Joining is serialized with the draw and only allowed while the group is open
+	var group *models.Group
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, user.GroupID)
+		if err != nil {
+			return err
 		}
-		return err
-	}
 
-	if err := s.userStore.CreateUser(user); err != nil {
-		if errors.Is(err, database.ErrUserAlreadyExists) {
-			return userService.ErrUserAlreadyExists
+		if err := groupService.CheckAction(group.State, groupService.ActionJoin); err != nil {
+			return err
+		}
+
+		if err := repos.Users().CreateUser(user); err != nil {
+			if errors.Is(err, database.ErrUserAlreadyExists) {
+				return userService.ErrUserAlreadyExists
+			}
+			if errors.Is(err, database.ErrEmailAlreadyUsed) {
+				return userService.ErrEmailAlreadyUsed
+			}
+			return err
 		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
 
@@ -91,6 +115,565 @@ func (s *UserService) UpdateUser(user *models.User) error {
 	return nil
 }
 
-func (s *UserService) DeleteUser(userID string) error {
-	return s.userStore.DeleteUser(userID)
+// UpdateProfile changes the username of a member, and their email once confirmed:
+// a new email stays pending until the link mailed to it is followed, see ConfirmEmail.
+// Empty values are left unchanged, and sending the current email cancels a pending one.
+func (s *UserService) UpdateProfile(groupID string, userID string, username string, email string) (*models.User, error) {
+	var group *models.Group
+	var user *models.User
+	var token string
+	err := s.uow.Do(func(repos database.Repositories) error {
+		var err error
+		group, err = lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateProfile); err != nil {
+			return err
+		}
+
+		user, err = groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+
+		if email == user.Email {
+			user.PendingEmail, user.EmailTokenHash, user.EmailTokenExpiresAt = "", "", nil
+		} else if email != "" {
+			// Logins look members up by email, it must stay unique in the group
+			other, err := repos.Users().GetGroupUserByEmail(groupID, email)
+			if err == nil && other.ID != user.ID {
+				return userService.ErrEmailAlreadyUsed
+			}
+			if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+				return err
+			}
+
+			secret, err := newEmailToken()
+			if err != nil {
+				return err
+			}
+			expiresAt := time.Now().Add(emailTokenLifetime)
+			user.PendingEmail, user.EmailTokenHash, user.EmailTokenExpiresAt = email, hashToken(secret), &expiresAt
+			token = user.ID + "." + secret
+		}
+		if username != "" {
+			user.Username = username
+		}
+
+		return updateMemberProfile(repos, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		s.mailService.SendEmailConfirmation(group, user, token)
+	}
+	return user, nil
+}
+
+// ConfirmEmail replaces the email of a member by their pending email, with the
+// token of the link mailed to it. The password verifier doesn't depend on the
+// email, logins use the new email as their SRP identity from then on.
+func (s *UserService) ConfirmEmail(token string) (*models.User, error) {
+	userID, secret, found := strings.Cut(token, ".")
+	if !found {
+		return nil, userService.ErrInvalidEmailToken
+	}
+	member, err := s.userStore.GetUser(userID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, userService.ErrInvalidEmailToken
+		}
+		return nil, err
+	}
+
+	var user *models.User
+	err = s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, member.GroupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateProfile); err != nil {
+			return err
+		}
+
+		user, err = groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if user.EmailTokenHash == "" || user.EmailTokenExpiresAt == nil || time.Now().After(*user.EmailTokenExpiresAt) ||
+			subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(user.EmailTokenHash)) != 1 {
+			return userService.ErrInvalidEmailToken
+		}
+
+		user.Email = user.PendingEmail
+		user.PendingEmail, user.EmailTokenHash, user.EmailTokenExpiresAt = "", "", nil
+		return updateMemberProfile(repos, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("User email confirmed", zap.String("groupID", user.GroupID), zap.String("userID", user.ID))
+	return user, nil
+}
+
+// updateMemberProfile saves user and maps the conflicts on their username or email
+func updateMemberProfile(repos database.Repositories, user *models.User) error {
+	if err := repos.Users().UpdateUser(user); err != nil {
+		if errors.Is(err, database.ErrUserAlreadyExists) {
+			return userService.ErrUserAlreadyExists
+		}
+		if errors.Is(err, database.ErrEmailAlreadyUsed) {
+			return userService.ErrEmailAlreadyUsed
+		}
+		return err
+	}
+	return nil
+}
+
+// newEmailToken returns the secret of a link confirming a new email
+func newEmailToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate email token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// SetMessageKey gives a key pair of the mailbox to a member who joined before
+// they existed. It can't be replaced, the messages encrypted to it would be lost.
+func (s *UserService) SetMessageKey(groupID string, userID string, publicKeySecret string, privateKeyEncrypted string) (*models.User, error) {
+	var user *models.User
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateProfile); err != nil {
+			return err
+		}
+
+		user, err = groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if user.MessageKeySecret != "" {
+			return userService.ErrMessageKeySet
+		}
+
+		user.MessageKeySecret, user.MessageKeyEncrypted = publicKeySecret, privateKeyEncrypted
+		return repos.Users().UpdateUser(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser removes a member from a group which is still open, on behalf of the admin
+func (s *UserService) DeleteUser(groupID string, adminID string, userID string) error {
+	return s.removeMember(groupID, userID, func(group *models.Group) error {
+		_, err := groupAdmin(group, adminID)
+		return err
+	})
+}
+
+// LeaveGroup removes a member from a group which is still open, at their request
+func (s *UserService) LeaveGroup(groupID string, userID string) error {
+	return s.removeMember(groupID, userID, func(*models.Group) error { return nil })
+}
+
+// removeMember deletes the member userID of an open group once allowed by check.
+// The admin can't be removed, they must transfer the role first.
+func (s *UserService) removeMember(groupID string, userID string, check func(group *models.Group) error) error {
+	return s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+
+		if err := groupService.CheckAction(group.State, groupService.ActionLeave); err != nil {
+			return err
+		}
+		if err := check(group); err != nil {
+			return err
+		}
+
+		user, err := groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if user.IsAdmin {
+			return userService.ErrUserIsAdmin
+		}
+
+		return repos.Users().DeleteUser(user.ID)
+	})
+}
+
+// EraseAccount deletes the account of a user at their request, along with
+// their notification preferences, and anonymises their notification history.
+// The admin can only erase their account once alone in the group, which
+// deletes the group too, or once the group is archived.
+func (s *UserService) EraseAccount(groupID string, userID string) error {
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+
+		if err := groupService.CheckAction(group.State, groupService.ActionEraseAccount); err != nil {
+			return err
+		}
+
+		user, err := groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+
+		if user.IsAdmin && len(group.Users) == 1 {
+			if err := repos.Users().DeleteUser(user.ID); err != nil {
+				return err
+			}
+			return repos.Groups().DeleteGroup(group.ID)
+		}
+		if user.IsAdmin && group.State != models.GroupStateArchived {
+			return userService.ErrUserIsAdmin
+		}
+
+		return repos.Users().DeleteUser(user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.notificationService.ForgetUser(userID); err != nil {
+		return err
+	}
+
+	s.logger.Info("User account erased", zap.String("groupID", groupID), zap.String("userID", userID))
+	return nil
+}
+
+// GetWishItems returns the wishlist of a user
+func (s *UserService) GetWishItems(userID string) ([]models.WishItem, error) {
+	user, err := s.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return user.WishItems, nil
+}
+
+// CreateWishItem adds an item to the wishlist of a member, until the group is archived.
+// Items without a position are added last.
+func (s *UserService) CreateWishItem(groupID string, userID string, item *models.WishItem, position *int) error {
+	return s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateWishes); err != nil {
+			return err
+		}
+
+		user, err := groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkWishEncryption(group, item); err != nil {
+			return err
+		}
+		if err := checkBudget(group, item); err != nil {
+			return err
+		}
+
+		item.UserID = user.ID
+		item.Position = len(user.WishItems)
+		if len(user.WishItems) > 0 {
+			item.Position = max(item.Position, user.WishItems[len(user.WishItems)-1].Position+1)
+		}
+		if position != nil {
+			item.Position = *position
+		}
+		return repos.Wishes().CreateWishItem(item)
+	})
+}
+
+// UpdateWishItem replaces an item of the wishlist of a member, until the group is archived.
+// The item keeps its position if none is given.
+func (s *UserService) UpdateWishItem(groupID string, userID string, item *models.WishItem, position *int) error {
+	return s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateWishes); err != nil {
+			return err
+		}
+
+		user, err := groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		current, err := wishItem(user, item.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkWishEncryption(group, item); err != nil {
+			return err
+		}
+		if err := checkBudget(group, item); err != nil {
+			return err
+		}
+
+		item.UserID = user.ID
+		item.CreatedAt = current.CreatedAt
+		item.Position = current.Position
+		if position != nil {
+			item.Position = *position
+		}
+		return repos.Wishes().UpdateWishItem(item)
+	})
+}
+
+// DeleteWishItem removes an item from the wishlist of a member, until the group is archived
+func (s *UserService) DeleteWishItem(groupID string, userID string, itemID string) error {
+	return s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionUpdateWishes); err != nil {
+			return err
+		}
+
+		user, err := groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if _, err := wishItem(user, itemID); err != nil {
+			return err
+		}
+		return repos.Wishes().DeleteWishItem(itemID)
+	})
+}
+
+// ClaimWishItem marks the wish item of another member as being bought by userID.
+// The owner of the item never sees the claim.
+func (s *UserService) ClaimWishItem(groupID string, userID string, itemID string) (*models.WishItem, error) {
+	return s.setWishItemClaim(groupID, userID, itemID, true)
+}
+
+// UnclaimWishItem releases a wish item claimed by userID
+func (s *UserService) UnclaimWishItem(groupID string, userID string, itemID string) (*models.WishItem, error) {
+	return s.setWishItemClaim(groupID, userID, itemID, false)
+}
+
+func (s *UserService) setWishItemClaim(groupID string, userID string, itemID string, claim bool) (*models.WishItem, error) {
+	var item *models.WishItem
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+		if err := groupService.CheckAction(group.State, groupService.ActionClaimWish); err != nil {
+			return err
+		}
+
+		if _, err := groupMember(group, userID); err != nil {
+			return err
+		}
+		item, err = groupWishItem(group, itemID)
+		if err != nil {
+			return err
+		}
+		if item.UserID == userID {
+			return userService.ErrOwnWishItem
+		}
+
+		claimedByUser := item.ClaimedByID != nil && *item.ClaimedByID == userID
+		if claim {
+			if item.ClaimedByID != nil && !claimedByUser {
+				return userService.ErrWishItemClaimed
+			}
+			item.ClaimedByID = &userID
+		} else {
+			if !claimedByUser {
+				return userService.ErrWishItemNotClaimedBy
+			}
+			item.ClaimedByID = nil
+		}
+		return repos.Wishes().SetWishItemClaim(item.ID, item.ClaimedByID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	item.ViewClaim(userID)
+	return item, nil
+}
+
+// groupWishItem returns the wish item itemID of any member of a locked group
+func groupWishItem(group *models.Group, itemID string) (*models.WishItem, error) {
+	for i := range group.Users {
+		if item, err := wishItem(&group.Users[i], itemID); err == nil {
+			return item, nil
+		}
+	}
+	return nil, userService.ErrWishItemNotFound
+}
+
+// wishItem returns the wish item itemID of user
+func wishItem(user *models.User, itemID string) (*models.WishItem, error) {
+	for i := range user.WishItems {
+		if user.WishItems[i].ID == itemID {
+			return &user.WishItems[i], nil
+		}
+	}
+	return nil, userService.ErrWishItemNotFound
+}
+
+// checkWishEncryption requires the title, URL and notes of an item to be
+// encrypted into its payload when the group has encrypted wishes, and in clear otherwise
+func checkWishEncryption(group *models.Group, item *models.WishItem) error {
+	if !group.EncryptedWishes {
+		if item.Payload != "" {
+			return userService.ErrWishPayloadUnexpected
+		}
+		return nil
+	}
+	if item.Payload == "" || item.Title != "" || item.URL != "" || item.Notes != "" {
+		return userService.ErrWishPayloadRequired
+	}
+	return nil
+}
+
+// checkBudget checks the price of item against the budget of the group.
+// Prices without a currency are in the currency of the group.
+func checkBudget(group *models.Group, item *models.WishItem) error {
+	if item.Price == nil {
+		item.Currency = ""
+		return nil
+	}
+	if item.Currency == "" {
+		item.Currency = group.Currency
+	}
+	if item.Currency == "" {
+		return userService.ErrCurrencyRequired
+	}
+
+	if group.Budget == nil {
+		return nil
+	}
+	if item.Currency != group.Currency {
+		return userService.ErrCurrencyMismatch
+	}
+	if *item.Price > *group.Budget {
+		return userService.ErrOverBudget
+	}
+	return nil
+}
+
+// SetParticipation puts a member in or out of the draw, on behalf of the admin
+func (s *UserService) SetParticipation(groupID string, adminID string, userID string, participates bool) (*models.User, error) {
+	return s.updateMember(groupID, adminID, userID, groupService.ActionParticipation, func(user *models.User) bool {
+		if user.Participates == participates {
+			return false
+		}
+		user.Participates = participates
+		return true
+	})
+}
+
+// SetTeam puts a member in a team, or in none when team is empty, on behalf of the admin
+func (s *UserService) SetTeam(groupID string, adminID string, userID string, team string) (*models.User, error) {
+	return s.updateMember(groupID, adminID, userID, groupService.ActionUpdateTeam, func(user *models.User) bool {
+		if user.Team == team {
+			return false
+		}
+		user.Team = team
+		return true
+	})
+}
+
+// updateMember lets adminID apply update to the member userID, update returns false
+// when the member is left unchanged
+func (s *UserService) updateMember(groupID string, adminID string, userID string, action groupService.Action, update func(user *models.User) bool) (*models.User, error) {
+	var user *models.User
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+
+		if err := groupService.CheckAction(group.State, action); err != nil {
+			return err
+		}
+
+		if _, err := groupAdmin(group, adminID); err != nil {
+			return err
+		}
+
+		user, err = groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if !update(user) {
+			return nil
+		}
+		return repos.Users().UpdateUser(user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// TransferAdmin makes userID the admin of the group in place of adminID
+func (s *UserService) TransferAdmin(groupID string, adminID string, userID string) (*models.User, error) {
+	var newAdmin *models.User
+	err := s.uow.Do(func(repos database.Repositories) error {
+		group, err := lockGroup(repos, groupID)
+		if err != nil {
+			return err
+		}
+
+		if err := groupService.CheckAction(group.State, groupService.ActionTransferAdmin); err != nil {
+			return err
+		}
+
+		admin, err := groupAdmin(group, adminID)
+		if err != nil {
+			return err
+		}
+
+		newAdmin, err = groupMember(group, userID)
+		if err != nil {
+			return err
+		}
+		if newAdmin.ID == admin.ID {
+			return nil
+		}
+
+		// Demote first, only one admin is allowed per group
+		admin.IsAdmin = false
+		if err := repos.Users().UpdateUser(admin); err != nil {
+			return err
+		}
+		newAdmin.IsAdmin = true
+		return repos.Users().UpdateUser(newAdmin)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Group admin transferred",
+		zap.String("groupID", groupID),
+		zap.String("fromUserID", adminID),
+		zap.String("toUserID", userID))
+
+	return newAdmin, nil
 }
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"onxzy/super-santa-server/database/models"
+	"onxzy/super-santa-server/services/userService"
+	"testing"
+	"time"
+)
+
+// setEmailToken replaces the secret mailed for the pending email of user by secret
+func (s *testServices) setEmailToken(t *testing.T, userID string, secret string, expiresAt time.Time) string {
+	t.Helper()
+
+	user, err := s.userStore.GetUser(userID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	user.EmailTokenHash, user.EmailTokenExpiresAt = hashToken(secret), &expiresAt
+	if err := s.userStore.UpdateUser(user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	return userID + "." + secret
+}
+
+func TestJoinRejectsUsedEmail(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+
+	err := s.users.CreateUser(&models.User{Username: "other", Email: group.Users[1].Email, GroupID: group.ID})
+	if !errors.Is(err, userService.ErrEmailAlreadyUsed) {
+		t.Errorf("expected ErrEmailAlreadyUsed, got %v", err)
+	}
+}
+
+func TestUpdateProfileKeepsEmailPending(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+	user := group.Users[1]
+
+	updated, err := s.users.UpdateProfile(group.ID, user.ID, "", "new@example.com")
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if updated.Email != user.Email || updated.PendingEmail != "new@example.com" {
+		t.Fatalf("expected email %q pending %q, got %q pending %q", user.Email, "new@example.com", updated.Email, updated.PendingEmail)
+	}
+	if _, err := s.userStore.GetGroupUserByEmail(group.ID, "new@example.com"); err == nil {
+		t.Errorf("expected logins to ignore the pending email")
+	}
+
+	token := s.setEmailToken(t, user.ID, "secret", time.Now().Add(time.Hour))
+	if _, err := s.users.ConfirmEmail(user.ID + ".other"); !errors.Is(err, userService.ErrInvalidEmailToken) {
+		t.Errorf("expected ErrInvalidEmailToken for a wrong secret, got %v", err)
+	}
+	confirmed, err := s.users.ConfirmEmail(token)
+	if err != nil {
+		t.Fatalf("ConfirmEmail: %v", err)
+	}
+	if confirmed.Email != "new@example.com" || confirmed.PendingEmail != "" {
+		t.Errorf("expected email %q and no pending email, got %q pending %q", "new@example.com", confirmed.Email, confirmed.PendingEmail)
+	}
+	if _, err := s.users.ConfirmEmail(token); !errors.Is(err, userService.ErrInvalidEmailToken) {
+		t.Errorf("expected the token to be used once, got %v", err)
+	}
+}
+
+func TestConfirmEmailExpired(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+	user := group.Users[1]
+
+	if _, err := s.users.UpdateProfile(group.ID, user.ID, "", "new@example.com"); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	token := s.setEmailToken(t, user.ID, "secret", time.Now().Add(-time.Minute))
+	if _, err := s.users.ConfirmEmail(token); !errors.Is(err, userService.ErrInvalidEmailToken) {
+		t.Errorf("expected ErrInvalidEmailToken, got %v", err)
+	}
+}
+
+func TestConfirmEmailTakenMeanwhile(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+	user := group.Users[1]
+
+	if _, err := s.users.UpdateProfile(group.ID, user.ID, "", group.Users[0].Email); !errors.Is(err, userService.ErrEmailAlreadyUsed) {
+		t.Errorf("expected ErrEmailAlreadyUsed, got %v", err)
+	}
+
+	if _, err := s.users.UpdateProfile(group.ID, user.ID, "", "new@example.com"); err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	token := s.setEmailToken(t, user.ID, "secret", time.Now().Add(time.Hour))
+	if err := s.users.CreateUser(&models.User{Username: "other", Email: "new@example.com", GroupID: group.ID}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if _, err := s.users.ConfirmEmail(token); !errors.Is(err, userService.ErrEmailAlreadyUsed) {
+		t.Errorf("expected ErrEmailAlreadyUsed, got %v", err)
+	}
+}
+
+func TestSetMessageKeyOnce(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 2)
+	user := group.Users[1]
+
+	updated, err := s.users.SetMessageKey(group.ID, user.ID, "public", "private")
+	if err != nil {
+		t.Fatalf("SetMessageKey: %v", err)
+	}
+	if updated.MessageKeySecret != "public" || updated.MessageKeyEncrypted != "private" {
+		t.Errorf("expected the message key to be set, got %q and %q", updated.MessageKeySecret, updated.MessageKeyEncrypted)
+	}
+
+	if _, err := s.users.SetMessageKey(group.ID, user.ID, "other", "other"); !errors.Is(err, userService.ErrMessageKeySet) {
+		t.Errorf("expected ErrMessageKeySet, got %v", err)
+	}
+	stored, err := s.userStore.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if stored.MessageKeySecret != "public" {
+		t.Errorf("expected the message key to be kept, got %q", stored.MessageKeySecret)
+	}
+}
+
+// ownWishItem returns the item itemID from the wishlist of userID, as served on /group/wishes
+func (s *testServices) ownWishItem(t *testing.T, userID string, itemID string) models.WishItem {
+	t.Helper()
+
+	items, err := s.users.GetWishItems(userID)
+	if err != nil {
+		t.Fatalf("GetWishItems: %v", err)
+	}
+	for _, item := range items {
+		if item.ID == itemID {
+			return item
+		}
+	}
+	t.Fatalf("wish item %s not found", itemID)
+	return models.WishItem{}
+}
+
+// viewedWishItem returns the item itemID from the group view of viewerID
+func (s *testServices) viewedWishItem(t *testing.T, group *models.Group, viewerID string, itemID string) models.WishItem {
+	t.Helper()
+
+	viewed, err := s.groups.GetGroup(group.ID)
+	if err != nil {
+		t.Fatalf("GetGroup: %v", err)
+	}
+	viewed.ViewClaims(viewerID)
+	item, err := groupWishItem(viewed, itemID)
+	if err != nil {
+		t.Fatalf("groupWishItem: %v", err)
+	}
+	return *item
+}
+
+func TestClaimHiddenFromOwner(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	owner, member := group.Users[1], group.Users[2]
+
+	item := &models.WishItem{Title: "Book"}
+	if err := s.users.CreateWishItem(group.ID, owner.ID, item, nil); err != nil {
+		t.Fatalf("CreateWishItem: %v", err)
+	}
+	if _, err := s.users.ClaimWishItem(group.ID, member.ID, item.ID); err != nil {
+		t.Fatalf("ClaimWishItem: %v", err)
+	}
+
+	if viewed := s.viewedWishItem(t, group, member.ID, item.ID); !viewed.Claimed || !viewed.ClaimedByMe {
+		t.Errorf("expected the member to see their claim, got claimed=%v claimed_by_me=%v", viewed.Claimed, viewed.ClaimedByMe)
+	}
+	for name, seen := range map[string]models.WishItem{
+		"group view":   s.viewedWishItem(t, group, owner.ID, item.ID),
+		"own wishlist": s.ownWishItem(t, owner.ID, item.ID),
+	} {
+		encoded, err := json.Marshal(seen)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if fields["claimed"] != false || fields["claimed_by_me"] != false {
+			t.Errorf("%s: expected the owner to see no claim, got %s", name, encoded)
+		}
+		for _, hidden := range []string{"claimed_by_id", "ClaimedByID", "claimed_at", "ClaimedAt"} {
+			if _, ok := fields[hidden]; ok {
+				t.Errorf("%s: expected %s to be hidden, got %s", name, hidden, encoded)
+			}
+		}
+	}
+}
+
+func TestUpdateWishItemKeepsClaim(t *testing.T) {
+	s := newTestServices(t)
+	group := s.createGroup(t, 3)
+	owner, member := group.Users[1], group.Users[2]
+
+	item := &models.WishItem{Title: "Book"}
+	if err := s.users.CreateWishItem(group.ID, owner.ID, item, nil); err != nil {
+		t.Fatalf("CreateWishItem: %v", err)
+	}
+	if _, err := s.users.ClaimWishItem(group.ID, member.ID, item.ID); err != nil {
+		t.Fatalf("ClaimWishItem: %v", err)
+	}
+	claimed := s.ownWishItem(t, owner.ID, item.ID)
+	if claimed.ClaimedAt == nil {
+		t.Fatalf("expected the claim date to be set")
+	}
+
+	if err := s.users.UpdateWishItem(group.ID, owner.ID, &models.WishItem{ID: item.ID, Title: "Another book"}, nil); err != nil {
+		t.Fatalf("UpdateWishItem: %v", err)
+	}
+
+	updated := s.ownWishItem(t, owner.ID, item.ID)
+	if updated.Title != "Another book" {
+		t.Errorf("expected the title to be updated, got %q", updated.Title)
+	}
+	if updated.ClaimedByID == nil || *updated.ClaimedByID != member.ID {
+		t.Errorf("expected the item to stay claimed by %s, got %v", member.ID, updated.ClaimedByID)
+	}
+	if updated.ClaimedAt == nil || !updated.ClaimedAt.Equal(*claimed.ClaimedAt) {
+		t.Errorf("expected the claim date %v to be untouched, got %v", claimed.ClaimedAt, updated.ClaimedAt)
+	}
+	if viewed := s.viewedWishItem(t, group, member.ID, item.ID); !viewed.ClaimedByMe {
+		t.Errorf("expected the member to still see their claim")
+	}
+}
+
+func TestCheckWishEncryption(t *testing.T) {
+	price := int64(1500)
+	encrypted := &models.Group{EncryptedWishes: true}
+	plain := &models.Group{}
+
+	for name, test := range map[string]struct {
+		group *models.Group
+		item  models.WishItem
+		err   error
+	}{
+		"plaintext title": {encrypted, models.WishItem{Payload: "payload", Title: "Book"}, userService.ErrWishPayloadRequired},
+		"plaintext url":   {encrypted, models.WishItem{Payload: "payload", URL: "https://example.com"}, userService.ErrWishPayloadRequired},
+		"plaintext notes": {encrypted, models.WishItem{Payload: "payload", Notes: "Blue"}, userService.ErrWishPayloadRequired},
+		"missing payload": {encrypted, models.WishItem{}, userService.ErrWishPayloadRequired},
+		// The price, currency and priority stay in plaintext for the budget check
+		"payload with price": {encrypted, models.WishItem{Payload: "payload", Price: &price, Currency: "EUR", Priority: 2}, nil},
+		"plaintext item":     {plain, models.WishItem{Title: "Book"}, nil},
+		"unexpected payload": {plain, models.WishItem{Title: "Book", Payload: "payload"}, userService.ErrWishPayloadUnexpected},
+	} {
+		if err := checkWishEncryption(test.group, &test.item); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// Emails holds the default email templates, shipped inside the binary
+//
+//go:embed emails/*.html
+var Emails embed.FS
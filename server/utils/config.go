@@ -37,9 +37,37 @@ type Config struct {
 	} `mapstructure:"log"`
 
 	DB struct {
-		SQLitePath string `mapstructure:"sqlitepath"`
+		Driver      string `mapstructure:"driver"` // sqlite, postgres or mysql
+		DSN         string `mapstructure:"dsn"`    // Connection string, defaults to sqlitepath for sqlite
+		SQLitePath  string `mapstructure:"sqlitepath"`
+		AutoMigrate bool   `mapstructure:"auto_migrate"` // Apply pending migrations at startup
+		Pool        struct {
+			MaxOpenConns    int `mapstructure:"max_open_conns"`
+			MaxIdleConns    int `mapstructure:"max_idle_conns"`
+			ConnMaxLifetime int `mapstructure:"conn_max_lifetime"`  // Seconds
+			ConnMaxIdleTime int `mapstructure:"conn_max_idle_time"` // Seconds
+		} `mapstructure:"pool"`
 	} `mapstructure:"db"`
 
+	Encryption struct {
+		Key     string `mapstructure:"key" json:"-"` // Primary key encrypting the user columns, formatted as <id>:<base64 key>
+		KeyFile string `mapstructure:"key_file"`     // File of keys, one per line, the first one is primary when key is empty
+	} `mapstructure:"encryption"`
+
+	Backup struct {
+		Interval int    `mapstructure:"interval"` // Seconds between two scheduled backups, 0 disables them
+		Dir      string `mapstructure:"dir"`
+		Keep     int    `mapstructure:"keep"` // Number of backups to keep, 0 keeps them all
+	} `mapstructure:"backup"`
+
+	Retention struct {
+		Interval     int  `mapstructure:"interval"`      // Seconds between two retention runs, 0 disables them
+		ExchangeDays int  `mapstructure:"exchange_days"` // Purge groups this many days after their exchange date, 0 keeps them
+		DeletedDays  int  `mapstructure:"deleted_days"`  // Purge soft-deleted groups and users this many days after deletion, 0 keeps them
+		WarningDays  int  `mapstructure:"warning_days"`  // Warn the admin this many days before purging their group, 0 disables the warning
+		DryRun       bool `mapstructure:"dry_run"`       // Only log what would be purged
+	} `mapstructure:"retention"`
+
 	Mail struct {
 		SMTP struct {
 			Host      string `mapstructure:"host"`
@@ -49,8 +77,15 @@ type Config struct {
 			FromEmail string `mapstructure:"from_email"`
 			FromName  string `mapstructure:"from_name"`
 		} `mapstructure:"smtp"`
-		Enabled      bool   `mapstructure:"enabled"`
-		TemplatesDir string `mapstructure:"templates_dir"`
+		DKIM struct {
+			Selector       string `mapstructure:"selector"`
+			Domain         string `mapstructure:"domain"`
+			PrivateKeyPath string `mapstructure:"private_key_path"` // PEM encoded RSA or Ed25519 key
+		} `mapstructure:"dkim"`
+		Enabled        bool   `mapstructure:"enabled"`
+		TemplatesDir   string `mapstructure:"templates_dir"`   // Optional directory overriding the embedded templates
+		DigestInterval int    `mapstructure:"digest_interval"` // Seconds between two digest emails
+		Preview        bool   `mapstructure:"preview"`         // Expose the unauthenticated sample preview, development only
 	} `mapstructure:"mail"`
 }
 
@@ -75,14 +110,36 @@ func InitConfig() *Config {
 	v.SetDefault("auth.jwt.expire_group", 3600)
 	v.SetDefault("cors.allow_origins", []string{"*"})
 	v.SetDefault("log.level", "info")
+	v.SetDefault("db.driver", "sqlite")
+	v.SetDefault("db.dsn", "")
 	v.SetDefault("db.sqlitepath", "data.db")
+	v.SetDefault("db.auto_migrate", false)
+	v.SetDefault("db.pool.max_open_conns", 0)
+	v.SetDefault("db.pool.max_idle_conns", 0)
+	v.SetDefault("db.pool.conn_max_lifetime", 0)
+	v.SetDefault("db.pool.conn_max_idle_time", 0)
+	v.SetDefault("encryption.key", "")
+	v.SetDefault("encryption.key_file", "")
+	v.SetDefault("backup.interval", 0)
+	v.SetDefault("backup.dir", "backups")
+	v.SetDefault("backup.keep", 7)
+	v.SetDefault("retention.interval", 0)
+	v.SetDefault("retention.exchange_days", 0)
+	v.SetDefault("retention.deleted_days", 0)
+	v.SetDefault("retention.warning_days", 7)
+	v.SetDefault("retention.dry_run", false)
 	v.SetDefault("mail.enabled", false)
-	v.SetDefault("mail.templates_dir", "./templates/emails")
+	v.SetDefault("mail.templates_dir", "")
+	v.SetDefault("mail.digest_interval", 86400)
+	v.SetDefault("mail.preview", false)
 	v.SetDefault("mail.smtp.host", "")
 	v.SetDefault("mail.smtp.port", 587)
 	v.SetDefault("mail.smtp.username", "")
 	v.SetDefault("mail.smtp.password", "")
 	v.SetDefault("mail.smtp.from_email", "")
+	v.SetDefault("mail.dkim.selector", "")
+	v.SetDefault("mail.dkim.domain", "")
+	v.SetDefault("mail.dkim.private_key_path", "")
 
 	// Configure environment variables
 	v.SetEnvPrefix("SSS") // Secret Santa Server
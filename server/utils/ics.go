@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+type CalendarEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	URL         string
+	Date        time.Time // All-day event, only the date part is used
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// BuildICS renders a single all-day event as an RFC 5545 calendar
+func BuildICS(event CalendarEvent) []byte {
+	var buf bytes.Buffer
+	writeLine := func(line string) {
+		// Lines longer than 75 octets must be folded
+		for len(line) > 75 {
+			cut := 75
+			for cut > 0 && !isRuneStart(line[cut]) {
+				cut--
+			}
+			buf.WriteString(line[:cut] + "\r\n")
+			line = " " + line[cut:]
+		}
+		buf.WriteString(line + "\r\n")
+	}
+
+	start := event.Date.UTC()
+	end := start.AddDate(0, 0, 1)
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//super-santa//Secret Santa//EN")
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("METHOD:PUBLISH")
+	writeLine("BEGIN:VEVENT")
+	writeLine("UID:" + event.UID)
+	writeLine("DTSTAMP:" + time.Now().UTC().Format("20060102T150405Z"))
+	writeLine("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+	writeLine("DTEND;VALUE=DATE:" + end.Format("20060102"))
+	writeLine("SUMMARY:" + icsEscaper.Replace(event.Summary))
+	if event.Description != "" {
+		writeLine("DESCRIPTION:" + icsEscaper.Replace(event.Description))
+	}
+	if event.URL != "" {
+		writeLine("URL:" + event.URL)
+	}
+	writeLine("TRANSP:TRANSPARENT")
+	writeLine("END:VEVENT")
+	writeLine("END:VCALENDAR")
+
+	return buf.Bytes()
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}